@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/syntax-framework/chain"
+	"github.com/syntax-framework/demo/server/accesslog"
+	"github.com/syntax-framework/demo/server/apiauth"
+	"github.com/syntax-framework/demo/server/auth"
+	"github.com/syntax-framework/demo/server/authz"
+	"github.com/syntax-framework/demo/server/cache"
+	"github.com/syntax-framework/demo/server/compress"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/csrf"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/errorpage"
+	"github.com/syntax-framework/demo/server/fsys"
+	"github.com/syntax-framework/demo/server/health"
+	"github.com/syntax-framework/demo/server/https"
+	"github.com/syntax-framework/demo/server/limits"
+	"github.com/syntax-framework/demo/server/livereload"
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/oidc"
+	"github.com/syntax-framework/demo/server/query"
+	"github.com/syntax-framework/demo/server/ratelimit"
+	"github.com/syntax-framework/demo/server/redis"
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/route"
+	"github.com/syntax-framework/demo/server/schedule"
+	"github.com/syntax-framework/demo/server/secure"
+	"github.com/syntax-framework/demo/server/session"
+	"github.com/syntax-framework/demo/server/storage"
+	"github.com/syntax-framework/demo/server/tenant"
+	"github.com/syntax-framework/demo/server/trace"
+	"github.com/syntax-framework/demo/web/controllers"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+// application the subsystems shared by the commands
+type application struct {
+	opts      config.Options
+	cfg       *config.Config
+	files     fsys.Layered
+	queries   *query.Registry // named SQL queries and commands declared in db/
+	dbs       map[string]*db.DB
+	tenants   *tenant.Resolver             // nil without `tenants.list`
+	tenantDBs map[string]map[string]*db.DB // connections of each tenant, by tenant and name
+	redis     map[string]*redis.Client
+	scheduler *schedule.Scheduler
+	health    *health.Checker
+	cache     cache.Cache            // results of the queries that declare `cache`
+	auth      *auth.Auth             // nil when `auth.db` is empty
+	oidc      *oidc.RelyingParty     // "Sign in with ..." OpenID Connect providers, nil when `auth.oidc` is empty
+	apiauth   *apiauth.Authenticator // API tokens and signed requests, nil when `api` is not configured
+	authz     *authz.Authorizer      // roles and permissions of the users and API clients, nil without both
+
+	// addWebFiles registers the web/ FileSystem on the site, see webFileSystem()
+	addWebFiles func(app *syntax.Syntax)
+}
+
+// newApplication loads the configuration and the application files. The database connections are opened lazily by
+// the driver, nothing is listening yet.
+func newApplication(opts config.Options) (*application, error) {
+	cfg, err := config.Load(opts)
+	if err != nil {
+		return nil, err
+	}
+	config.Set(cfg)
+
+	a := &application{opts: opts, cfg: cfg}
+
+	if a.files, err = appFiles(cfg); err != nil {
+		return nil, err
+	}
+
+	if a.queries, err = query.New(subFS(a.files, "db")); err != nil {
+		return nil, err
+	}
+
+	jobs, err := schedule.Load(subFS(a.files, "schedule"))
+	if err != nil {
+		return nil, err
+	}
+	a.scheduler = schedule.New(jobs, func(ctx context.Context, command string) error {
+		_, errCommand := a.runCommand(authz.WithSystem(ctx), command, nil)
+		return errCommand
+	})
+
+	if a.dbs, err = db.OpenAll(cfg.DB); err != nil {
+		return nil, err
+	}
+	if err = a.openTenants(); err != nil {
+		a.close()
+		return nil, err
+	}
+
+	a.health = &health.Checker{Timeout: cfg.Server.Health.Timeout.Std(), Cache: cfg.Server.Health.Cache.Std()}
+	a.redis = map[string]*redis.Client{}
+	a.registerChecks()
+
+	if a.cache, err = cache.New(cfg.Cache, a.redis); err != nil {
+		return nil, err
+	}
+	if a.auth, err = auth.New(cfg.Auth, a.dbs, cfg.Dev); err != nil {
+		return nil, err
+	}
+	if a.oidc, err = oidc.New(cfg.Auth.OIDC, a.auth, cfg.Dev); err != nil {
+		return nil, err
+	}
+	if a.apiauth, err = apiauth.New(cfg.API, a.dbs, a.redis); err != nil {
+		return nil, err
+	}
+	if a.tenants != nil {
+		// the users and the API tokens are shared by the tenants, the remember-me and the API tokens only hold on the
+		// tenant that issued them
+		if a.auth != nil {
+			a.auth.Partition = requestTenant
+		}
+		if a.apiauth != nil && a.apiauth.Tokens != nil {
+			a.apiauth.Tokens.Partition = tenant.Name
+		}
+	}
+	if a.auth != nil || a.apiauth != nil {
+		policy, errPolicy := authz.LoadPolicy(a.files, cfg.Auth.Policy)
+		if errPolicy != nil {
+			return nil, errPolicy
+		}
+		a.authz = authz.New(policy, principal)
+	}
+	return a, nil
+}
+
+// requestTenant the partition of the sessions and of the remember-me tokens, see tenant.Name
+func requestTenant(r *http.Request) string {
+	return tenant.Name(r.Context())
+}
+
+// clientID identifies the API client or the logged user of the request for the rate limits (`key: user`)
+func clientID(r *http.Request) string {
+	if c := apiauth.FromRequest(r); c != nil {
+		return c.Principal()
+	}
+	return auth.UserID(r)
+}
+
+// principal the API client of the request with the scopes of its credential, or the logged user with the roles of the
+// store
+func principal(r *http.Request) *authz.Principal {
+	if c := apiauth.FromRequest(r); c != nil {
+		return &authz.Principal{ID: c.Principal(), Permissions: c.Scopes, Machine: true}
+	}
+	user := auth.FromRequest(r)
+	if user == nil {
+		return nil
+	}
+	return &authz.Principal{ID: user.ID, Roles: user.Roles}
+}
+
+// openTenants opens the databases of each tenant: its own connection for the `tenancy: database` databases and for
+// the ones it overrides, the shared connection for the others
+func (a *application) openTenants() error {
+	for name, conn := range a.dbs {
+		if a.cfg.DB[name].Tenancy == "column" {
+			conn.Column = a.cfg.Tenants.Column
+		}
+	}
+	a.tenants = tenant.New(a.cfg.Tenants)
+	if a.tenants == nil {
+		return nil
+	}
+	a.tenantDBs = map[string]map[string]*db.DB{}
+	for _, name := range a.tenants.Names() {
+		conns := map[string]*db.DB{}
+		a.tenantDBs[name] = conns
+		for dbName, cfg := range a.tenants.Tenant(name).Config.DB {
+			shared := a.dbs[dbName]
+			if shared != nil && reflect.DeepEqual(cfg, a.cfg.DB[dbName]) {
+				conns[dbName] = shared
+				continue
+			}
+			conn, err := db.Open(dbName, cfg)
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", name, err)
+			}
+			conn.Tenant = name
+			if cfg.Tenancy == "column" {
+				conn.Column = a.cfg.Tenants.Column
+			}
+			conns[dbName] = conn
+		}
+	}
+	return nil
+}
+
+// registerChecks adds the readiness checks of each dependency. An invalid redis uri does not prevent the startup, it
+// is reported by the check.
+func (a *application) registerChecks() {
+	for name, conn := range a.dbs {
+		a.health.Register("db."+name, 0, conn.PingContext)
+	}
+	for _, conns := range a.tenantDBs {
+		for name, conn := range conns {
+			if conn.Tenant != "" {
+				a.health.Register("db."+name+"@"+conn.Tenant, 0, conn.PingContext)
+			}
+		}
+	}
+
+	for name, cfg := range a.cfg.Redis {
+		client, err := redis.ParseURI(cfg.URI)
+		if err != nil {
+			a.health.Register("redis."+name, 0, func(ctx context.Context) error {
+				return err
+			})
+			continue
+		}
+		a.redis[name] = client
+		a.health.Register("redis."+name, 0, client.Ping)
+	}
+
+	for name, cfg := range a.cfg.Storage {
+		a.health.Register("storage."+name, 0, storage.Check(cfg))
+	}
+	for tenantName, t := range a.cfg.Tenants.List {
+		for name, cfg := range t.Storage {
+			if !reflect.DeepEqual(cfg, a.cfg.Storage[name]) {
+				a.health.Register("storage."+name+"@"+tenantName, 0, storage.Check(cfg))
+			}
+		}
+	}
+
+	a.health.Register("schedule", 0, a.scheduler.Check)
+}
+
+// close releases the database and redis connections
+func (a *application) close() {
+	for _, conn := range a.dbs {
+		conn.Close()
+	}
+	for _, conns := range a.tenantDBs {
+		for _, conn := range conns {
+			if conn.Tenant != "" {
+				conn.Close()
+			}
+		}
+	}
+	for _, client := range a.redis {
+		client.Close()
+	}
+}
+
+// conn returns the connection of the database that declares the definition, the one of the tenant of the context.
+// The databases of `tenancy: database` have no connection outside of a tenant.
+func (a *application) conn(ctx context.Context, definition *query.Definition) (*db.DB, error) {
+	conns := a.dbs
+	if t := tenant.FromContext(ctx); t != nil {
+		if conns = a.tenantDBs[t.Name]; conns == nil {
+			return nil, fmt.Errorf("there is no tenant named %q in the configuration", t.Name)
+		}
+	} else if cfg := a.cfg.DB[definition.Database]; cfg != nil && cfg.Tenancy == "database" {
+		return nil, fmt.Errorf("%s: %w, each tenant has its own %s database", definition.File, query.ErrNoTenant, definition.Database)
+	}
+	conn, exists := conns[definition.Database]
+	if !exists {
+		return nil, fmt.Errorf("%s: there is no database named %q in the configuration", definition.File, definition.Database)
+	}
+	return conn, nil
+}
+
+// runQuery executes the named query. The result of the queries that declare `cache` is reused until the ttl of the
+// cache configuration, a failure of the cache does not fail the query.
+func (a *application) runQuery(ctx context.Context, name string, params map[string]interface{}) ([]map[string]interface{}, error) {
+	definition := a.queries.Query(name)
+	if definition == nil {
+		return nil, fmt.Errorf("query '%s' does not exist", name)
+	}
+	if err := authz.Check(ctx, definition.Auth); err != nil {
+		return nil, fmt.Errorf("query '%s': %w", name, err)
+	}
+	conn, err := a.conn(ctx, definition)
+	if err != nil {
+		return nil, err
+	}
+	if definition.Cache == nil {
+		return definition.Query(ctx, conn, params)
+	}
+
+	// the results of the tenants are cached apart
+	key := definition.CacheKey(params)
+	if t := tenant.FromContext(ctx); t != nil {
+		key = t.Name + "/" + key
+	}
+	var result []map[string]interface{}
+	if data, found, errCache := a.cache.Get(ctx, key); errCache != nil {
+		log.Printf("cache: %s: %v", name, errCache)
+	} else if found && json.Unmarshal(data, &result) == nil {
+		return result, nil
+	}
+
+	if result, err = definition.Query(ctx, conn, params); err != nil {
+		return nil, err
+	}
+	ttl := a.cfg.Cache.TTL.Std()
+	if ttl <= 0 {
+		ttl = cache.DefaultTTL
+	}
+	if data, errJSON := json.Marshal(result); errJSON == nil {
+		if errCache := a.cache.Set(ctx, key, data, ttl); errCache != nil {
+			log.Printf("cache: %s: %v", name, errCache)
+		}
+	}
+	return result, nil
+}
+
+// runCommand executes the named command and fires its triggers
+func (a *application) runCommand(ctx context.Context, name string, params map[string]interface{}) (int64, error) {
+	definition := a.queries.Command(name)
+	if definition == nil {
+		return 0, fmt.Errorf("command '%s' does not exist", name)
+	}
+	if err := authz.Check(ctx, definition.Auth); err != nil {
+		return 0, fmt.Errorf("command '%s': %w", name, err)
+	}
+	conn, err := a.conn(ctx, definition)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := definition.Exec(ctx, conn, params)
+	if err != nil {
+		return 0, err
+	}
+	for _, trigger := range definition.Triggers {
+		a.scheduler.Fire(context.Background(), trigger)
+	}
+	return affected, nil
+}
+
+// routes registers the application endpoints, the requests that do not match any of them are served by the site
+func (a *application) routes(router *Router, hub *livereload.Hub) {
+	router.GET("/healthz", func(w http.ResponseWriter, r *http.Request, _ Params) {
+		a.health.Liveness(w, r)
+	})
+	router.GET("/readyz", func(w http.ResponseWriter, r *http.Request, _ Params) {
+		a.health.Readiness(w, r)
+	})
+
+	if !a.cfg.Metrics.Disabled {
+		router.GET(a.cfg.Metrics.Endpoint, func(w http.ResponseWriter, r *http.Request, _ Params) {
+			metrics.Default.ServeHTTP(w, r)
+		})
+	}
+
+	if csp := a.cfg.Security.CSP; !a.cfg.Security.Disabled && !csp.Disabled && csp.ReportURI != "" {
+		collector := &secure.Collector{}
+		router.POST(csp.ReportURI, func(w http.ResponseWriter, r *http.Request, _ Params) {
+			collector.ServeHTTP(w, r)
+		})
+	}
+
+	if a.auth != nil {
+		endpoints := a.auth.Routes()
+		if a.oidc != nil {
+			endpoints = append(endpoints, a.oidc.Routes()...)
+		}
+		for _, endpoint := range endpoints {
+			handler := endpoint.Handler
+			router.Handle(endpoint.Method, endpoint.Path, func(w http.ResponseWriter, r *http.Request, _ Params) {
+				handler(w, r)
+			})
+		}
+	}
+
+	if hub != nil {
+		router.GET(a.cfg.LiveReload.Endpoint, func(w http.ResponseWriter, r *http.Request, _ Params) {
+			hub.ServeHTTP(w, r)
+		})
+	}
+}
+
+// middlewares wraps the router with the handlers applied to all requests, the outermost first: request ID, access
+// log, tracing, metrics, compression, security headers, error pages, HSTS, tenant, sessions, logged user, API clients,
+// rate limiting, timeouts and body limits, CSRF tokens, roles and permissions
+func (a *application) middlewares(router http.Handler) (http.Handler, error) {
+	if a.authz != nil {
+		router = a.authz.Handler(router)
+	}
+
+	protection, err := csrf.New(a.cfg.CSRF)
+	if err != nil {
+		return nil, err
+	}
+	if protection != nil {
+		protection.Trusted = func(r *http.Request) bool {
+			return apiauth.FromRequest(r) != nil
+		}
+		router = protection.Handler(router)
+	}
+
+	bounds, err := limits.New(a.cfg.Limits)
+	if err != nil {
+		return nil, err
+	}
+	if bounds != nil {
+		router = bounds.Handler(router)
+	}
+
+	var user func(r *http.Request) string
+	if a.auth != nil || a.apiauth != nil {
+		user = clientID
+	}
+	limiter, err := ratelimit.New(a.cfg.RateLimit, a.redis, user)
+	if err != nil {
+		return nil, err
+	}
+	if limiter != nil {
+		router = limiter.Handler(router)
+	}
+
+	if a.apiauth != nil {
+		router = a.apiauth.Handler(router)
+	}
+
+	if a.auth != nil {
+		if err = a.auth.Init(); err != nil {
+			return nil, err
+		}
+		router = a.auth.Handler(router)
+	}
+
+	sessions, err := session.New(a.cfg.Session, a.dbs, a.redis)
+	if err != nil {
+		return nil, err
+	}
+	if a.tenants != nil {
+		// the users are shared, the logins are not: a session only holds on the tenant that created it
+		sessions.Partition = requestTenant
+	}
+	router = sessions.Handler(router)
+
+	if a.tenants != nil {
+		// the probes of the load balancer and the monitoring are not made on behalf of a tenant
+		a.tenants.Public = []string{"/healthz", "/readyz"}
+		if !a.cfg.Metrics.Disabled {
+			a.tenants.Public = append(a.tenants.Public, a.cfg.Metrics.Endpoint)
+		}
+		router = a.tenants.Handler(router)
+	}
+
+	handler := errorpage.Handler(https.HSTS(a.cfg.Server.HSTS).Handler(router))
+	if headers := secure.New(a.cfg.Security); headers != nil {
+		handler = headers.Handler(handler)
+	}
+	if compression := a.cfg.Compression; !compression.Disabled {
+		handler = (&compress.Compressor{MinSize: compression.MinSize, ContentTypes: compression.ContentTypes}).Handler(handler)
+	}
+	if !a.cfg.Metrics.Disabled {
+		handler = metrics.HTTP(handler)
+	}
+	handler = trace.HTTP(handler)
+
+	logger, err := accesslog.New(a.cfg.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+	if logger != nil {
+		handler = logger.Handler(handler)
+	}
+	return requestid.Handler(handler), nil
+}
+
+// liveReloadEnabled reports whether the site is served with live reload
+func (a *application) liveReloadEnabled() bool {
+	return a.cfg.Dev && !a.cfg.LiveReload.Disabled && !a.cfg.Embedded()
+}
+
+// liveConnections open streams of the live controllers (GET /live)
+var liveConnections = metrics.NewGauge("live_connections", "Open connections of the live controllers")
+
+// siteMiddleware sets the route pattern of the pages, assets and live endpoints (see server/route), counts the live
+// connections, logs the live events with the request ID and renders the pages with their request (see pageRenderer).
+//
+// The middleware only runs for the matched routes, but ctx.MatchedRoutePath is not reliable here (chain copies the
+// context for middlewares with params). The assets are the only site route with params.
+func siteMiddleware(pages *pageRenderer) func(ctx *chain.Context, next func() error) error {
+	return func(ctx *chain.Context, next func() error) error {
+		path := ctx.Request.URL.Path
+		if strings.HasPrefix(path, "/assets/") {
+			route.SetPattern(ctx.Request, "/assets/*filepath")
+		} else {
+			route.SetPattern(ctx.Request, path)
+		}
+
+		method := ctx.Request.Method
+		switch {
+		case path == "/live" && method == http.MethodGet:
+			liveConnections.Inc()
+			log.Printf("live: connection opened (request %s)", requestid.FromRequest(ctx.Request))
+			defer func() {
+				liveConnections.Dec()
+				log.Printf("live: connection closed (request %s)", requestid.FromRequest(ctx.Request))
+			}()
+		case path == "/live" && method == http.MethodPost:
+			log.Printf("live: event received (request %s)", requestid.FromRequest(ctx.Request))
+		case method == http.MethodGet:
+			if pg := pages.byPath[path]; pg != nil {
+				pages.render(ctx, pg)
+				return nil
+			}
+		}
+		return next()
+	}
+}
+
+// syntaxConfig converts the application configuration to the framework configuration
+func syntaxConfig(cfg *config.Config) *syntax.Config {
+	return &syntax.Config{
+		Dev:    cfg.Dev,
+		Cookie: syntax.ConfigCookie{Name: "SID", MaxAge: 24 * 60 * 60 * 1000},
+		// implemented by the livereload package, see liveReload()
+		LiveReload: syntax.ConfigLiveReload{Disabled: true},
+	}
+}
+
+func (a *application) createSite() (http.Handler, error) {
+
+	app := syntax.New(syntaxConfig(a.cfg))
+
+	a.addWebFiles(app)
+
+	if a.liveReloadEnabled() {
+		livereload.Register(app, a.cfg.LiveReload)
+	}
+	if !a.cfg.CSRF.Disabled {
+		csrf.Register(app)
+	}
+	authz.Register(app, renderPrincipal)
+	nonces := secure.New(a.cfg.Security).Nonces()
+	if nonces {
+		secure.Register(app)
+	}
+
+	//site.Midleware()
+
+	pages := &pageRenderer{app: app}
+	app.Use(siteMiddleware(pages))
+
+	controllers.Register(app)
+	wrapControllers(app)
+
+	if err := app.Init(); err != nil {
+		return nil, err
+	}
+	if err := pages.compile(subFS(a.files, "web")); err != nil {
+		return nil, err
+	}
+	if nonces {
+		secure.MarkAssets(app)
+	}
+
+	return app.Handler, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/syntax-framework/demo/server/cache"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/query"
+	"github.com/syntax-framework/demo/server/tenant"
+)
+
+// each tenant has its own sqlite file, the queries and the cached results of one tenant never reach the others
+func Test_tenant_databases(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	content := `
+db:
+  main:
+    engine: sqlite
+    dsn: ` + filepath.Join(dir, "main.db") + `
+    tenancy: database
+cache:
+  engine: memory
+tenants:
+  list:
+    acme:
+    globex:
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(config.Options{File: file, Environ: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{
+		"main/queries/list.yaml":  {Data: []byte("name: ListNotes\ncache:\n  key: notes\nquery: SELECT text FROM notes ORDER BY text")},
+		"main/commands/add.yaml":  {Data: []byte("name: AddNote\nparams:\n  text: string\nquery: INSERT INTO notes (text) VALUES (:text)")},
+		"migrations/V1.Notes.sql": {Data: []byte("CREATE TABLE notes (text TEXT)")},
+	}
+	a := &application{cfg: cfg}
+	if a.queries, err = query.New(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if a.cache, err = cache.New(cfg.Cache, nil); err != nil {
+		t.Fatal(err)
+	}
+	if a.dbs, err = db.OpenAll(cfg.DB); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.openTenants(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+
+	migrations, err := db.LoadMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := map[string]context.Context{}
+	for _, name := range []string{"acme", "globex"} {
+		conn := a.tenantDBs[name]["main"]
+		if conn.Tenant != name {
+			t.Fatalf("%s: Expected its own connection, got %q", name, conn.Tenant)
+		}
+		if _, err = conn.Migrate(context.Background(), migrations); err != nil {
+			t.Fatal(err)
+		}
+		ctx[name] = tenant.WithTenant(context.Background(), a.tenants.Tenant(name))
+	}
+	if _, err = os.Stat(filepath.Join(dir, "main-acme.db")); err != nil {
+		t.Errorf("Expected the file of the tenant: %v", err)
+	}
+
+	// cached before the insert of the other tenant
+	if rows, errQuery := a.runQuery(ctx["globex"], "ListNotes", nil); errQuery != nil || len(rows) != 0 {
+		t.Fatalf("globex: Expected no notes, got %v %v", rows, errQuery)
+	}
+	if _, err = a.runCommand(ctx["acme"], "AddNote", map[string]interface{}{"text": "acme only"}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := a.runQuery(ctx["acme"], "ListNotes", nil)
+	if err != nil || !reflect.DeepEqual(rows, []map[string]interface{}{{"text": "acme only"}}) {
+		t.Errorf("acme: got %v %v", rows, err)
+	}
+	if rows, err = a.runQuery(ctx["globex"], "ListNotes", nil); err != nil || len(rows) != 0 {
+		t.Errorf("globex: Expected no notes, got %v %v", rows, err)
+	}
+
+	if _, err = a.runQuery(context.Background(), "ListNotes", nil); !errors.Is(err, query.ErrNoTenant) {
+		t.Errorf("without tenant: Expected ErrNoTenant, got %v", err)
+	}
+}
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/syntax-framework/demo/server/authz"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/secrets"
+	"github.com/syntax-framework/demo/server/tenant"
+	"gopkg.in/yaml.v3"
+)
+
+// command a subcommand of the binary (Ex. `demo migrate`)
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []*command
+
+func init() {
+	// assigned in init, the help command references the list
+	commands = []*command{
+		{"serve", "serve [-addr host:port] [-cert file] [-key file]", "start the server (default command)", serveCommand},
+		{"routes", "routes", "list the routes of the application", routesCommand},
+		{"migrate", "migrate [-db name] [-tenant name]", "apply the pending migrations of db/<name>/migrations", migrateCommand},
+		{"query", "query run [-tenant name] <name> [--param key=value]...", "execute a query or command declared in db/", queryCommand},
+		{"roles", "roles [-clear] <email> [role]...", "show or replace the roles of a user (see auth/policy.yaml)", rolesCommand},
+		{"tokens", "tokens create [-scopes a,b] [-ttl 720h] [-tenant name] <name> | tokens list | tokens revoke <id>", "mint, list or revoke the API tokens", tokensCommand},
+		{"schedule", "schedule list | schedule run <job>", "list or execute the jobs declared in schedule/", scheduleCommand},
+		{"secrets", "secrets list | secrets edit | secrets set <name> | secrets rotate", "manage the encrypted secrets referenced as secret:<name>", secretsCommand},
+		{"config", "config check | config show", "validate or print the merged configuration (secrets redacted)", configCommand},
+		{"new", "new controller|page|query <name>", "create the stub of a controller, page or query", newCommand},
+		{"help", "help", "show this help", helpCommand},
+	}
+}
+
+// errUsage the arguments are invalid, the usage of the command was printed
+var errUsage = errors.New("invalid arguments")
+
+// run executes the command of the arguments and returns the exit code
+func run(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(args)
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+			return 2
+		default:
+			fmt.Fprintln(os.Stderr, secrets.Redact(err.Error()))
+			return 1
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	helpCommand(nil)
+	return 2
+}
+
+func helpCommand(_ []string) error {
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "Usage: demo <command> [arguments]")
+	fmt.Fprintln(w)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.usage, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "All commands accept -config <file> and -profile <name>, see config.yaml")
+	return w.Flush()
+}
+
+// newFlagSet creates the flags of the command, with the configuration flags common to all commands
+func newFlagSet(name string, opts *config.Options) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&opts.File, "config", "", "configuration `file` (default config.yaml)")
+	flags.StringVar(&opts.Profile, "profile", "", "configuration profile, loads config.<profile>.yaml (default $"+config.EnvProfile+")")
+	flags.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(flags.Output(), "Usage: demo %s\n", cmd.usage)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses the flags, which can be mixed with the positional arguments (Ex. `query run Name --param a=1`),
+// and returns the positional ones
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// usage prints the usage of the command and returns errUsage
+func usage(flags *flag.FlagSet, format string, args ...interface{}) error {
+	if format != "" {
+		fmt.Fprintf(flags.Output(), format+"\n", args...)
+	}
+	flags.Usage()
+	return errUsage
+}
+
+func serveCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("serve", &opts)
+	addr := flags.String("addr", "", "TLS `address`, overrides server.addr")
+	cert := flags.String("cert", "", "certificate `file`, overrides server.cert")
+	key := flags.String("key", "", "private key `file`, overrides server.key")
+	if positional, err := parseArgs(flags, args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return usage(flags, "unexpected argument %q", positional[0])
+	}
+
+	a, err := newApplication(opts)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if *addr != "" {
+		a.cfg.Server.Addr = *addr
+	}
+	if *cert != "" {
+		a.cfg.Server.Cert = *cert
+	}
+	if *key != "" {
+		a.cfg.Server.Key = *key
+	}
+	return serve(a)
+}
+
+func routesCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("routes", &opts)
+	if positional, err := parseArgs(flags, args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return usage(flags, "unexpected argument %q", positional[0])
+	}
+
+	a, err := newApplication(opts)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	router := &Router{}
+	a.routes(router, nil)
+	routes := router.Routes()
+	if a.liveReloadEnabled() {
+		routes = append(routes, Route{Method: "GET", Path: a.cfg.LiveReload.Endpoint})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tHANDLER")
+	for _, route := range routes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", route.Method, route.Path, "app")
+	}
+
+	pages, err := sitePages(subFS(a.files, "web"))
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		fmt.Fprintf(w, "GET\t%s\tpage web%s\n", page.path, page.file)
+	}
+	fmt.Fprintf(w, "GET\t/assets/*filepath\tsyntax assets\n")
+	fmt.Fprintf(w, "GET\t/live\tsyntax live controllers\n")
+	fmt.Fprintf(w, "POST\t/live\tsyntax live controllers\n")
+	return w.Flush()
+}
+
+type sitePage struct {
+	path string
+	file string
+}
+
+// sitePages lists the pages published by the framework, same rules of syntax.servePages(): every html file outside
+// the directories starting with `_`, index.html is served on the directory path
+func sitePages(web fs.FS) ([]sitePage, error) {
+	var pages []sitePage
+	err := fs.WalkDir(web, ".", func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if file != "." && strings.HasPrefix(d.Name(), "_") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(file) != ".html" {
+			return nil
+		}
+		route := "/" + file
+		if path.Base(file) == "index.html" {
+			route = strings.TrimSuffix(route, "index.html")
+		}
+		pages = append(pages, sitePage{path: route, file: "/" + file})
+		return nil
+	})
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].path < pages[j].path
+	})
+	return pages, err
+}
+
+func migrateCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("migrate", &opts)
+	only := flags.String("db", "", "migrate only the database with this `name`")
+	onlyTenant := flags.String("tenant", "", "migrate only the databases of the tenant with this `name`")
+	if positional, err := parseArgs(flags, args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return usage(flags, "unexpected argument %q", positional[0])
+	}
+
+	a, err := newApplication(opts)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if *only != "" {
+		if _, exists := a.dbs[*only]; !exists {
+			return fmt.Errorf("there is no database named %q in the configuration", *only)
+		}
+	}
+	if *onlyTenant != "" {
+		if _, exists := a.tenantDBs[*onlyTenant]; !exists {
+			return fmt.Errorf("there is no tenant named %q in the configuration", *onlyTenant)
+		}
+	}
+
+	var names []string
+	for name := range a.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if *only != "" && name != *only {
+			continue
+		}
+		migrations, errLoad := db.LoadMigrations(a.files, path.Join("db", name, "migrations"))
+		if errors.Is(errLoad, fs.ErrNotExist) {
+			fmt.Printf("%s: no migrations\n", name)
+			continue
+		} else if errLoad != nil {
+			return errLoad
+		}
+
+		// the shared connection, followed by the ones of the tenants (Ex. "mydatabase@acme")
+		targets := map[string]*db.DB{}
+		if *onlyTenant == "" {
+			targets[name] = a.dbs[name]
+		}
+		for tenantName, conns := range a.tenantDBs {
+			if conn := conns[name]; conn != nil && conn.Tenant != "" && (*onlyTenant == "" || tenantName == *onlyTenant) {
+				targets[name+"@"+tenantName] = conn
+			}
+		}
+		var labels []string
+		for label := range targets {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			applied, errMigrate := targets[label].Migrate(context.Background(), migrations)
+			for _, migration := range applied {
+				fmt.Printf("%s: applied V%d %s\n", label, migration.Version, migration.Description)
+			}
+			if errMigrate != nil {
+				return errMigrate
+			}
+			if len(applied) == 0 {
+				fmt.Printf("%s: up to date\n", label)
+			}
+		}
+	}
+	return nil
+}
+
+// paramsFlag repeatable `--param key=value`
+type paramsFlag map[string]interface{}
+
+func (p paramsFlag) String() string {
+	return ""
+}
+
+func (p paramsFlag) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i < 1 {
+		return fmt.Errorf("expected key=value, found %q", value)
+	}
+	p[value[:i]] = value[i+1:]
+	return nil
+}
+
+func queryCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("query", &opts)
+	params := paramsFlag{}
+	flags.Var(params, "param", "query param, repeatable (Ex. --param name=alex)")
+	tenantName := flags.String("tenant", "", "execute on behalf of the tenant with this `name`")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 || positional[0] != "run" {
+		return usage(flags, "")
+	}
+	name := positional[1]
+
+	a, err := newApplication(opts)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	var result interface{}
+	ctx, err := a.tenantContext(*tenantName)
+	if err != nil {
+		return err
+	}
+	if a.queries.Query(name) != nil {
+		if result, err = a.runQuery(ctx, name, params); err != nil {
+			return err
+		}
+	} else if a.queries.Command(name) != nil {
+		affected, errCommand := a.runCommand(ctx, name, params)
+		if errCommand != nil {
+			return errCommand
+		}
+		result = map[string]int64{"affected": affected}
+	} else {
+		return fmt.Errorf("there is no query or command named '%s' in db/", name)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// rolesCommand shows the roles and the permissions of the user, or replaces its roles by the given ones
+func rolesCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("roles", &opts)
+	clear := flags.Bool("clear", false, "remove all the roles of the user")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || *clear && len(positional) > 1 {
+		return usage(flags, "")
+	}
+
+	a, err := newApplication(opts)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if a.auth == nil {
+		return errors.New("the authentication is disabled, see auth.db in the configuration")
+	}
+
+	ctx := context.Background()
+	user, err := a.auth.Store.UserByEmail(ctx, positional[0])
+	if err != nil {
+		return err
+	} else if user == nil {
+		return fmt.Errorf("there is no user with the email %s", positional[0])
+	}
+
+	policy := a.authz.Policy()
+	if roles := positional[1:]; len(roles) > 0 || *clear {
+		for _, role := range roles {
+			if !policy.HasRole(role) {
+				return fmt.Errorf("there is no role named %q in %s", role, a.cfg.Auth.Policy)
+			}
+		}
+		if err = a.auth.Store.SetRoles(ctx, user.ID, roles); err != nil {
+			return err
+		}
+		user.Roles = roles
+	}
+
+	p := policy.Expand(&authz.Principal{ID: user.ID, Roles: user.Roles})
+	fmt.Printf("user:        %s (%s)\n", user.Email, user.ID)
+	fmt.Printf("roles:       %s\n", dash(strings.Join(user.Roles, ", ")))
+	fmt.Printf("effective:   %s\n", dash(strings.Join(p.Roles, ", ")))
+	fmt.Printf("permissions: %s\n", dash(strings.Join(p.Permissions, ", ")))
+	return nil
+}
+
+// tenantContext returns the context of the commands executed on behalf of the named tenant, empty for none
+func (a *application) tenantContext(name string) (context.Context, error) {
+	ctx := authz.WithSystem(context.Background())
+	if name == "" {
+		return ctx, nil
+	}
+	var t *tenant.Tenant
+	if a.tenants != nil {
+		t = a.tenants.Tenant(name)
+	}
+	if t == nil {
+		return nil, fmt.Errorf("there is no tenant named %q in the configuration", name)
+	}
+	return tenant.WithTenant(ctx, t), nil
+}
+
+// tokensCommand manages the API tokens of `api.tokens`, the secret of a new token is only shown once
+func tokensCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("tokens", &opts)
+	scopes := flags.String("scopes", "", "permissions of the token, separated by commas (Ex. users.read,posts.*)")
+	ttl := flags.Duration("ttl", 0, "validity of the token, negative never expires (default api.tokens.ttl)")
+	tenantName := flags.String("tenant", "", "create the token for the tenant with this `name`")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || !(positional[0] == "list" && len(positional) == 1 || (positional[0] == "create" || positional[0] == "revoke") && len(positional) == 2) {
+		return usage(flags, "")
+	}
+
+	a, err := newApplication(opts)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if a.apiauth == nil || a.apiauth.Tokens == nil {
+		return errors.New("the API tokens are disabled, see api.tokens.db in the configuration")
+	}
+	tokens := a.apiauth.Tokens
+	ctx, err := a.tenantContext(*tenantName)
+	if err != nil {
+		return err
+	}
+	if a.tenants != nil && *tenantName == "" && positional[0] == "create" {
+		return errors.New("the tokens belong to a tenant, use -tenant")
+	}
+
+	switch positional[0] {
+	case "create":
+		secret, token, errCreate := tokens.Create(ctx, positional[1], authz.ParseList(*scopes), *ttl)
+		if errCreate != nil {
+			return errCreate
+		}
+		fmt.Printf("token %s created, expires %s\n", token.ID, dash(formatTime(token.Expires)))
+		fmt.Println("the secret below is not shown again:")
+		fmt.Println(secret)
+		return nil
+	case "revoke":
+		if err = tokens.Revoke(ctx, positional[1]); err != nil {
+			return err
+		}
+		fmt.Printf("%s: revoked\n", positional[1])
+		return nil
+	}
+
+	list, err := tokens.Store.Tokens(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES\tSTATUS")
+	now := time.Now()
+	for _, token := range list {
+		status := "valid"
+		if !token.Revoked.IsZero() {
+			status = "revoked " + formatTime(token.Revoked)
+		} else if !token.Valid(now) {
+			status = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", token.ID, token.Name, dash(strings.Join(token.Scopes, ", ")),
+			formatTime(token.Created), dash(formatTime(token.Expires)), status)
+	}
+	return w.Flush()
+}
+
+// secretsCommand manages the encrypted file of `secrets.file`. The master key comes from $DEMO_SECRETS_KEY or from
+// `secrets.key-file`, which edit and set create when neither exists.
+func secretsCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("secrets", &opts)
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || !((positional[0] == "list" || positional[0] == "edit" || positional[0] == "rotate") && len(positional) == 1 || positional[0] == "set" && len(positional) == 2) {
+		return usage(flags, "")
+	}
+
+	files, err := config.SecretFiles(opts)
+	if err != nil {
+		return err
+	}
+	env := os.Getenv(config.EnvSecretsKey)
+	key, err := secrets.LoadKey(env, files.KeyFile)
+	if errors.Is(err, secrets.ErrNoKey) && (positional[0] == "edit" || positional[0] == "set") {
+		if _, errStat := os.Stat(files.File); errStat == nil {
+			return fmt.Errorf("%w, it is required to change %s", err, files.File)
+		}
+		key = secrets.NewKey()
+		if err = secrets.WriteKey(files.KeyFile, key); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "master key created on %s, do not commit it\n", files.KeyFile)
+	} else if err != nil {
+		return err
+	}
+	values, err := secrets.Read(files.File, key)
+	if err != nil {
+		return err
+	}
+
+	switch positional[0] {
+	case "set":
+		name := positional[1]
+		if !secrets.ValidName(name) {
+			return fmt.Errorf("invalid name %q, use letters, digits, `_`, `.` and `-`", name)
+		}
+		fmt.Fprintf(os.Stderr, "value of %s (end with Ctrl-D):\n", name)
+		data, errRead := io.ReadAll(os.Stdin)
+		if errRead != nil {
+			return errRead
+		}
+		values[name] = strings.TrimRight(string(data), "\r\n")
+		if err = secrets.Write(files.File, key, values); err != nil {
+			return err
+		}
+		fmt.Printf("%s: saved on %s\n", name, files.File)
+		return nil
+	case "edit":
+		edited, errEdit := editSecrets(values)
+		if errEdit != nil {
+			return errEdit
+		}
+		if err = secrets.Write(files.File, key, edited); err != nil {
+			return err
+		}
+		fmt.Printf("%d secrets saved on %s\n", len(edited), files.File)
+		return nil
+	case "rotate":
+		newKey := secrets.NewKey()
+		if env != "" {
+			if err = secrets.Write(files.File, newKey, values); err != nil {
+				return err
+			}
+			fmt.Printf("%s re-encrypted, replace %s by the new key:\n%s\n", files.File, config.EnvSecretsKey, secrets.EncodeKey(newKey))
+			return nil
+		}
+		// the new key is saved beside the old one first, an interruption does not leave the file without its key
+		pending := files.KeyFile + ".new"
+		if err = secrets.WriteKey(pending, newKey); err != nil {
+			return err
+		}
+		if err = secrets.Write(files.File, newKey, values); err != nil {
+			return err
+		}
+		if err = os.Rename(pending, files.KeyFile); err != nil {
+			return err
+		}
+		fmt.Printf("%s re-encrypted with the key %s of %s\n", files.File, secrets.KeyID(newKey), files.KeyFile)
+		return nil
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// editSecrets opens the values on $EDITOR (vi by default), in a temporary yaml readable only by the owner
+func editSecrets(values map[string]string) (map[string]string, error) {
+	tmp, err := os.CreateTemp("", "secrets-*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	content := []byte("# name: value, saved encrypted when the editor is closed\n")
+	if len(values) > 0 {
+		out, errMarshal := yaml.Marshal(values)
+		if errMarshal != nil {
+			tmp.Close()
+			return nil, errMarshal
+		}
+		content = append(content, out...)
+	}
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor: %w", err)
+	}
+
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	edited := map[string]string{}
+	if err = yaml.Unmarshal(data, &edited); err != nil {
+		return nil, fmt.Errorf("the secrets were not changed: %w", err)
+	}
+	return edited, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func scheduleCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("schedule", &opts)
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || !(positional[0] == "list" && len(positional) == 1 || positional[0] == "run" && len(positional) == 2) {
+		return usage(flags, "")
+	}
+
+	a, err := newApplication(opts)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if positional[0] == "run" {
+		if err = a.scheduler.RunJob(context.Background(), positional[1]); err != nil {
+			return err
+		}
+		fmt.Printf("%s: done\n", positional[1])
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "JOB\tTRIGGER\tEVERY\tCOMMANDS\tFILE")
+	for _, job := range a.scheduler.List() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\tschedule/%s\n", job.Name, dash(job.When.Name), dash(job.When.Every), dash(strings.Join(job.Commands, ", ")), job.File)
+	}
+	return w.Flush()
+}
+
+// configCommand validates the configuration files without starting the server (used in CI), or prints the merge of
+// the files
+func configCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("config", &opts)
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || positional[0] != "check" && positional[0] != "show" {
+		return usage(flags, "")
+	}
+
+	if positional[0] == "show" {
+		out, errShow := config.Show(opts)
+		if errShow != nil {
+			return errShow
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+
+	cfg, err := config.Load(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("configuration ok: %s\n", strings.Join(cfg.Files, ", "))
+	return nil
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
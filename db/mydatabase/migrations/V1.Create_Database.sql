@@ -1,6 +1,5 @@
-
-
-CREATE table USER
-    NAME VARCHAR
-    EMAIL VARCHAR
-
+CREATE TABLE users (
+    id    INTEGER PRIMARY KEY,
+    name  VARCHAR(255) NOT NULL,
+    email VARCHAR(255)
+);
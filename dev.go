@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/syntax-framework/demo/server/authz"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/livereload"
+	"github.com/syntax-framework/demo/server/schedule"
+)
+
+// liveReload serves the site in development mode, watching the application files and re-initialising the affected
+// parts when they change:
+//
+//	config.yaml  configuration reloaded and validated, site recreated
+//	web/, i18n/  site recreated (templates, assets and routes)
+//	db/          queries reloaded
+//	auth/        policy of the roles and permissions reloaded
+//	schedule/    jobs reloaded
+//
+// The browsers connected to the hub are notified after each change.
+func (a *application) liveReload(hub *livereload.Hub) (http.Handler, error) {
+	site, err := a.createSite()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := a.cfg
+	handler := &livereload.Handler{}
+	handler.Swap(site)
+
+	var patterns []*regexp.Regexp
+	for _, pattern := range cfg.LiveReload.Patterns {
+		if pattern == "" {
+			continue
+		}
+		compiled, errPattern := regexp.Compile(pattern)
+		if errPattern != nil {
+			return nil, errPattern
+		}
+		patterns = append(patterns, compiled)
+	}
+
+	watcher := &livereload.Watcher{
+		Paths:    append([]string{"web", "i18n", "db", "auth", "schedule"}, cfg.Files...),
+		Patterns: patterns,
+		Debounce: time.Duration(cfg.LiveReload.Debounce) * time.Millisecond,
+	}
+
+	go watcher.Run(context.Background(), func(files []string) {
+		log.Printf("live-reload: %s changed", strings.Join(files, ", "))
+
+		var reloadConfig, reloadSite, reloadQueries, reloadPolicy, reloadJobs bool
+		for _, file := range files {
+			switch {
+			case strings.HasPrefix(file, "web/"), strings.HasPrefix(file, "i18n/"):
+				reloadSite = true
+			case strings.HasPrefix(file, "db/"):
+				reloadQueries = true
+			case strings.HasPrefix(file, "auth/"):
+				reloadPolicy = true
+			case strings.HasPrefix(file, "schedule/"):
+				reloadJobs = true
+			default:
+				reloadConfig = true
+			}
+		}
+
+		if reloadConfig {
+			newConfig, errConfig := config.Load(a.opts)
+			if errConfig != nil {
+				log.Printf("live-reload: %v", errConfig)
+				return
+			}
+			config.Set(newConfig)
+			a.cfg = newConfig
+			reloadSite, reloadPolicy = true, true
+		}
+
+		if reloadQueries {
+			if errQueries := a.queries.Reload(); errQueries != nil {
+				log.Printf("live-reload: %v", errQueries)
+				return
+			}
+		}
+
+		if reloadPolicy && a.authz != nil {
+			policy, errPolicy := authz.LoadPolicy(a.files, a.cfg.Auth.Policy)
+			if errPolicy != nil {
+				log.Printf("live-reload: %v", errPolicy)
+				return
+			}
+			a.authz.SetPolicy(policy)
+		}
+
+		if reloadJobs {
+			jobs, errJobs := schedule.Load(subFS(a.files, "schedule"))
+			if errJobs != nil {
+				log.Printf("live-reload: %v", errJobs)
+				return
+			}
+			a.scheduler.Set(jobs)
+		}
+
+		if reloadSite {
+			newSite, errSite := a.createSite()
+			if errSite != nil {
+				log.Printf("live-reload: %v", errSite)
+				return
+			}
+			handler.Swap(newSite)
+		}
+
+		hub.Broadcast(livereload.Classify(files))
+	})
+
+	return handler, nil
+}
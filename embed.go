@@ -0,0 +1,106 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/fsys"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+// webFiles pages, layouts and assets. Kept apart from the other directories because the framework publishes every
+// html file of the FileSystem
+//
+//go:embed all:web
+var webFiles embed.FS
+
+// dataFiles the other application directories
+//
+//go:embed auth db i18n integrations schedule
+var dataFiles embed.FS
+
+// appFiles returns the application files (web/, auth/, db/, i18n/, integrations/, schedule/).
+//
+// In development the files are read from the working directory. Otherwise the files embedded in the binary are used,
+// with the `embed.overlay` directory layered over them.
+func appFiles(cfg *config.Config) (fsys.Layered, error) {
+	if !cfg.Embedded() {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		return fsys.Layered{{Name: "dir:" + wd, FS: os.DirFS(wd)}}, nil
+	}
+
+	var layers fsys.Layered
+	if cfg.Embed.Overlay != "" {
+		if _, err := os.Stat(cfg.Embed.Overlay); err != nil {
+			return nil, err
+		}
+		layers = append(layers, fsys.Layer{Name: "overlay:" + cfg.Embed.Overlay, FS: os.DirFS(cfg.Embed.Overlay)})
+	}
+	layers = append(layers,
+		fsys.Layer{Name: "embedded", FS: webFiles},
+		fsys.Layer{Name: "embedded", FS: dataFiles},
+	)
+	return layers, nil
+}
+
+// logFileSources startup check, lists the source each application file is served from
+func logFileSources(files fsys.Layered) error {
+	sources, err := files.Sources()
+	if err != nil {
+		return err
+	}
+	log.Printf("application files (%d):", len(sources))
+	for _, source := range sources {
+		log.Printf("  %-50s %s", source.Path, source.Layer)
+	}
+	return nil
+}
+
+// subFS returns the directory of the application files, used by the subsystems (Ex. "db" for the queries)
+func subFS(files fsys.Layered, dir string) fs.FS {
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		// only fails for invalid paths, which are constants
+		panic(any(err))
+	}
+	return sub
+}
+
+// webFileSystem returns the function that registers the web/ FileSystem on the site.
+//
+// The framework does not accept an fs.FS, so when there is an overlay the merged files are written to a temporary
+// directory, created once per process.
+func webFileSystem(cfg *config.Config, files fsys.Layered) (func(app *syntax.Syntax), error) {
+	if !cfg.Embedded() {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		return func(app *syntax.Syntax) {
+			app.AddFileSystemDir(wd+"/web/", 0)
+		}, nil
+	}
+
+	if cfg.Embed.Overlay == "" {
+		return func(app *syntax.Syntax) {
+			app.AddFileSystemEmbed(webFiles, "web/", 0)
+		}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "demo-web-")
+	if err != nil {
+		return nil, err
+	}
+	if err = fsys.CopyDir(subFS(files, "web"), dir); err != nil {
+		return nil, err
+	}
+	return func(app *syntax.Syntax) {
+		app.AddFileSystemDir(dir, 0)
+	}, nil
+}
@@ -1,45 +1,176 @@
 package main
 
 import (
-	"github.com/syntax-framework/demo/web/controllers"
-	"github.com/syntax-framework/syntax/syntax"
+	"context"
+	"errors"
+	"github.com/syntax-framework/demo/server/https"
+	"github.com/syntax-framework/demo/server/listen"
+	"github.com/syntax-framework/demo/server/livereload"
+	"github.com/syntax-framework/demo/server/secrets"
+	"github.com/syntax-framework/demo/server/trace"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	handler := createSite()
-	httpAddr := "localhost:8080"
-	if err := http.ListenAndServeTLS(httpAddr, "localhost.crt", "localhost.key", handler); err != nil {
-		log.Fatalf("ListenAndServe %s: %v", httpAddr, err)
-	}
+	// the values of `secret:<name>` are redacted from everything logged
+	log.SetOutput(secrets.Writer(os.Stderr))
+	os.Exit(run(os.Args[1:]))
 }
 
-func createSite() http.Handler {
+// serve starts the listeners, blocks until the server fails or a SIGINT/SIGTERM finishes the shutdown.
+//
+// A SIGHUP or SIGUSR2 starts a new copy of the binary with the same listeners (zero-downtime upgrade), once it is
+// ready this process drains and exits.
+func serve(a *application) error {
+	var err error
+	if a.cfg.Embedded() {
+		if err = logFileSources(a.files); err != nil {
+			return err
+		}
+	}
+
+	if a.addWebFiles, err = webFileSystem(a.cfg, a.files); err != nil {
+		return err
+	}
+
+	var tracer *trace.Tracer
+	if tracing := a.cfg.Tracing; tracing.Exporter == "otlp" {
+		exporter := &trace.OTLP{Endpoint: tracing.Endpoint, Headers: tracing.Headers, Service: tracing.Service}
+		tracer = trace.New(exporter, tracing.Sample)
+		trace.SetTracer(tracer)
+		log.Printf("tracing: exporting to %s", tracing.Endpoint)
+	}
+
+	router := &Router{}
+
+	var hub *livereload.Hub
+	if a.liveReloadEnabled() {
+		hub = &livereload.Hub{}
+		router.NotFound, err = a.liveReload(hub)
+	} else {
+		router.NotFound, err = a.createSite()
+	}
+	if err != nil {
+		return err
+	}
+	a.routes(router, hub)
+
+	handler, err := a.middlewares(router)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	//syntax.LoadConfig()
+	go a.scheduler.Start(ctx)
 
-	app := syntax.New(nil)
+	server := a.cfg.Server
+	mode, _ := listen.ParseMode(server.Socket.Mode)
+	socket := listen.Options{Mode: mode, Group: server.Socket.Group}
 
-	path, err := os.Getwd()
+	tlsListener, err := listen.Listen(server.Addr, socket)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	app.AddFileSystemDir(path+"/web/", 0)
+	servers := []*http.Server{{Handler: handler}}
+	listeners := []net.Listener{tlsListener}
+	byAddr := map[string]net.Listener{server.Addr: tlsListener}
 
-	//site.Midleware()
+	if server.HTTP.Addr != "" {
+		httpListener, errListen := listen.Listen(server.HTTP.Addr, socket)
+		if errListen != nil {
+			tlsListener.Close()
+			return errListen
+		}
+		// the public port is unknown for unix and systemd listeners, the redirect uses the default https port
+		redirect := &https.Redirect{TLSAddr: listen.TCPAddr(server.Addr), WellKnown: server.HTTP.WellKnown}
+		servers = append(servers, &http.Server{Handler: redirect})
+		listeners = append(listeners, httpListener)
+		byAddr[server.HTTP.Addr] = httpListener
+	}
 
-	// go:embed site_embed/*
-	//var embedSiteDir embed.FS
-	//site.AddFileSystemEmbed(embedSiteDir, "site_embed/", 0) // test only
+	failed := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func(tls bool, srv *http.Server, ln net.Listener) {
+			var errServe error
+			if tls {
+				log.Printf("listening on https %s", listenerAddr(ln))
+				errServe = srv.ServeTLS(ln, server.Cert, server.Key)
+			} else {
+				log.Printf("listening on http %s", listenerAddr(ln))
+				errServe = srv.Serve(ln)
+			}
+			if !errors.Is(errServe, http.ErrServerClosed) {
+				failed <- errServe
+			}
+		}(i == 0, srv, listeners[i])
+	}
 
-	controllers.RegisterMyController(app)
-	controllers.RegisterMyLiveController(app)
+	// started by an upgrade, the previous process can drain
+	if err = listen.Ready(); err != nil {
+		log.Printf("upgrade: %v", err)
+	}
+
+	upgrade := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgrade, upgradeSignals...)
+		defer signal.Stop(upgrade)
+	}
 
-	if err := app.Init(); err != nil {
-		log.Fatal(err)
+	upgraded := false
+wait:
+	for {
+		select {
+		case err = <-failed:
+			return err
+		case <-ctx.Done():
+			break wait
+		case <-upgrade:
+			log.Printf("upgrade: starting the new process")
+			child, errUpgrade := (&listen.Upgrader{}).Upgrade(byAddr)
+			if errUpgrade != nil {
+				log.Printf("upgrade: %v, keep serving", errUpgrade)
+				continue
+			}
+			log.Printf("upgrade: process %d is ready", child.Pid)
+			upgraded = true
+			break wait
+		}
 	}
+	stop()
+
+	// the load balancer stops sending new requests once /readyz fails. After an upgrade the new process is already
+	// accepting on the same sockets, no need to wait.
+	a.health.Drain()
+	if drain := server.Shutdown.Drain.Std(); drain > 0 && !upgraded {
+		log.Printf("shutdown: draining for %s", drain)
+		time.Sleep(drain)
+	}
+
+	log.Printf("shutdown: waiting for the requests in progress")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), server.Shutdown.Timeout.Std())
+	defer cancel()
+	for _, srv := range servers {
+		if errShutdown := srv.Shutdown(shutdownCtx); errShutdown != nil {
+			err = errShutdown
+		}
+	}
+	if tracer != nil {
+		if errFlush := tracer.Flush(shutdownCtx); errFlush != nil {
+			log.Printf("tracing: %v", errFlush)
+		}
+	}
+	return err
+}
 
-	return app.Handler
+// listenerAddr describes the listener in the logs (Ex. "tcp:127.0.0.1:8080", "unix:/run/demo.sock")
+func listenerAddr(ln net.Listener) string {
+	return ln.Addr().Network() + ":" + ln.Addr().String()
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/syntax-framework/chain"
+	"github.com/syntax-framework/demo/server/auth"
+	"github.com/syntax-framework/demo/server/authz"
+	"github.com/syntax-framework/demo/server/session"
+	"github.com/syntax-framework/demo/server/tenant"
+	"github.com/syntax-framework/demo/server/trace"
+	"github.com/syntax-framework/shtml/sht"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+// renderRequestKey name of the request of the render in the context of the scope
+const renderRequestKey = "render.request"
+
+// page of the site, see pageRenderer
+type page struct {
+	path     string
+	compiled *sht.Compiled
+	config   *syntax.PageConfig // Of the <page> element, nil when absent
+	layout   *sht.Compiled
+}
+
+// pageRenderer renders the pages of the site with the request of the render in the scopes (see requestFromScope).
+//
+// The page handler of the framework creates the scopes without the request, so siteMiddleware renders the pages
+// instead of calling it. The framework has already bundled the assets of the pages on app.Init, the pages are compiled
+// again with its directives on a template system of their own.
+type pageRenderer struct {
+	app    *syntax.Syntax
+	byPath map[string]*page
+}
+
+// compile compiles the pages of web and their layouts, must be called after `app.Init()`
+func (p *pageRenderer) compile(web fs.FS) error {
+	pages, err := sitePages(web)
+	if err != nil {
+		return err
+	}
+	site := p.app.Template.(*sht.TemplateSystem)
+	system := &sht.TemplateSystem{Loader: site.Loader, Directives: site.Directives}
+
+	p.byPath = map[string]*page{}
+	layouts := map[string]*sht.Compiled{}
+	for _, sp := range pages {
+		compiled, context, errCompile := system.Compile(sp.file)
+		if errCompile != nil {
+			return errCompile
+		}
+		pg := &page{path: sp.path, compiled: compiled}
+		layout := syntax.LayoutDefault
+		if pg.config, _ = context.Get(syntax.PageConfigKey).(*syntax.PageConfig); pg.config != nil && pg.config.Layout != "" {
+			layout = pg.config.Layout
+		}
+		if pg.layout = layouts[layout]; pg.layout == nil {
+			if pg.layout, _, errCompile = system.Compile("/_layout/" + layout); errCompile != nil {
+				return errCompile
+			}
+			layouts[layout] = pg.layout
+		}
+		p.byPath[sp.path] = pg
+	}
+	return nil
+}
+
+// render writes the page, the request of the render (with its span) is in the scopes of the page and of its layout
+func (p *pageRenderer) render(ctx *chain.Context, pg *page) {
+	spanCtx, span := trace.Start(ctx.Request.Context(), "render "+pg.path, trace.KindInternal)
+	defer span.End()
+	r := ctx.Request.WithContext(spanCtx)
+
+	rootScope := sht.NewRootScope()
+	rootScope.Context.Set(renderRequestKey, r)
+	timing := rootScope.Context.Timing
+
+	metricContent := timing.Metric("rpc", "<!{S}> Render Content").Start()
+	content := pg.compiled.Exec(rootScope)
+	metricContent.Stop()
+
+	config, _ := rootScope.Context.Get(syntax.PageConfigKey).(*syntax.PageConfig)
+	if config == nil {
+		config = pg.config
+	}
+	if config == nil {
+		config = &syntax.PageConfig{}
+	}
+
+	metricFull := timing.Metric("rpf", "<!{S}> Render Full").Start()
+	layoutScope := sht.NewRootScope()
+	layoutScope.Context.Set(renderRequestKey, r)
+	layoutScope.Set("page", config)
+	layoutScope.Set("content", content.String())
+	layoutScope.Set("styles", p.app.Bundler.GetStyles(pg.path))
+	layoutScope.Set("scripts", p.app.Bundler.GetScripts(pg.path))
+	document := []byte(pg.layout.Exec(layoutScope).String())
+	metricFull.Stop()
+
+	header := ctx.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(document)))
+	if p.app.Config.Dev {
+		header.Set("Server-Timing", timing.String())
+	}
+	ctx.WriteHeader(http.StatusOK)
+	if _, err := ctx.Write(document); err != nil {
+		log.Printf("render %s: %v", pg.path, err)
+	}
+}
+
+// requestFromScope returns the request of the page being rendered, nil outside of a page render (Ex. the events of
+// the live controllers)
+func requestFromScope(scope *sht.Scope) *http.Request {
+	r, _ := scope.Context.Get(renderRequestKey).(*http.Request)
+	return r
+}
+
+// renderPrincipal returns the principal of the page being rendered, nil for anonymous requests (see the roles-allowed
+// directive of server/authz)
+func renderPrincipal(scope *sht.Scope) *authz.Principal {
+	r := requestFromScope(scope)
+	if r == nil {
+		return nil
+	}
+	return authz.FromRequest(r)
+}
+
+// wrapControllers runs the setup of each controller of the site with the session, the logged user and its roles of the
+// request (`session`, `user` and `principal` in the scope, see session.FromScope, auth.FromScope and authz.FromScope)
+// and in a span
+func wrapControllers(app *syntax.Syntax) {
+	for _, controller := range app.Controllers {
+		name, setup := controller.Name, controller.Setup
+		controller.Setup = func(scope *sht.Scope, params map[string]interface{}) {
+			r := requestFromScope(scope)
+			if r == nil {
+				setup(scope, params)
+				return
+			}
+			if s := session.FromRequest(r); s != nil {
+				scope.Set(session.ScopeKey, s)
+			}
+			if user := auth.FromRequest(r); user != nil {
+				scope.Set(auth.ScopeKey, user)
+			}
+			if p := authz.FromRequest(r); p != nil {
+				scope.Set(authz.ScopeKey, p)
+			}
+			if t := tenant.FromRequest(r); t != nil {
+				scope.Set(tenant.ScopeKey, t)
+			}
+			_, span := trace.Start(r.Context(), "controller "+name, trace.KindInternal)
+			defer span.End()
+			setup(scope, params)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/syntax-framework/demo/server/authz"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+// the directives of the pages see the principal of the request being rendered, not of any other request
+func Test_render_request(t *testing.T) {
+	dir := t.TempDir()
+	content := `<page title="Test"/><div roles-allowed="admin">admin only</div>`
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := syntax.New(&syntax.Config{LiveReload: syntax.ConfigLiveReload{Disabled: true}})
+	app.AddFileSystemDir(dir, 0)
+	authz.Register(app, renderPrincipal)
+	pages := &pageRenderer{app: app}
+	app.Use(siteMiddleware(pages))
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pages.compile(os.DirFS(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer := authz.New(&authz.Policy{}, func(r *http.Request) *authz.Principal {
+		if r.Header.Get("X-User") == "" {
+			return nil
+		}
+		return &authz.Principal{ID: r.Header.Get("X-User"), Roles: []string{"admin"}}
+	})
+	handler := authorizer.Handler(app.Handler)
+
+	for _, user := range []string{"", "1", ""} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("user %q: Expected 200, got %d", user, rec.Code)
+		}
+		if shown := strings.Contains(rec.Body.String(), "admin only"); shown != (user != "") {
+			t.Errorf("user %q: Expected the admin content shown=%v, got %s", user, user != "", rec.Body.String())
+		}
+	}
+}
@@ -88,6 +88,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+
+	"github.com/syntax-framework/demo/server/route"
 )
 
 // Param is a single URL parameter, consisting of a key and a value.
@@ -151,6 +153,16 @@ type mMiddlewares struct {
 type Router struct {
 	handlers    map[string]*mHandlers    // { [HTTP_METHOD] => Handlers }
 	middlewares map[string]*mMiddlewares // { [HTTP_METHOD] => Middlewares }
+
+	// Configurable http.Handler which is called when no matching route is found. If it is not set, http.NotFound is
+	// used.
+	NotFound http.Handler
+}
+
+// Route a registered method + path combo, as returned by Router.Routes
+type Route struct {
+	Method string
+	Path   string
 }
 
 // router.Map(path string, &MyController{});
@@ -472,3 +484,42 @@ func (r *Router) Lookup(method, route string) (*handler, Params) {
 
 	return nil, nil
 }
+
+// ServeHTTP makes the router implement the http.Handler interface.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h, ps := r.Lookup(req.Method, req.URL.Path); h != nil {
+		route.SetPattern(req, h.path)
+		h.fn(w, req, ps)
+		return
+	}
+
+	if r.NotFound != nil {
+		r.NotFound.ServeHTTP(w, req)
+	} else {
+		http.NotFound(w, req)
+	}
+}
+
+// Routes returns all registered routes, sorted by path and method
+func (r *Router) Routes() []Route {
+	var routes []Route
+	for method, root := range r.handlers {
+		for _, handlers := range root.common {
+			for _, h := range handlers {
+				routes = append(routes, Route{Method: method, Path: h.path})
+			}
+		}
+		for _, handlers := range root.catchAll {
+			for _, h := range handlers {
+				routes = append(routes, Route{Method: method, Path: h.path})
+			}
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
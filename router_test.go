@@ -7,6 +7,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
@@ -318,3 +319,32 @@ func catchPanic(testFunc func()) (recv interface{}) {
 	testFunc()
 	return
 }
+
+func Test_serve_http(t *testing.T) {
+	router := &Router{}
+	router.GET("/user/:name", func(w http.ResponseWriter, r *http.Request, ps Params) {
+		w.Write([]byte("user " + ps.ByName("name")))
+	})
+	router.POST("/user/:name", func(w http.ResponseWriter, r *http.Request, ps Params) {})
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("site"))
+	})
+
+	tests := map[string]string{
+		"/user/gopher": "user gopher",
+		"/index.html":  "site",
+	}
+	for path, body := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Body.String() != body {
+			t.Errorf("body mismatch for route '%s': Expected '%s', got '%s'", path, body, w.Body.String())
+		}
+	}
+
+	routes := router.Routes()
+	expected := []Route{{http.MethodGet, "/user/:name"}, {http.MethodPost, "/user/:name"}}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("routes mismatch: Expected %v, got %v", expected, routes)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/syntax-framework/demo/server/config"
+)
+
+// registerMarker line of web/controllers/controllers.go before which the new controllers are registered
+const registerMarker = "// demo new controller:"
+
+var identifierReg = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+func newCommand(args []string) error {
+	opts := config.Options{}
+	flags := newFlagSet("new", &opts)
+	live := flags.Bool("live", false, "controller: create a live controller")
+	title := flags.String("title", "", "page: title of the page")
+	database := flags.String("db", "", "query: `name` of the database, defaults to the only one configured")
+	isCommand := flags.Bool("command", false, "query: create a command (insert, update, delete) instead of a query")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usage(flags, "")
+	}
+
+	kind, name := positional[0], positional[1]
+	var files []string
+	switch kind {
+	case "controller":
+		files, err = newController(".", name, *live)
+	case "page":
+		files, err = newPage(".", name, *title)
+	case "query":
+		files, err = newQuery(".", opts, name, *database, *isCommand)
+	default:
+		return usage(flags, "unknown stub %q, expected controller, page or query", kind)
+	}
+	for _, file := range files {
+		fmt.Println("created " + file)
+	}
+	return err
+}
+
+var controllerTemplate = template.Must(template.New("controller").Parse(`package controllers
+
+import (
+	"github.com/syntax-framework/shtml/sht"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+func {{.Setup}}(scope *sht.Scope, params map[string]interface{}) {
+	// Scope só possui os parametros recebido na tag html (param-name="value")
+
+	scope.Set("value", "Valor da {{.Name}}")
+}
+{{if .Live}}
+func {{.Mount}}(scope *sht.Scope, params map[string]interface{}, live *syntax.LiveState) {
+
+	// Estado de vida longo, eventos enviados pelo client
+
+	live.On("change", func(params map[string]interface{}) {
+		scope.Set("value", "Evento change")
+	})
+}
+{{end}}
+func Register{{.Name}}(app *syntax.Syntax) {
+	app.RegisterController("{{.Name}}", {{.Setup}}, {{if .Live}}{{.Mount}}{{else}}nil{{end}})
+}
+`))
+
+// newController writes web/controllers/<name>.go and registers it in web/controllers/controllers.go
+func newController(root string, name string, live bool) ([]string, error) {
+	if !identifierReg.MatchString(name) {
+		return nil, fmt.Errorf("invalid controller name %q, expected a Go identifier starting with an uppercase letter (Ex. Cart)", name)
+	}
+	if !strings.HasSuffix(name, "Controller") {
+		name += "Controller"
+	}
+
+	dir := filepath.Join(root, "web", "controllers")
+	registry := filepath.Join(dir, "controllers.go")
+	source, err := os.ReadFile(registry)
+	if err != nil {
+		return nil, fmt.Errorf("%w, run the command in the root directory of the application", err)
+	}
+	if bytes.Contains(source, []byte("Register"+name+"(app)")) {
+		return nil, fmt.Errorf("controller %s is already registered in %s", name, registry)
+	}
+	marker := bytes.Index(source, []byte(registerMarker))
+	if marker < 0 {
+		return nil, fmt.Errorf("%s: registration marker %q not found", registry, registerMarker)
+	}
+
+	buf := &bytes.Buffer{}
+	lower := strings.ToLower(name[:1]) + name[1:]
+	err = controllerTemplate.Execute(buf, map[string]interface{}{
+		"Name":  name,
+		"Setup": lower + "Setup",
+		"Mount": lower,
+		"Live":  live,
+	})
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join(dir, kebab(name)+".go")
+	if err = writeNew(file, buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	lineStart := bytes.LastIndexByte(source[:marker], '\n') + 1
+	updated := append([]byte{}, source[:lineStart]...)
+	updated = append(updated, []byte("\tRegister"+name+"(app)\n")...)
+	updated = append(updated, source[lineStart:]...)
+	if updated, err = format.Source(updated); err != nil {
+		return []string{file}, fmt.Errorf("%s: %w", registry, err)
+	}
+	if err = os.WriteFile(registry, updated, 0644); err != nil {
+		return []string{file}, err
+	}
+	return []string{file, registry}, nil
+}
+
+// newPage writes web/<name>.html, published by the framework on the same path
+func newPage(root string, name string, title string) ([]string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+strings.TrimSuffix(name, ".html")), "/")
+	if name == "" || name == "." {
+		return nil, fmt.Errorf("invalid page name, expected a path (Ex. about, blog/post)")
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, "_") {
+			return nil, fmt.Errorf("invalid page name %q, directories and files starting with _ are not published", name)
+		}
+	}
+	if title == "" {
+		words := strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(path.Base(name)))
+		for i, word := range words {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+		title = strings.Join(words, " ")
+	}
+
+	content := fmt.Sprintf("<page\n  title=%q\n/>\n\n<div>\n  <h1>%s</h1>\n</div>\n", title, title)
+	file := filepath.Join(root, "web", filepath.FromSlash(name)+".html")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	if err := writeNew(file, []byte(content)); err != nil {
+		return nil, err
+	}
+	return []string{file}, nil
+}
+
+// newQuery writes db/<database>/queries/<name>.yaml, or commands/ for a command
+func newQuery(root string, opts config.Options, name string, database string, command bool) ([]string, error) {
+	if !identifierReg.MatchString(name) {
+		return nil, fmt.Errorf("invalid query name %q, expected an identifier starting with an uppercase letter (Ex. GetUserByEmail)", name)
+	}
+
+	if database == "" {
+		cfg, err := config.Load(opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.DB) != 1 {
+			return nil, fmt.Errorf("found %d databases in the configuration, inform -db", len(cfg.DB))
+		}
+		for configured := range cfg.DB {
+			database = configured
+		}
+	}
+
+	kind, sql := "queries", "SELECT id FROM my_table WHERE id = :id"
+	if command {
+		kind, sql = "commands", "UPDATE my_table SET id = :id WHERE id = :id"
+	}
+	content := fmt.Sprintf("name: %s\nparams:\n  id: int\nquery: >\n  %s\n", name, sql)
+
+	dir := filepath.Join(root, "db", database, kind)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file := filepath.Join(dir, kebab(name)+".yaml")
+	if err := writeNew(file, []byte(content)); err != nil {
+		return nil, err
+	}
+	return []string{file}, nil
+}
+
+// writeNew creates the file, fails if it already exists
+func writeNew(file string, content []byte) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// kebab converts the identifier to the file name format (Ex. "MyController" => "my-controller")
+func kebab(name string) string {
+	buf := &strings.Builder{}
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// "HTTPServer" => "http-server"
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				buf.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_new_controller(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "web", "controllers")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	source, err := os.ReadFile(filepath.Join("web", "controllers", "controllers.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "controllers.go"), source, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := newController(root, "ShoppingCart", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || filepath.Base(files[0]) != "shopping-cart-controller.go" {
+		t.Fatalf("files: got %v", files)
+	}
+
+	controller, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(controller), `app.RegisterController("ShoppingCartController", shoppingCartControllerSetup, shoppingCartController)`) {
+		t.Errorf("controller: got\n%s", controller)
+	}
+
+	registry, _ := os.ReadFile(files[1])
+	expected := "\tRegisterMyLiveController(app)\n\tRegisterShoppingCartController(app)\n\t" + registerMarker
+	if !strings.Contains(string(registry), expected) {
+		t.Errorf("registry: Expected registration before the marker, got\n%s", registry)
+	}
+
+	if _, err = newController(root, "ShoppingCart", false); err == nil {
+		t.Error("Expected error for a controller already registered")
+	}
+	if _, err = newController(root, "shopping", false); err == nil {
+		t.Error("Expected error for an invalid name")
+	}
+}
+
+func Test_new_page(t *testing.T) {
+	root := t.TempDir()
+	files, err := newPage(root, "blog/my-post", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(files[0])
+	if !strings.HasPrefix(string(content), "<page\n  title=\"My Post\"\n/>") {
+		t.Errorf("page: got\n%s", content)
+	}
+	if _, err = newPage(root, "blog/my-post", ""); err == nil {
+		t.Error("Expected error for an existing page")
+	}
+	if _, err = newPage(root, "_layout/x", ""); err == nil {
+		t.Error("Expected error for a page in an ignored directory")
+	}
+}
+
+func Test_kebab(t *testing.T) {
+	tests := map[string]string{
+		"MyController":   "my-controller",
+		"GetUserByEmail": "get-user-by-email",
+		"HTTPServer":     "http-server",
+	}
+	for name, expected := range tests {
+		if got := kebab(name); got != expected {
+			t.Errorf("kebab(%s): Expected '%s', got '%s'", name, expected, got)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package accesslog writes one line per request with the method, the matched route pattern, the status, the response
+// size, the duration, the remote address and the request ID (see server/requestid).
+//
+// Two formats are supported:
+//
+//	json      {"time":"...","method":"GET","route":"/user/:name","path":"/user/alex","status":200,...}
+//	combined  Apache Combined Log Format, followed by the duration in seconds, the route and the request ID
+//	          127.0.0.1 - - [18/Oct/2026:10:00:00 +0000] "GET /user/alex HTTP/1.1" 200 512 "-" "curl/8.0" 0.001 "/user/:name" "-"
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/clientip"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/route"
+	"github.com/syntax-framework/demo/server/secrets"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatCombined = "combined"
+)
+
+// Logger the access log middleware
+type Logger struct {
+	Format     string    // FormatJSON or FormatCombined. Defaults to FormatJSON
+	Output     io.Writer // Defaults to os.Stdout
+	Sample     float64   // Fraction of the requests logged, errors (status >= 500) are always logged. 0 logs all
+	Exclude    []string  // Paths not logged, a `*` suffix matches the prefix (Ex. /assets/*)
+	TrustProxy bool      // Remote address from X-Real-IP or the last X-Forwarded-For entry, set by the proxy
+
+	mutex sync.Mutex
+}
+
+// Entry a line of the log
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route,omitempty"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"-"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"duration_ms"`
+	Remote    string    `json:"remote"`
+	RequestID string    `json:"request_id,omitempty"`
+	Referer   string    `json:"-"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// New creates the logger of the configuration, opening the output file. Returns nil when the log is disabled.
+func New(cfg config.AccessLog) (*Logger, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+	logger := &Logger{
+		Format:     strings.ToLower(cfg.Format),
+		Sample:     cfg.Sample,
+		Exclude:    cfg.Exclude,
+		TrustProxy: cfg.TrustProxy,
+	}
+	switch cfg.Output {
+	case "", "stdout":
+		logger.Output = os.Stdout
+	case "stderr":
+		logger.Output = os.Stderr
+	default:
+		file, err := os.OpenFile(cfg.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		logger.Output = file
+	}
+	// a secret of the configuration can end up on the query string (Ex. a webhook token)
+	logger.Output = secrets.Writer(logger.Output)
+	return logger, nil
+}
+
+// Handler logs the requests served by next
+func (l *Logger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w, r, info := route.Track(w, r)
+		next.ServeHTTP(w, r)
+
+		if info.Status < 500 && l.Sample > 0 && l.Sample < 1 && rand.Float64() >= l.Sample {
+			return
+		}
+		l.Log(l.entry(r, info))
+	})
+}
+
+// Log writes the entry
+func (l *Logger) Log(entry *Entry) {
+	var line []byte
+	if l.Format == FormatCombined {
+		line = []byte(combined(entry))
+	} else {
+		line, _ = json.Marshal(entry)
+	}
+	line = append(line, '\n')
+
+	output := l.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	l.mutex.Lock()
+	output.Write(line)
+	l.mutex.Unlock()
+}
+
+func (l *Logger) entry(r *http.Request, info *route.Info) *Entry {
+	status := info.Status
+	if status == 0 {
+		// the handler did not write anything
+		status = http.StatusOK
+	}
+	return &Entry{
+		Time:      info.Start,
+		Method:    r.Method,
+		Route:     info.Pattern,
+		Path:      r.URL.RequestURI(),
+		Proto:     r.Proto,
+		Status:    status,
+		Bytes:     info.Bytes,
+		Duration:  float64(time.Since(info.Start).Microseconds()) / 1000,
+		Remote:    l.remote(r),
+		RequestID: requestid.FromRequest(r),
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+func (l *Logger) excluded(path string) bool {
+	for _, pattern := range l.Exclude {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logger) remote(r *http.Request) string {
+	if ip := clientip.FromRequest(r, l.TrustProxy); ip != "" {
+		return ip
+	}
+	return "-"
+}
+
+func combined(e *Entry) string {
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %d "%s" "%s" %.6f "%s" "%s"`,
+		e.Remote,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.Path, e.Proto,
+		e.Status, e.Bytes,
+		dash(e.Referer), dash(e.UserAgent),
+		e.Duration/1000,
+		dash(e.Route), dash(e.RequestID),
+	)
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
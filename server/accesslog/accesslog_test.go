@@ -0,0 +1,80 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/route"
+)
+
+func handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		route.SetPattern(r, "/user/:name")
+		w.Write([]byte("hello"))
+	})
+}
+
+func Test_json(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := &Logger{Output: out, Exclude: []string{"/healthz", "/assets/*"}, TrustProxy: true}
+	server := requestid.Handler(logger.Handler(handler()))
+
+	for _, path := range []string{"/healthz", "/assets/js/a.js", "/user/alex?x=1"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Request-ID", "abc")
+		req.Header.Set("X-Forwarded-For", "10.0.0.1, 203.0.113.9")
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected the excluded paths not to be logged, got %d lines", len(lines))
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal([]byte(lines[0]), entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Method != "GET" || entry.Route != "/user/:name" || entry.Path != "/user/alex?x=1" || entry.Status != 200 ||
+		entry.Bytes != 5 || entry.Remote != "203.0.113.9" || entry.RequestID != "abc" {
+		t.Errorf("got %+v", entry)
+	}
+}
+
+func Test_combined(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := &Logger{Output: out, Format: FormatCombined}
+	req := httptest.NewRequest(http.MethodGet, "/user/alex", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "curl/8.0")
+	requestid.Handler(logger.Handler(handler())).ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := regexp.MustCompile(`^192\.0\.2\.1 - - \[[^]]+] "GET /user/alex HTTP/1\.1" 200 5 "-" "curl/8\.0" [0-9.]+ "/user/:name" "[0-9A-Z]{26}"\n$`)
+	if !expected.MatchString(out.String()) {
+		t.Errorf("got %s", out.String())
+	}
+}
+
+func Test_sample(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := &Logger{Output: out, Sample: 0.000001}
+	server := logger.Handler(handler())
+	for i := 0; i < 50; i++ {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user/alex", nil))
+	}
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"status":500`) {
+		t.Errorf("Expected only the error to be logged, got %v", lines)
+	}
+}
@@ -0,0 +1,213 @@
+// Package apiauth authenticates the machine clients, which do not keep a cookie session, by the `api` block of
+// config.yaml:
+//
+//	api:
+//	  tokens:
+//	    db: mydatabase
+//	    routes: [ "/api/*" ]
+//	  hmac:
+//	    routes: [ "POST /integrations/inbound/*" ]
+//	    keys:
+//	      partner: { secret: "${PARTNER_KEY}", scopes: [ orders.write ] }
+//
+// API tokens (`demo tokens create`) are sent as `Authorization: Bearer stx_...`. Only their SHA-256 is stored, each
+// one has a name, scopes, an expiration and can be revoked.
+//
+// Server to server calls sign the request with a shared key (see Sign): the signature covers the method, the path,
+// the query, a timestamp, a nonce and the SHA-256 of the body. Requests with a timestamp outside of the allowed skew or
+// with a nonce already used are rejected (replay).
+//
+// The credentials are checked on any route when present, the routes of the configuration reject the requests without
+// them (401). The scopes are the permissions of the principal of the request (see server/authz).
+package apiauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/redis"
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/route"
+)
+
+const (
+	KindToken = "token"
+	KindHMAC  = "hmac"
+)
+
+var (
+	ErrMissing  = errors.New("apiauth: credentials required")
+	ErrInvalid  = errors.New("apiauth: invalid credentials")
+	ErrExpired  = errors.New("apiauth: expired credentials")
+	ErrReplay   = errors.New("apiauth: request already received")
+	ErrTooLarge = errors.New("apiauth: the body of the signed request is too large")
+)
+
+var requests = metrics.NewCounter("apiauth_requests_total", "Requests of the machine clients, by kind of credential and result", "kind", "result")
+
+// Credential the authenticated machine client
+type Credential struct {
+	Kind   string // KindToken or KindHMAC
+	ID     string // Token ID or key ID
+	Name   string
+	Scopes []string
+}
+
+// Principal the identifier of the client on the logs, on the rate limits and on the authorization ("token:<id>")
+func (c *Credential) Principal() string {
+	return c.Kind + ":" + c.ID
+}
+
+type credentialKey struct{}
+
+// FromRequest returns the credential of the request, nil when it was not authenticated by a token or a signature
+func FromRequest(r *http.Request) *Credential {
+	return FromContext(r.Context())
+}
+
+// WithCredential returns a copy of the context with the authenticated client
+func WithCredential(ctx context.Context, c *Credential) context.Context {
+	return context.WithValue(ctx, credentialKey{}, c)
+}
+
+// FromContext returns the credential of the request of the context
+func FromContext(ctx context.Context) *Credential {
+	c, _ := ctx.Value(credentialKey{}).(*Credential)
+	return c
+}
+
+// Authenticator checks the API tokens and the signed requests
+type Authenticator struct {
+	Tokens *Tokens   // nil when the tokens are disabled
+	HMAC   *Verifier // nil without keys
+}
+
+// New creates the authenticator of the configuration, nil when neither the tokens nor the keys are configured. The
+// tokens are kept on the named database, the nonces of the signed requests on the named redis connection.
+func New(cfg config.API, dbs map[string]*db.DB, clients map[string]*redis.Client) (*Authenticator, error) {
+	a := &Authenticator{}
+	if cfg.Tokens.DB != "" {
+		conn, exists := dbs[cfg.Tokens.DB]
+		if !exists {
+			return nil, fmt.Errorf("apiauth: there is no db connection named %q", cfg.Tokens.DB)
+		}
+		routes, err := parseRoutes("tokens", cfg.Tokens.Routes)
+		if err != nil {
+			return nil, err
+		}
+		a.Tokens = &Tokens{Store: &SQL{DB: conn}, Routes: routes, TTL: cfg.Tokens.TTL.Std()}
+	}
+	if len(cfg.HMAC.Keys) > 0 {
+		routes, err := parseRoutes("hmac", cfg.HMAC.Routes)
+		if err != nil {
+			return nil, err
+		}
+		v := &Verifier{Keys: map[string]*Key{}, Routes: routes, Skew: cfg.HMAC.Skew.Std()}
+		for id, key := range cfg.HMAC.Keys {
+			v.Keys[id] = &Key{Secret: []byte(key.Secret), Scopes: key.Scopes}
+		}
+		switch cfg.HMAC.Store {
+		case "", "memory":
+			v.Nonces = &MemoryNonces{}
+		case "redis":
+			client, exists := clients[cfg.HMAC.Redis]
+			if !exists {
+				return nil, fmt.Errorf("apiauth: there is no redis connection named %q", cfg.HMAC.Redis)
+			}
+			v.Nonces = &RedisNonces{Client: client, Prefix: "apiauth:nonce:"}
+		default:
+			return nil, fmt.Errorf("apiauth: unknown store %q", cfg.HMAC.Store)
+		}
+		a.HMAC = v
+	}
+	if a.Tokens == nil && a.HMAC == nil {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// Handler authenticates the requests with a token (`Authorization: Bearer stx_...`) or a signature
+// (`Authorization: HMAC-SHA256 ...`), see FromRequest. Invalid credentials and the requests of the configured routes
+// without the credential of its kind are rejected with 401.
+func (a *Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		var c *Credential
+		var err error
+		kind := ""
+		switch {
+		case a.Tokens != nil && isToken(header):
+			kind = KindToken
+			c, err = a.Tokens.Authenticate(r)
+		case a.HMAC != nil && isSignature(header):
+			kind = KindHMAC
+			c, err = a.HMAC.Authenticate(r)
+		}
+
+		if err == nil {
+			tokenRoute := a.Tokens != nil && matches(a.Tokens.Routes, r)
+			hmacRoute := a.HMAC != nil && matches(a.HMAC.Routes, r)
+			if (tokenRoute || hmacRoute) && !(kind == KindToken && tokenRoute || kind == KindHMAC && hmacRoute) {
+				err = ErrMissing
+				if kind == "" {
+					kind = KindToken
+					if !tokenRoute {
+						kind = KindHMAC
+					}
+				}
+			}
+		}
+		if err != nil {
+			message, status := strings.TrimPrefix(err.Error(), "apiauth: "), http.StatusUnauthorized
+			switch {
+			case errors.Is(err, ErrTooLarge):
+				status = http.StatusRequestEntityTooLarge
+			case !errors.Is(err, ErrMissing) && !errors.Is(err, ErrInvalid) && !errors.Is(err, ErrExpired) && !errors.Is(err, ErrReplay):
+				// the store failed, the client only receives a generic message
+				log.Printf("apiauth: %s %s: %v (request %s)", r.Method, r.URL.Path, err, requestid.FromRequest(r))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			requests.Inc(kind, "rejected")
+			log.Printf("apiauth: %s %s rejected: %s (request %s)", r.Method, r.URL.Path, message, requestid.FromRequest(r))
+			if kind == KindToken {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			}
+			http.Error(w, message, status)
+			return
+		}
+		if c != nil {
+			requests.Inc(kind, "ok")
+			r = r.WithContext(WithCredential(r.Context(), c))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func matches(routes []*route.Rule, r *http.Request) bool {
+	for _, rule := range routes {
+		if _, matched := rule.Match(r); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRoutes(section string, values []string) ([]*route.Rule, error) {
+	var routes []*route.Rule
+	for i, value := range values {
+		rule, err := route.ParseRule(value)
+		if err != nil {
+			return nil, fmt.Errorf("apiauth: %s.routes[%d]: %w", section, i, err)
+		}
+		routes = append(routes, rule)
+	}
+	return routes, nil
+}
@@ -0,0 +1,218 @@
+package apiauth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/tenant"
+)
+
+func newTestAuthenticator(t *testing.T) (*Authenticator, *time.Time) {
+	conn, err := db.Open("test", &config.DB{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	cfg := config.API{
+		Tokens: config.APITokens{DB: "test", Routes: []string{"/api/*"}, TTL: config.Duration(time.Hour)},
+		HMAC: config.APIHMAC{
+			Routes: []string{"POST /hooks/*"},
+			Skew:   config.Duration(5 * time.Minute),
+			Keys:   map[string]*config.APIKey{"partner": {Secret: "s3cr3t", Scopes: []string{"orders.write"}}},
+		},
+	}
+	a, err := New(cfg, map[string]*db.DB{"test": conn}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a.Tokens.now = func() time.Time { return now }
+	a.HMAC.now = func() time.Time { return now }
+	return a, &now
+}
+
+func serve(a *Authenticator, r *http.Request) (int, string) {
+	rec := httptest.NewRecorder()
+	a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if c := FromRequest(r); c != nil {
+			w.Write([]byte(c.Principal() + " " + strings.Join(c.Scopes, ",") + " "))
+		}
+		w.Write(body)
+	})).ServeHTTP(rec, r)
+	return rec.Code, rec.Body.String()
+}
+
+func Test_tokens(t *testing.T) {
+	a, now := newTestAuthenticator(t)
+	ctx := context.Background()
+	secret, token, err := a.Tokens.Create(ctx, "billing", []string{"orders.read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, TokenPrefix+token.ID+"_") || token.Expires.Sub(token.Created) != time.Hour {
+		t.Errorf("got %s %+v", secret, token)
+	}
+	stored, _ := a.Tokens.Store.Token(ctx, token.ID)
+	if stored.Hash == "" || strings.Contains(secret, stored.Hash) {
+		t.Errorf("expected the hash of the secret, got %q", stored.Hash)
+	}
+
+	request := func(path, authorization string) (int, string) {
+		r := httptest.NewRequest("GET", path, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		return serve(a, r)
+	}
+	tests := []struct {
+		path, authorization string
+		expected            int
+		body                string
+	}{
+		{"/api/orders", "", http.StatusUnauthorized, "credentials required"},
+		{"/api/orders", "Bearer " + secret, http.StatusOK, "token:" + token.ID + " orders.read "},
+		{"/api/orders", "bearer " + secret, http.StatusOK, "token:" + token.ID},
+		{"/api/orders", "Bearer " + secret + "x", http.StatusUnauthorized, "invalid credentials"},
+		{"/api/orders", "Bearer " + TokenPrefix + "0000000000000000_abc", http.StatusUnauthorized, "invalid credentials"},
+		{"/api/orders", "Bearer other-token", http.StatusUnauthorized, "credentials required"},
+		{"/page", "", http.StatusOK, ""},
+		{"/page", "Bearer " + secret, http.StatusOK, "token:" + token.ID},
+		{"/page", "Bearer " + secret + "x", http.StatusUnauthorized, "invalid credentials"},
+	}
+	for _, test := range tests {
+		if code, body := request(test.path, test.authorization); code != test.expected || !strings.Contains(body, test.body) {
+			t.Errorf("%s %q: got %d %q, expected %d %q", test.path, test.authorization, code, body, test.expected, test.body)
+		}
+	}
+
+	*now = now.Add(2 * time.Hour)
+	if code, body := request("/api/orders", "Bearer "+secret); code != http.StatusUnauthorized || !strings.Contains(body, "expired") {
+		t.Errorf("expired: got %d %s", code, body)
+	}
+
+	forever, token2, _ := a.Tokens.Create(ctx, "sync", nil, -1)
+	if !token2.Expires.IsZero() {
+		t.Errorf("expected no expiration, got %s", token2.Expires)
+	}
+	if code, _ := request("/api/orders", "Bearer "+forever); code != http.StatusOK {
+		t.Errorf("got %d", code)
+	}
+	if err = a.Tokens.Revoke(ctx, forever); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := request("/api/orders", "Bearer "+forever); code != http.StatusUnauthorized {
+		t.Errorf("revoked: got %d", code)
+	}
+	if err = a.Tokens.Revoke(ctx, "unknown"); err == nil {
+		t.Error("expected an error for an unknown token")
+	}
+
+	list, err := a.Tokens.Store.Tokens(ctx)
+	if err != nil || len(list) != 2 || list[1].Revoked.IsZero() == list[0].Revoked.IsZero() {
+		t.Errorf("got %d tokens, %v", len(list), err)
+	}
+}
+
+type partitionKey struct{}
+
+// the tokens minted for a partition (Ex. a tenant) do not authenticate on the others
+func Test_tokens_partition(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	a.Tokens.Partition = func(ctx context.Context) string {
+		partition, _ := ctx.Value(partitionKey{}).(string)
+		return partition
+	}
+	acme := context.WithValue(context.Background(), partitionKey{}, "acme")
+	secret, _, err := a.Tokens.Create(acme, "billing", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for partition, expected := range map[string]int{"acme": http.StatusOK, "globex": http.StatusUnauthorized} {
+		r := httptest.NewRequest("GET", "/api/orders", nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		r = r.WithContext(context.WithValue(r.Context(), partitionKey{}, partition))
+		if code, body := serve(a, r); code != expected {
+			t.Errorf("%s: got %d %q, expected %d", partition, code, body, expected)
+		}
+	}
+}
+
+func Test_hmac(t *testing.T) {
+	a, now := newTestAuthenticator(t)
+	signed := func(method, path, body string, secret string) *http.Request {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if err := Sign(r, "partner", []byte(secret)); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := signed("POST", "/hooks/orders?x=1", `{"id":1}`, "s3cr3t")
+	replay := r.Header.Get("Authorization")
+	if code, body := serve(a, r); code != http.StatusOK || body != `hmac:partner orders.write {"id":1}` {
+		t.Errorf("got %d %q", code, body)
+	}
+
+	r = httptest.NewRequest("POST", "/hooks/orders?x=1", strings.NewReader(`{"id":1}`))
+	r.Header.Set("Authorization", replay)
+	if code, body := serve(a, r); code != http.StatusUnauthorized || !strings.Contains(body, "already received") {
+		t.Errorf("replay: got %d %q", code, body)
+	}
+
+	tampered := []func(r *http.Request){
+		func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader(`{"id":2}`)) },
+		func(r *http.Request) { r.URL.RawQuery, r.RequestURI = "x=2", "/hooks/orders?x=2" },
+		func(r *http.Request) { r.URL.Path, r.RequestURI = "/hooks/other", "/hooks/other?x=1" },
+		func(r *http.Request) { r.Method = "PUT" },
+	}
+	for i, tamper := range tampered {
+		r = signed("POST", "/hooks/orders?x=1", `{"id":1}`, "s3cr3t")
+		tamper(r)
+		if code, _ := serve(a, r); code != http.StatusUnauthorized {
+			t.Errorf("tampered %d: got %d", i, code)
+		}
+	}
+
+	if code, _ := serve(a, signed("POST", "/hooks/orders", "", "wrong")); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: got %d", code)
+	}
+	if code, body := serve(a, httptest.NewRequest("POST", "/hooks/orders", nil)); code != http.StatusUnauthorized || !strings.Contains(body, "required") {
+		t.Errorf("unsigned: got %d %q", code, body)
+	}
+
+	// the signature covers the path sent by the client, not the one left after the prefix of the tenant is removed
+	tenants := tenant.New(config.Tenants{Resolve: []string{"path"}, List: map[string]*config.Tenant{"acme": {Path: "/acme"}, "globex": {Path: "/globex"}}})
+	for path, expected := range map[string]int{"/acme/hooks/orders": http.StatusNotFound, "/globex/hooks/orders": http.StatusUnauthorized} {
+		r = signed("POST", "/acme/hooks/orders", `{"id":1}`, "s3cr3t")
+		r.URL.Path, r.RequestURI = path, path
+		rec := httptest.NewRecorder()
+		tenants.Handler(a.Handler(http.NotFoundHandler())).ServeHTTP(rec, r)
+		if rec.Code != expected {
+			t.Errorf("tenant %s: got %d", path, rec.Code)
+		}
+	}
+
+	r = signed("POST", "/hooks/orders", "", "s3cr3t")
+	*now = now.Add(6 * time.Minute)
+	if code, body := serve(a, r); code != http.StatusUnauthorized || !strings.Contains(body, "expired") {
+		t.Errorf("old timestamp: got %d %q", code, body)
+	}
+
+	// a token does not open the signed routes
+	secret, _, _ := a.Tokens.Create(context.Background(), "billing", nil, 0)
+	r = httptest.NewRequest("POST", "/hooks/orders", nil)
+	r.Header.Set("Authorization", "Bearer "+secret)
+	if code, _ := serve(a, r); code != http.StatusUnauthorized {
+		t.Errorf("token on a signed route: got %d", code)
+	}
+}
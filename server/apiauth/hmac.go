@@ -0,0 +1,213 @@
+package apiauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/redis"
+	"github.com/syntax-framework/demo/server/route"
+)
+
+// Scheme of the Authorization header of the signed requests:
+//
+//	Authorization: HMAC-SHA256 key=partner, ts=1700000000, nonce=3q2-7wX0, sig=<base64 of the HMAC>
+const Scheme = "HMAC-SHA256"
+
+// MaxSignedBody size of the bodies of the signed requests, the body is read to verify its hash
+const MaxSignedBody = 10 << 20
+
+// Key a key shared with a client
+type Key struct {
+	Secret []byte
+	Scopes []string
+}
+
+// Nonces remembers the nonces already used
+type Nonces interface {
+	// Use marks the nonce as used for the ttl, false if it was already used
+	Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// Verifier checks the signed requests
+type Verifier struct {
+	Keys   map[string]*Key // By key id
+	Routes []*route.Rule   // Require a signed request
+	Skew   time.Duration   // Max difference between the timestamp of the request and the clock
+	Nonces Nonces
+	now    func() time.Time
+}
+
+func (v *Verifier) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+// Authenticate checks the signature, the timestamp and the nonce of the request. The body is restored.
+func (v *Verifier) Authenticate(r *http.Request) (*Credential, error) {
+	params := parseSignature(r.Header.Get("Authorization"))
+	key := v.Keys[params["key"]]
+	if key == nil || params["nonce"] == "" || params["sig"] == "" {
+		return nil, ErrInvalid
+	}
+	ts, err := strconv.ParseInt(params["ts"], 10, 64)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	if diff := v.clock().Sub(time.Unix(ts, 0)); diff > v.Skew || diff < -v.Skew {
+		return nil, ErrExpired
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["sig"])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(signature, sign(key.Secret, r, params["ts"], params["nonce"], body)) {
+		return nil, ErrInvalid
+	}
+
+	// the timestamps are accepted within ±Skew, the nonces are kept for the whole window
+	first, err := v.Nonces.Use(r.Context(), params["key"]+":"+params["nonce"], 2*v.Skew)
+	if err != nil {
+		return nil, err
+	} else if !first {
+		return nil, ErrReplay
+	}
+	return &Credential{Kind: KindHMAC, ID: params["key"], Name: params["key"], Scopes: key.Scopes}, nil
+}
+
+// Sign signs the request with the key, for the clients of the signed routes (Ex. outbound integrations, tests). The
+// body is restored.
+func Sign(r *http.Request, keyID string, secret []byte) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+	signature := base64.StdEncoding.EncodeToString(sign(secret, r, ts, encoded, body))
+	r.Header.Set("Authorization", fmt.Sprintf("%s key=%s, ts=%s, nonce=%s, sig=%s", Scheme, keyID, ts, encoded, signature))
+	return nil
+}
+
+// sign the HMAC-SHA256 of the method, the path and the query, the timestamp, the nonce and the SHA-256 of the body
+func sign(secret []byte, r *http.Request, ts, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{Scheme, r.Method, target(r), ts, nonce, hex.EncodeToString(sum[:])}, "\n")))
+	return mac.Sum(nil)
+}
+
+// target the path and the query sent by the client. The received requests use the RequestURI, the URL may have been
+// rewritten before the verification (Ex. the prefix of the tenant removed from the path).
+func target(r *http.Request) string {
+	if strings.HasPrefix(r.RequestURI, "/") {
+		return r.RequestURI
+	}
+	if r.RequestURI != "" {
+		// absolute form (Ex. GET https://example.org/path HTTP/1.1)
+		if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			return u.RequestURI()
+		}
+	}
+	return r.URL.RequestURI()
+}
+
+// readBody reads the body up to MaxSignedBody and restores it
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxSignedBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxSignedBody {
+		return nil, ErrTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// isSignature reports whether the Authorization header carries a signature
+func isSignature(header string) bool {
+	return len(header) > len(Scheme) && strings.EqualFold(header[:len(Scheme)+1], Scheme+" ")
+}
+
+// parseSignature the `name=value` pairs of the header
+func parseSignature(header string) map[string]string {
+	params := map[string]string{}
+	for _, pair := range strings.Split(header[len(Scheme)+1:], ",") {
+		if name, value, found := strings.Cut(strings.TrimSpace(pair), "="); found {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// MemoryNonces keeps the nonces in the memory of the process, replays on other instances are not detected
+type MemoryNonces struct {
+	mutex   sync.Mutex
+	expires map[string]time.Time
+	sweep   time.Time
+}
+
+func (m *MemoryNonces) Use(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if m.expires == nil {
+		m.expires = map[string]time.Time{}
+	}
+	if now.After(m.sweep) {
+		for key, expires := range m.expires {
+			if now.After(expires) {
+				delete(m.expires, key)
+			}
+		}
+		m.sweep = now.Add(ttl)
+	}
+	if expires, used := m.expires[nonce]; used && now.Before(expires) {
+		return false, nil
+	}
+	m.expires[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// RedisNonces keeps the nonces on a redis server (SET NX), shared by all instances
+type RedisNonces struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (r *RedisNonces) Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	_, err := r.Client.Do(ctx, "SET", r.Prefix+nonce, "1", "NX", "PX", ttl.Milliseconds())
+	if errors.Is(err, redis.ErrNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
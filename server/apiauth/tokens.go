@@ -0,0 +1,282 @@
+package apiauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/route"
+)
+
+// TokenPrefix of the API tokens, identifies them on the Authorization header and on the secret scanners
+const TokenPrefix = "stx_"
+
+// Token an API token. The secret is only known when the token is created, the store keeps its SHA-256.
+type Token struct {
+	ID      string
+	Name    string // Description of the client (Ex. "billing job")
+	Hash    string
+	Scopes  []string
+	Created time.Time
+	Expires time.Time // Zero never expires
+	Revoked time.Time // Zero while valid
+}
+
+// Valid reports whether the token can be used at the time
+func (t *Token) Valid(now time.Time) bool {
+	return t.Revoked.IsZero() && (t.Expires.IsZero() || now.Before(t.Expires))
+}
+
+// Store persists the API tokens
+type Store interface {
+	CreateToken(ctx context.Context, token *Token) error
+	// Token returns the token, nil if it does not exist
+	Token(ctx context.Context, id string) (*Token, error)
+	// Tokens returns all tokens, the most recent first
+	Tokens(ctx context.Context) ([]*Token, error)
+	// RevokeToken marks the token as revoked, false if it does not exist
+	RevokeToken(ctx context.Context, id string, at time.Time) (bool, error)
+}
+
+// Tokens mints and checks the API tokens
+type Tokens struct {
+	Store  Store
+	Routes []*route.Rule // Require a token
+	TTL    time.Duration // Default validity of the new tokens, negative never expires
+	// Partition of the context (Ex. the tenant), the tokens minted on another partition are not accepted
+	Partition func(ctx context.Context) string
+	now       func() time.Time
+}
+
+func (t *Tokens) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// Create mints a token with the scopes (permissions of server/authz), ttl 0 uses the default TTL and a negative one
+// never expires. Returns the secret to be given to the client, it can not be recovered later.
+func (t *Tokens) Create(ctx context.Context, name string, scopes []string, ttl time.Duration) (string, *Token, error) {
+	if ttl == 0 {
+		ttl = t.TTL
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	token := &Token{ID: hex.EncodeToString(id), Name: name, Hash: t.hash(ctx, encoded), Scopes: scopes, Created: t.clock()}
+	if ttl > 0 {
+		token.Expires = token.Created.Add(ttl)
+	}
+	if err := t.Store.CreateToken(ctx, token); err != nil {
+		return "", nil, err
+	}
+	return TokenPrefix + token.ID + "_" + encoded, token, nil
+}
+
+// Revoke invalidates the token immediately, by the ID or by the token itself
+func (t *Tokens) Revoke(ctx context.Context, id string) error {
+	if token := strings.TrimPrefix(id, TokenPrefix); token != id {
+		id, _, _ = strings.Cut(token, "_")
+	}
+	revoked, err := t.Store.RevokeToken(ctx, id, t.clock())
+	if err != nil {
+		return err
+	} else if !revoked {
+		return fmt.Errorf("apiauth: there is no token %q", id)
+	}
+	return nil
+}
+
+// Authenticate checks the Bearer token of the request
+func (t *Tokens) Authenticate(r *http.Request) (*Credential, error) {
+	value := strings.TrimSpace(r.Header.Get("Authorization")[len("Bearer "):])
+	id, secret, found := strings.Cut(strings.TrimPrefix(value, TokenPrefix), "_")
+	if !found || len(id) != 16 || secret == "" {
+		return nil, ErrInvalid
+	}
+	token, err := t.Store.Token(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || subtle.ConstantTimeCompare([]byte(token.Hash), []byte(t.hash(r.Context(), secret))) != 1 {
+		return nil, ErrInvalid
+	}
+	if !token.Revoked.IsZero() {
+		return nil, ErrInvalid
+	}
+	if !token.Valid(t.clock()) {
+		return nil, ErrExpired
+	}
+	return &Credential{Kind: KindToken, ID: token.ID, Name: token.Name, Scopes: token.Scopes}, nil
+}
+
+// isToken reports whether the Authorization header carries an API token, other Bearer tokens are ignored
+func isToken(header string) bool {
+	return len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") && strings.HasPrefix(strings.TrimSpace(header[7:]), TokenPrefix)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// hash the hash of the secret includes the partition, the token does not authenticate on the others
+func (t *Tokens) hash(ctx context.Context, secret string) string {
+	if t.Partition == nil {
+		return hashSecret(secret)
+	}
+	return hashSecret(t.Partition(ctx) + ":" + secret)
+}
+
+// SQL keeps the tokens on the table api_tokens of the database, created on the first use
+type SQL struct {
+	DB *db.DB
+
+	mutex sync.Mutex
+	ready bool
+}
+
+const schema = `CREATE TABLE IF NOT EXISTS api_tokens (
+	id VARCHAR(32) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	hash VARCHAR(64) NOT NULL,
+	scopes VARCHAR(1024) NOT NULL,
+	created BIGINT NOT NULL,
+	expires BIGINT NOT NULL,
+	revoked BIGINT NOT NULL
+)`
+
+// init creates the table, retried on the next use when it fails
+func (s *SQL) init(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ready {
+		return nil
+	}
+	if _, err := s.DB.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("apiauth: creating the table on db.%s: %w", s.DB.Name, err)
+	}
+	s.ready = true
+	return nil
+}
+
+// sql replaces the `?` of the statement by the placeholders of the engine
+func (s *SQL) sql(statement string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range statement {
+		if c == '?' {
+			n++
+			b.WriteString(s.DB.Placeholder(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+const tokenColumns = "id, name, hash, scopes, created, expires, revoked"
+
+func (s *SQL) CreateToken(ctx context.Context, t *Token) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, s.sql("INSERT INTO api_tokens ("+tokenColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		t.ID, t.Name, t.Hash, strings.Join(t.Scopes, " "), t.Created.Unix(), unix(t.Expires), unix(t.Revoked))
+	return err
+}
+
+func (s *SQL) Token(ctx context.Context, id string) (*Token, error) {
+	if err := s.init(ctx); err != nil {
+		return nil, err
+	}
+	t, err := scanToken(s.DB.QueryRowContext(ctx, s.sql("SELECT "+tokenColumns+" FROM api_tokens WHERE id = ?"), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *SQL) Tokens(ctx context.Context) ([]*Token, error) {
+	if err := s.init(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+tokenColumns+" FROM api_tokens ORDER BY created DESC, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []*Token
+	for rows.Next() {
+		t, errScan := scanToken(rows)
+		if errScan != nil {
+			return nil, errScan
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *SQL) RevokeToken(ctx context.Context, id string, at time.Time) (bool, error) {
+	if err := s.init(ctx); err != nil {
+		return false, err
+	}
+	result, err := s.DB.ExecContext(ctx, s.sql("UPDATE api_tokens SET revoked = ? WHERE id = ? AND revoked = 0"), at.Unix(), id)
+	if err != nil {
+		return false, err
+	}
+	if updated, _ := result.RowsAffected(); updated > 0 {
+		return true, nil
+	}
+	// already revoked
+	t, err := s.Token(ctx, id)
+	return t != nil, err
+}
+
+// scanner a sql.Row or sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanToken(row scanner) (*Token, error) {
+	t := &Token{}
+	var scopes string
+	var created, expires, revoked int64
+	if err := row.Scan(&t.ID, &t.Name, &t.Hash, &scopes, &created, &expires, &revoked); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	t.Created = time.Unix(created, 0)
+	if expires > 0 {
+		t.Expires = time.Unix(expires, 0)
+	}
+	if revoked > 0 {
+		t.Revoked = time.Unix(revoked, 0)
+	}
+	return t, nil
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
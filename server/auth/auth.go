@@ -0,0 +1,488 @@
+// Package auth authenticates the users of the site, by the `auth` block of config.yaml:
+//
+//	auth:
+//	  db: mydatabase             # users on the tables auth_users and auth_tokens
+//	  salt: ${AUTH_PEPPER:}      # pepper of the password hashes
+//	  ttl: 12h                   # max duration of a login, 0 keeps it while the session lasts
+//	  controller: minhaController
+//	  registration: true
+//	  lockout: { attempts: 5, duration: 15m }
+//
+// The endpoints (see Routes) accept forms or JSON: POST /login, /logout, /register, /password/forgot and
+// /password/reset. The login is kept in the session (see server/session), renewed on login to prevent fixation and
+// destroyed on logout. The remember-me cookie logs the user in again after the session expires, its token is single
+// use and rotated on every use.
+//
+// Accounts are locked for a while after consecutive failed logins. The applications customise the lookup of the users,
+// the registration and the delivery of the reset links with a Controller, selected by `auth.controller`.
+//
+// Handlers read the user with FromRequest, the controllers with FromScope.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/session"
+	"github.com/syntax-framework/shtml/sht"
+)
+
+// ScopeKey name of the logged user in the scope of the controllers
+const ScopeKey = "user"
+
+// RememberCookie keeps the remember-me token
+const RememberCookie = "__Host-remember"
+
+// keys of the login in the session
+const (
+	sessionUser = "auth.user"
+	sessionTime = "auth.time" // Unix time of the login
+)
+
+var (
+	ErrInvalidCredentials = errors.New("auth: invalid email or password")
+	ErrLocked             = errors.New("auth: account locked after too many failed logins, try again later")
+	ErrInvalidToken       = errors.New("auth: invalid or expired token")
+	ErrDenied             = errors.New("auth: login denied")
+	ErrNoSession          = errors.New("auth: the sessions are required")
+)
+
+var logins = metrics.NewCounter("auth_logins_total", "Login attempts, by result", "result")
+
+// Controller customises the authentication, all hooks are optional. Registered by the application with
+// RegisterController and selected by `auth.controller`.
+type Controller struct {
+	// Lookup finds the user of the login (Ex. by username), defaults to Store.UserByEmail
+	Lookup func(ctx context.Context, store Store, login string) (*User, error)
+	// Register validates or completes the new user before it is saved, an error rejects the registration
+	Register func(r *http.Request, user *User) error
+	// Authorize is called after the password is verified, an error denies the login (Ex. unconfirmed email)
+	Authorize func(r *http.Request, user *User) error
+	// SendReset delivers the password reset link (Ex. by email). Without it the link is only logged in dev mode.
+	SendReset func(ctx context.Context, user *User, link string) error
+}
+
+var (
+	controllersMutex sync.RWMutex
+	controllers      = map[string]*Controller{}
+)
+
+// RegisterController makes the controller available to the `auth.controller` configuration
+func RegisterController(name string, controller *Controller) {
+	controllersMutex.Lock()
+	defer controllersMutex.Unlock()
+	controllers[name] = controller
+}
+
+// Auth logs the users in and out
+type Auth struct {
+	Store          Store
+	Hasher         *Hasher
+	Controller     *Controller // Resolved by Init from ControllerName
+	ControllerName string
+	TTL            time.Duration // Max duration of a login, 0 keeps it while the session lasts
+	RememberMe     time.Duration // 0 disables the remember-me
+	ResetTTL       time.Duration
+	MinPassword    int
+	Registration   bool
+	Redirect       string // Page after login and logout
+	BaseURL        string // Public URL of the site, the links and callbacks sent to the users are not built from the Host header
+	Attempts       int    // Failed logins before the lock, 0 disables the lockout
+	LockDuration   time.Duration
+	Dev            bool // Logs the password reset links when the controller does not send them
+	// Partition of the request (Ex. the tenant), the remember-me tokens issued on another partition are not accepted
+	Partition func(r *http.Request) string
+
+	now       func() time.Time
+	dummyOnce sync.Once
+	dummy     string // Verified for unknown users, the response takes the same time
+}
+
+// New creates the authentication of the configuration, nil when `auth.db` is empty
+func New(cfg config.Auth, dbs map[string]*db.DB, dev bool) (*Auth, error) {
+	if cfg.DB == "" {
+		return nil, nil
+	}
+	conn, exists := dbs[cfg.DB]
+	if !exists {
+		return nil, fmt.Errorf("auth: there is no db connection named %q", cfg.DB)
+	}
+	a := &Auth{
+		Store:          &SQL{DB: conn},
+		Hasher:         &Hasher{Algorithm: cfg.Hash, Pepper: []byte(cfg.Salt)},
+		ControllerName: cfg.Controller,
+		TTL:            cfg.TTL.Std(),
+		ResetTTL:       cfg.ResetTTL.Std(),
+		MinPassword:    cfg.MinPassword,
+		Registration:   cfg.Registration,
+		Redirect:       cfg.Redirect,
+		BaseURL:        cfg.BaseURL,
+		LockDuration:   cfg.Lockout.Duration.Std(),
+		Dev:            dev,
+	}
+	if cfg.RememberMe > 0 {
+		a.RememberMe = cfg.RememberMe.Std()
+	}
+	if cfg.Lockout.Attempts > 0 {
+		a.Attempts = cfg.Lockout.Attempts
+	}
+	return a, nil
+}
+
+// Init resolves the controller, must be called after the application registers it
+func (a *Auth) Init() error {
+	if a.ControllerName == "" || a.Controller != nil {
+		return nil
+	}
+	controllersMutex.RLock()
+	defer controllersMutex.RUnlock()
+	controller, exists := controllers[a.ControllerName]
+	if !exists {
+		return fmt.Errorf("auth: there is no controller named %q (see auth.RegisterController)", a.ControllerName)
+	}
+	a.Controller = controller
+	return nil
+}
+
+func (a *Auth) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+func (a *Auth) hooks() *Controller {
+	if a.Controller == nil {
+		return &Controller{}
+	}
+	return a.Controller
+}
+
+type stateKey struct{}
+
+// state of the request, the user is loaded on the first access
+type state struct {
+	a    *Auth
+	w    http.ResponseWriter
+	r    *http.Request
+	once sync.Once
+	user *User
+}
+
+// Handler makes the logged user available to the handlers (see FromRequest). Must run inside the sessions handler.
+func (a *Auth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &state{a: a, w: w}
+		s.r = r.WithContext(context.WithValue(r.Context(), stateKey{}, s))
+		next.ServeHTTP(w, s.r)
+	})
+}
+
+// FromRequest returns the logged user of the request, nil for anonymous requests or without the Handler
+func FromRequest(r *http.Request) *User {
+	return FromContext(r.Context())
+}
+
+// FromContext returns the logged user of the request of the context
+func FromContext(ctx context.Context) *User {
+	s, _ := ctx.Value(stateKey{}).(*state)
+	if s == nil {
+		return nil
+	}
+	s.once.Do(func() {
+		var err error
+		if s.user, err = s.a.current(s.w, s.r); err != nil {
+			log.Printf("auth: loading the user: %v (request %s)", err, requestid.FromRequest(s.r))
+		}
+	})
+	return s.user
+}
+
+// UserID returns the ID of the logged user of the request, empty for anonymous requests
+func UserID(r *http.Request) string {
+	if user := FromRequest(r); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// FromScope returns the logged user of the page being rendered, nil for anonymous requests
+func FromScope(scope *sht.Scope) *User {
+	value, _ := scope.Get(ScopeKey)
+	user, _ := value.(*User)
+	return user
+}
+
+// current returns the user of the session, or of the remember-me cookie after the session expired
+func (a *Auth) current(w http.ResponseWriter, r *http.Request) (*User, error) {
+	s := session.FromRequest(r)
+	if s == nil {
+		return nil, nil
+	}
+	if id := s.GetString(sessionUser); id != "" {
+		loggedAt, _ := s.Get(sessionTime).(float64)
+		if a.TTL > 0 && a.clock().Sub(time.Unix(int64(loggedAt), 0)) > a.TTL {
+			s.Delete(sessionUser)
+			s.Delete(sessionTime)
+			return nil, nil
+		}
+		user, err := a.Store.UserByID(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		// removed while logged in, or logged in before a reset of the password (Ex. a stolen session)
+		if user == nil || int64(loggedAt) < user.PasswordSet.Unix() {
+			s.Delete(sessionUser)
+			s.Delete(sessionTime)
+			return nil, nil
+		}
+		return user, nil
+	}
+
+	cookie, err := r.Cookie(RememberCookie)
+	if err != nil || a.RememberMe == 0 {
+		return nil, nil
+	}
+	token, err := a.Store.TakeToken(r.Context(), TokenRemember, a.rememberHash(r, cookie.Value))
+	if err != nil {
+		return nil, err
+	}
+	var user *User
+	if token != nil {
+		if user, err = a.Store.UserByID(r.Context(), token.UserID); err != nil {
+			return nil, err
+		}
+	}
+	if user == nil || a.locked(user) {
+		http.SetCookie(w, a.rememberCookie("", -1))
+		return nil, nil
+	}
+	a.start(s, user)
+	// the token is rotated, a stolen cookie stops working once the user comes back
+	return user, a.remember(w, r, user)
+}
+
+// start keeps the login in the session, with a new ID to prevent session fixation
+func (a *Auth) start(s *session.Session, user *User) {
+	s.Renew()
+	s.Set(sessionUser, user.ID)
+	// float64, the type of the numbers read back from the JSON of the session
+	s.Set(sessionTime, float64(a.clock().Unix()))
+}
+
+// remember issues a remember-me token in the cookie
+func (a *Auth) remember(w http.ResponseWriter, r *http.Request, user *User) error {
+	secret := newSecret(32)
+	token := &Token{Hash: a.rememberHash(r, secret), Kind: TokenRemember, UserID: user.ID, Expires: a.clock().Add(a.RememberMe)}
+	if err := a.Store.CreateToken(r.Context(), token); err != nil {
+		return err
+	}
+	http.SetCookie(w, a.rememberCookie(secret, a.RememberMe))
+	return nil
+}
+
+func (a *Auth) rememberCookie(value string, ttl time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     RememberCookie,
+		Value:    value,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(ttl / time.Second)
+	}
+	return cookie
+}
+
+func (a *Auth) locked(user *User) bool {
+	return !user.LockedUntil.IsZero() && a.clock().Before(user.LockedUntil)
+}
+
+// Login verifies the password of the user of the login and keeps it in the session. Consecutive failures lock the
+// account for LockDuration.
+func (a *Auth) Login(w http.ResponseWriter, r *http.Request, login, password string, remember bool) (*User, error) {
+	s := session.FromRequest(r)
+	if s == nil {
+		return nil, ErrNoSession
+	}
+	ctx := r.Context()
+
+	var user *User
+	var err error
+	if lookup := a.hooks().Lookup; lookup != nil {
+		user, err = lookup(ctx, a.Store, login)
+	} else {
+		user, err = a.Store.UserByEmail(ctx, login)
+	}
+	if err != nil {
+		logins.Inc("error")
+		return nil, err
+	}
+	if user == nil || user.Password == "" {
+		// same cost of a known user, the response time does not reveal the registered emails
+		a.Hasher.Verify(password, a.dummyHash())
+		logins.Inc("invalid")
+		return nil, ErrInvalidCredentials
+	}
+	if a.locked(user) {
+		logins.Inc("locked")
+		return nil, ErrLocked
+	}
+
+	valid, err := a.Hasher.Verify(password, user.Password)
+	if err != nil {
+		logins.Inc("error")
+		return nil, err
+	}
+	if !valid {
+		// counted by the database, the concurrent attempts do not overwrite each other
+		until := a.clock().Add(a.LockDuration)
+		_, locked, errFailure := a.Store.AddFailure(ctx, user.ID, a.Attempts, until)
+		if errFailure != nil {
+			return nil, errFailure
+		}
+		logins.Inc("invalid")
+		if locked {
+			log.Printf("auth: user %s locked until %s (request %s)", user.ID, until.Format(time.RFC3339), requestid.FromRequest(r))
+			return nil, ErrLocked
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if err = a.authorize(r, user); err != nil {
+		logins.Inc("denied")
+		return nil, err
+	}
+
+	changed := user.Failures > 0 || !user.LockedUntil.IsZero()
+	user.Failures, user.LockedUntil = 0, time.Time{}
+	if a.Hasher.NeedsRehash(user.Password) {
+		if hash, errHash := a.Hasher.Hash(password); errHash == nil {
+			user.Password, changed = hash, true
+		}
+	}
+	if changed {
+		if err = a.Store.UpdateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = a.establish(w, r, s, user, remember); err != nil {
+		return nil, err
+	}
+	logins.Inc("success")
+	return user, nil
+}
+
+// SignIn logs in the user authenticated by other means (Ex. an OIDC provider), after the Authorize hook of the
+// controller. The locked accounts are refused as on Login.
+func (a *Auth) SignIn(w http.ResponseWriter, r *http.Request, user *User, remember bool) error {
+	s := session.FromRequest(r)
+	if s == nil {
+		return ErrNoSession
+	}
+	if a.locked(user) {
+		logins.Inc("locked")
+		return ErrLocked
+	}
+	if err := a.authorize(r, user); err != nil {
+		logins.Inc("denied")
+		return err
+	}
+	if err := a.establish(w, r, s, user, remember); err != nil {
+		logins.Inc("error")
+		return err
+	}
+	logins.Inc("success")
+	return nil
+}
+
+// authorize asks the controller whether the user may log in
+func (a *Auth) authorize(r *http.Request, user *User) error {
+	if authorize := a.hooks().Authorize; authorize != nil {
+		if err := authorize(r, user); err != nil {
+			return fmt.Errorf("%w: %v", ErrDenied, err)
+		}
+	}
+	return nil
+}
+
+// establish logs the user in the session of the request
+func (a *Auth) establish(w http.ResponseWriter, r *http.Request, s *session.Session, user *User, remember bool) error {
+	a.start(s, user)
+	if remember && a.RememberMe > 0 {
+		if err := a.remember(w, r, user); err != nil {
+			return err
+		}
+	}
+	if st, _ := r.Context().Value(stateKey{}).(*state); st != nil {
+		st.once.Do(func() {})
+		st.user = user
+	}
+	return nil
+}
+
+// Logout removes the login from the session and the remember-me token of the browser
+func (a *Auth) Logout(w http.ResponseWriter, r *http.Request) error {
+	if cookie, err := r.Cookie(RememberCookie); err == nil {
+		if _, err = a.Store.TakeToken(r.Context(), TokenRemember, a.rememberHash(r, cookie.Value)); err != nil {
+			return err
+		}
+		http.SetCookie(w, a.rememberCookie("", -1))
+	}
+	if s := session.FromRequest(r); s != nil {
+		s.Destroy()
+	}
+	if st, _ := r.Context().Value(stateKey{}).(*state); st != nil {
+		st.once.Do(func() {})
+		st.user = nil
+	}
+	return nil
+}
+
+// dummyHash a hash of the current algorithm, computed on the first use
+func (a *Auth) dummyHash() string {
+	a.dummyOnce.Do(func() {
+		a.dummy, _ = a.Hasher.Hash(newSecret(16))
+	})
+	return a.dummy
+}
+
+// newSecret returns a random base64url string of size bytes
+func newSecret(size int) string {
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret)
+}
+
+// hashToken the tokens are stored as SHA-256, a leak of the table does not expose them
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// rememberHash the hash of the remember-me tokens includes the partition, the cookie does not log in on the others
+func (a *Auth) rememberHash(r *http.Request, secret string) string {
+	if a.Partition == nil {
+		return hashToken(secret)
+	}
+	return hashToken(a.Partition(r) + ":" + secret)
+}
@@ -0,0 +1,428 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/session"
+)
+
+// fast parameters, the defaults take tens of milliseconds per hash
+var testArgon2 = Argon2{Memory: 64, Time: 1, Threads: 1}
+
+func Test_hasher(t *testing.T) {
+	argon := &Hasher{Algorithm: Argon2id, Pepper: []byte("pepper"), Argon2: testArgon2}
+	bcryptHasher := &Hasher{Algorithm: Bcrypt, Pepper: []byte("pepper"), BcryptCost: 4}
+
+	for _, h := range []*Hasher{argon, bcryptHasher} {
+		hash, err := h.Hash("secret password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid, err := h.Verify("secret password", hash); !valid || err != nil {
+			t.Errorf("%s: expected a valid password, got %v %v", h.Algorithm, valid, err)
+		}
+		if valid, _ := h.Verify("other password", hash); valid {
+			t.Errorf("%s: expected an invalid password", h.Algorithm)
+		}
+		// the pepper is part of the hash
+		if valid, _ := (&Hasher{}).Verify("secret password", hash); valid {
+			t.Errorf("%s: expected the pepper to be required", h.Algorithm)
+		}
+	}
+
+	// the algorithm can change, the old hashes are still verified and rehashed on login
+	hash, _ := bcryptHasher.Hash("secret password")
+	if valid, err := argon.Verify("secret password", hash); !valid || err != nil {
+		t.Errorf("got %v %v", valid, err)
+	}
+	if !argon.NeedsRehash(hash) {
+		t.Errorf("expected the bcrypt hash to be rehashed with argon2id")
+	}
+	hash, _ = argon.Hash("secret password")
+	if argon.NeedsRehash(hash) || !(&Hasher{Argon2: Argon2{Memory: 128, Time: 1, Threads: 1}}).NeedsRehash(hash) {
+		t.Errorf("expected a rehash only with stronger parameters")
+	}
+	if _, err := argon.Verify("x", "$argon2id$v=19$broken"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("got %v", err)
+	}
+}
+
+// client a browser of the test server, keeps the cookies and does not follow redirects
+type client struct {
+	t   *testing.T
+	srv *httptest.Server
+	*http.Client
+}
+
+func newClient(t *testing.T, srv *httptest.Server) *client {
+	jar, _ := cookiejar.New(nil)
+	c := *srv.Client() // shared by the server, each client has its own cookies
+	c.Jar = jar
+	c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &client{t: t, srv: srv, Client: &c}
+}
+
+func (c *client) post(path string, form url.Values) *http.Response {
+	res, err := c.PostForm(c.srv.URL+path, form)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func (c *client) postJSON(path, body string) (*http.Response, string) {
+	res, err := c.Post(c.srv.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res, string(data)
+}
+
+// me returns the ID of the logged user and the flash messages
+func (c *client) me() (string, []session.Flash) {
+	res, err := c.Get(c.srv.URL + "/me")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	parts := strings.SplitN(string(data), "\n", 2)
+	var flashes []session.Flash
+	for _, line := range strings.Split(parts[1], "\n") {
+		if kind, message, found := strings.Cut(line, ": "); found {
+			flashes = append(flashes, session.Flash{Kind: kind, Message: message})
+		}
+	}
+	return parts[0], flashes
+}
+
+func (c *client) forget(name string) {
+	u, _ := url.Parse(c.srv.URL)
+	c.Jar.SetCookies(u, []*http.Cookie{{Name: name, Path: "/", MaxAge: -1}})
+}
+
+func newTestServer(t *testing.T) (*Auth, *httptest.Server, *time.Time, *[]string) {
+	conn, err := db.Open("test", &config.DB{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	now := time.Now()
+	var links []string
+	a := &Auth{
+		Store:        &SQL{DB: conn},
+		Hasher:       &Hasher{Algorithm: Argon2id, Argon2: testArgon2},
+		RememberMe:   time.Hour,
+		ResetTTL:     time.Hour,
+		MinPassword:  8,
+		Registration: true,
+		Redirect:     "/",
+		BaseURL:      "https://example.com",
+		Attempts:     3,
+		LockDuration: 15 * time.Minute,
+		Controller: &Controller{
+			SendReset: func(ctx context.Context, user *User, link string) error {
+				links = append(links, link)
+				return nil
+			},
+		},
+		now: func() time.Time { return now },
+	}
+
+	sessions, err := session.New(config.Session{
+		Cookie: "__Host-session",
+		Keys:   []string{base64.StdEncoding.EncodeToString(make([]byte, 32))},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := map[string]http.HandlerFunc{}
+	for _, route := range a.Routes() {
+		routes[route.Method+" "+route.Path] = route.Handler
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if handler := routes[r.Method+" "+r.URL.Path]; handler != nil {
+			handler(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	// logs in the user of the id as an external provider does
+	mux.HandleFunc("/signin", func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.Store.UserByID(r.Context(), r.URL.Query().Get("id"))
+		if err == nil {
+			err = a.SignIn(w, r, user, false)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, UserID(r)+"\n")
+		for _, flash := range session.FromRequest(r).Flashes() {
+			io.WriteString(w, flash.Kind+": "+flash.Message+"\n")
+		}
+	})
+	srv := httptest.NewTLSServer(sessions.Handler(a.Handler(mux)))
+	t.Cleanup(srv.Close)
+	return a, srv, &now, &links
+}
+
+func Test_auth(t *testing.T) {
+	a, srv, now, links := newTestServer(t)
+	c := newClient(t, srv)
+
+	// register logs the user in
+	res, body := c.postJSON("/register", `{"email":"Ana@Example.org","password":"12345678","name":"Ana"}`)
+	if res.StatusCode != http.StatusCreated || !strings.Contains(body, `"email":"ana@example.org"`) {
+		t.Fatalf("got %d %s", res.StatusCode, body)
+	}
+	id, _ := c.me()
+	if id == "" {
+		t.Fatalf("expected the user to be logged in")
+	}
+	if res, _ = c.postJSON("/register", `{"email":"ana@example.org","password":"12345678"}`); res.StatusCode != http.StatusConflict {
+		t.Errorf("expected the email to be taken, got %d", res.StatusCode)
+	}
+	if res, _ = c.postJSON("/register", `{"email":"bia@example.org","password":"short"}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a short password, got %d", res.StatusCode)
+	}
+
+	// logout, the form is redirected with a flash message
+	res = c.post("/logout", nil)
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/" {
+		t.Errorf("got %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+	if id, flashes := c.me(); id != "" || len(flashes) != 1 || flashes[0].Kind != "success" {
+		t.Errorf("got %q %v", id, flashes)
+	}
+
+	// login with a form, next must be on the site
+	res = c.post("/login", url.Values{"email": {"ana@example.org"}, "password": {"12345678"}, "next": {"https://evil.example/x"}})
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/" {
+		t.Errorf("got %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+	res = c.post("/login", url.Values{"email": {"ana@example.org"}, "password": {"12345678"}, "next": {"/account?tab=1"}})
+	if res.Header.Get("Location") != "/account?tab=1" {
+		t.Errorf("got %s", res.Header.Get("Location"))
+	}
+	if got, _ := c.me(); got != id {
+		t.Errorf("got %q", got)
+	}
+
+	// lockout after 3 failures, even with the right password
+	other := newClient(t, srv)
+	for i := 0; i < 2; i++ {
+		if res, _ = other.postJSON("/login", `{"email":"ana@example.org","password":"wrong"}`); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("got %d", res.StatusCode)
+		}
+	}
+	if res, _ = other.postJSON("/login", `{"email":"ana@example.org","password":"wrong"}`); res.StatusCode != http.StatusLocked {
+		t.Errorf("expected the account to be locked, got %d", res.StatusCode)
+	}
+	if res, _ = other.postJSON("/login", `{"email":"ana@example.org","password":"12345678"}`); res.StatusCode != http.StatusLocked {
+		t.Errorf("expected the account to be locked, got %d", res.StatusCode)
+	}
+	// the other ways to log in are refused too
+	if res, err := other.Get(srv.URL + "/signin?id=" + id); err != nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the sign in of the locked account to be refused, got %v %v", res, err)
+	} else {
+		res.Body.Close()
+	}
+	*now = now.Add(16 * time.Minute)
+	if res, _ = other.postJSON("/login", `{"email":"ana@example.org","password":"12345678","remember":true}`); res.StatusCode != http.StatusOK {
+		t.Errorf("expected the lock to expire, got %d", res.StatusCode)
+	}
+	if res, _ = other.postJSON("/login", `{"email":"nobody@example.org","password":"12345678"}`); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got %d", res.StatusCode)
+	}
+
+	// remember-me logs in again without the session, the token is rotated
+	stolen := newClient(t, srv)
+	u, _ := url.Parse(srv.URL)
+	stolen.Jar.SetCookies(u, other.Jar.Cookies(u))
+	stolen.forget("__Host-session")
+	other.forget("__Host-session")
+	if got, _ := other.me(); got != id {
+		t.Errorf("expected the remember-me login, got %q", got)
+	}
+	if got, _ := stolen.me(); got != "" {
+		t.Errorf("expected the used token to be rejected, got %q", got)
+	}
+	other.forget("__Host-session")
+	if got, _ := other.me(); got != id {
+		t.Errorf("expected the rotated token to log in, got %q", got)
+	}
+
+	// a copy of the session of c, ended by the reset of the password
+	hijacked := newClient(t, srv)
+	hijacked.Jar.SetCookies(u, c.Jar.Cookies(u))
+	if got, _ := hijacked.me(); got != id {
+		t.Errorf("expected the copied session to be logged in, got %q", got)
+	}
+
+	// password reset, unknown emails receive the same response
+	_, unknown := c.postJSON("/password/forgot", `{"email":"nobody@example.org"}`)
+	_, known := c.postJSON("/password/forgot", `{"email":"ana@example.org"}`)
+	if unknown != known || len(*links) != 1 {
+		t.Fatalf("got %q %q %v", unknown, known, *links)
+	}
+	link, _ := url.Parse((*links)[0])
+	token := link.Query().Get("token")
+	// the link is on the configured site, not on the Host of the request
+	if link.Host != "example.com" || link.Path != "/password/reset" || token == "" {
+		t.Errorf("got %q", (*links)[0])
+	}
+	if res, _ = c.postJSON("/password/reset", `{"token":"`+token+`","password":"short"}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d", res.StatusCode)
+	}
+	if res, _ = c.postJSON("/password/reset", `{"token":"`+token+`","password":"new password"}`); res.StatusCode != http.StatusOK {
+		t.Errorf("got %d", res.StatusCode)
+	}
+	if got, _ := hijacked.me(); got != "" {
+		t.Errorf("expected the sessions started before the reset to be logged out, got %q", got)
+	}
+	if res, _ = c.postJSON("/password/reset", `{"token":"`+token+`","password":"new password"}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected the token to be single use, got %d", res.StatusCode)
+	}
+	if res, _ = c.postJSON("/login", `{"email":"ana@example.org","password":"12345678"}`); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the old password to be rejected, got %d", res.StatusCode)
+	}
+	if res, _ = c.postJSON("/login", `{"email":"ana@example.org","password":"new password"}`); res.StatusCode != http.StatusOK {
+		t.Errorf("got %d", res.StatusCode)
+	}
+	// the reset revokes the remember-me tokens
+	other.forget("__Host-session")
+	if got, _ := other.me(); got != "" {
+		t.Errorf("expected the remember-me token to be revoked, got %q", got)
+	}
+
+	// ttl of the login
+	a.TTL = time.Hour
+	*now = now.Add(2 * time.Hour)
+	if got, _ := c.me(); got != "" {
+		t.Errorf("expected the login to expire, got %q", got)
+	}
+}
+
+// the failures are counted by the database, parallel attempts can not exceed the lockout
+func Test_lockout_concurrent(t *testing.T) {
+	a, _, now, _ := newTestServer(t)
+	ctx := context.Background()
+	user := &User{Email: "ana@example.org"}
+	if err := a.Store.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var locks int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, locked, err := a.Store.AddFailure(ctx, user.ID, 3, now.Add(time.Hour))
+			if err != nil {
+				t.Error(err)
+			}
+			if locked {
+				atomic.AddInt32(&locks, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if locks != 3 {
+		t.Errorf("Expected 3 locks, got %d", locks)
+	}
+	got, err := a.Store.UserByID(ctx, user.ID)
+	if err != nil || got.Failures != 1 || !got.LockedUntil.After(*now) {
+		t.Errorf("got %+v %v", got, err)
+	}
+}
+
+func Test_controller(t *testing.T) {
+	a, srv, _, _ := newTestServer(t)
+	a.Controller = &Controller{
+		Lookup: func(ctx context.Context, store Store, login string) (*User, error) {
+			return store.UserByEmail(ctx, login+"@example.org")
+		},
+		Register: func(r *http.Request, user *User) error {
+			if user.Name == "" {
+				return errors.New("name is required")
+			}
+			return nil
+		},
+		Authorize: func(r *http.Request, user *User) error {
+			if user.Name == "Blocked" {
+				return errors.New("account disabled")
+			}
+			return nil
+		},
+	}
+	c := newClient(t, srv)
+
+	if res, body := c.postJSON("/register", `{"email":"ana@example.org","password":"12345678"}`); res.StatusCode != http.StatusBadRequest || !strings.Contains(body, "name is required") {
+		t.Errorf("got %d %s", res.StatusCode, body)
+	}
+	c.postJSON("/register", `{"email":"ana@example.org","password":"12345678","name":"Ana"}`)
+	c.post("/logout", nil)
+	if res, _ := c.postJSON("/login", `{"email":"ana","password":"12345678"}`); res.StatusCode != http.StatusOK {
+		t.Errorf("expected the lookup by username, got %d", res.StatusCode)
+	}
+
+	if res, body := c.postJSON("/register", `{"email":"bob@example.org","password":"12345678","name":"Blocked"}`); res.StatusCode != http.StatusAccepted || !strings.Contains(body, "account disabled") {
+		t.Errorf("got %d %s", res.StatusCode, body)
+	}
+	if res, _ := c.postJSON("/login", `{"email":"bob","password":"12345678"}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("got %d", res.StatusCode)
+	}
+}
+
+func Test_roles(t *testing.T) {
+	conn, err := db.Open("test", &config.DB{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store := &SQL{DB: conn}
+	ctx := context.Background()
+
+	user := &User{Email: "ana@example.org", Password: "x", Roles: []string{"editor"}}
+	if err = store.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetRoles(ctx, user.ID, []string{"user", "admin", "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.UserByEmail(ctx, "ana@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(loaded.Roles, ","); got != "admin,user" {
+		t.Errorf("got %s", got)
+	}
+	if err = store.SetRoles(ctx, user.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ = store.UserByID(ctx, user.ID); len(loaded.Roles) != 0 {
+		t.Errorf("got %v", loaded.Roles)
+	}
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/session"
+)
+
+//go:embed reset.html
+var resetPage string
+
+var resetTemplate = template.Must(template.New("reset").Parse(resetPage))
+
+var (
+	ErrInvalidEmail  = errors.New("auth: invalid email")
+	ErrShortPassword = errors.New("auth: password too short")
+)
+
+// Route an endpoint of the authentication
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+}
+
+// Routes returns the endpoints, POST /register only when the registration is enabled
+func (a *Auth) Routes() []Route {
+	routes := []Route{
+		{http.MethodPost, "/login", a.handleLogin},
+		{http.MethodPost, "/logout", a.handleLogout},
+		{http.MethodPost, "/password/forgot", a.handleForgot},
+		{http.MethodGet, "/password/reset", a.handleResetPage},
+		{http.MethodPost, "/password/reset", a.handleReset},
+	}
+	if a.Registration {
+		routes = append(routes, Route{http.MethodPost, "/register", a.handleRegister})
+	}
+	return routes
+}
+
+// input fields of the forms or of the JSON body
+type input struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	Remember bool   `json:"remember"`
+	Next     string `json:"next"` // Page after the login, defaults to Redirect
+	Token    string `json:"token"`
+}
+
+func isJSON(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+func readInput(r *http.Request) (*input, error) {
+	in := &input{}
+	if isJSON(r) {
+		if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	in.Email = r.PostForm.Get("email")
+	in.Password = r.PostForm.Get("password")
+	in.Name = r.PostForm.Get("name")
+	in.Next = r.PostForm.Get("next")
+	in.Token = r.PostForm.Get("token")
+	switch r.PostForm.Get("remember") {
+	case "on", "true", "1":
+		in.Remember = true
+	}
+	return in, nil
+}
+
+// respond answers JSON requests with the status and the user or the error. Forms are redirected to next (or to the
+// page of the form on errors), with a flash message.
+func (a *Auth) respond(w http.ResponseWriter, r *http.Request, status int, user *User, err error, message, next string) {
+	if isJSON(r) {
+		body := map[string]interface{}{}
+		if err != nil {
+			body["error"] = strings.TrimPrefix(err.Error(), "auth: ")
+		} else {
+			body["message"] = message
+		}
+		if user != nil {
+			body["user"] = map[string]string{"id": user.ID, "email": user.Email, "name": user.Name}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	target := a.Redirect
+	if err != nil {
+		if referer := local(r, r.Referer()); referer != "" {
+			target = referer
+		}
+	} else if next = local(r, next); next != "" {
+		target = next
+	}
+	if s := session.FromRequest(r); s != nil {
+		if err != nil {
+			s.AddFlash("error", strings.TrimPrefix(err.Error(), "auth: "))
+		} else if message != "" {
+			s.AddFlash("success", message)
+		}
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// local returns the path of the target when it is on the site, empty for other sites (open redirect)
+func local(r *http.Request, target string) string {
+	u, err := url.Parse(target)
+	if err != nil || target == "" || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return ""
+	}
+	if u.IsAbs() || u.Host != "" {
+		if u.Host != r.Host {
+			return ""
+		}
+		u.Scheme, u.Host, u.User = "", "", nil
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		return ""
+	}
+	return u.String()
+}
+
+// fail logs the unexpected errors, the client only receives a generic message
+func (a *Auth) fail(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("auth: %s %s: %v (request %s)", r.Method, r.URL.Path, err, requestid.FromRequest(r))
+	a.respond(w, r, http.StatusInternalServerError, nil, errors.New("auth: unexpected error, try again later"), "", "")
+}
+
+func (a *Auth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	in, err := readInput(r)
+	if err != nil {
+		a.respond(w, r, http.StatusBadRequest, nil, err, "", "")
+		return
+	}
+	user, err := a.Login(w, r, in.Email, in.Password, in.Remember)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		a.respond(w, r, http.StatusUnauthorized, nil, err, "", "")
+	case errors.Is(err, ErrLocked):
+		a.respond(w, r, http.StatusLocked, nil, err, "", "")
+	case errors.Is(err, ErrDenied):
+		a.respond(w, r, http.StatusForbidden, nil, err, "", "")
+	case err != nil:
+		a.fail(w, r, err)
+	default:
+		a.respond(w, r, http.StatusOK, user, nil, "", in.Next)
+	}
+}
+
+func (a *Auth) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := a.Logout(w, r); err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	a.respond(w, r, http.StatusOK, nil, nil, "Logged out", "")
+}
+
+func (a *Auth) handleRegister(w http.ResponseWriter, r *http.Request) {
+	in, err := readInput(r)
+	if err != nil {
+		a.respond(w, r, http.StatusBadRequest, nil, err, "", "")
+		return
+	}
+	address, err := mail.ParseAddress(in.Email)
+	if err != nil || address.Address != strings.TrimSpace(in.Email) {
+		a.respond(w, r, http.StatusBadRequest, nil, ErrInvalidEmail, "", "")
+		return
+	}
+	if len([]rune(in.Password)) < a.MinPassword {
+		a.respond(w, r, http.StatusBadRequest, nil, ErrShortPassword, "", "")
+		return
+	}
+
+	user := &User{Email: address.Address, Name: strings.TrimSpace(in.Name)}
+	if register := a.hooks().Register; register != nil {
+		if err = register(r, user); err != nil {
+			a.respond(w, r, http.StatusBadRequest, nil, err, "", "")
+			return
+		}
+	}
+	if user.Password, err = a.Hasher.Hash(in.Password); err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	if err = a.Store.CreateUser(r.Context(), user); errors.Is(err, ErrEmailTaken) {
+		a.respond(w, r, http.StatusConflict, nil, err, "", "")
+		return
+	} else if err != nil {
+		a.fail(w, r, err)
+		return
+	}
+
+	if err = a.authorize(r, user); err != nil {
+		// created, but the controller does not allow the login yet (Ex. unconfirmed email)
+		a.respond(w, r, http.StatusAccepted, nil, nil, strings.TrimPrefix(err.Error(), "auth: "), "")
+		return
+	}
+	s := session.FromRequest(r)
+	if s == nil {
+		a.fail(w, r, ErrNoSession)
+		return
+	}
+	if err = a.establish(w, r, s, user, in.Remember); err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	a.respond(w, r, http.StatusCreated, user, nil, "Welcome", in.Next)
+}
+
+// handleForgot sends the reset link. The response is the same for unknown emails, it does not reveal the users.
+func (a *Auth) handleForgot(w http.ResponseWriter, r *http.Request) {
+	in, err := readInput(r)
+	if err != nil {
+		a.respond(w, r, http.StatusBadRequest, nil, err, "", "")
+		return
+	}
+	const message = "If the email is registered, a link to reset the password was sent"
+
+	ctx := r.Context()
+	user, err := a.Store.UserByEmail(ctx, in.Email)
+	if err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	if user == nil {
+		a.respond(w, r, http.StatusOK, nil, nil, message, "")
+		return
+	}
+
+	secret := newSecret(32)
+	token := &Token{Hash: hashToken(secret), Kind: TokenReset, UserID: user.ID, Expires: a.clock().Add(a.ResetTTL)}
+	if err = a.Store.CreateToken(ctx, token); err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	// the Host of the request is chosen by the client, a forged one would send the token to another site
+	link := a.BaseURL + "/password/reset?" + url.Values{"token": {secret}}.Encode()
+	if send := a.hooks().SendReset; send != nil {
+		if err = send(ctx, user, link); err != nil {
+			a.fail(w, r, err)
+			return
+		}
+	} else if a.Dev {
+		log.Printf("auth: password reset link of %s: %s", user.Email, link)
+	} else {
+		log.Printf("auth: the controller does not send the password reset links (user %s)", user.ID)
+	}
+	a.respond(w, r, http.StatusOK, nil, nil, message, "")
+}
+
+// handleResetPage the form of the link sent by handleForgot
+func (a *Auth) handleResetPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	resetTemplate.Execute(w, map[string]interface{}{"Token": r.URL.Query().Get("token"), "MinPassword": a.MinPassword})
+}
+
+// handleReset changes the password of the token. The other reset and remember-me tokens of the user are revoked, the
+// logins of the sessions started before end (see User.PasswordSet) and the lockout is cleared.
+func (a *Auth) handleReset(w http.ResponseWriter, r *http.Request) {
+	in, err := readInput(r)
+	if err != nil {
+		a.respond(w, r, http.StatusBadRequest, nil, err, "", "")
+		return
+	}
+	// checked before the token is used, a short password does not waste the link
+	if len([]rune(in.Password)) < a.MinPassword {
+		a.respond(w, r, http.StatusBadRequest, nil, ErrShortPassword, "", "")
+		return
+	}
+
+	ctx := r.Context()
+	token, err := a.Store.TakeToken(ctx, TokenReset, hashToken(in.Token))
+	if err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	var user *User
+	if token != nil {
+		if user, err = a.Store.UserByID(ctx, token.UserID); err != nil {
+			a.fail(w, r, err)
+			return
+		}
+	}
+	if user == nil {
+		a.respond(w, r, http.StatusBadRequest, nil, ErrInvalidToken, "", "")
+		return
+	}
+
+	if user.Password, err = a.Hasher.Hash(in.Password); err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	user.Failures, user.LockedUntil = 0, time.Time{}
+	user.PasswordSet = a.clock()
+	if err = a.Store.UpdateUser(ctx, user); err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	for _, kind := range []string{TokenReset, TokenRemember} {
+		if err = a.Store.DeleteTokens(ctx, user.ID, kind); err != nil {
+			a.fail(w, r, err)
+			return
+		}
+	}
+	a.respond(w, r, http.StatusOK, nil, nil, "Password changed, log in with the new password", a.Redirect)
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// ErrUnknownHash returned when the stored hash was not produced by one of the supported algorithms
+var ErrUnknownHash = errors.New("auth: unknown password hash")
+
+// Argon2 parameters of argon2id, the defaults follow the OWASP recommendation
+type Argon2 struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+}
+
+// DefaultArgon2 19 MiB, 2 iterations, 1 thread
+var DefaultArgon2 = Argon2{Memory: 19 * 1024, Time: 2, Threads: 1}
+
+// Hasher hashes the passwords with argon2id or bcrypt. The hashes are self-describing (PHC format for argon2id,
+// modular crypt for bcrypt), Verify accepts both, so the algorithm can be changed without invalidating the passwords.
+type Hasher struct {
+	Algorithm  string // Argon2id or Bcrypt
+	Pepper     []byte // Secret mixed into all passwords (HMAC-SHA256), optional
+	Argon2     Argon2
+	BcryptCost int
+}
+
+// input applies the pepper. bcrypt only uses the first 72 bytes, the HMAC keeps longer passwords meaningful.
+func (h *Hasher) input(password string) []byte {
+	if len(h.Pepper) == 0 {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, h.Pepper)
+	mac.Write([]byte(password))
+	return []byte(base64.RawStdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// Hash returns the encoded hash of the password
+func (h *Hasher) Hash(password string) (string, error) {
+	input := h.input(password)
+	if h.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword(input, h.bcryptCost())
+		return string(hash), err
+	}
+
+	p := h.argon2()
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(input, salt, p.Time, p.Memory, p.Threads, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether the password matches the hash
+func (h *Hasher) Verify(password, hash string) (bool, error) {
+	input := h.input(password)
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), input)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	p, salt, key, err := parseArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey(input, salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+// NeedsRehash reports whether the hash uses another algorithm or weaker parameters than the current ones, the
+// password is hashed again on the next login
+func (h *Hasher) NeedsRehash(hash string) bool {
+	if h.Algorithm == Bcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < h.bcryptCost()
+	}
+	p, _, _, err := parseArgon2(hash)
+	current := h.argon2()
+	return err != nil || p.Memory < current.Memory || p.Time < current.Time || p.Threads < current.Threads
+}
+
+func (h *Hasher) argon2() Argon2 {
+	if h.Argon2.Memory == 0 {
+		return DefaultArgon2
+	}
+	return h.Argon2
+}
+
+func (h *Hasher) bcryptCost() int {
+	if h.BcryptCost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.BcryptCost
+}
+
+// parseArgon2 decodes `$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>`
+func parseArgon2(hash string) (p Argon2, salt []byte, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return p, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownHash
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownHash
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/db"
+)
+
+// ErrEmailTaken returned when registering an email that already has a user
+var ErrEmailTaken = errors.New("auth: email already registered")
+
+// User of the application
+type User struct {
+	ID          string
+	Email       string
+	Name        string
+	Password    string // Hash, see Hasher. Empty for the users of external providers, until they set a password
+	Failures    int    // Consecutive failed logins
+	LockedUntil time.Time
+	Created     time.Time
+	PasswordSet time.Time // Last reset of the password, the logins of the sessions started before are ended
+	Roles       []string  // See the policy of server/authz
+}
+
+// Token a single use secret sent to the user (password reset, remember-me), only the SHA-256 is stored
+type Token struct {
+	Hash    string
+	Kind    string
+	UserID  string
+	Expires time.Time
+}
+
+const (
+	TokenReset    = "reset"
+	TokenRemember = "remember"
+)
+
+// Store persists the users and the tokens
+type Store interface {
+	// UserByEmail returns the user of the email (case-insensitive), nil if it does not exist
+	UserByEmail(ctx context.Context, email string) (*User, error)
+	// UserByID returns the user, nil if it does not exist
+	UserByID(ctx context.Context, id string) (*User, error)
+	// CreateUser saves a new user, the ID is generated when empty. Fails with ErrEmailTaken.
+	CreateUser(ctx context.Context, user *User) error
+	// UpdateUser saves the password, the failures and the lock of the user
+	UpdateUser(ctx context.Context, user *User) error
+	// AddFailure increments the failed logins of the user atomically and returns them. When they reach attempts
+	// (0 disables) the count restarts and the user is locked until the given time, locked reports this call locked it.
+	AddFailure(ctx context.Context, userID string, attempts int, until time.Time) (failures int, locked bool, err error)
+	// SetRoles replaces the roles of the user
+	SetRoles(ctx context.Context, userID string, roles []string) error
+	// UserByIdentity returns the user linked to the subject of an external provider (Ex. OIDC), nil if not linked
+	UserByIdentity(ctx context.Context, provider, subject string) (*User, error)
+	// LinkIdentity links the subject of the provider to the user
+	LinkIdentity(ctx context.Context, provider, subject, userID string) error
+
+	CreateToken(ctx context.Context, token *Token) error
+	// TakeToken removes and returns the token, nil if it does not exist or is expired
+	TakeToken(ctx context.Context, kind, hash string) (*Token, error)
+	// DeleteTokens removes the tokens of the kind of the user
+	DeleteTokens(ctx context.Context, userID, kind string) error
+}
+
+// SQL keeps the users on the tables auth_users, auth_roles, auth_identities and auth_tokens of the database, created on
+// the first use
+type SQL struct {
+	DB *db.DB
+
+	mutex sync.Mutex
+	ready bool
+}
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS auth_users (
+		id VARCHAR(64) PRIMARY KEY,
+		email VARCHAR(255) NOT NULL UNIQUE,
+		name VARCHAR(255) NOT NULL,
+		password VARCHAR(255) NOT NULL,
+		failures INTEGER NOT NULL,
+		locked_until BIGINT NOT NULL,
+		created BIGINT NOT NULL,
+		password_set BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS auth_roles (
+		user_id VARCHAR(64) NOT NULL,
+		role VARCHAR(64) NOT NULL,
+		PRIMARY KEY (user_id, role)
+	)`,
+	`CREATE TABLE IF NOT EXISTS auth_identities (
+		provider VARCHAR(64) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id VARCHAR(64) NOT NULL,
+		created BIGINT NOT NULL,
+		PRIMARY KEY (provider, subject)
+	)`,
+	`CREATE TABLE IF NOT EXISTS auth_tokens (
+		hash VARCHAR(64) PRIMARY KEY,
+		kind VARCHAR(16) NOT NULL,
+		user_id VARCHAR(64) NOT NULL,
+		expires BIGINT NOT NULL
+	)`,
+}
+
+// init creates the tables, retried on the next use when it fails
+func (s *SQL) init(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ready {
+		return nil
+	}
+	for _, statement := range schema {
+		if _, err := s.DB.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("auth: creating the tables on db.%s: %w", s.DB.Name, err)
+		}
+	}
+	s.ready = true
+	return nil
+}
+
+// sql replaces the `?` of the statement by the placeholders of the engine
+func (s *SQL) sql(statement string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range statement {
+		if c == '?' {
+			n++
+			b.WriteString(s.DB.Placeholder(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+const userColumns = "id, email, name, password, failures, locked_until, created, password_set"
+
+func (s *SQL) user(ctx context.Context, where string, arg interface{}) (*User, error) {
+	if err := s.init(ctx); err != nil {
+		return nil, err
+	}
+	u := &User{}
+	var locked, created, passwordSet int64
+	err := s.DB.QueryRowContext(ctx, s.sql("SELECT "+userColumns+" FROM auth_users WHERE "+where), arg).
+		Scan(&u.ID, &u.Email, &u.Name, &u.Password, &u.Failures, &locked, &created, &passwordSet)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if locked > 0 {
+		u.LockedUntil = time.Unix(locked, 0)
+	}
+	u.Created = time.Unix(created, 0)
+	if passwordSet > 0 {
+		u.PasswordSet = time.Unix(passwordSet, 0)
+	}
+	if u.Roles, err = s.roles(ctx, u.ID); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *SQL) roles(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, s.sql("SELECT role FROM auth_roles WHERE user_id = ? ORDER BY role"), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err = rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (s *SQL) UserByEmail(ctx context.Context, email string) (*User, error) {
+	return s.user(ctx, "email = ?", strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (s *SQL) UserByID(ctx context.Context, id string) (*User, error) {
+	return s.user(ctx, "id = ?", id)
+}
+
+func (s *SQL) CreateUser(ctx context.Context, u *User) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	existing, err := s.UserByEmail(ctx, u.Email)
+	if err != nil {
+		return err
+	} else if existing != nil {
+		return ErrEmailTaken
+	}
+	if u.ID == "" {
+		u.ID = newSecret(16)
+	}
+	if u.Created.IsZero() {
+		u.Created = time.Now()
+	}
+	u.Email = strings.ToLower(strings.TrimSpace(u.Email))
+	_, err = s.DB.ExecContext(ctx, s.sql("INSERT INTO auth_users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		u.ID, u.Email, u.Name, u.Password, u.Failures, unix(u.LockedUntil), u.Created.Unix(), unix(u.PasswordSet))
+	if err != nil || len(u.Roles) == 0 {
+		return err
+	}
+	return s.SetRoles(ctx, u.ID, u.Roles)
+}
+
+func (s *SQL) UpdateUser(ctx context.Context, u *User) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, s.sql("UPDATE auth_users SET name = ?, password = ?, failures = ?, locked_until = ?, password_set = ? WHERE id = ?"),
+		u.Name, u.Password, u.Failures, unix(u.LockedUntil), unix(u.PasswordSet), u.ID)
+	return err
+}
+
+func (s *SQL) AddFailure(ctx context.Context, userID string, attempts int, until time.Time) (int, bool, error) {
+	if err := s.init(ctx); err != nil {
+		return 0, false, err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+	// the update locks the row until the commit, the count read next is the one of this attempt
+	if _, err = tx.ExecContext(ctx, s.sql("UPDATE auth_users SET failures = failures + 1 WHERE id = ?"), userID); err != nil {
+		return 0, false, err
+	}
+	var failures int
+	if err = tx.QueryRowContext(ctx, s.sql("SELECT failures FROM auth_users WHERE id = ?"), userID).Scan(&failures); err != nil {
+		return 0, false, err
+	}
+	locked := false
+	if attempts > 0 && failures >= attempts {
+		result, errLock := tx.ExecContext(ctx, s.sql("UPDATE auth_users SET failures = 0, locked_until = ? WHERE id = ? AND failures >= ?"),
+			unix(until), userID, attempts)
+		if errLock != nil {
+			return 0, false, errLock
+		}
+		affected, _ := result.RowsAffected()
+		locked = affected > 0
+	}
+	return failures, locked, tx.Commit()
+}
+
+func (s *SQL) SetRoles(ctx context.Context, userID string, roles []string) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, s.sql("DELETE FROM auth_roles WHERE user_id = ?"), userID); err != nil {
+		return err
+	}
+	added := map[string]bool{}
+	for _, role := range roles {
+		if added[role] {
+			continue
+		}
+		added[role] = true
+		if _, err = tx.ExecContext(ctx, s.sql("INSERT INTO auth_roles (user_id, role) VALUES (?, ?)"), userID, role); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQL) UserByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+	if err := s.init(ctx); err != nil {
+		return nil, err
+	}
+	var userID string
+	err := s.DB.QueryRowContext(ctx, s.sql("SELECT user_id FROM auth_identities WHERE provider = ? AND subject = ?"), provider, subject).
+		Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return s.UserByID(ctx, userID)
+}
+
+func (s *SQL) LinkIdentity(ctx context.Context, provider, subject, userID string) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, s.sql("INSERT INTO auth_identities (provider, subject, user_id, created) VALUES (?, ?, ?, ?)"),
+		provider, subject, userID, time.Now().Unix())
+	return err
+}
+
+func (s *SQL) CreateToken(ctx context.Context, t *Token) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	// expired tokens are removed when new ones are created
+	if _, err := s.DB.ExecContext(ctx, s.sql("DELETE FROM auth_tokens WHERE expires <= ?"), time.Now().Unix()); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, s.sql("INSERT INTO auth_tokens (hash, kind, user_id, expires) VALUES (?, ?, ?, ?)"),
+		t.Hash, t.Kind, t.UserID, t.Expires.Unix())
+	return err
+}
+
+func (s *SQL) TakeToken(ctx context.Context, kind, hash string) (*Token, error) {
+	if err := s.init(ctx); err != nil {
+		return nil, err
+	}
+	t := &Token{Hash: hash, Kind: kind}
+	var expires int64
+	err := s.DB.QueryRowContext(ctx, s.sql("SELECT user_id, expires FROM auth_tokens WHERE hash = ? AND kind = ?"), hash, kind).
+		Scan(&t.UserID, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	result, err := s.DB.ExecContext(ctx, s.sql("DELETE FROM auth_tokens WHERE hash = ?"), hash)
+	if err != nil {
+		return nil, err
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		// taken by a concurrent request
+		return nil, nil
+	}
+	t.Expires = time.Unix(expires, 0)
+	if time.Now().After(t.Expires) {
+		return nil, nil
+	}
+	return t, nil
+}
+
+func (s *SQL) DeleteTokens(ctx context.Context, userID, kind string) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, s.sql("DELETE FROM auth_tokens WHERE user_id = ? AND kind = ?"), userID, kind)
+	return err
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
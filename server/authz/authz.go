@@ -0,0 +1,208 @@
+// Package authz authorizes the requests by the roles and permissions of the logged user (the principal), by the
+// policy file auth/policy.yaml:
+//
+//	default: [ user ]              # roles of every logged user
+//	roles:
+//	  admin:
+//	    inherits: [ editor ]
+//	    permissions: [ "users.*" ]
+//	  editor:
+//	    permissions: [ posts.read, posts.write ]
+//	routes:
+//	  - route: /admin/*
+//	    roles: [ admin ]
+//	live:
+//	  MyLiveController.change: { permissions: [ posts.write ] }
+//
+// A Requirement is enforced in four places:
+//
+//   - Routes, the rules of the policy are checked by the Handler before the request reaches the site (401 for anonymous
+//     requests, 403 for the logged users without the role or permission).
+//   - Events of the live controllers (POST /live), by the name of the controller or controller.event.
+//   - Templates, the elements with `roles-allowed="admin,editor"` or `permissions-required="posts.write"` are removed
+//     from the page before it is sent (see Directives).
+//   - Queries and commands of db/ that declare `auth:`, see Check.
+//
+// The permissions accept a trailing wildcard: `users.*` grants users.read and users.write, `*` grants all.
+package authz
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/syntax-framework/shtml/sht"
+	"gopkg.in/yaml.v3"
+)
+
+// ScopeKey name of the principal in the scope of the controllers
+const ScopeKey = "principal"
+
+var (
+	ErrUnauthenticated = errors.New("authz: login required")
+	ErrForbidden       = errors.New("authz: permission denied")
+)
+
+// Principal the logged user, with the permissions of its roles
+type Principal struct {
+	ID          string
+	Roles       []string
+	Permissions []string // Granted by the roles, see Policy.Expand
+	Machine     bool     // API client, the Permissions are the scopes of its credential (see server/apiauth)
+}
+
+// HasRole reports whether the principal has the role, directly or inherited
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the principal has the permission
+func (p *Principal) Can(permission string) bool {
+	if p == nil {
+		return false
+	}
+	for _, granted := range p.Permissions {
+		if granted == permission || granted == "*" {
+			return true
+		}
+		if prefix := strings.TrimSuffix(granted, "*"); prefix != granted && strings.HasPrefix(permission, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Requirement to access a resource. Accepts the short forms `auth: true` (any logged user), `auth: admin, editor`
+// and `auth: [admin, editor]` (any of the roles).
+type Requirement struct {
+	Authenticated bool     `yaml:"authenticated"`
+	Roles         []string `yaml:"roles"`       // Any of the roles
+	Permissions   []string `yaml:"permissions"` // All the permissions
+}
+
+func (q *Requirement) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		switch strings.TrimSpace(node.Value) {
+		case "", "false":
+		case "true", "authenticated":
+			q.Authenticated = true
+		default:
+			q.Roles = ParseList(node.Value)
+		}
+		return nil
+	case yaml.SequenceNode:
+		return node.Decode(&q.Roles)
+	}
+	type plain Requirement
+	return node.Decode((*plain)(q))
+}
+
+// ParseList splits a list of roles or permissions separated by commas or spaces
+func ParseList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// Check returns nil when the principal meets the requirement, ErrUnauthenticated for anonymous requests (nil
+// principal) and ErrForbidden otherwise. A nil requirement is always met.
+func (q *Requirement) Check(p *Principal) error {
+	if q == nil || (!q.Authenticated && len(q.Roles) == 0 && len(q.Permissions) == 0) {
+		return nil
+	}
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	if len(q.Roles) > 0 {
+		allowed := false
+		for _, role := range q.Roles {
+			if p.HasRole(role) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrForbidden
+		}
+	}
+	for _, permission := range q.Permissions {
+		if !p.Can(permission) {
+			return ErrForbidden
+		}
+	}
+	return nil
+}
+
+type stateKey struct{}
+
+// state of the request, the principal is resolved on the first access
+type state struct {
+	once      sync.Once
+	resolve   func() *Principal
+	principal *Principal
+}
+
+func (s *state) get() *Principal {
+	s.once.Do(func() {
+		s.principal = s.resolve()
+	})
+	return s.principal
+}
+
+type systemKey struct{}
+
+// WithSystem returns a copy of the context of the calls made by the application itself (Ex. the CLI and the scheduled
+// jobs), which are not checked against the requirements
+func WithSystem(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemKey{}, true)
+}
+
+// FromContext returns the principal of the request of the context, nil for anonymous requests. The second value is
+// false outside of a request.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	s, _ := ctx.Value(stateKey{}).(*state)
+	if s == nil {
+		return nil, false
+	}
+	return s.get(), true
+}
+
+// Check verifies the requirement against the principal of the request of the context. Only the contexts marked by
+// WithSystem are trusted, any other call without a principal (Ex. a request that did not pass by the Handler) is
+// handled as anonymous.
+func Check(ctx context.Context, q *Requirement) error {
+	if system, _ := ctx.Value(systemKey{}).(bool); system {
+		return nil
+	}
+	p, _ := FromContext(ctx)
+	err := q.Check(p)
+	if err != nil {
+		denied.Inc(reason(err))
+	}
+	return err
+}
+
+// reason label of the denials in the metrics
+func reason(err error) string {
+	if errors.Is(err, ErrUnauthenticated) {
+		return "unauthenticated"
+	}
+	return "forbidden"
+}
+
+// FromScope returns the principal of the page being rendered, nil for anonymous requests
+func FromScope(scope *sht.Scope) *Principal {
+	value, _ := scope.Get(ScopeKey)
+	p, _ := value.(*Principal)
+	return p
+}
@@ -0,0 +1,178 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `
+default: [ user ]
+roles:
+  user:
+    permissions: [ posts.read ]
+  editor:
+    inherits: [ user ]
+    permissions: [ posts.write ]
+  admin:
+    inherits: [ editor ]
+    permissions: [ "users.*" ]
+routes:
+  - route: /admin/*
+    roles: [ admin ]
+  - route: POST /posts
+    permissions: [ posts.write ]
+  - route: /account
+    authenticated: true
+live:
+  Chat: true
+  Chat.delete: admin
+`
+
+func Test_requirement(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Live["Chat"]; !got.Authenticated || len(got.Roles) > 0 {
+		t.Errorf("short form `true`: got %+v", got)
+	}
+	if got := p.Live["Chat.delete"].Roles; !reflect.DeepEqual(got, []string{"admin"}) {
+		t.Errorf("short form of the roles: got %v", got)
+	}
+	if got := p.Routes[0]; got.Route != "/admin/*" || !reflect.DeepEqual(got.Roles, []string{"admin"}) {
+		t.Errorf("route rule: got %+v", got)
+	}
+
+	editor := p.Expand(&Principal{ID: "1", Roles: []string{"editor"}})
+	if !reflect.DeepEqual(editor.Roles, []string{"editor", "user"}) {
+		t.Errorf("roles: got %v", editor.Roles)
+	}
+	if !reflect.DeepEqual(editor.Permissions, []string{"posts.read", "posts.write"}) {
+		t.Errorf("permissions: got %v", editor.Permissions)
+	}
+	admin := p.Expand(&Principal{ID: "2", Roles: []string{"admin"}})
+	if !admin.Can("users.delete") || !admin.Can("posts.write") || admin.Can("billing.read") {
+		t.Errorf("admin permissions: got %v", admin.Permissions)
+	}
+	machine := p.Expand(&Principal{ID: "token:1", Permissions: []string{"orders.read"}, Machine: true})
+	if machine.HasRole("user") || machine.Can("posts.read") || !machine.Can("orders.read") {
+		t.Errorf("machine: got %+v", machine)
+	}
+
+	tests := []struct {
+		requirement *Requirement
+		principal   *Principal
+		expected    error
+	}{
+		{nil, nil, nil},
+		{&Requirement{}, nil, nil},
+		{&Requirement{Authenticated: true}, nil, ErrUnauthenticated},
+		{&Requirement{Authenticated: true}, p.Expand(&Principal{ID: "3"}), nil},
+		{&Requirement{Roles: []string{"admin", "editor"}}, editor, nil},
+		{&Requirement{Roles: []string{"admin"}}, editor, ErrForbidden},
+		{&Requirement{Permissions: []string{"posts.read", "posts.write"}}, editor, nil},
+		{&Requirement{Permissions: []string{"posts.write", "users.read"}}, editor, ErrForbidden},
+	}
+	for i, test := range tests {
+		if err = test.requirement.Check(test.principal); err != test.expected {
+			t.Errorf("%d: got %v, expected %v", i, err, test.expected)
+		}
+	}
+
+	for _, invalid := range []string{
+		"default: [ guest ]",
+		"roles: { admin: { inherits: [ root ] } }",
+		"routes: [ { route: admin, roles: [ admin ] } ]",
+	} {
+		if _, err = ParsePolicy([]byte(invalid)); err == nil {
+			t.Errorf("%s: expected an error", invalid)
+		}
+	}
+}
+
+func Test_handler(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received string
+	authorizer := New(policy, func(r *http.Request) *Principal {
+		if id := r.Header.Get("X-User"); id != "" {
+			return &Principal{ID: id, Roles: ParseList(r.Header.Get("X-Roles"))}
+		}
+		return nil
+	})
+	handler := authorizer.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		if p := FromRequest(r); p != nil {
+			w.Write([]byte(p.ID))
+		}
+	}))
+
+	tests := []struct {
+		method, path, body, user, roles string
+		expected                        int
+	}{
+		{"GET", "/", "", "", "", http.StatusOK},
+		{"GET", "/admin/users", "", "", "", http.StatusUnauthorized},
+		{"GET", "/admin/users", "", "1", "editor", http.StatusForbidden},
+		{"GET", "/admin/users", "", "1", "admin", http.StatusOK},
+		{"GET", "/posts", "", "", "", http.StatusOK},
+		{"POST", "/posts", "", "1", "", http.StatusForbidden},
+		{"POST", "/posts", "", "1", "editor", http.StatusOK},
+		{"GET", "/account", "", "1", "", http.StatusOK},
+		{"POST", "/live", `{"t":"Chat:1","e":"send","s":1,"p":{}}`, "", "", http.StatusUnauthorized},
+		{"POST", "/live", `{"t":"Chat:1","e":"send","s":1,"p":{}}`, "1", "", http.StatusOK},
+		{"POST", "/live", `{"t":"Chat:1","e":"delete","s":2,"p":{}}`, "1", "editor", http.StatusForbidden},
+		{"POST", "/live", `{"t":"Chat:1","e":"delete","s":2,"p":{}}`, "1", "admin", http.StatusOK},
+		{"POST", "/live", `{"t":"Counter:1","e":"inc","s":1,"p":{}}`, "", "", http.StatusOK},
+		{"POST", "/live", `not json`, "", "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		received = ""
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.user != "" {
+			req.Header.Set("X-User", test.user)
+			req.Header.Set("X-Roles", test.roles)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("%s %s %s (%s): got %d, expected %d", test.method, test.path, test.body, test.roles, rec.Code, test.expected)
+		}
+		if rec.Code == http.StatusOK && received != test.body {
+			t.Errorf("%s %s: the body was not restored, got %q", test.method, test.path, received)
+		}
+	}
+}
+
+func Test_check(t *testing.T) {
+	q := &Requirement{Permissions: []string{"users.write"}}
+	if err := Check(context.Background(), q); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("without principal: got %v", err)
+	}
+	if err := Check(context.Background(), nil); err != nil {
+		t.Errorf("without requirement: got %v", err)
+	}
+	if err := Check(WithSystem(context.Background()), q); err != nil {
+		t.Errorf("system: got %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), stateKey{}, &state{resolve: func() *Principal { return nil }})
+	if err := Check(ctx, q); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("anonymous: got %v", err)
+	}
+	ctx = context.WithValue(context.Background(), stateKey{}, &state{resolve: func() *Principal {
+		return &Principal{ID: "1", Permissions: []string{"users.*"}}
+	}})
+	if err := Check(ctx, q); err != nil {
+		t.Errorf("granted: got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package authz
+
+import (
+	"github.com/syntax-framework/shtml/sht"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+// Register adds the directives `roles-allowed` (any of the roles) and `permissions-required` (all the permissions) to
+// the templates. The elements are removed from the page when the principal of the render (nil when anonymous) does
+// not meet the requirement, the content never reaches the browser. The principal is resolved from the scope of the
+// render. Must be called before `app.Init()`.
+//
+//	<div roles-allowed="admin, editor">...</div>
+//	<button permissions-required="posts.write">Publish</button>
+func Register(app *syntax.Syntax, principal func(scope *sht.Scope) *Principal) {
+	app.Template.(*sht.TemplateSystem).Register(
+		directive("roles-allowed", principal, func(values []string) *Requirement {
+			return &Requirement{Roles: values}
+		}),
+		directive("permissions-required", principal, func(values []string) *Requirement {
+			return &Requirement{Permissions: values}
+		}),
+	)
+}
+
+func directive(name string, principal func(scope *sht.Scope) *Principal, requirement func(values []string) *Requirement) *sht.Directive {
+	return &sht.Directive{
+		Name:     name,
+		Restrict: sht.ATTRIBUTE,
+		// before `if` (899), the condition is not evaluated for the removed elements
+		Priority:   950,
+		Terminal:   true,
+		Transclude: "element",
+		Compile: func(node *sht.Node, attrs *sht.Attributes, c *sht.Compiler) (*sht.DirectiveMethods, error) {
+			values := ParseList(attrs.Get(name))
+			q := requirement(values)
+			return &sht.DirectiveMethods{
+				Process: func(scope *sht.Scope, attrs *sht.Attributes, transclude sht.TranscludeFunc) *sht.Rendered {
+					// an empty list allows no one
+					if len(values) == 0 || q.Check(principal(scope)) != nil {
+						return nil
+					}
+					return transclude("", nil)
+				},
+			}, nil
+		},
+	}
+}
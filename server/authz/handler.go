@@ -0,0 +1,124 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/requestid"
+)
+
+// LivePath endpoint of the events of the live controllers (stx.js)
+const LivePath = "/live"
+
+var denied = metrics.NewCounter("authz_denied_total", "Requests denied by the roles and permissions", "reason")
+
+// Authorizer enforces the policy on the requests
+type Authorizer struct {
+	// Identify returns the logged user of the request with its own roles, nil for anonymous requests. The default
+	// roles, the inherited roles and the permissions are added by the policy.
+	Identify func(r *http.Request) *Principal
+
+	mutex  sync.RWMutex
+	policy *Policy
+}
+
+// New creates the Authorizer of the policy
+func New(policy *Policy, identify func(r *http.Request) *Principal) *Authorizer {
+	return &Authorizer{Identify: identify, policy: policy}
+}
+
+// Policy returns the current policy
+func (a *Authorizer) Policy() *Policy {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.policy
+}
+
+// SetPolicy replaces the policy (Ex. auth/policy.yaml changed in dev), the requests in progress keep the previous one
+func (a *Authorizer) SetPolicy(policy *Policy) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.policy = policy
+}
+
+// Handler adds the principal to the context of the request (see FromRequest) and checks the rules of the routes and
+// of the live events, 401 for anonymous requests and 403 for the logged users without the role or permission
+func (a *Authorizer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := a.Policy()
+		s := &state{resolve: func() *Principal {
+			if a.Identify == nil {
+				return nil
+			}
+			return policy.Expand(a.Identify(r))
+		}}
+		r = r.WithContext(context.WithValue(r.Context(), stateKey{}, s))
+
+		for _, rule := range policy.Routes {
+			if _, matches := rule.rule.Match(r); !matches {
+				continue
+			}
+			if err := rule.Check(s.get()); err != nil {
+				deny(w, r, err, "route "+rule.Route)
+				return
+			}
+		}
+
+		if r.Method == http.MethodPost && r.URL.Path == LivePath && len(policy.Live) > 0 {
+			topic, event, err := liveEvent(r)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			for _, q := range policy.liveRequirements(topic, event) {
+				if err = q.Check(s.get()); err != nil {
+					deny(w, r, err, "live event "+topic+" "+event)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// liveEvent reads the topic and the event of the payload of stx.js (`{"t": "Controller:id", "e": "event", ...}`), the
+// body is restored for the framework
+func liveEvent(r *http.Request) (topic string, event string, err error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	payload := struct {
+		Topic string `json:"t"`
+		Event string `json:"e"`
+	}{}
+	if err = json.Unmarshal(body, &payload); err != nil {
+		return "", "", err
+	}
+	return payload.Topic, payload.Event, nil
+}
+
+func deny(w http.ResponseWriter, r *http.Request, err error, resource string) {
+	status := http.StatusForbidden
+	if errors.Is(err, ErrUnauthenticated) {
+		status = http.StatusUnauthorized
+	}
+	denied.Inc(reason(err))
+	log.Printf("authz: %s %s denied by %s: %v (request %s)", r.Method, r.URL.Path, resource, err, requestid.FromRequest(r))
+	http.Error(w, http.StatusText(status), status)
+}
+
+// FromRequest returns the principal of the request, nil for anonymous requests
+func FromRequest(r *http.Request) *Principal {
+	p, _ := FromContext(r.Context())
+	return p
+}
@@ -0,0 +1,158 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/route"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy the permissions of the roles and the requirements of the routes and of the live events
+type Policy struct {
+	Default []string                `yaml:"default"` // Roles of every logged user
+	Roles   map[string]*Role        `yaml:"roles"`
+	Routes  []*RouteRule            `yaml:"routes"`
+	Live    map[string]*Requirement `yaml:"live"` // By "Controller" (all events) or "Controller.event"
+}
+
+// Role grants its permissions and the ones of the inherited roles
+type Role struct {
+	Inherits    []string `yaml:"inherits"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// RouteRule the requests of the route (Ex. "/admin/*", "POST /posts") must meet the requirement
+type RouteRule struct {
+	Route       string `yaml:"route"`
+	Requirement `yaml:",inline"`
+
+	rule *route.Rule
+}
+
+// UnmarshalYAML decodes the route and the requirement, the UnmarshalYAML of the embedded Requirement would skip the
+// route
+func (r *RouteRule) UnmarshalYAML(node *yaml.Node) error {
+	var value struct {
+		Route string `yaml:"route"`
+	}
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	r.Route = value.Route
+	return node.Decode(&r.Requirement)
+}
+
+// LoadPolicy reads the policy file of the FileSystem, an empty policy when the file does not exist
+func LoadPolicy(fsys fs.FS, file string) (*Policy, error) {
+	data, err := fs.ReadFile(fsys, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Policy{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return p, nil
+}
+
+// ParsePolicy decodes and validates the policy
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	for _, role := range p.Default {
+		if _, exists := p.Roles[role]; !exists {
+			return nil, fmt.Errorf("default: there is no role named %q", role)
+		}
+	}
+	for name, role := range p.Roles {
+		if role == nil {
+			p.Roles[name] = &Role{}
+			continue
+		}
+		for _, inherited := range role.Inherits {
+			if _, exists := p.Roles[inherited]; !exists {
+				return nil, fmt.Errorf("roles.%s.inherits: there is no role named %q", name, inherited)
+			}
+		}
+	}
+	for i, rule := range p.Routes {
+		parsed, err := route.ParseRule(rule.Route)
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d]: %w", i, err)
+		}
+		rule.rule = parsed
+	}
+	return p, nil
+}
+
+// Expand returns the principal with the default roles, the inherited roles and the permissions of all of them. The
+// machine principals are returned as is, they only have the scopes of their credentials.
+func (p *Policy) Expand(principal *Principal) *Principal {
+	if principal == nil || principal.Machine {
+		return principal
+	}
+	roles := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		if roles[name] {
+			return
+		}
+		roles[name] = true
+		if role := p.Roles[name]; role != nil {
+			for _, inherited := range role.Inherits {
+				visit(inherited)
+			}
+		}
+	}
+	for _, name := range append(append([]string{}, p.Default...), principal.Roles...) {
+		visit(name)
+	}
+
+	expanded := &Principal{ID: principal.ID}
+	permissions := map[string]bool{}
+	for name := range roles {
+		expanded.Roles = append(expanded.Roles, name)
+		if role := p.Roles[name]; role != nil {
+			for _, permission := range role.Permissions {
+				permissions[permission] = true
+			}
+		}
+	}
+	for permission := range permissions {
+		expanded.Permissions = append(expanded.Permissions, permission)
+	}
+	sort.Strings(expanded.Roles)
+	sort.Strings(expanded.Permissions)
+	return expanded
+}
+
+// HasRole reports whether the role is declared
+func (p *Policy) HasRole(name string) bool {
+	_, exists := p.Roles[name]
+	return exists
+}
+
+// liveRequirements returns the requirements of the event of the controller, by the topic of stx.js
+// ("Controller:instance")
+func (p *Policy) liveRequirements(topic, event string) []*Requirement {
+	controller := topic
+	if i := strings.IndexByte(topic, ':'); i >= 0 {
+		controller = topic[:i]
+	}
+	var requirements []*Requirement
+	if q := p.Live[controller]; q != nil {
+		requirements = append(requirements, q)
+	}
+	if q := p.Live[controller+"."+event]; q != nil {
+		requirements = append(requirements, q)
+	}
+	return requirements
+}
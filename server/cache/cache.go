@@ -0,0 +1,168 @@
+// Package cache stores the results of the queries that declare a `cache` block, in memory or in one of the redis
+// connections of config.yaml:
+//
+//	cache:
+//	  engine: redis
+//	  redis: my-redis
+//	  ttl: 1m
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/redis"
+)
+
+const (
+	DefaultTTL  = time.Minute
+	DefaultSize = 1000
+)
+
+var (
+	hits   uint64
+	misses uint64
+
+	lookups = metrics.NewCounter("cache_lookups_total", "Lookups on the cache, by result (hit, miss)", "result")
+	_       = metrics.NewGaugeFunc("cache_hit_ratio", "Fraction of the lookups found on the cache", hitRatio)
+)
+
+// Cache a key/value store with expiration
+type Cache interface {
+	// Get returns the value of the key, false if it does not exist or is expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// New creates the cache of the configuration, the redis engine uses the client of the named connection
+func New(cfg config.Cache, clients map[string]*redis.Client) (Cache, error) {
+	switch cfg.Engine {
+	case "", "memory":
+		size := cfg.Size
+		if size <= 0 {
+			size = DefaultSize
+		}
+		return &counted{&Memory{Size: size}}, nil
+	case "redis":
+		client, exists := clients[cfg.Redis]
+		if !exists {
+			return nil, fmt.Errorf("cache: there is no redis connection named %q", cfg.Redis)
+		}
+		return &counted{&Redis{Client: client, Prefix: "cache:"}}, nil
+	}
+	return nil, fmt.Errorf("cache: unknown engine %q", cfg.Engine)
+}
+
+// counted records the hits and misses of the cache
+type counted struct {
+	Cache
+}
+
+func (c *counted) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, found, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		atomic.AddUint64(&hits, 1)
+		lookups.Inc("hit")
+	} else {
+		atomic.AddUint64(&misses, 1)
+		lookups.Inc("miss")
+	}
+	return value, found, nil
+}
+
+func hitRatio() float64 {
+	h, m := atomic.LoadUint64(&hits), atomic.LoadUint64(&misses)
+	if h+m == 0 {
+		return 0
+	}
+	return float64(h) / float64(h+m)
+}
+
+// Memory a LRU cache limited to Size entries
+type Memory struct {
+	Size int
+
+	mutex   sync.Mutex
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	element, exists := m.entries[key]
+	if !exists {
+		return nil, false, nil
+	}
+	e := element.Value.(*entry)
+	if time.Now().After(e.expires) {
+		m.order.Remove(element)
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(element)
+	return e.value, true, nil
+}
+
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.entries == nil {
+		m.entries = map[string]*list.Element{}
+		m.order = list.New()
+	}
+	e := &entry{key: key, value: value, expires: time.Now().Add(ttl)}
+	if element, exists := m.entries[key]; exists {
+		element.Value = e
+		m.order.MoveToFront(element)
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(e)
+	for m.Size > 0 && m.order.Len() > m.Size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*entry).key)
+	}
+	return nil
+}
+
+// Redis stores the entries on a redis server, with the key prefix
+type Redis struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := r.Client.Do(ctx, "GET", r.Prefix+key)
+	if errors.Is(err, redis.ErrNil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	value, isString := reply.(string)
+	if !isString {
+		return nil, false, fmt.Errorf("cache: unexpected reply %T", reply)
+	}
+	return []byte(value), true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := r.Client.Do(ctx, "SET", r.Prefix+key, value, "PX", ttl.Milliseconds())
+	return err
+}
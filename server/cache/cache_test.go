@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+)
+
+func Test_memory(t *testing.T) {
+	ctx := context.Background()
+	m := &Memory{Size: 2}
+	m.Set(ctx, "a", []byte("1"), time.Minute)
+	m.Set(ctx, "b", []byte("2"), time.Minute)
+	m.Get(ctx, "a") // b is now the least recently used
+	m.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, found, _ := m.Get(ctx, "b"); found {
+		t.Error("Expected b to be evicted")
+	}
+	if value, found, _ := m.Get(ctx, "a"); !found || string(value) != "1" {
+		t.Errorf("Expected a=1, got %q %v", value, found)
+	}
+
+	m.Set(ctx, "expired", []byte("x"), -time.Second)
+	if _, found, _ := m.Get(ctx, "expired"); found {
+		t.Error("Expected the entry to be expired")
+	}
+}
+
+func Test_hit_ratio(t *testing.T) {
+	ctx := context.Background()
+	c, err := New(config.Cache{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Get(ctx, "a")
+	c.Get(ctx, "a")
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+
+	if ratio := hitRatio(); ratio != 0.75 {
+		t.Errorf("Expected 0.75, got %v", ratio)
+	}
+	if hits := lookups.Value("hit"); hits != 3 {
+		t.Errorf("Expected 3 hits, got %v", hits)
+	}
+
+	if _, err = New(config.Cache{Engine: "redis", Redis: "nope"}, nil); err == nil {
+		t.Error("Expected an error for an unknown redis connection")
+	}
+}
@@ -0,0 +1,33 @@
+// Package clientip finds the address of the client of a request, used by the access log and the rate limiter.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// FromRequest returns the IP of the client. With trustProxy, X-Real-IP or the last X-Forwarded-For entry (the one
+// added by the proxy) are used instead of the connection address.
+//
+// Returns empty for connections on unix sockets.
+func FromRequest(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			entries := strings.Split(forwarded, ",")
+			return strings.TrimSpace(entries[len(entries)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	// unix sockets (Ex. "@")
+	if host == "@" {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,297 @@
+// Package compress compresses the responses with brotli or gzip, negotiated by the Accept-Encoding header.
+//
+// Only the content types of the allowlist are compressed, from MinSize bytes. The responses already encoded, partial
+// (Range) and marked with `Cache-Control: no-transform` are sent as they are. A Flush before MinSize starts the
+// compression, the streaming handlers keep working.
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	Brotli = "br"
+	Gzip   = "gzip"
+
+	DefaultMinSize = 1024
+)
+
+// DefaultContentTypes compressible content types, matched by prefix
+var DefaultContentTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"text/xml",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/manifest+json",
+	"image/svg+xml",
+}
+
+// Compressor the compression middleware
+type Compressor struct {
+	MinSize      int      // Smaller responses are not compressed. Defaults to DefaultMinSize
+	ContentTypes []string // Allowlist, matched by prefix. Defaults to DefaultContentTypes
+	Encodings    []string // Supported encodings, by preference. Defaults to br, gzip
+}
+
+// encoder a pooled compressor of an encoding
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var pools = map[string]*sync.Pool{
+	Brotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, 5)
+	}},
+	Gzip: {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+}
+
+// Handler compresses the responses of next
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Vary is set for the eligible responses, the Range requests are never compressed
+		if r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &writer{
+			ResponseWriter: w,
+			compressor:     c,
+			encoding:       c.negotiate(r.Header.Get("Accept-Encoding")),
+			head:           r.Method == http.MethodHead,
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate returns the encoding of the highest quality accepted, by the preference of the server on ties. Empty
+// when none is accepted.
+func (c *Compressor) negotiate(header string) string {
+	if header == "" {
+		return ""
+	}
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		qualities[name] = quality
+	}
+
+	encodings := c.Encodings
+	if len(encodings) == 0 {
+		encodings = []string{Brotli, Gzip}
+	}
+	best, bestQuality := "", 0.0
+	for _, encoding := range encodings {
+		if pools[encoding] == nil {
+			continue
+		}
+		quality, exists := qualities[encoding]
+		if !exists {
+			quality, exists = qualities["*"]
+		}
+		if exists && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+func (c *Compressor) allowed(contentType string) bool {
+	types := c.ContentTypes
+	if len(types) == 0 {
+		types = DefaultContentTypes
+	}
+	contentType = strings.ToLower(contentType)
+	for _, allowed := range types {
+		if strings.HasPrefix(contentType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compressor) minSize() int {
+	if c.MinSize <= 0 {
+		return DefaultMinSize
+	}
+	return c.MinSize
+}
+
+// writer holds the first MinSize bytes to decide whether the response is compressed
+type writer struct {
+	http.ResponseWriter
+	compressor *Compressor
+	encoding   string // negotiated, empty when the client does not accept any
+	head       bool
+
+	status  int
+	decided bool
+	buffer  []byte
+	encoder encoder // nil when the response is not compressed
+}
+
+func (w *writer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *writer) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		if len(w.buffer)+len(data) < w.compressor.minSize() {
+			w.buffer = append(w.buffer, data...)
+			return len(data), nil
+		}
+		w.buffer = append(w.buffer, data...)
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// decide sends the header, compressing when the response is eligible and big enough, and the buffered bytes
+func (w *writer) decide(bigEnough bool) error {
+	w.decided = true
+	header := w.Header()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" && len(w.buffer) > 0 {
+		// the same detection done by net/http, the header must be set before deciding
+		contentType = http.DetectContentType(w.buffer)
+		header.Set("Content-Type", contentType)
+	}
+
+	eligible := w.eligible(contentType)
+	if eligible {
+		addVary(header, "Accept-Encoding")
+	}
+	if eligible && bigEnough && w.encoding != "" {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// the representation changed, the strong validator no longer applies
+			header.Set("ETag", "W/"+etag)
+		}
+		if !w.head {
+			w.encoder = pools[w.encoding].Get().(encoder)
+			w.encoder.Reset(w.ResponseWriter)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buffer) == 0 || w.head {
+		w.buffer = nil
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buffer)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer)
+	}
+	w.buffer = nil
+	return err
+}
+
+// eligible reports whether the response can be compressed, regardless of the size and of the client
+func (w *writer) eligible(contentType string) bool {
+	header := w.Header()
+	if w.status < 200 || w.status == http.StatusNoContent || w.status == http.StatusNotModified ||
+		w.status == http.StatusPartialContent || header.Get("Content-Range") != "" {
+		return false
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	if length := header.Get("Content-Length"); length != "" {
+		if size, err := strconv.Atoi(length); err == nil && size < w.compressor.minSize() {
+			return false
+		}
+	}
+	return w.compressor.allowed(contentType)
+}
+
+// Flush sends the buffered bytes, compressed when eligible even if smaller than MinSize
+func (w *writer) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close finishes the response and returns the encoder to the pool
+func (w *writer) close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buffer) == 0 {
+			// nothing was written, net/http sends the 200
+			return
+		}
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.encoder.Reset(nil)
+		pools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+func addVary(header http.Header, value string) {
+	for _, vary := range header.Values("Vary") {
+		for _, v := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) || strings.TrimSpace(v) == "*" {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
@@ -0,0 +1,152 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func Test_negotiate(t *testing.T) {
+	c := &Compressor{}
+	for header, expected := range map[string]string{
+		"":                         "",
+		"gzip":                     "gzip",
+		"gzip, deflate, br":        "br",
+		"br;q=0.5, gzip":           "gzip",
+		"br;q=0, gzip;q=0":         "",
+		"*":                        "br",
+		"*;q=0.1, gzip;q=0.5":      "gzip",
+		"deflate, identity":        "",
+		" GZIP ; q=0.8 , br;q=0.8": "br",
+	} {
+		if got := c.negotiate(header); got != expected {
+			t.Errorf("%q: Expected %q, got %q", header, expected, got)
+		}
+	}
+}
+
+var page = strings.Repeat("<p>hello world</p>", 200)
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	var reader io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case Gzip:
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gz
+	case Brotli:
+		reader = brotli.NewReader(reader)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func Test_handler(t *testing.T) {
+	handler := (&Compressor{}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Write([]byte("<p>small</p>"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(page))
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte(page))
+		case "/etag":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+			w.Write([]byte(page))
+		default:
+			// no Content-Type, sniffed
+			w.Write([]byte(page[:500]))
+			w.Write([]byte(page[500:]))
+		}
+	}))
+
+	for _, test := range []struct {
+		path     string
+		accept   string
+		range_   string
+		encoding string
+		vary     bool
+	}{
+		{"/", "gzip", "", Gzip, true},
+		{"/", "br, gzip", "", Brotli, true},
+		{"/", "", "", "", true},
+		{"/", "gzip", "bytes=0-10", "", false},
+		{"/small", "gzip", "", "", true},
+		{"/image", "gzip", "", "", false},
+		{"/encoded", "br", "", "gzip", false},
+		{"/etag", "gzip", "", Gzip, true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept-Encoding", test.accept)
+		}
+		if test.range_ != "" {
+			req.Header.Set("Range", test.range_)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		name := test.path + " " + test.accept
+		if got := rec.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%s: Expected encoding %q, got %q", name, test.encoding, got)
+		}
+		if got := rec.Header().Get("Vary") == "Accept-Encoding"; got != test.vary {
+			t.Errorf("%s: Expected vary %v, got %q", name, test.vary, rec.Header().Get("Vary"))
+		}
+		if test.encoding == Gzip || test.encoding == Brotli {
+			if test.path != "/encoded" && decode(t, test.encoding, rec.Body.Bytes()) != page {
+				t.Errorf("%s: invalid body", name)
+			}
+			if rec.Header().Get("Content-Length") != "" {
+				t.Errorf("%s: Expected no Content-Length", name)
+			}
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if etag := rec.Header().Get("ETag"); etag != `W/"v1"` {
+		t.Errorf("Expected a weak ETag, got %s", etag)
+	}
+}
+
+func Test_flush(t *testing.T) {
+	flushed := make(chan string, 1)
+	handler := (&Compressor{}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		flushed <- ""
+		w.Write([]byte(" second chunk"))
+	}))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(rec, req)
+	<-flushed
+
+	if !rec.Flushed || rec.Header().Get("Content-Encoding") != Gzip {
+		t.Fatalf("Expected a flushed gzip response, got %v", rec.Header())
+	}
+	if body := decode(t, Gzip, rec.Body.Bytes()); body != "first chunk second chunk" {
+		t.Errorf("got %q", body)
+	}
+}
@@ -0,0 +1,577 @@
+// Package config loads the application configuration from config.yaml into typed structs.
+//
+// Unknown keys, values of the wrong type and missing required fields are rejected, every problem is reported with the
+// file and line where it was declared.
+//
+// The file is processed in the following order, each step overriding the previous one:
+//
+//  1. config.yaml
+//  2. the profile overlay, config.<profile>.yaml (Ex. config.prod.yaml), when a profile is active
+//  3. `${ENV_VAR:default}` interpolation on every scalar value
+//  4. `DEMO_*` environment variables (Ex. DEMO_LIVE_RELOAD_INTERVAL=50, DEMO_DB_MYDATABASE_ENGINE=sqlite)
+//
+// After loading, the configuration is available to controllers and subsystems through Current().
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Dev         bool                `yaml:"dev"`         // Running in development mode
+	Content     []string            `yaml:"content"`     // Content directories
+	Server      Server              `yaml:"server"`      // Listeners
+	AccessLog   AccessLog           `yaml:"access-log"`  // One line per request
+	Metrics     Metrics             `yaml:"metrics"`     // Prometheus endpoint
+	Tracing     Tracing             `yaml:"tracing"`     // Spans exported to an OpenTelemetry collector
+	Compression Compression         `yaml:"compression"` // gzip and brotli responses
+	RateLimit   RateLimit           `yaml:"rate-limit"`  // Requests per client, by route or group
+	Limits      Limits              `yaml:"limits"`      // Timeouts and request body sizes, by route or group
+	Security    Security            `yaml:"security-headers"`
+	CSRF        CSRF                `yaml:"csrf"`        // Cross-site request forgery tokens on forms and live events
+	Session     Session             `yaml:"session"`     // Sessions of the users, in an encrypted cookie or server-side
+	Embed       Embed               `yaml:"embed"`       // Application files embedded in the binary
+	LiveReload  LiveReload          `yaml:"live-reload"` // Live reload, only used when Dev is true
+	Redis       map[string]*Redis   `yaml:"redis"`       // Redis connections, by name
+	DB          map[string]*DB      `yaml:"db"`          // SQL database connections, by name
+	Storage     map[string]*Storage `yaml:"storage"`     // File storages, by name
+	Cache       Cache               `yaml:"cache"`
+	CMS         CMS                 `yaml:"cms"`
+	Auth        Auth                `yaml:"auth"`
+	API         API                 `yaml:"api"`     // Authentication of the machine clients (API tokens, signed requests)
+	Secrets     Secrets             `yaml:"secrets"` // Encrypted file of the values referenced as `secret:<name>`
+	Tenants     Tenants             `yaml:"tenants"` // Customers served by the deployment, with their own db, storage and cms
+
+	// Files that were merged to produce this configuration, in order
+	Files []string `yaml:"-"`
+}
+
+// Server the addresses accept `host:port`, `tcp:host:port`, `unix:/path` and `systemd:name`, see package listen
+type Server struct {
+	Addr     string     `yaml:"addr"` // TLS address. Defaults to `localhost:8080`
+	Cert     string     `yaml:"cert"` // Certificate file. Defaults to `localhost.crt`
+	Key      string     `yaml:"key"`  // Private key file. Defaults to `localhost.key`
+	Socket   Socket     `yaml:"socket"`
+	HTTP     ServerHTTP `yaml:"http"`
+	HSTS     HSTS       `yaml:"hsts"`
+	Health   Health     `yaml:"health"`
+	Shutdown Shutdown   `yaml:"shutdown"`
+}
+
+type ServerHTTP struct {
+	Addr      string `yaml:"addr"`       // Plain HTTP address redirecting to TLS. Empty disables the listener
+	WellKnown string `yaml:"well-known"` // Directory served under /.well-known/ by the plain HTTP listener
+}
+
+// Socket permissions of the `unix:` listeners
+type Socket struct {
+	Mode  string `yaml:"mode"`  // Octal permissions. Defaults to 0660
+	Group string `yaml:"group"` // Group owner (Ex. www-data, to allow nginx)
+}
+
+type HSTS struct {
+	MaxAge            int  `yaml:"max-age"`            // Seconds. 0 disables the header
+	IncludeSubDomains bool `yaml:"include-subdomains"` // The rule also applies to all subdomains
+	Preload           bool `yaml:"preload"`            // Allows the domain to be included in the browsers preload list
+}
+
+type Health struct {
+	Timeout Duration `yaml:"timeout"` // Timeout of each readiness check. Defaults to 2s
+	Cache   Duration `yaml:"cache"`   // Time the result of a check is reused. Defaults to 2s
+}
+
+type Shutdown struct {
+	Drain   Duration `yaml:"drain"`   // Time /readyz reports draining before the listeners are closed
+	Timeout Duration `yaml:"timeout"` // Max time to wait for the requests in progress. Defaults to 30s
+}
+
+type AccessLog struct {
+	Disabled   bool     `yaml:"disabled"`
+	Format     string   `yaml:"format" check:"oneof=json|combined"` // Defaults to json
+	Output     string   `yaml:"output"`                             // stdout, stderr or a file. Defaults to stdout
+	Sample     float64  `yaml:"sample"`                             // Fraction of the requests logged (0.1 = 10%), errors are always logged. Defaults to 1
+	Exclude    []string `yaml:"exclude"`                            // Paths not logged, `*` suffix matches the prefix (Ex. /assets/*)
+	TrustProxy bool     `yaml:"trust-proxy"`                        // Remote address from X-Real-IP/X-Forwarded-For
+}
+
+type Metrics struct {
+	Disabled bool   `yaml:"disabled"`
+	Endpoint string `yaml:"endpoint"` // Defaults to /metrics
+}
+
+type Tracing struct {
+	Exporter string            `yaml:"exporter" check:"oneof=none|otlp"` // Defaults to none (disabled)
+	Endpoint string            `yaml:"endpoint"`                         // OTLP/HTTP collector. Defaults to http://localhost:4318
+	Headers  map[string]string `yaml:"headers"`                          // Sent to the collector (Ex. authorization)
+	Service  string            `yaml:"service"`                          // service.name of the spans. Defaults to demo
+	Sample   float64           `yaml:"sample"`                           // Fraction of the new traces recorded. Defaults to 1
+}
+
+type Compression struct {
+	Disabled     bool     `yaml:"disabled"`
+	MinSize      int      `yaml:"min-size"`      // Smaller responses are not compressed. Defaults to 1024
+	ContentTypes []string `yaml:"content-types"` // Compressed content types, matched by prefix. Defaults to text, json, js, xml and svg
+}
+
+type RateLimit struct {
+	Store      string           `yaml:"store" check:"oneof=memory|redis"` // Defaults to memory
+	Redis      string           `yaml:"redis"`                            // Name of the redis connection, when store is redis
+	TrustProxy bool             `yaml:"trust-proxy"`                      // Client IP from X-Real-IP/X-Forwarded-For
+	Rules      []*RateLimitRule `yaml:"rules"`
+}
+
+// RateLimitRule every request that matches one of the routes consumes the quota of its key
+type RateLimitRule struct {
+	Name      string   `yaml:"name" check:"required"`                               // Used on the store keys and on the metrics
+	Routes    []string `yaml:"routes" check:"required"`                             // "[METHOD ]pattern", `*` suffix is a group (Ex. "POST /login", "/api/*")
+	Key       string   `yaml:"key"`                                                 // ip, user, api-key, param:<name> or header:<name> (requires trust-proxy). Defaults to ip
+	Algorithm string   `yaml:"algorithm" check:"oneof=sliding-window|token-bucket"` // Defaults to sliding-window
+	Limit     int      `yaml:"limit" check:"required"`                              // Requests per window
+	Window    Duration `yaml:"window" check:"required"`
+	Burst     int      `yaml:"burst"` // Size of the token bucket. Defaults to limit
+}
+
+type Limits struct {
+	Timeout       Duration       `yaml:"timeout"`                              // Deadline of the requests, 0 disables
+	TimeoutStatus int            `yaml:"timeout-status" check:"oneof=503|504"` // Defaults to 503
+	TimeoutBody   string         `yaml:"timeout-body"`                         // Response on timeout, empty uses the error page
+	MaxBodyBytes  int64          `yaml:"max-body-bytes"`                       // Larger request bodies are rejected with 413, 0 disables
+	Routes        []*LimitsRoute `yaml:"routes"`                               // Overrides, the first route that informs the value is used
+}
+
+// LimitsRoute overrides the defaults of Limits, the keys not informed keep the default
+type LimitsRoute struct {
+	Routes       []string  `yaml:"routes" check:"required"` // "[METHOD ]pattern", `*` suffix is a group (Ex. "POST /upload/*")
+	Timeout      *Duration `yaml:"timeout"`                 // 0 disables (Ex. streams)
+	MaxBodyBytes *int64    `yaml:"max-body-bytes"`          // 0 disables
+}
+
+// Security headers of the responses
+type Security struct {
+	Disabled           bool   `yaml:"disabled"`
+	CSP                CSP    `yaml:"csp"`
+	ContentTypeOptions string `yaml:"content-type-options"`                        // Defaults to nosniff
+	ReferrerPolicy     string `yaml:"referrer-policy"`                             // Defaults to strict-origin-when-cross-origin
+	PermissionsPolicy  string `yaml:"permissions-policy"`                          // Ex. camera=(), microphone=(), geolocation=()
+	FrameOptions       string `yaml:"frame-options" check:"oneof=DENY|SAMEORIGIN"` // Defaults to DENY
+}
+
+// CSP Content-Security-Policy, `'nonce'` in the directives is replaced by the nonce of the request, also added to the
+// inline scripts and styles of the pages
+type CSP struct {
+	Disabled   bool              `yaml:"disabled"`
+	ReportOnly bool              `yaml:"report-only"` // Violations are only reported (Content-Security-Policy-Report-Only)
+	Directives map[string]string `yaml:"directives"`  // Ex. script-src: "'self' 'nonce'". Defaults to 'self' and nonces
+	ReportURI  string            `yaml:"report-uri"`  // Endpoint that collects the violations. Defaults to /csp-report
+}
+
+// CSRF tokens checked on the unsafe methods (POST, PUT, PATCH and DELETE)
+type CSRF struct {
+	Disabled bool     `yaml:"disabled"`
+	Exempt   []string `yaml:"exempt"` // Routes not checked, "[METHOD ]pattern" (Ex. inbound webhooks)
+}
+
+// Session the data is kept in the encrypted cookie (store cookie) or in a server-side store, the cookie only has the ID
+type Session struct {
+	Store           string   `yaml:"store" check:"oneof=cookie|memory|db|redis"` // Defaults to cookie
+	DB              string   `yaml:"db"`                                         // Connection of the db store
+	Redis           string   `yaml:"redis"`                                      // Connection of the redis store
+	Cookie          string   `yaml:"cookie"`                                     // Defaults to __Host-session
+	Keys            []string `yaml:"keys"`                                       // Base64 AES keys, the first encrypts and all decrypt
+	IdleTimeout     Duration `yaml:"idle-timeout"`                               // Defaults to 30m
+	AbsoluteTimeout Duration `yaml:"absolute-timeout"`                           // Defaults to 24h
+}
+
+type Embed struct {
+	Enabled *bool  `yaml:"enabled"` // Serve the files embedded in the binary. Defaults to true when dev is false
+	Overlay string `yaml:"overlay"` // Directory on disk layered over the embedded files, used for hotfixes
+}
+
+// Embedded reports whether the application files embedded in the binary are used
+func (c *Config) Embedded() bool {
+	if c.Embed.Enabled != nil {
+		return *c.Embed.Enabled
+	}
+	return !c.Dev
+}
+
+type LiveReload struct {
+	Disabled        bool     `yaml:"disabled"`           // Allows you to disable LiveReload entirely
+	Interval        int      `yaml:"interval"`           // Millis to wait on client to refresh when receive update. Defaults to `100`
+	Debounce        int      `yaml:"debounce"`           // Millis to wait before sending live reload events to the browser
+	Patterns        []string `yaml:"patterns"`           // Regex of the files that trigger the live reloading
+	Endpoint        string   `yaml:"endpoint"`           // Endpoint of the live reload SSE event. Defaults to `/dev.livereload`
+	ReloadPageOnCss bool     `yaml:"reload-page-on-css"` // If true, CSS changes will trigger a full page reload
+}
+
+type Redis struct {
+	URI string `yaml:"uri" check:"required"`
+}
+
+type DB struct {
+	Engine  string `yaml:"engine" check:"required,oneof=sqlite|postgres|mysql"`
+	DSN     string `yaml:"dsn"`                                   // Data source name, defaults depend on the engine
+	Tenancy string `yaml:"tenancy" check:"oneof=column|database"` // How the tenants share it, empty is not scoped
+}
+
+type Storage struct {
+	Engine    string `yaml:"engine" check:"required,oneof=s3|local"`
+	Dir       string `yaml:"dir"` // local engine
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	Endpoint  string `yaml:"endpoint"`
+	AccessKey string `yaml:"access-key"`
+	SecretKey string `yaml:"secret-key"`
+}
+
+type Cache struct {
+	Engine string   `yaml:"engine" check:"oneof=memory|redis"`
+	Redis  string   `yaml:"redis"` // Name of the redis connection, when engine is redis
+	TTL    Duration `yaml:"ttl"`   // Defaults to 1m
+	Size   int      `yaml:"size"`  // Max entries of the memory engine. Defaults to 1000
+}
+
+type CMS struct {
+	Enabled bool                 `yaml:"enabled"`
+	Sites   []map[string]CMSSite `yaml:"sites"`
+}
+
+type CMSSite struct {
+	Path string `yaml:"path"`
+}
+
+// Auth users of the SQL database, with login, logout, registration and password reset endpoints
+type Auth struct {
+	DB           string      `yaml:"db"`                                 // Connection of the users, empty disables the auth
+	Salt         string      `yaml:"salt"`                               // Pepper of the password hashes, changing it invalidates them
+	TTL          Duration    `yaml:"ttl"`                                // Max duration of a login. 0 keeps it while the session lasts
+	Controller   string      `yaml:"controller"`                         // Name of the auth controller registered by the application
+	Hash         string      `yaml:"hash" check:"oneof=argon2id|bcrypt"` // Defaults to argon2id
+	MinPassword  int         `yaml:"min-password"`                       // Defaults to 8
+	Registration bool        `yaml:"registration"`                       // Enables POST /register
+	RememberMe   Duration    `yaml:"remember-me"`                        // Duration of the remember-me login. Defaults to 720h, negative disables
+	ResetTTL     Duration    `yaml:"reset-ttl"`                          // Validity of the password reset tokens. Defaults to 1h
+	Redirect     string      `yaml:"redirect"`                           // Page after login and logout. Defaults to /
+	BaseURL      string      `yaml:"base-url"`                           // Public URL of the site in the reset links and oidc callbacks. Defaults to https://<server.addr>
+	Lockout      AuthLockout `yaml:"lockout"`
+	Policy       string      `yaml:"policy"` // Roles, permissions and rules of the routes. Defaults to auth/policy.yaml
+	OIDC         AuthOIDC    `yaml:"oidc"`   // Login with OpenID Connect providers ("Sign in with ...")
+}
+
+// AuthLockout the account is locked for Duration after Attempts consecutive failures
+type AuthLockout struct {
+	Attempts int      `yaml:"attempts"` // Defaults to 5, negative disables
+	Duration Duration `yaml:"duration"` // Defaults to 15m
+}
+
+// AuthOIDC the users log in with the providers at GET /login/oidc/<name>, see package oidc
+type AuthOIDC struct {
+	Providers map[string]*OIDCProvider `yaml:"providers"` // By name
+	Local     OIDCLocal                `yaml:"local"`
+}
+
+// OIDCProvider the registration of the site (client) on an OpenID Connect provider
+type OIDCProvider struct {
+	Issuer       string   `yaml:"issuer" check:"required"`    // Metadata at <issuer>/.well-known/openid-configuration
+	ClientID     string   `yaml:"client-id" check:"required"` // The redirect URI is https://<host>/login/oidc/<name>/callback
+	ClientSecret string   `yaml:"client-secret"`              // Empty for public clients, PKCE only
+	Scopes       []string `yaml:"scopes"`                     // Defaults to openid, email and profile
+	RolesClaim   string   `yaml:"roles-claim"`                // Claim with the roles of the user, replaces them on each login
+}
+
+// OIDCLocal built-in provider for development and tests, registered as the provider `local`
+type OIDCLocal struct {
+	Enabled bool   `yaml:"enabled"` // Ignored outside of dev mode
+	Issuer  string `yaml:"issuer"`  // Defaults to https://<server.addr>/oidc
+}
+
+// API the machine clients authenticate with API tokens or by signing the requests, see package apiauth
+type API struct {
+	Tokens APITokens `yaml:"tokens"`
+	HMAC   APIHMAC   `yaml:"hmac"`
+}
+
+// APITokens sent as `Authorization: Bearer`, minted with `demo tokens create`
+type APITokens struct {
+	DB     string   `yaml:"db"`     // Connection of the tokens, empty disables them
+	Routes []string `yaml:"routes"` // Routes that require a token, "[METHOD ]pattern"
+	TTL    Duration `yaml:"ttl"`    // Default validity of the new tokens. Defaults to 2160h (90 days), negative never expires
+}
+
+// APIHMAC requests signed with a key shared with the other server
+type APIHMAC struct {
+	Keys   map[string]*APIKey `yaml:"keys"`                             // By key id
+	Routes []string           `yaml:"routes"`                           // Routes that require a signed request, "[METHOD ]pattern"
+	Skew   Duration           `yaml:"skew"`                             // Max difference between the timestamp and the clock. Defaults to 5m
+	Store  string             `yaml:"store" check:"oneof=memory|redis"` // Nonces already used. Defaults to memory
+	Redis  string             `yaml:"redis"`                            // Name of the redis connection, when store is redis
+}
+
+type APIKey struct {
+	Secret string   `yaml:"secret" check:"required"`
+	Scopes []string `yaml:"scopes"` // Permissions of the signed requests (see auth/policy.yaml)
+}
+
+// Secrets the values `secret:<name>` of the configuration are read from the encrypted file, see package secrets
+type Secrets struct {
+	File    string `yaml:"file"`     // Encrypted with AES-256-GCM. Defaults to secrets.enc
+	KeyFile string `yaml:"key-file"` // Master key, when $DEMO_SECRETS_KEY is not set. Defaults to secrets.key
+}
+
+// Tenants the tenant of each request is resolved from the host, a path prefix or a header, see package tenant
+type Tenants struct {
+	Resolve    []string           `yaml:"resolve"`     // Order of the resolution: host, path and header. Defaults to host
+	Header     string             `yaml:"header"`      // Header set by a trusted proxy. Defaults to X-Tenant
+	TrustProxy bool               `yaml:"trust-proxy"` // Reads the header, only behind a proxy that replaces the one of the client
+	Default    string             `yaml:"default"`     // Tenant of the requests not resolved, empty answers 404
+	Column     string             `yaml:"column"`      // Of the tables of the `tenancy: column` databases. Defaults to tenant_id
+	List       map[string]*Tenant `yaml:"list"`        // By name
+}
+
+// Tenant the db, storage and cms blocks override the ones of the configuration key by key. After the load they hold
+// the complete blocks of the tenant.
+type Tenant struct {
+	Hosts   []string            `yaml:"hosts"` // Exact host names, without the port
+	Path    string              `yaml:"path"`  // Prefix, removed from the path of the request (Ex. /acme)
+	DB      map[string]*DB      `yaml:"db"`
+	Storage map[string]*Storage `yaml:"storage"`
+	CMS     CMS                 `yaml:"cms"`
+}
+
+// Duration accepts Go duration strings ("1h30m", "500ms") or an integer number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	value := strings.TrimSpace(node.Value)
+	if value == "" {
+		*d = 0
+		return nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*d = Duration(time.Duration(seconds) * time.Second)
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", node.Line, node.Value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Std returns the value as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+var current atomic.Value
+
+// Current returns the configuration in use by the application.
+//
+// Returns an empty configuration if none was set.
+func Current() *Config {
+	if c, ok := current.Load().(*Config); ok {
+		return c
+	}
+	return &Config{}
+}
+
+// Set defines the configuration in use by the application.
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// setDefaults fill the values not informed in the configuration files
+func (s *Secrets) setDefaults() {
+	if s.File == "" {
+		s.File = "secrets.enc"
+	}
+	if s.KeyFile == "" {
+		s.KeyFile = "secrets.key"
+	}
+}
+
+func (t *Tenants) setDefaults(dbs map[string]*DB) {
+	if len(t.Resolve) == 0 {
+		t.Resolve = []string{"host"}
+	}
+	if t.Header == "" {
+		t.Header = "X-Tenant"
+	}
+	if t.Column == "" {
+		t.Column = "tenant_id"
+	}
+	for name, tenant := range t.List {
+		if tenant == nil {
+			continue
+		}
+		tenant.Path = strings.TrimSuffix(tenant.Path, "/")
+		for dbName, cfg := range tenant.DB {
+			// each tenant has its own sqlite file, unless it sets the dsn (Ex. data.db => data-acme.db)
+			if base := dbs[dbName]; base != nil && base.Tenancy == "database" && strings.EqualFold(cfg.Engine, "sqlite") && cfg.DSN == base.DSN {
+				dsn := cfg.DSN
+				if dsn == "" {
+					dsn = dbName + ".db"
+				}
+				cfg.DSN = TenantFile(dsn, name)
+			}
+		}
+	}
+}
+
+// TenantFile adds the name of the tenant to the file of the sqlite dsn, before the extension (Ex. data.db?mode=rwc
+// => data-acme.db?mode=rwc)
+func TenantFile(dsn string, tenant string) string {
+	file, query, hasQuery := strings.Cut(dsn, "?")
+	ext := filepath.Ext(file)
+	file = strings.TrimSuffix(file, ext) + "-" + tenant + ext
+	if hasQuery {
+		return file + "?" + query
+	}
+	return file
+}
+
+func (c *Config) setDefaults() {
+	if c.Server.Addr == "" {
+		c.Server.Addr = "localhost:8080"
+	}
+	if c.Server.Cert == "" {
+		c.Server.Cert = "localhost.crt"
+	}
+	if c.Server.Key == "" {
+		c.Server.Key = "localhost.key"
+	}
+	if c.Server.Shutdown.Timeout == 0 {
+		c.Server.Shutdown.Timeout = Duration(30 * time.Second)
+	}
+	if c.AccessLog.Format == "" {
+		c.AccessLog.Format = "json"
+	}
+	if strings.TrimSpace(c.Metrics.Endpoint) == "" {
+		c.Metrics.Endpoint = "/metrics"
+	}
+	if c.RateLimit.Store == "" {
+		c.RateLimit.Store = "memory"
+	}
+	for _, rule := range c.RateLimit.Rules {
+		if rule.Key == "" {
+			rule.Key = "ip"
+		}
+		if rule.Algorithm == "" {
+			rule.Algorithm = "sliding-window"
+		}
+		if rule.Burst == 0 {
+			rule.Burst = rule.Limit
+		}
+	}
+	if c.Limits.TimeoutStatus == 0 {
+		c.Limits.TimeoutStatus = 503
+	}
+	if c.Security.ContentTypeOptions == "" {
+		c.Security.ContentTypeOptions = "nosniff"
+	}
+	if c.Security.ReferrerPolicy == "" {
+		c.Security.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if c.Security.FrameOptions == "" {
+		c.Security.FrameOptions = "DENY"
+	}
+	if len(c.Security.CSP.Directives) == 0 {
+		c.Security.CSP.Directives = map[string]string{
+			"default-src":     "'self'",
+			"script-src":      "'self' 'nonce'",
+			"style-src":       "'self' 'nonce'",
+			"object-src":      "'none'",
+			"base-uri":        "'self'",
+			"frame-ancestors": "'none'",
+		}
+	}
+	if c.Security.CSP.ReportURI == "" {
+		c.Security.CSP.ReportURI = "/csp-report"
+	}
+	if c.Session.Store == "" {
+		c.Session.Store = "cookie"
+	}
+	if c.Session.Cookie == "" {
+		c.Session.Cookie = "__Host-session"
+	}
+	if c.Session.IdleTimeout == 0 {
+		c.Session.IdleTimeout = Duration(30 * time.Minute)
+	}
+	if c.Session.AbsoluteTimeout == 0 {
+		c.Session.AbsoluteTimeout = Duration(24 * time.Hour)
+	}
+	if c.Auth.Hash == "" {
+		c.Auth.Hash = "argon2id"
+	}
+	if c.Auth.MinPassword == 0 {
+		c.Auth.MinPassword = 8
+	}
+	if c.Auth.RememberMe == 0 {
+		c.Auth.RememberMe = Duration(30 * 24 * time.Hour)
+	}
+	if c.Auth.ResetTTL == 0 {
+		c.Auth.ResetTTL = Duration(time.Hour)
+	}
+	if c.Auth.Redirect == "" {
+		c.Auth.Redirect = "/"
+	}
+	if c.Auth.Policy == "" {
+		c.Auth.Policy = "auth/policy.yaml"
+	}
+	if c.Auth.BaseURL == "" {
+		host := c.Server.Addr
+		if strings.HasPrefix(host, ":") {
+			host = "localhost" + host
+		}
+		c.Auth.BaseURL = "https://" + host
+	}
+	c.Auth.BaseURL = strings.TrimSuffix(c.Auth.BaseURL, "/")
+	if c.Auth.OIDC.Local.Issuer == "" {
+		c.Auth.OIDC.Local.Issuer = c.Auth.BaseURL + "/oidc"
+	}
+	for _, provider := range c.Auth.OIDC.Providers {
+		if provider != nil && len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+	if c.Auth.Lockout.Attempts == 0 {
+		c.Auth.Lockout.Attempts = 5
+	}
+	if c.Auth.Lockout.Duration == 0 {
+		c.Auth.Lockout.Duration = Duration(15 * time.Minute)
+	}
+	if c.API.Tokens.TTL == 0 {
+		c.API.Tokens.TTL = Duration(90 * 24 * time.Hour)
+	}
+	if c.API.HMAC.Skew == 0 {
+		c.API.HMAC.Skew = Duration(5 * time.Minute)
+	}
+	if c.API.HMAC.Store == "" {
+		c.API.HMAC.Store = "memory"
+	}
+	c.Secrets.setDefaults()
+	c.Tenants.setDefaults(c.DB)
+	if c.Tracing.Exporter == "" {
+		c.Tracing.Exporter = "none"
+	}
+	if c.Tracing.Endpoint == "" {
+		c.Tracing.Endpoint = "http://localhost:4318"
+	}
+	if c.Tracing.Service == "" {
+		c.Tracing.Service = "demo"
+	}
+	if c.LiveReload.Interval == 0 {
+		c.LiveReload.Interval = 100
+	}
+	if strings.TrimSpace(c.LiveReload.Endpoint) == "" {
+		c.LiveReload.Endpoint = "/dev.livereload"
+	}
+}
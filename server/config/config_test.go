@@ -0,0 +1,422 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syntax-framework/demo/server/secrets"
+)
+
+const testConfig = `
+dev: true
+content:
+  - ./site
+server:
+  addr: ${ADDR:localhost:9090}
+  hsts:
+    max-age: ${HSTS_MAX_AGE:0}
+live-reload:
+  interval: 100
+  patterns:
+    - ".*\\.html$"
+redis:
+  my-redis:
+    uri: ${REDIS_URI}
+db:
+  mydatabase:
+    engine: sqlite
+cache:
+auth:
+  ttl: 15m
+`
+
+const testConfigProd = `
+dev: false
+server:
+  hsts:
+    include-subdomains: true
+db:
+  mydatabase:
+    dsn: /var/lib/demo/data.db
+`
+
+func writeConfig(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.prod.yaml"), []byte(testConfigProd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+func Test_load(t *testing.T) {
+	file := writeConfig(t)
+
+	config, err := Load(Options{File: file, Environ: []string{"REDIS_URI=redis://localhost:6379"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !config.Dev {
+		t.Error("dev: Expected true")
+	}
+	if config.Server.Addr != "localhost:9090" {
+		t.Errorf("server.addr: Expected default from interpolation, got '%s'", config.Server.Addr)
+	}
+	if config.Server.Cert != "localhost.crt" {
+		t.Errorf("server.cert: Expected default 'localhost.crt', got '%s'", config.Server.Cert)
+	}
+	if config.Redis["my-redis"].URI != "redis://localhost:6379" {
+		t.Errorf("redis.my-redis.uri: got '%s'", config.Redis["my-redis"].URI)
+	}
+	if config.DB["mydatabase"].Engine != "sqlite" {
+		t.Errorf("db.mydatabase.engine: got '%s'", config.DB["mydatabase"].Engine)
+	}
+	if config.Auth.TTL.Std() != 15*time.Minute {
+		t.Errorf("auth.ttl: Expected 15m, got %s", config.Auth.TTL.Std())
+	}
+	if !reflect.DeepEqual(config.LiveReload.Patterns, []string{`.*\.html$`}) {
+		t.Errorf("live-reload.patterns: got %v", config.LiveReload.Patterns)
+	}
+}
+
+func Test_load_missing_env(t *testing.T) {
+	_, err := Load(Options{File: writeConfig(t), Environ: []string{}})
+	if err == nil || !strings.Contains(err.Error(), "REDIS_URI") {
+		t.Fatalf("Expected error about REDIS_URI, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "config.yaml:15") {
+		t.Errorf("Expected error with file and line, got %v", err)
+	}
+}
+
+func Test_load_profile(t *testing.T) {
+	config, err := Load(Options{File: writeConfig(t), Environ: []string{
+		"REDIS_URI=x",
+		"DEMO_PROFILE=prod",
+		"HSTS_MAX_AGE=31536000",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Dev {
+		t.Error("dev: Expected false from profile")
+	}
+	if len(config.Files) != 2 {
+		t.Errorf("files: Expected 2 files, got %v", config.Files)
+	}
+	if config.Server.HSTS.MaxAge != 31536000 || !config.Server.HSTS.IncludeSubDomains {
+		t.Errorf("server.hsts: Expected merge of both files, got %+v", config.Server.HSTS)
+	}
+	db := config.DB["mydatabase"]
+	if db.Engine != "sqlite" || db.DSN != "/var/lib/demo/data.db" {
+		t.Errorf("db.mydatabase: Expected merge of both files, got %+v", db)
+	}
+
+	if _, err = Load(Options{File: writeConfig(t), Profile: "missing", Environ: []string{"REDIS_URI=x"}}); err == nil {
+		t.Error("Expected error for missing profile file")
+	}
+}
+
+func Test_load_env_overrides(t *testing.T) {
+	config, err := Load(Options{File: writeConfig(t), Environ: []string{
+		"REDIS_URI=x",
+		"DEMO_DEV=false",
+		"DEMO_LIVE_RELOAD_INTERVAL=50",
+		"DEMO_LIVE_RELOAD_RELOAD_PAGE_ON_CSS=true",
+		"DEMO_CONTENT=./a, ./b",
+		"DEMO_REDIS_MY_REDIS_URI=redis://cache:6379",
+		"DEMO_DB_MYDATABASE_ENGINE=postgres",
+		"DEMO_DB_OTHER_ENGINE=mysql",
+		"DEMO_CACHE_SIZE=10",
+		"DEMO_SERVER_HTTP_WELL_KNOWN=/srv/acme",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Dev {
+		t.Error("DEMO_DEV: Expected false")
+	}
+	if config.LiveReload.Interval != 50 || !config.LiveReload.ReloadPageOnCss {
+		t.Errorf("DEMO_LIVE_RELOAD_*: got %+v", config.LiveReload)
+	}
+	if !reflect.DeepEqual(config.Content, []string{"./a", "./b"}) {
+		t.Errorf("DEMO_CONTENT: got %v", config.Content)
+	}
+	if config.Redis["my-redis"].URI != "redis://cache:6379" {
+		t.Errorf("DEMO_REDIS_MY_REDIS_URI: got '%s'", config.Redis["my-redis"].URI)
+	}
+	if config.DB["mydatabase"].Engine != "postgres" {
+		t.Errorf("DEMO_DB_MYDATABASE_ENGINE: got '%s'", config.DB["mydatabase"].Engine)
+	}
+	if other := config.DB["other"]; other == nil || other.Engine != "mysql" {
+		t.Errorf("DEMO_DB_OTHER_ENGINE: got %+v", other)
+	}
+	if config.Cache.Size != 10 {
+		t.Errorf("DEMO_CACHE_SIZE: got %d", config.Cache.Size)
+	}
+	if config.Server.HTTP.WellKnown != "/srv/acme" {
+		t.Errorf("DEMO_SERVER_HTTP_WELL_KNOWN: got '%s'", config.Server.HTTP.WellKnown)
+	}
+}
+
+const testConfigInvalid = `
+dev: yes please
+server:
+  addr: localhost:8080
+  hsts:
+    max-age: 300
+    preload: true
+live-reload:
+  interval: fast
+  pattern:
+    - ".*"
+db:
+  mydatabase:
+    engine: oracle
+  other:
+    dsn: file.db
+  empty:
+storage:
+  xpto:
+    engine: "S3"
+cache:
+  engine: redis
+  redis: missing
+cms:
+  enbled: true
+`
+
+func Test_validate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(file, []byte(testConfigInvalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(Options{File: file, Environ: []string{"DEMO_UNKNOWN_KEY=x"}})
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	problems, isErrors := err.(Errors)
+	if !isErrors {
+		t.Fatalf("Expected Errors, got %T: %v", err, err)
+	}
+
+	expected := []string{
+		file + ":2: dev: expected a boolean, found \"yes please\"",
+		file + ":9: live-reload.interval: expected an integer, found \"fast\"",
+		file + ":10: live-reload.pattern: unknown key, did you mean 'patterns'?",
+		file + ":14: db.mydatabase.engine: unsupported value \"oracle\", expected one of: sqlite, postgres, mysql",
+		file + ":16: db.other.engine: is required",
+		file + ":17: db.empty.engine: is required",
+		file + ":25: cms.enbled: unknown key, did you mean 'enabled'?",
+		"DEMO_UNKNOWN_KEY: environment variable does not match any configuration key",
+	}
+	var got []string
+	for _, problem := range problems {
+		got = append(got, problem.String())
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("problems mismatch:\nExpected:\n  %s\nGot:\n  %s", strings.Join(expected, "\n  "), strings.Join(got, "\n  "))
+	}
+}
+
+func Test_validate_references(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	content := "server:\n  addr: \"unix:\"\n  socket:\n    mode: \"999\"\n  hsts:\n    max-age: 300\n    preload: true\n" +
+		"cache:\n  engine: redis\n  redis: missing\n" +
+		"rate-limit:\n  rules:\n    - name: login\n      routes: [login]\n      key: cookie\n      limit: 0\n      window: 1m\n" +
+		"csrf:\n  exempt: [\"POST /hooks/*\", \"hooks\"]\n" +
+		"session:\n  store: db\n  keys: [\"c2hvcnQ=\"]\n" +
+		"auth:\n  db: missing\n  redirect: //evil.example\n" +
+		"  oidc:\n    providers:\n      google: { issuer: http://accounts.example.org, client-id: demo }\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(Options{File: file, Environ: []string{}})
+	problems, isErrors := err.(Errors)
+	if !isErrors || len(problems) != 13 {
+		t.Fatalf("Expected 13 problems, got %v", err)
+	}
+	expected := []struct {
+		key  string
+		line int
+	}{
+		{"cache.redis", 10}, {"server.addr", 2}, {"server.socket.mode", 4},
+		{"rate-limit.rules[0].limit", 16}, {"rate-limit.rules[0].key", 15}, {"rate-limit.rules[0].routes[0]", 14},
+		{"csrf.exempt[1]", 19}, {"session.db", 21}, {"session.keys[0]", 22},
+		{"auth.db", 24}, {"auth.redirect", 25}, {"auth.oidc.providers.google.issuer", 28}, {"server.hsts.preload", 7},
+	}
+	for i, e := range expected {
+		if problems[i].Key != e.key || problems[i].Line != e.line {
+			t.Errorf("Expected %s at line %d, got %s", e.key, e.line, problems[i])
+		}
+	}
+}
+
+// any client can forge the header, the key is only accepted when the proxy sets it
+func Test_validate_rate_limit_header(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	rules := "  rules:\n    - name: tenant\n      routes: [\"/*\"]\n      key: header:X-Tenant\n      limit: 10\n      window: 1m\n"
+	if err := os.WriteFile(file, []byte("rate-limit:\n"+rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Load(Options{File: file, Environ: []string{}})
+	if problems, isErrors := err.(Errors); !isErrors || len(problems) != 1 || problems[0].Key != "rate-limit.rules[0].key" || problems[0].Line != 5 {
+		t.Errorf("Expected the key to require trust-proxy, got %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte("rate-limit:\n  trust-proxy: true\n"+rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(Options{File: file, Environ: []string{}}); err != nil {
+		t.Errorf("trust-proxy: got %v", err)
+	}
+}
+
+func Test_suggest(t *testing.T) {
+	options := []string{"enabled", "sites"}
+	tests := map[string]string{
+		"enbled":  "enabled",
+		"enabeld": "enabled",
+		"site":    "sites",
+		"xpto":    "",
+	}
+	for name, expected := range tests {
+		if got := suggest(name, options); got != expected {
+			t.Errorf("suggest(%s): Expected '%s', got '%s'", name, expected, got)
+		}
+	}
+}
+
+func Test_load_secrets(t *testing.T) {
+	dir := t.TempDir()
+	key := secrets.NewKey()
+	if err := secrets.Write(filepath.Join(dir, "secrets.enc"), key, map[string]string{"redis_uri": "redis://:s3cr3t@redis:6379"}); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "config.yaml")
+	content := testConfig + "secrets:\n  file: " + filepath.Join(dir, "secrets.enc") + "\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	environ := []string{"REDIS_URI=secret:redis_uri", EnvSecretsKey + "=" + secrets.EncodeKey(key)}
+
+	config, err := Load(Options{File: file, Environ: environ})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Redis["my-redis"].URI != "redis://:s3cr3t@redis:6379" {
+		t.Errorf("redis.my-redis.uri: got '%s'", config.Redis["my-redis"].URI)
+	}
+
+	out, err := Show(Options{File: file, Environ: environ})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "s3cr3t") || !strings.Contains(string(out), "uri: secret:redis_uri") {
+		t.Errorf("show: Expected the reference instead of the value, got\n%s", out)
+	}
+	if got := secrets.Redact("dial redis://:s3cr3t@redis:6379: refused"); got != "dial "+secrets.Redacted+": refused" {
+		t.Errorf("redact: got '%s'", got)
+	}
+
+	_, err = Load(Options{File: file, Environ: []string{"REDIS_URI=secret:missing", EnvSecretsKey + "=" + secrets.EncodeKey(key)}})
+	if err == nil || !strings.Contains(err.Error(), `config.yaml:15: redis.my-redis.uri: there is no secret named "missing"`) {
+		t.Errorf("Expected error about the missing secret, got %v", err)
+	}
+
+	_, err = Load(Options{File: file, Environ: []string{"REDIS_URI=secret:redis_uri", EnvSecretsKey + "=" + secrets.EncodeKey(secrets.NewKey())}})
+	if !errors.Is(err, secrets.ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+}
+
+func Test_load_tenants(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	content := testConfig + `storage:
+  files:
+    engine: local
+    dir: ./files
+tenants:
+  resolve: [ host, path ]
+  list:
+    acme:
+      hosts: [ acme.example.com ]
+      db:
+        mydatabase:
+          tenancy: database
+      storage:
+        files:
+          dir: ./files/acme
+    globex:
+`
+	content = strings.Replace(content, "    engine: sqlite\n", "    engine: sqlite\n    dsn: data/app.db\n    tenancy: database\n", 1)
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(Options{File: file, Environ: []string{"REDIS_URI=redis://localhost:6379"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, globex := config.Tenants.List["acme"], config.Tenants.List["globex"]
+	if acme.DB["mydatabase"].Engine != "sqlite" || acme.DB["mydatabase"].DSN != "data/app-acme.db" {
+		t.Errorf("acme db: got %+v", acme.DB["mydatabase"])
+	}
+	if globex == nil || globex.DB["mydatabase"].DSN != "data/app-globex.db" {
+		t.Errorf("globex db: got %+v", globex)
+	}
+	if acme.Storage["files"].Engine != "local" || acme.Storage["files"].Dir != "./files/acme" || globex.Storage["files"].Dir != "./files" {
+		t.Errorf("storage: got %+v %+v", acme.Storage["files"], globex.Storage["files"])
+	}
+	if config.DB["mydatabase"].DSN != "data/app.db" {
+		t.Errorf("db: Expected the configuration unchanged, got %s", config.DB["mydatabase"].DSN)
+	}
+	if config.Tenants.Column != "tenant_id" || config.Tenants.Header != "X-Tenant" {
+		t.Errorf("defaults: got %+v", config.Tenants)
+	}
+
+	invalid := testConfig + `tenants:
+  resolve: [ cookie, header ]
+  default: initech
+  list:
+    Acme:
+      path: acme
+    globex:
+      db:
+        other:
+          engine: sqlite
+`
+	if err = os.WriteFile(file, []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(Options{File: file, Environ: []string{"REDIS_URI=redis://localhost:6379"}})
+	var problems Errors
+	if !errors.As(err, &problems) {
+		t.Fatalf("Expected Errors, got %v", err)
+	}
+	keys := map[string]bool{}
+	for _, problem := range problems {
+		keys[problem.Key] = true
+	}
+	for _, key := range []string{"tenants.resolve[0]", "tenants.resolve[1]", "tenants.default", "tenants.list.Acme", "tenants.list.Acme.path", "tenants.list.globex.db.other"} {
+		if !keys[key] {
+			t.Errorf("Expected a problem on %s, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefix of the environment variables that override configuration keys
+const EnvPrefix = "DEMO_"
+
+// EnvProfile environment variable used to select the profile when Options.Profile is empty
+const EnvProfile = EnvPrefix + "PROFILE"
+
+// envReserved variables with EnvPrefix that are not configuration keys
+var envReserved = map[string]bool{
+	EnvProfile:    true,
+	EnvSecretsKey: true,
+}
+
+// Options controls how the configuration is loaded
+type Options struct {
+	File    string   // Base configuration file. Defaults to `config.yaml`
+	Profile string   // Profile overlay (Ex. "prod" loads config.prod.yaml). Defaults to $DEMO_PROFILE
+	Environ []string // Environment used for interpolation and overrides, in "KEY=value" form. Defaults to os.Environ()
+}
+
+// document the merged yaml tree, keeping track of the file that declared each node
+type document struct {
+	root         *yaml.Node
+	sources      map[*yaml.Node]string
+	files        []string
+	env          map[string]string
+	unmatchedEnv []string              // DEMO_* variables that do not match any configuration key
+	secrets      map[*yaml.Node]string // Values read from the secrets file, by the node, with the name of the secret
+}
+
+// Load reads, merges, validates and decodes the configuration files.
+//
+// When the configuration is invalid, the returned error is of type Errors and contains every problem found.
+func Load(opts Options) (*Config, error) {
+	doc, err := loadDocument(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = doc.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	doc.mergeTenants()
+	if err = doc.validate(); err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err = doc.root.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %w", strings.Join(doc.files, ", "), err)
+	}
+	config.Files = doc.files
+	config.setDefaults()
+
+	if err = config.checkReferences(doc); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadDocument executes the merge steps, producing the final yaml tree
+func loadDocument(opts Options) (*document, error) {
+	if opts.File == "" {
+		opts.File = "config.yaml"
+	}
+	if opts.Environ == nil {
+		opts.Environ = os.Environ()
+	}
+	env := environ(opts.Environ)
+	if opts.Profile == "" {
+		opts.Profile = env[EnvProfile]
+	}
+
+	doc := &document{
+		root:    &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+		sources: map[*yaml.Node]string{},
+		env:     env,
+	}
+	doc.sources[doc.root] = opts.File
+
+	if err := doc.mergeFile(opts.File, true); err != nil {
+		return nil, err
+	}
+
+	if opts.Profile != "" {
+		ext := filepath.Ext(opts.File)
+		overlay := strings.TrimSuffix(opts.File, ext) + "." + opts.Profile + ext
+		if err := doc.mergeFile(overlay, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := doc.interpolate(doc.root, env); err != nil {
+		return nil, err
+	}
+
+	doc.applyEnv(env)
+
+	return doc, nil
+}
+
+// mergeFile parses the file and merges it on top of the current tree
+func (d *document) mergeFile(file string, optional bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	node := &yaml.Node{}
+	if err = yaml.Unmarshal(data, node); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	d.files = append(d.files, file)
+
+	if node.Kind == 0 || len(node.Content) == 0 {
+		// empty file
+		return nil
+	}
+
+	content := node.Content[0]
+	if content.Kind == yaml.ScalarNode && content.Tag == "!!null" {
+		return nil
+	}
+	if content.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: the configuration must be a mapping of keys", file, content.Line)
+	}
+	d.track(content, file)
+	d.root = merge(d.root, content)
+	return nil
+}
+
+// track records the file of origin of the node and all its children
+func (d *document) track(node *yaml.Node, file string) {
+	d.sources[node] = file
+	for _, child := range node.Content {
+		d.track(child, file)
+	}
+}
+
+// source returns "file:line" of the node, used in error messages
+func (d *document) source(node *yaml.Node) string {
+	file := d.sources[node]
+	if node.Line == 0 {
+		return file
+	}
+	return fmt.Sprintf("%s:%d", file, node.Line)
+}
+
+// merge overlays src on dst. Mappings are merged key by key, everything else is replaced.
+func merge(dst *yaml.Node, src *yaml.Node) *yaml.Node {
+	if dst == nil || dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return src
+	}
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		if index := mappingIndex(dst, key.Value); index >= 0 {
+			dst.Content[index+1] = merge(dst.Content[index+1], value)
+		} else {
+			dst.Content = append(dst.Content, key, value)
+		}
+	}
+	return dst
+}
+
+// mappingIndex index of the key node in mapping.Content, -1 if not found
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// interpolationReg `${NAME}` or `${NAME:default}`
+var interpolationReg = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?}`)
+
+// interpolate replaces `${ENV_VAR:default}` on every scalar value of the tree.
+func (d *document) interpolate(node *yaml.Node, env map[string]string) error {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		var missing []string
+		node.Value = interpolationReg.ReplaceAllStringFunc(node.Value, func(match string) string {
+			groups := interpolationReg.FindStringSubmatch(match)
+			if value, exists := env[groups[1]]; exists {
+				return value
+			}
+			if !strings.Contains(match, ":") {
+				missing = append(missing, groups[1])
+			}
+			return groups[2]
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("%s: environment variable %s is not defined and has no default value", d.source(node), strings.Join(missing, ", "))
+		}
+		if node.Style == 0 {
+			// plain scalar, let the decoder resolve the type again (Ex. "${PORT:8080}" => int)
+			node.Tag = ""
+		}
+		return nil
+	}
+
+	for _, child := range node.Content {
+		if err := d.interpolate(child, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides configuration keys with DEMO_* environment variables.
+//
+// Variables that do not match any configuration key are reported by the validation.
+func (d *document) applyEnv(env map[string]string) {
+	var names []string
+	for name := range env {
+		if strings.HasPrefix(name, EnvPrefix) && !envReserved[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		segments := strings.Split(strings.ToLower(strings.TrimPrefix(name, EnvPrefix)), "_")
+		if !d.setEnv(reflect.TypeOf(Config{}), d.root, segments, name, env[name]) {
+			d.unmatchedEnv = append(d.unmatchedEnv, name)
+		}
+	}
+}
+
+// setEnv walks the config type and the yaml tree using the segments of the variable name, creating the missing
+// nodes. Returns false if the variable does not match any configuration key.
+func (d *document) setEnv(t reflect.Type, node *yaml.Node, segments []string, name string, value string) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if len(segments) == 0 {
+		if t.Kind() == reflect.Struct || t.Kind() == reflect.Map {
+			// does not replace entire blocks
+			return false
+		}
+		*node = *envNode(t, value)
+		d.track(node, name)
+		return true
+	}
+
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+		return false
+	}
+
+	if node.Kind != yaml.MappingNode {
+		if node.Kind != 0 && !(node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null") {
+			return false
+		}
+		// empty block (Ex. "cache:")
+		*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: node.Line, Column: node.Column}
+	}
+
+	var candidates []string
+	var types []reflect.Type
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			candidates = append(candidates, key)
+			types = append(types, field.Type)
+		}
+	case reflect.Map:
+		// existing keys first, then a new key with the first segment
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			candidates = append(candidates, node.Content[i].Value)
+			types = append(types, t.Elem())
+		}
+		candidates = append(candidates, segments[0])
+		types = append(types, t.Elem())
+	}
+
+	// longest match first (Ex. "live-reload" before "live")
+	for size := len(segments); size > 0; size-- {
+		prefix := strings.Join(segments[:size], "_")
+		for i, key := range candidates {
+			if envName(key) != prefix {
+				continue
+			}
+			if index := mappingIndex(node, key); index >= 0 {
+				if d.setEnv(types[i], node.Content[index+1], segments[size:], name, value) {
+					return true
+				}
+				continue
+			}
+			child := &yaml.Node{}
+			if d.setEnv(types[i], child, segments[size:], name, value) {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// envNode creates the node for the value of an environment variable. Slices are comma separated.
+func envNode(t reflect.Type, value string) *yaml.Node {
+	if t.Kind() == reflect.Slice {
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range strings.Split(value, ",") {
+			seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: strings.TrimSpace(item)})
+		}
+		return seq
+	}
+	if t.Kind() == reflect.String {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
+
+// envName normalizes a configuration key to the environment variable format (Ex. "live-reload" => "live_reload")
+func envName(key string) string {
+	return strings.ToLower(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+func environ(list []string) map[string]string {
+	env := map[string]string{}
+	for _, item := range list {
+		if i := strings.IndexByte(item, '='); i > 0 {
+			env[item[:i]] = item[i+1:]
+		}
+	}
+	return env
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+// SecretPrefix of the values read from the encrypted secrets file (Ex. `uri: secret:redis_uri`)
+const SecretPrefix = "secret:"
+
+// EnvSecretsKey environment variable with the master key of the secrets, in base64
+const EnvSecretsKey = EnvPrefix + "SECRETS_KEY"
+
+// reference a value `secret:<name>` of the document
+type reference struct {
+	node *yaml.Node
+	key  string
+}
+
+// references collects the `secret:<name>` values of the tree
+func references(node *yaml.Node, key string, refs []*reference) []*reference {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if strings.HasPrefix(node.Value, SecretPrefix) {
+			refs = append(refs, &reference{node, key})
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			refs = references(node.Content[i+1], join(key, node.Content[i].Value), refs)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			refs = references(child, key+"["+strconv.Itoa(i)+"]", refs)
+		}
+	}
+	return refs
+}
+
+// secretFiles decodes the `secrets` block of the document, with the defaults
+func (d *document) secretFiles() (Secrets, error) {
+	s := Secrets{}
+	if node := d.lookup("secrets"); node != nil {
+		if err := node.Decode(&s); err != nil {
+			return s, fmt.Errorf("%s: secrets: %w", d.source(node), err)
+		}
+	}
+	s.setDefaults()
+	return s, nil
+}
+
+// resolveSecrets replaces the `secret:<name>` values by the ones of the encrypted file, which is only read (and the
+// key only required) when there are references. The values are registered to be redacted from the logs.
+func (d *document) resolveSecrets() error {
+	refs := references(d.root, "", nil)
+	if len(refs) == 0 {
+		return nil
+	}
+	files, err := d.secretFiles()
+	if err != nil {
+		return err
+	}
+	key, err := secrets.LoadKey(d.env[EnvSecretsKey], files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", d.source(refs[0].node), refs[0].key, err)
+	}
+	values, err := secrets.Read(files.File, key)
+	if err != nil {
+		return err
+	}
+
+	var problems Errors
+	d.secrets = map[*yaml.Node]string{}
+	for _, ref := range refs {
+		name := strings.TrimPrefix(ref.node.Value, SecretPrefix)
+		value, exists := values[name]
+		if !exists {
+			problems = append(problems, &Problem{
+				File:    d.sources[ref.node],
+				Line:    ref.node.Line,
+				Key:     ref.key,
+				Message: fmt.Sprintf("there is no secret named %q in %s", name, files.File),
+			})
+			continue
+		}
+		d.secrets[ref.node] = name
+		ref.node.Value = value
+		if ref.node.Style == 0 {
+			ref.node.Tag = ""
+		}
+		secrets.Register(value)
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// SecretFiles returns the `secrets` block of the configuration files, without reading the secrets (used by the
+// commands that edit them)
+func SecretFiles(opts Options) (Secrets, error) {
+	doc, err := loadDocument(opts)
+	if err != nil {
+		return Secrets{}, err
+	}
+	return doc.secretFiles()
+}
+
+// Show returns the merged configuration in yaml, after the validation. The secrets are shown by their references and
+// their values are redacted from the rest of the document.
+func Show(opts Options) ([]byte, error) {
+	doc, err := loadDocument(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = doc.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	if err = doc.validate(); err != nil {
+		return nil, err
+	}
+	for node, name := range doc.secrets {
+		node.Value = SecretPrefix + name
+		node.Tag, node.Style = "!!str", 0
+	}
+	uncomment(doc.root)
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(doc.root); err != nil {
+		return nil, err
+	}
+	return []byte(secrets.Redact(out.String())), nil
+}
+
+// uncomment removes the comments of the tree, they do not follow the merge of the files
+func uncomment(node *yaml.Node) {
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	for _, child := range node.Content {
+		uncomment(child)
+	}
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tenantSections blocks of the configuration that the tenants override
+var tenantSections = []string{"db", "storage", "cms"}
+
+var (
+	tenantNameReg = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	columnReg     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// mergeTenants applies the blocks of each tenant on a copy of the ones of the configuration, so the validation and
+// the decoding see the complete blocks of the tenant (Ex. `db: { mydatabase: { dsn: acme.db } }` keeps the engine)
+func (d *document) mergeTenants() {
+	list := d.lookup("tenants", "list")
+	if list == nil || list.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(list.Content); i += 2 {
+		tenant := list.Content[i+1]
+		if isNull(tenant) {
+			tenant = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: list.Content[i].Line}
+			d.sources[tenant] = d.sources[list.Content[i]]
+			list.Content[i+1] = tenant
+		}
+		if tenant.Kind != yaml.MappingNode {
+			continue
+		}
+		for _, section := range tenantSections {
+			base := d.lookup(section)
+			if base == nil || base.Kind != yaml.MappingNode {
+				continue
+			}
+			index := mappingIndex(tenant, section)
+			if index < 0 {
+				key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: section, Line: tenant.Line}
+				d.sources[key] = d.sources[tenant]
+				tenant.Content = append(tenant.Content, key, d.clone(base))
+			} else if override := tenant.Content[index+1]; isNull(override) {
+				tenant.Content[index+1] = d.clone(base)
+			} else {
+				tenant.Content[index+1] = merge(d.clone(base), override)
+			}
+		}
+	}
+}
+
+// clone copies the tree of the node, keeping the file of origin of each copy
+func (d *document) clone(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = d.clone(child)
+	}
+	d.sources[&copied] = d.sources[node]
+	if name, secret := d.secrets[node]; secret {
+		d.secrets[&copied] = name
+	}
+	return &copied
+}
+
+// checkTenants validates the resolution and the isolation of the tenants
+func (c *Config) checkTenants(v *validator, d *document) {
+	t := c.Tenants
+	for i, method := range t.Resolve {
+		key := "tenants.resolve[" + strconv.Itoa(i) + "]"
+		if method != "host" && method != "path" && method != "header" {
+			v.report(d.lookupOrParent("tenants", "resolve", strconv.Itoa(i)), key, "unsupported value %q, expected one of: host, path, header", method)
+		} else if method == "header" && !t.TrustProxy {
+			v.report(d.lookupOrParent("tenants", "resolve", strconv.Itoa(i)), key, "the header %s is sent by the clients, requires tenants.trust-proxy", t.Header)
+		}
+	}
+	if !columnReg.MatchString(t.Column) {
+		v.report(d.lookupOrParent("tenants", "column"), "tenants.column", "invalid column name %q", t.Column)
+	}
+	if t.Default != "" && t.List[t.Default] == nil {
+		v.report(d.lookupOrParent("tenants", "default"), "tenants.default", "there is no tenant named %q", t.Default)
+	}
+
+	if len(t.List) == 0 {
+		for name, cfg := range c.DB {
+			if cfg != nil && cfg.Tenancy != "" {
+				v.report(d.lookupOrParent("db", name, "tenancy"), "db."+name+".tenancy", "requires the tenants of tenants.list")
+			}
+		}
+		return
+	}
+
+	hosts := map[string]string{}
+	paths := map[string]string{}
+	names := make([]string, 0, len(t.List))
+	for name := range t.List {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tenant := t.List[name]
+		if tenant == nil {
+			continue
+		}
+		key := "tenants.list." + name
+		if !tenantNameReg.MatchString(name) {
+			v.report(d.lookupOrParent("tenants", "list", name), key, "invalid tenant name, use lowercase letters, digits, `_` and `-`")
+		}
+		for i, host := range tenant.Hosts {
+			host = strings.ToLower(host)
+			if other, exists := hosts[host]; exists {
+				v.report(d.lookupOrParent("tenants", "list", name, "hosts", strconv.Itoa(i)), key+".hosts["+strconv.Itoa(i)+"]", "the host %q is already used by the tenant %q", host, other)
+			}
+			hosts[host] = name
+		}
+		if tenant.Path != "" {
+			if !strings.HasPrefix(tenant.Path, "/") || tenant.Path == "/" {
+				v.report(d.lookupOrParent("tenants", "list", name, "path"), key+".path", "expected a prefix starting with / (Ex. /%s)", name)
+			} else if other, exists := paths[tenant.Path]; exists {
+				v.report(d.lookupOrParent("tenants", "list", name, "path"), key+".path", "the path %q is already used by the tenant %q", tenant.Path, other)
+			}
+			paths[tenant.Path] = name
+		}
+		for dbName, cfg := range tenant.DB {
+			base := c.DB[dbName]
+			if cfg == nil {
+				continue
+			}
+			if base == nil {
+				v.report(d.lookupOrParent("tenants", "list", name, "db", dbName), key+".db."+dbName, "there is no database named %q in db", dbName)
+				continue
+			}
+			if cfg.Tenancy != base.Tenancy {
+				v.report(d.lookupOrParent("tenants", "list", name, "db", dbName, "tenancy"), key+".db."+dbName+".tenancy", "the tenancy is declared by db.%s", dbName)
+			}
+			if base.Tenancy == "database" && !strings.EqualFold(base.Engine, "sqlite") && cfg.DSN == base.DSN {
+				v.report(d.lookupOrParent("tenants", "list", name, "db", dbName), key+".db."+dbName+".dsn", "each tenant requires its own dsn, db.%s has tenancy database", dbName)
+			}
+		}
+		for storageName := range tenant.Storage {
+			if c.Storage[storageName] == nil {
+				v.report(d.lookupOrParent("tenants", "list", name, "storage", storageName), key+".storage."+storageName, "there is no storage named %q in storage", storageName)
+			}
+		}
+	}
+}
@@ -0,0 +1,576 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/listen"
+	"github.com/syntax-framework/demo/server/route"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a single error found in the configuration
+type Problem struct {
+	File    string // File that declared the key, or the environment variable name
+	Line    int
+	Key     string // Full key (Ex. "cms.enabled", "db.mydatabase.engine")
+	Message string
+}
+
+func (p *Problem) String() string {
+	location := p.File
+	if p.Line > 0 {
+		location += ":" + strconv.Itoa(p.Line)
+	}
+	if p.Key == "" {
+		return location + ": " + p.Message
+	}
+	return location + ": " + p.Key + ": " + p.Message
+}
+
+// Errors all the problems found while validating the configuration
+type Errors []*Problem
+
+func (e Errors) Error() string {
+	buf := &strings.Builder{}
+	buf.WriteString("invalid configuration, ")
+	buf.WriteString(strconv.Itoa(len(e)))
+	if len(e) == 1 {
+		buf.WriteString(" problem found:")
+	} else {
+		buf.WriteString(" problems found:")
+	}
+	for _, problem := range e {
+		buf.WriteString("\n  ")
+		buf.WriteString(problem.String())
+	}
+	return buf.String()
+}
+
+// validator walks the yaml tree against the Config type collecting every problem, instead of stopping at the first
+// one as the decoder does.
+//
+// Besides the types, fields can declare rules with the `check` tag:
+//
+//	required               the key must be informed
+//	oneof=sqlite|postgres  the value must be one of the options (case-insensitive)
+type validator struct {
+	doc      *document
+	problems Errors
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// validate checks the document, returning nil if there are no problems
+func (d *document) validate() error {
+	v := &validator{doc: d}
+	v.walk(reflect.TypeOf(Config{}), d.root, "")
+
+	for _, name := range d.unmatchedEnv {
+		v.problems = append(v.problems, &Problem{
+			File:    name,
+			Message: "environment variable does not match any configuration key",
+		})
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.File != b.File {
+			return d.fileOrder(a.File) < d.fileOrder(b.File)
+		}
+		return a.Line < b.Line
+	})
+	return v.problems
+}
+
+// fileOrder position of the file in the merge, environment variables last
+func (d *document) fileOrder(file string) int {
+	for i, f := range d.files {
+		if f == file {
+			return i
+		}
+	}
+	return len(d.files)
+}
+
+func (v *validator) report(node *yaml.Node, key string, format string, args ...interface{}) {
+	v.problems = append(v.problems, &Problem{
+		File:    v.doc.sources[node],
+		Line:    node.Line,
+		Key:     key,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) walk(t reflect.Type, node *yaml.Node, key string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		if node.Kind != yaml.ScalarNode {
+			v.report(node, key, "expected %s, found %s", typeName(t), nodeName(node))
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.report(node, key, "expected %s, found %q", typeName(t), node.Value)
+		}
+		return
+	}
+
+	if isNull(node) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.report(node, key, "expected a mapping of keys, found %s", nodeName(node))
+			return
+		}
+		v.walkStruct(t, node, key)
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.report(node, key, "expected a mapping of keys, found %s", nodeName(node))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name, value := node.Content[i], node.Content[i+1]
+			if isNull(value) && t.Elem().Kind() == reflect.Ptr && t.Elem().Elem().Kind() == reflect.Struct {
+				// (Ex. "mydatabase:" without keys), still needs the required fields
+				empty := &yaml.Node{Kind: yaml.MappingNode, Line: name.Line}
+				v.doc.sources[empty] = v.doc.sources[name]
+				v.walkStruct(t.Elem().Elem(), empty, join(key, name.Value))
+				continue
+			}
+			v.walk(t.Elem(), value, join(key, name.Value))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.report(node, key, "expected a list, found %s", nodeName(node))
+			return
+		}
+		for i, item := range node.Content {
+			v.walk(t.Elem(), item, key+"["+strconv.Itoa(i)+"]")
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			v.report(node, key, "expected %s, found %s", typeName(t), nodeName(node))
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.report(node, key, "expected %s, found %q", typeName(t), node.Value)
+		}
+	}
+}
+
+func (v *validator) walkStruct(t reflect.Type, node *yaml.Node, key string) {
+	fields := map[string]reflect.StructField{}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = field
+		names = append(names, name)
+	}
+
+	declared := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i], node.Content[i+1]
+		field, known := fields[name.Value]
+		if !known {
+			if suggestion := suggest(name.Value, names); suggestion != "" {
+				v.report(name, join(key, name.Value), "unknown key, did you mean '%s'?", suggestion)
+			} else {
+				v.report(name, join(key, name.Value), "unknown key")
+			}
+			continue
+		}
+		declared[name.Value] = value
+		v.walk(field.Type, value, join(key, name.Value))
+	}
+
+	for _, name := range names {
+		rules := fields[name].Tag.Get("check")
+		if rules == "" {
+			continue
+		}
+		value, exists := declared[name]
+		for _, rule := range strings.Split(rules, ",") {
+			switch {
+			case rule == "required":
+				if !exists || isNull(value) || (value.Kind == yaml.ScalarNode && strings.TrimSpace(value.Value) == "") {
+					v.report(node, join(key, name), "is required")
+				}
+			case strings.HasPrefix(rule, "oneof="):
+				if !exists || value.Kind != yaml.ScalarNode || value.Value == "" {
+					continue
+				}
+				options := strings.Split(strings.TrimPrefix(rule, "oneof="), "|")
+				valid := false
+				for _, option := range options {
+					if strings.EqualFold(option, value.Value) {
+						valid = true
+						break
+					}
+				}
+				if !valid {
+					v.report(value, join(key, name), "unsupported value %q, expected one of: %s", value.Value, strings.Join(options, ", "))
+				}
+			}
+		}
+	}
+}
+
+// checkReferences validates rules that depend on more than one key
+func (c *Config) checkReferences(d *document) error {
+	v := &validator{doc: d}
+
+	if c.Cache.Engine == "redis" {
+		if node := d.lookup("cache", "redis"); node == nil {
+			v.report(d.lookupOrParent("cache"), "cache.redis", "is required when cache.engine is redis")
+		} else if _, exists := c.Redis[c.Cache.Redis]; !exists {
+			v.report(node, "cache.redis", "there is no redis connection named %q", c.Cache.Redis)
+		}
+	}
+
+	addrs := [][]string{{"server", "addr"}, {"server", "http", "addr"}}
+	for i, addr := range []string{c.Server.Addr, c.Server.HTTP.Addr} {
+		if addr == "" {
+			continue
+		}
+		if _, err := listen.Parse(addr); err != nil {
+			v.report(d.lookupOrParent(addrs[i]...), strings.Join(addrs[i], "."), "%v", err)
+		}
+	}
+	if _, err := listen.ParseMode(c.Server.Socket.Mode); err != nil {
+		v.report(d.lookupOrParent("server", "socket", "mode"), "server.socket.mode", "%v", err)
+	}
+
+	if sample := c.AccessLog.Sample; sample < 0 || sample > 1 {
+		v.report(d.lookupOrParent("access-log", "sample"), "access-log.sample", "expected a number between 0 and 1")
+	}
+
+	if sample := c.Tracing.Sample; sample < 0 || sample > 1 {
+		v.report(d.lookupOrParent("tracing", "sample"), "tracing.sample", "expected a number between 0 and 1")
+	}
+
+	if c.RateLimit.Store == "redis" {
+		if node := d.lookup("rate-limit", "redis"); node == nil {
+			v.report(d.lookupOrParent("rate-limit"), "rate-limit.redis", "is required when rate-limit.store is redis")
+		} else if _, exists := c.Redis[c.RateLimit.Redis]; !exists {
+			v.report(node, "rate-limit.redis", "there is no redis connection named %q", c.RateLimit.Redis)
+		}
+	}
+	names := map[string]bool{}
+	for i, rule := range c.RateLimit.Rules {
+		index := strconv.Itoa(i)
+		key := "rate-limit.rules[" + index + "]"
+		if rule == nil {
+			continue
+		}
+		if names[rule.Name] {
+			v.report(d.lookupOrParent("rate-limit", "rules", index, "name"), key+".name", "duplicated rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if node := d.lookup("rate-limit", "rules", index, "limit"); node != nil && rule.Limit <= 0 {
+			v.report(node, key+".limit", "expected a positive number")
+		}
+		if node := d.lookup("rate-limit", "rules", index, "window"); node != nil && rule.Window <= 0 {
+			v.report(node, key+".window", "expected a positive duration")
+		}
+		if rule.Burst < 0 {
+			v.report(d.lookupOrParent("rate-limit", "rules", index, "burst"), key+".burst", "expected a positive number")
+		}
+		if !validRateLimitKey(rule.Key) {
+			v.report(d.lookupOrParent("rate-limit", "rules", index, "key"), key+".key", "unsupported value %q, expected one of: ip, user, api-key, param:<name>, header:<name>", rule.Key)
+		} else if strings.HasPrefix(rule.Key, "header:") && !c.RateLimit.TrustProxy {
+			// any client can send the header, only a proxy that sets it makes the value trusted
+			v.report(d.lookupOrParent("rate-limit", "rules", index, "key"), key+".key", "%s requires rate-limit.trust-proxy, the header must be set by the proxy", rule.Key)
+		}
+		for j, value := range rule.Routes {
+			if _, err := route.ParseRule(value); err != nil {
+				v.report(d.lookupOrParent("rate-limit", "rules", index, "routes", strconv.Itoa(j)), key+".routes["+strconv.Itoa(j)+"]", "%v", err)
+			}
+		}
+	}
+
+	if c.Limits.Timeout < 0 {
+		v.report(d.lookupOrParent("limits", "timeout"), "limits.timeout", "expected a positive duration")
+	}
+	if c.Limits.MaxBodyBytes < 0 {
+		v.report(d.lookupOrParent("limits", "max-body-bytes"), "limits.max-body-bytes", "expected a positive number")
+	}
+	for i, override := range c.Limits.Routes {
+		index := strconv.Itoa(i)
+		key := "limits.routes[" + index + "]"
+		if override == nil {
+			continue
+		}
+		if override.Timeout != nil && *override.Timeout < 0 {
+			v.report(d.lookupOrParent("limits", "routes", index, "timeout"), key+".timeout", "expected a positive duration")
+		}
+		if override.MaxBodyBytes != nil && *override.MaxBodyBytes < 0 {
+			v.report(d.lookupOrParent("limits", "routes", index, "max-body-bytes"), key+".max-body-bytes", "expected a positive number")
+		}
+		for j, value := range override.Routes {
+			if _, err := route.ParseRule(value); err != nil {
+				v.report(d.lookupOrParent("limits", "routes", index, "routes", strconv.Itoa(j)), key+".routes["+strconv.Itoa(j)+"]", "%v", err)
+			}
+		}
+	}
+
+	for i, value := range c.CSRF.Exempt {
+		if _, err := route.ParseRule(value); err != nil {
+			v.report(d.lookupOrParent("csrf", "exempt", strconv.Itoa(i)), "csrf.exempt["+strconv.Itoa(i)+"]", "%v", err)
+		}
+	}
+
+	switch c.Session.Store {
+	case "db":
+		if node := d.lookup("session", "db"); node == nil {
+			v.report(d.lookupOrParent("session"), "session.db", "is required when session.store is db")
+		} else if _, exists := c.DB[c.Session.DB]; !exists {
+			v.report(node, "session.db", "there is no db connection named %q", c.Session.DB)
+		}
+	case "redis":
+		if node := d.lookup("session", "redis"); node == nil {
+			v.report(d.lookupOrParent("session"), "session.redis", "is required when session.store is redis")
+		} else if _, exists := c.Redis[c.Session.Redis]; !exists {
+			v.report(node, "session.redis", "there is no redis connection named %q", c.Session.Redis)
+		}
+	}
+	for i, key := range c.Session.Keys {
+		if key == "" {
+			continue
+		}
+		if data, err := base64.StdEncoding.DecodeString(key); err != nil || (len(data) != 16 && len(data) != 24 && len(data) != 32) {
+			v.report(d.lookupOrParent("session", "keys", strconv.Itoa(i)), "session.keys["+strconv.Itoa(i)+"]", "expected the base64 of 16, 24 or 32 bytes")
+		}
+	}
+	if c.Session.IdleTimeout < 0 {
+		v.report(d.lookupOrParent("session", "idle-timeout"), "session.idle-timeout", "expected a positive duration")
+	}
+	if c.Session.AbsoluteTimeout < 0 {
+		v.report(d.lookupOrParent("session", "absolute-timeout"), "session.absolute-timeout", "expected a positive duration")
+	}
+
+	if c.Auth.DB != "" {
+		if _, exists := c.DB[c.Auth.DB]; !exists {
+			v.report(d.lookupOrParent("auth", "db"), "auth.db", "there is no db connection named %q", c.Auth.DB)
+		}
+		if u, err := url.Parse(c.Auth.BaseURL); err != nil || u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			v.report(d.lookupOrParent("auth", "base-url"), "auth.base-url", "expected the public https URL of the site (Ex. https://example.com)")
+		}
+	}
+	if !strings.HasPrefix(c.Auth.Redirect, "/") || strings.HasPrefix(c.Auth.Redirect, "//") {
+		v.report(d.lookupOrParent("auth", "redirect"), "auth.redirect", "expected a path of the site (Ex. /account)")
+	}
+
+	oidc := c.Auth.OIDC
+	if (len(oidc.Providers) > 0 || oidc.Local.Enabled) && c.Auth.DB == "" {
+		v.report(d.lookupOrParent("auth", "oidc"), "auth.db", "is required by auth.oidc")
+	}
+	if _, exists := oidc.Providers["local"]; exists && oidc.Local.Enabled {
+		v.report(d.lookupOrParent("auth", "oidc", "providers", "local"), "auth.oidc.providers.local", "the name is used by the built-in provider (auth.oidc.local)")
+	}
+	for name, provider := range oidc.Providers {
+		if provider == nil {
+			continue
+		}
+		if u, err := url.Parse(provider.Issuer); provider.Issuer != "" && (err != nil || u.Scheme != "https" || u.Host == "") {
+			v.report(d.lookupOrParent("auth", "oidc", "providers", name, "issuer"), "auth.oidc.providers."+name+".issuer", "expected a https URL")
+		}
+	}
+	if oidc.Local.Enabled {
+		if u, err := url.Parse(oidc.Local.Issuer); err != nil || u.Scheme != "https" || u.Host == "" || u.Path == "" || u.Path == "/" {
+			v.report(d.lookupOrParent("auth", "oidc", "local", "issuer"), "auth.oidc.local.issuer", "expected a https URL with a path (Ex. https://localhost:8080/oidc)")
+		}
+	}
+
+	if c.API.Tokens.DB != "" {
+		if _, exists := c.DB[c.API.Tokens.DB]; !exists {
+			v.report(d.lookupOrParent("api", "tokens", "db"), "api.tokens.db", "there is no db connection named %q", c.API.Tokens.DB)
+		}
+	}
+	for _, api := range []struct {
+		section string
+		routes  []string
+	}{{"tokens", c.API.Tokens.Routes}, {"hmac", c.API.HMAC.Routes}} {
+		for i, value := range api.routes {
+			if _, err := route.ParseRule(value); err != nil {
+				index := strconv.Itoa(i)
+				v.report(d.lookupOrParent("api", api.section, "routes", index), "api."+api.section+".routes["+index+"]", "%v", err)
+			}
+		}
+	}
+	if len(c.API.Tokens.Routes) > 0 && c.API.Tokens.DB == "" {
+		v.report(d.lookupOrParent("api", "tokens"), "api.tokens.db", "is required when api.tokens.routes is declared")
+	}
+	if c.API.HMAC.Store == "redis" {
+		if node := d.lookup("api", "hmac", "redis"); node == nil {
+			v.report(d.lookupOrParent("api", "hmac"), "api.hmac.redis", "is required when api.hmac.store is redis")
+		} else if _, exists := c.Redis[c.API.HMAC.Redis]; !exists {
+			v.report(node, "api.hmac.redis", "there is no redis connection named %q", c.API.HMAC.Redis)
+		}
+	}
+
+	c.checkTenants(v, d)
+
+	hsts := c.Server.HSTS
+	if hsts.Preload && (!hsts.IncludeSubDomains || hsts.MaxAge < 31536000) {
+		v.report(d.lookupOrParent("server", "hsts", "preload"), "server.hsts.preload", "requires include-subdomains and a max-age of at least 31536000 (1 year)")
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return v.problems
+}
+
+// lookup finds the node of the key, nil if it was not declared. Items of lists are found by the index (Ex. "rules",
+// "0", "name").
+func (d *document) lookup(keys ...string) *yaml.Node {
+	node := d.root
+	for _, key := range keys {
+		switch node.Kind {
+		case yaml.MappingNode:
+			index := mappingIndex(node, key)
+			if index < 0 {
+				return nil
+			}
+			node = node.Content[index+1]
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				return nil
+			}
+			node = node.Content[index]
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// validRateLimitKey reports whether the key of a rate limit rule is supported
+func validRateLimitKey(key string) bool {
+	switch key {
+	case "ip", "user", "api-key":
+		return true
+	}
+	for _, prefix := range []string{"param:", "header:"} {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupOrParent finds the node of the key or of the closest declared parent
+func (d *document) lookupOrParent(keys ...string) *yaml.Node {
+	for i := len(keys); i > 0; i-- {
+		if node := d.lookup(keys[:i]...); node != nil {
+			return node
+		}
+	}
+	return d.root
+}
+
+// suggest returns the most similar known key, if close enough to be a typo
+func suggest(name string, options []string) string {
+	best, bestDistance := "", -1
+	for _, option := range options {
+		distance := levenshtein(strings.ToLower(name), option)
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = option, distance
+		}
+	}
+	limit := len(name) / 3
+	if limit < 2 {
+		limit = 2
+	}
+	if bestDistance < 0 || bestDistance > limit {
+		return ""
+	}
+	return best
+}
+
+func levenshtein(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, value := range values[1:] {
+		if value < m {
+			m = value
+		}
+	}
+	return m
+}
+
+func join(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == 0 || (node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null")
+}
+
+func nodeName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", node.Value)
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if t == reflect.TypeOf(Duration(0)) {
+			return "a duration (Ex. 30s, 15m, 1h)"
+		}
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "a text"
+}
@@ -0,0 +1,56 @@
+(function () {
+  // CSRF client, injected by the server in every page. Sends the token of the page (<meta name="csrf-token">) on the
+  // unsafe requests of the scripts (Ex. the events of the live controllers) and on the forms created by scripts
+  const script = document.currentScript;
+  const header = script.dataset.header;
+  const field = script.dataset.field;
+  const safe = /^(GET|HEAD|OPTIONS|TRACE)$/i;
+
+  function token() {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.content : '';
+  }
+
+  function sameOrigin(url) {
+    return new URL(url, window.location.href).origin === window.location.origin;
+  }
+
+  const fetch = window.fetch;
+  window.fetch = function (input, init) {
+    const request = input instanceof Request ? input : null;
+    const method = (init && init.method) || (request ? request.method : 'GET');
+    if (safe.test(method) || !sameOrigin(request ? request.url : String(input))) {
+      return fetch.call(this, input, init);
+    }
+    const headers = new Headers((init && init.headers) || (request ? request.headers : undefined));
+    if (!headers.has(header)) {
+      headers.set(header, token());
+    }
+    return fetch.call(this, input, Object.assign({}, init, {headers: headers}));
+  };
+
+  const open = XMLHttpRequest.prototype.open;
+  const send = XMLHttpRequest.prototype.send;
+  XMLHttpRequest.prototype.open = function (method, url) {
+    this._csrf = !safe.test(method) && sameOrigin(url);
+    return open.apply(this, arguments);
+  };
+  XMLHttpRequest.prototype.send = function () {
+    if (this._csrf) {
+      this.setRequestHeader(header, token());
+    }
+    return send.apply(this, arguments);
+  };
+
+  document.addEventListener('submit', function (event) {
+    const form = event.target;
+    if (safe.test(form.method) || form.method === 'dialog' || !sameOrigin(form.action) || form.elements[field]) {
+      return;
+    }
+    const input = document.createElement('input');
+    input.type = 'hidden';
+    input.name = field;
+    input.value = token();
+    form.appendChild(input);
+  }, true);
+})();
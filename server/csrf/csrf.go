@@ -0,0 +1,283 @@
+// Package csrf protects the unsafe requests (POST, PUT, PATCH and DELETE) against cross-site request forgery, by the
+// `csrf` block of config.yaml.
+//
+// It uses the double-submit pattern: the browser receives a random secret in the `__Host-csrf` cookie, and the unsafe
+// requests must send a token derived from it, in the X-CSRF-Token header or in the `_csrf` form field. Other sites can
+// make the browser send the cookie, but can not read it to build the token. Tokens are masked with a random pad on
+// every request, so they do not leak the secret through compression (BREACH).
+//
+// The HTML pages receive the token automatically:
+//
+//	<head>                =>  <head><meta name="csrf-token" content="...">
+//	<form method="post">  =>  <form method="post"><input type="hidden" name="_csrf" value="...">
+//
+// The client script added by Register sends the token of the page on the requests of the scripts, including the
+// events of the live controllers (POST /live). Routes called by other systems (Ex. inbound webhooks) are exempt.
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/rewrite"
+	"github.com/syntax-framework/demo/server/route"
+	"github.com/syntax-framework/shtml/sht"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+//go:embed client.js
+var clientScript string
+
+const (
+	CookieName = "__Host-csrf"  // The __Host- prefix prevents subdomains from setting the cookie
+	FieldName  = "_csrf"        // Form field
+	HeaderName = "X-CSRF-Token" // Header sent by scripts
+)
+
+// secretSize of the secret in the cookie, the tokens have twice the size (pad + masked secret)
+const secretSize = 32
+
+// maxMemory used to parse multipart forms, the same default of net/http
+const maxMemory = 32 << 20
+
+var (
+	ErrNoCookie     = errors.New("csrf: cookie not found")
+	ErrNoToken      = errors.New("csrf: token not found")
+	ErrInvalidToken = errors.New("csrf: invalid token")
+)
+
+var rejected = metrics.NewCounter("csrf_rejected_total", "Unsafe requests rejected without a valid CSRF token")
+
+// Protection checks the tokens of the unsafe requests, except for the Exempt routes
+type Protection struct {
+	Exempt []*route.Rule
+	// Trusted reports whether the request was authenticated without cookies (Ex. API tokens), those requests can not be
+	// forged by other sites and are not checked
+	Trusted func(r *http.Request) bool
+}
+
+// New creates the protection of the configuration, nil if disabled
+func New(cfg config.CSRF) (*Protection, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+	p := &Protection{}
+	for i, value := range cfg.Exempt {
+		rule, err := route.ParseRule(value)
+		if err != nil {
+			return nil, fmt.Errorf("csrf: exempt[%d]: %w", i, err)
+		}
+		p.Exempt = append(p.Exempt, rule)
+	}
+	return p, nil
+}
+
+// Register adds the client script to all pages of the site. Must be called before `app.Init()`.
+func Register(app *syntax.Syntax) {
+	asset := app.Template.(*sht.TemplateSystem).RegisterAssetJsContent(clientScript)
+	// the bundler writes the attributes without a separator
+	asset.Attributes = map[string]string{
+		" data-header": HeaderName,
+		" data-field":  FieldName,
+	}
+	app.Bundler.AddRequiredAsset(asset)
+}
+
+type stateKey struct{}
+
+// state of the request, the cookie is only set when a token is used
+type state struct {
+	w      http.ResponseWriter
+	secret []byte
+	fresh  bool // The secret is not in the cookie yet
+	token  string
+}
+
+// Token returns the token of the request, to be sent back on unsafe requests. Empty when the protection is disabled.
+// Must be called before the response starts, it may set the cookie.
+func Token(r *http.Request) string {
+	return TokenFromContext(r.Context())
+}
+
+// TokenFromContext returns the token of the request of the context
+func TokenFromContext(ctx context.Context) string {
+	s, _ := ctx.Value(stateKey{}).(*state)
+	if s == nil {
+		return ""
+	}
+	if s.token == "" {
+		s.token = mask(s.secret)
+		if s.fresh {
+			s.fresh = false
+			http.SetCookie(s.w, &http.Cookie{
+				Name:     CookieName,
+				Value:    base64.RawURLEncoding.EncodeToString(s.secret),
+				Path:     "/",
+				Secure:   true,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	return s.token
+}
+
+// Handler rejects the unsafe requests without a valid token (403) and adds the token to the HTML pages
+func (p *Protection) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &state{w: w, secret: cookieSecret(r)}
+		if !safe(r.Method) && !p.exempt(r) && (p.Trusted == nil || !p.Trusted(r)) {
+			if err := Check(r, s.secret); err != nil {
+				rejected.Inc()
+				log.Printf("csrf: %s %s rejected: %v (request %s)", r.Method, r.URL.Path, err, requestid.FromRequest(r))
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		if s.secret == nil {
+			s.secret = newSecret()
+			s.fresh = true
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), stateKey{}, s))
+		rw := rewrite.HTML(w, func(document []byte) []byte {
+			return Inject(document, TokenFromContext(r.Context()))
+		})
+		defer rw.Close()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func (p *Protection) exempt(r *http.Request) bool {
+	for _, rule := range p.Exempt {
+		if _, matched := rule.Match(r); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// Check validates the token of the request (header or form field) against the secret of the cookie
+func Check(r *http.Request, secret []byte) error {
+	if secret == nil {
+		return ErrNoCookie
+	}
+	token := r.Header.Get(HeaderName)
+	if token == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/x-www-form-urlencoded":
+			token = r.PostFormValue(FieldName)
+		case "multipart/form-data":
+			if err := r.ParseMultipartForm(maxMemory); err == nil {
+				token = r.PostFormValue(FieldName)
+			}
+		}
+	}
+	if token == "" {
+		return ErrNoToken
+	}
+	if subtle.ConstantTimeCompare(unmask(token), secret) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// cookieSecret returns the secret of the cookie, nil if absent or invalid
+func cookieSecret(r *http.Request) []byte {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil {
+		return nil
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(secret) != secretSize {
+		return nil
+	}
+	return secret
+}
+
+func newSecret() []byte {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// mask returns pad + (pad XOR secret) with a random pad
+func mask(secret []byte) string {
+	token := make([]byte, 2*secretSize)
+	pad, masked := token[:secretSize], token[secretSize:]
+	if _, err := rand.Read(pad); err != nil {
+		panic(err)
+	}
+	xor(masked, pad, secret)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// unmask returns the secret of the token, nil if invalid
+func unmask(token string) []byte {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != 2*secretSize {
+		return nil
+	}
+	secret := make([]byte, secretSize)
+	xor(secret, data[:secretSize], data[secretSize:])
+	return secret
+}
+
+func xor(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// Inject adds the token to the `<head>` (meta csrf-token) and to the forms of the document that submit to the same
+// site with an unsafe method
+func Inject(document []byte, token string) []byte {
+	escaped := html.EscapeString(token)
+	return rewrite.StartTags(document, func(tag *rewrite.Tag) (string, string) {
+		switch tag.Name {
+		case "head":
+			return "", `<meta name="csrf-token" content="` + escaped + `">`
+		case "form":
+			method := strings.ToLower(strings.TrimSpace(tag.Attrs["method"]))
+			if method == "" || method == "get" || method == "dialog" || !relative(tag.Attrs["action"]) {
+				return "", ""
+			}
+			return "", `<input type="hidden" name="` + FieldName + `" value="` + escaped + `">`
+		}
+		return "", ""
+	})
+}
+
+// relative reports whether the action submits to the same site, the token is not sent to other sites
+func relative(action string) bool {
+	action = strings.TrimSpace(action)
+	if strings.HasPrefix(action, "//") || strings.HasPrefix(action, `/\`) {
+		return false
+	}
+	colon := strings.IndexByte(action, ':')
+	return colon < 0 || strings.ContainsAny(action[:colon], "/?#")
+}
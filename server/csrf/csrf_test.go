@@ -0,0 +1,151 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/syntax-framework/demo/server/config"
+)
+
+func Test_inject(t *testing.T) {
+	document := `<html><HEAD><title>t</title></HEAD><body>` +
+		`<form action="/search"><input name="q"></form>` +
+		`<form method="POST" action="/login"><input name="user"></form>` +
+		`<form method="post" action="https://other.com/login"></form>` +
+		`<form method="post" action="//other.com/login"></form>` +
+		`<form method="delete"></form>` +
+		`<script>let s = "<form method=post>";</script></body></html>`
+	expected := `<html><HEAD><meta name="csrf-token" content="t&amp;1"><title>t</title></HEAD><body>` +
+		`<form action="/search"><input name="q"></form>` +
+		`<form method="POST" action="/login"><input type="hidden" name="_csrf" value="t&amp;1"><input name="user"></form>` +
+		`<form method="post" action="https://other.com/login"></form>` +
+		`<form method="post" action="//other.com/login"></form>` +
+		`<form method="delete"><input type="hidden" name="_csrf" value="t&amp;1"></form>` +
+		`<script>let s = "<form method=post>";</script></body></html>`
+	if got := string(Inject([]byte(document), "t&1")); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+}
+
+func Test_handler(t *testing.T) {
+	p, err := New(config.CSRF{Exempt: []string{"/integrations/inbound/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var called int
+	var token string
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		if r.Method == http.MethodGet && r.URL.Path == "/api" {
+			token = Token(r)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write([]byte(`<html><head></head><body><form method="post"></form></body></html>`))
+	}))
+
+	// the page receives the cookie and the token
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CookieName || !cookies[0].Secure || !cookies[0].HttpOnly {
+		t.Fatalf("got %v", cookies)
+	}
+	cookie := cookies[0]
+	body := w.Body.String()
+	if strings.Count(body, `name="_csrf" value="`) != 1 || !strings.Contains(body, `<meta name="csrf-token"`) {
+		t.Errorf("got %q", body)
+	}
+
+	// handlers read the token, the cookie is not sent again
+	r := httptest.NewRequest(http.MethodGet, "/api", nil)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if token == "" || len(w.Result().Cookies()) != 0 || w.Body.String() != "{}" {
+		t.Errorf("got %q %v %q", token, w.Result().Cookies(), w.Body.String())
+	}
+
+	post := func(path string, cookie *http.Cookie, header string, form url.Values) int {
+		var r *http.Request
+		if form != nil {
+			r = httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"event":"click"}`))
+			r.Header.Set("Content-Type", "application/json")
+		}
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		if header != "" {
+			r.Header.Set(HeaderName, header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	other := &http.Cookie{Name: CookieName, Value: strings.Repeat("A", 43)}
+	tests := []struct {
+		name     string
+		path     string
+		cookie   *http.Cookie
+		header   string
+		form     url.Values
+		expected int
+	}{
+		{"header (live event)", "/live", cookie, token, nil, http.StatusOK},
+		{"form field", "/login", cookie, "", url.Values{FieldName: {token}}, http.StatusOK},
+		{"no token", "/live", cookie, "", nil, http.StatusForbidden},
+		{"no cookie", "/live", nil, token, nil, http.StatusForbidden},
+		{"cookie of other browser", "/live", other, token, nil, http.StatusForbidden},
+		{"invalid token", "/login", cookie, "", url.Values{FieldName: {"abc"}}, http.StatusForbidden},
+		{"exempt", "/integrations/inbound/github", nil, "", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		called = 0
+		if code := post(tt.path, tt.cookie, tt.header, tt.form); code != tt.expected || (code == http.StatusOK) != (called == 1) {
+			t.Errorf("%s: got %d, called %d", tt.name, code, called)
+		}
+	}
+
+	// masked tokens change on every request, all of them are valid
+	r = httptest.NewRequest(http.MethodGet, "/api", nil)
+	r.AddCookie(cookie)
+	previous := token
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if token == previous || post("/live", cookie, previous, nil) != http.StatusOK {
+		t.Errorf("expected a new valid token")
+	}
+}
+
+// the tags split by a flush are rewritten whole, the text of the scripts is not taken for tags
+func Test_handler_flush(t *testing.T) {
+	p, err := New(config.CSRF{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		for _, part := range []string{
+			`<html><head><title>Demo</title><script`,
+			` src="/a.js"></script><script>let form = '`,
+			`<form method="post">';</script></head><body><form method="post"></form></body></html>`,
+		} {
+			w.Write([]byte(part))
+			w.(http.Flusher).Flush()
+		}
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	body := w.Body.String()
+	if !strings.Contains(body, `<script src="/a.js"></script><script>let form = '<form method="post">';</script>`) ||
+		strings.Count(body, `name="_csrf"`) != 1 || strings.Count(body, `<meta name="csrf-token"`) != 1 || !w.Flushed {
+		t.Errorf("got %q", body)
+	}
+}
@@ -0,0 +1,111 @@
+// Package db opens the SQL databases declared in the `db` block of config.yaml and applies their migrations.
+//
+// Only the sqlite driver is compiled in. The postgres and mysql engines are accepted by the configuration, but
+// require the application to import the driver (Ex. `_ "github.com/lib/pq"`) and register it with RegisterDriver.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/config"
+)
+
+// drivers name of the database/sql driver of each engine
+var drivers = map[string]string{
+	"sqlite":   "sqlite",
+	"postgres": "postgres",
+	"mysql":    "mysql",
+}
+
+// RegisterDriver changes the database/sql driver used by the engine
+func RegisterDriver(engine string, driver string) {
+	drivers[engine] = driver
+}
+
+// DB a named database connection
+type DB struct {
+	*sql.DB
+	Name   string // Name of the connection in config.yaml, also the directory in db/
+	Engine string
+	Tenant string // Of the connection of a `tenancy: database` database, empty when it is shared
+	Column string // Of the tenant, on the tables of a `tenancy: column` database (see query.Scoped)
+}
+
+// Open opens the connection, the DSN of sqlite defaults to `<name>.db` in the working directory
+func Open(name string, cfg *config.DB) (*DB, error) {
+	engine := strings.ToLower(cfg.Engine)
+	driver, exists := drivers[engine]
+	if !exists {
+		return nil, fmt.Errorf("db.%s: unsupported engine %q", name, cfg.Engine)
+	}
+
+	dsn := cfg.DSN
+	if dsn == "" {
+		if engine != "sqlite" {
+			return nil, fmt.Errorf("db.%s: dsn is required for the %s engine", name, engine)
+		}
+		dsn = name + ".db"
+	}
+
+	if !hasDriver(driver) {
+		return nil, fmt.Errorf("db.%s: the %s driver is not compiled in this binary", name, engine)
+	}
+
+	conn, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("db.%s: %w", name, err)
+	}
+	if engine == "sqlite" {
+		// sqlite allows a single writer, avoids "database is locked" errors
+		conn.SetMaxOpenConns(1)
+	}
+	return &DB{DB: conn, Name: name, Engine: engine}, nil
+}
+
+// OpenAll opens all databases of the configuration. On error, the connections already opened are closed.
+func OpenAll(cfg map[string]*config.DB) (map[string]*DB, error) {
+	var names []string
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dbs := map[string]*DB{}
+	for _, name := range names {
+		conn, err := Open(name, cfg[name])
+		if err != nil {
+			for _, opened := range dbs {
+				opened.Close()
+			}
+			return nil, err
+		}
+		dbs[name] = conn
+	}
+	return dbs, nil
+}
+
+// Placeholder returns the positional parameter of the engine, starting at 1 (`$1` on postgres, `?` on the others)
+func (d *DB) Placeholder(n int) string {
+	if d.Engine == "postgres" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// TenantColumn the column of the tenant on the shared tables, empty when the tables are not shared
+func (d *DB) TenantColumn() string {
+	return d.Column
+}
+
+func hasDriver(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
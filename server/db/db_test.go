@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/syntax-framework/demo/server/config"
+)
+
+func Test_migrate(t *testing.T) {
+	conn, err := Open("test", &config.DB{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fsys := fstest.MapFS{
+		"migrations/V2.Add_Email.sql":       {Data: []byte("ALTER TABLE users ADD COLUMN email VARCHAR(255)")},
+		"migrations/V1.Create_Database.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name VARCHAR(255))")},
+	}
+
+	migrations, err := LoadMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Description != "Add Email" {
+		t.Fatalf("load: got %+v %+v", migrations[0], migrations[1])
+	}
+
+	ctx := context.Background()
+	applied, err := conn.Migrate(ctx, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("first run: Expected 2 migrations applied, got %d", len(applied))
+	}
+	if _, err = conn.ExecContext(ctx, "INSERT INTO users (name, email) VALUES ('alex', 'alex@example.com')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied, err = conn.Migrate(ctx, migrations); err != nil || len(applied) != 0 {
+		t.Errorf("second run: Expected nothing to apply, got %d, %v", len(applied), err)
+	}
+
+	// changed after being applied
+	fsys["migrations/V1.Create_Database.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE users (id INTEGER)")}
+	if migrations, err = LoadMigrations(fsys, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Migrate(ctx, migrations); err == nil {
+		t.Error("Expected error for a changed migration")
+	}
+
+	// failed migration is rolled back
+	fsys["migrations/V1.Create_Database.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name VARCHAR(255))")}
+	fsys["migrations/V3.Invalid.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE")}
+	if migrations, err = LoadMigrations(fsys, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Migrate(ctx, migrations); err == nil {
+		t.Error("Expected error for an invalid migration")
+	}
+	var count int
+	conn.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 applied migrations, got %d", count)
+	}
+}
+
+func Test_open(t *testing.T) {
+	if _, err := Open("pg", &config.DB{Engine: "postgres"}); err == nil {
+		t.Error("Expected error for postgres without dsn")
+	}
+	if _, err := Open("pg", &config.DB{Engine: "postgres", DSN: "postgres://localhost"}); err == nil {
+		t.Error("Expected error for postgres driver not compiled in")
+	}
+}
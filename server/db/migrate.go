@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration a versioned SQL script, `V<version>.<Description>.sql` (Ex. V1.Create_Database.sql)
+type Migration struct {
+	Version     int
+	Description string
+	File        string
+	SQL         string
+	Checksum    string
+}
+
+var migrationReg = regexp.MustCompile(`^V(\d+)\.(.+)\.sql$`)
+
+// LoadMigrations reads the migrations of the directory, sorted by version
+func LoadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []*Migration
+	versions := map[int]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		file := path.Join(dir, entry.Name())
+		groups := migrationReg.FindStringSubmatch(entry.Name())
+		if groups == nil {
+			return nil, fmt.Errorf("%s: invalid migration name, expected V<version>.<Description>.sql", file)
+		}
+		version, _ := strconv.Atoi(groups[1])
+		if existing, exists := versions[version]; exists {
+			return nil, fmt.Errorf("%s: version %d is already declared in %s", file, version, existing)
+		}
+		versions[version] = file
+
+		data, errRead := fs.ReadFile(fsys, file)
+		if errRead != nil {
+			return nil, errRead
+		}
+		sum := sha256.Sum256(data)
+		migrations = append(migrations, &Migration{
+			Version:     version,
+			Description: strings.ReplaceAll(groups[2], "_", " "),
+			File:        file,
+			SQL:         string(data),
+			Checksum:    hex.EncodeToString(sum[:]),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrate applies the pending migrations, each one in its own transaction, and returns the applied ones.
+//
+// Fails if a migration already applied was changed after it was applied.
+func (d *DB) Migrate(ctx context.Context, migrations []*Migration) ([]*Migration, error) {
+	_, err := d.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description VARCHAR(255) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		applied_at VARCHAR(32) NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("db.%s: %w", d.Name, err)
+	}
+
+	applied := map[int]string{}
+	rows, err := d.QueryContext(ctx, "SELECT version, checksum FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("db.%s: %w", d.Name, err)
+	}
+	for rows.Next() {
+		var version int
+		var checksum string
+		if err = rows.Scan(&version, &checksum); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("db.%s: %w", d.Name, err)
+		}
+		applied[version] = checksum
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db.%s: %w", d.Name, err)
+	}
+
+	var done []*Migration
+	for _, migration := range migrations {
+		if checksum, exists := applied[migration.Version]; exists {
+			if checksum != migration.Checksum {
+				return done, fmt.Errorf("%s: migration was changed after being applied", migration.File)
+			}
+			continue
+		}
+		if err = d.apply(ctx, migration); err != nil {
+			return done, fmt.Errorf("%s: %w", migration.File, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+func (d *DB) apply(ctx context.Context, migration *Migration) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, migration.SQL); err != nil {
+		return err
+	}
+	insert := fmt.Sprintf(
+		"INSERT INTO schema_migrations (version, description, checksum, applied_at) VALUES (%s, %s, %s, %s)",
+		d.Placeholder(1), d.Placeholder(2), d.Placeholder(3), d.Placeholder(4),
+	)
+	_, err = tx.ExecContext(ctx, insert, migration.Version, migration.Description, migration.Checksum, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
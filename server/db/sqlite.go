@@ -0,0 +1,6 @@
+package db
+
+import (
+	// pure Go sqlite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
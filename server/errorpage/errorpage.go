@@ -0,0 +1,147 @@
+// Package errorpage replaces the plain text and empty error responses (Ex. http.NotFound, a panic recovered by the
+// router) by a page with the status and the request ID, which the user can send to the support.
+//
+// The error responses written by the handlers with another content type (Ex. the JSON of /readyz) are not changed.
+package errorpage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/requestid"
+)
+
+var page = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Status}} {{.Text}}</title>
+</head>
+<body>
+  <h1>{{.Status}} {{.Text}}</h1>
+  {{if .RequestID}}<p>Request ID: <code>{{.RequestID}}</code></p>{{end}}
+</body>
+</html>
+`))
+
+// Handler writes the error page of the error responses of next and recovers its panics
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &writer{ResponseWriter: w}
+		defer func() {
+			if rcv := recover(); rcv != nil {
+				if rcv == http.ErrAbortHandler {
+					panic(rcv)
+				}
+				log.Printf("panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestid.FromRequest(r), rcv, debug.Stack())
+				if ew.wroteHeader && !ew.intercepted {
+					// part of the response was sent
+					return
+				}
+				ew.status, ew.intercepted = http.StatusInternalServerError, true
+				ew.body.Reset()
+			}
+			ew.finish(r)
+		}()
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// writer holds the error responses that may be replaced
+type writer struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	intercepted bool
+	plain       bool // text/plain error, always replaced
+	body        bytes.Buffer
+}
+
+func (w *writer) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+
+	contentType := w.Header().Get("Content-Type")
+	if status >= 400 && (contentType == "" || strings.HasPrefix(contentType, "text/plain")) {
+		w.intercepted = true
+		w.plain = contentType != ""
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writer) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepted {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *writer) Flush() {
+	if w.intercepted {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the held response: the error page for the plain text and empty errors, the original otherwise
+func (w *writer) finish(r *http.Request) {
+	if !w.intercepted {
+		return
+	}
+	if !w.plain && w.body.Len() > 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+
+	data := map[string]interface{}{
+		"Status":    w.status,
+		"Text":      http.StatusText(w.status),
+		"RequestID": requestid.FromRequest(r),
+	}
+	content := &bytes.Buffer{}
+	header := w.Header()
+	if accepts(r, "application/json") && !accepts(r, "text/html") {
+		header.Set("Content-Type", "application/json")
+		json.NewEncoder(content).Encode(map[string]interface{}{
+			"status":     w.status,
+			"error":      data["Text"],
+			"request_id": data["RequestID"],
+		})
+	} else {
+		header.Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(content, data); err != nil {
+			content.Reset()
+			fmt.Fprintf(content, "%d %s\n", w.status, data["Text"])
+		}
+	}
+	header.Set("Content-Length", strconv.Itoa(content.Len()))
+	w.ResponseWriter.WriteHeader(w.status)
+	if r.Method != http.MethodHead {
+		w.ResponseWriter.Write(content.Bytes())
+	}
+}
+
+func accepts(r *http.Request, mediaType string) bool {
+	return strings.Contains(r.Header.Get("Accept"), mediaType)
+}
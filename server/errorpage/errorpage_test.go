@@ -0,0 +1,63 @@
+package errorpage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/syntax-framework/demo/server/requestid"
+)
+
+func Test_handler(t *testing.T) {
+	handler := requestid.Handler(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("hello"))
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":"fail"}`))
+		case "/html":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<h1>custom</h1>"))
+		case "/empty":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/panic":
+			panic("boom")
+		default:
+			http.NotFound(w, r)
+		}
+	})))
+
+	for _, test := range []struct {
+		path     string
+		accept   string
+		status   int
+		contains string
+	}{
+		{"/ok", "", 200, "hello"},
+		{"/json", "", 503, `{"status":"fail"}`},
+		{"/html", "", 404, "<h1>custom</h1>"},
+		{"/nope", "", 404, "<h1>404 Not Found</h1>"},
+		{"/empty", "", 500, "<h1>500 Internal Server Error</h1>"},
+		{"/panic", "", 500, "<h1>500 Internal Server Error</h1>"},
+		{"/nope", "application/json", 404, `"request_id":"abc"`},
+	} {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Header.Set(requestid.Header, "abc")
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.contains) {
+			t.Errorf("%s: got %d %q", test.path, rec.Code, rec.Body.String())
+		}
+		if test.status >= 400 && strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") &&
+			test.path != "/html" && !strings.Contains(rec.Body.String(), "<code>abc</code>") {
+			t.Errorf("%s: Expected the request ID on the page, got %q", test.path, rec.Body.String())
+		}
+	}
+}
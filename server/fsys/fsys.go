@@ -0,0 +1,176 @@
+// Package fsys composes the FileSystems used by the application, allowing directories on disk to be layered over the
+// files embedded in the binary.
+package fsys
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Layer a named FileSystem
+type Layer struct {
+	Name string // Used to report where the files came from (Ex. "embedded", "overlay:/srv/hotfix")
+	FS   fs.FS
+}
+
+// Layered is a read-only fs.FS composed of layers. When a file exists in more than one layer, the first one wins.
+// Directories are merged.
+type Layered []Layer
+
+// Open implements fs.FS
+func (l Layered) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, layer := range l {
+		file, err := layer.FS.Open(name)
+		if err == nil {
+			if stat, errStat := file.Stat(); errStat == nil && stat.IsDir() {
+				return &layeredDir{File: file, fsys: l, name: name}, nil
+			}
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// layeredDir a directory whose entries are merged from all layers
+type layeredDir struct {
+	fs.File
+	fsys    Layered
+	name    string
+	entries []fs.DirEntry
+	offset  int
+	loaded  bool
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *layeredDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+// ReadDir implements fs.ReadDirFS, returning the union of the entries of all layers
+func (l Layered) ReadDir(name string) ([]fs.DirEntry, error) {
+	found := false
+	entries := map[string]fs.DirEntry{}
+	for _, layer := range l {
+		list, err := fs.ReadDir(layer.FS, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, entry := range list {
+			if _, exists := entries[entry.Name()]; !exists {
+				entries[entry.Name()] = entry
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list, nil
+}
+
+// Source returns the name of the layer that serves the file, empty if it does not exist
+func (l Layered) Source(name string) string {
+	for _, layer := range l {
+		if stat, err := fs.Stat(layer.FS, name); err == nil && !stat.IsDir() {
+			return layer.Name
+		}
+	}
+	return ""
+}
+
+// FileSource a file and the layer it is served from
+type FileSource struct {
+	Path  string
+	Layer string
+}
+
+// Sources lists all files and the layer each one is served from, sorted by path
+func (l Layered) Sources() ([]FileSource, error) {
+	var sources []FileSource
+	err := fs.WalkDir(l, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			sources = append(sources, FileSource{Path: name, Layer: l.Source(name)})
+		}
+		return nil
+	})
+	return sources, err
+}
+
+// CopyDir writes all files of the FileSystem into the directory on disk
+func CopyDir(fsys fs.FS, dir string) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(fsys, name, target)
+	})
+}
+
+func copyFile(fsys fs.FS, name string, target string) error {
+	src, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,78 @@
+package fsys
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func testLayers() Layered {
+	return Layered{
+		{Name: "overlay", FS: fstest.MapFS{
+			"web/index.html": {Data: []byte("hotfix")},
+		}},
+		{Name: "embedded", FS: fstest.MapFS{
+			"web/index.html":         {Data: []byte("original")},
+			"web/assets/js/app.js":   {Data: []byte("app")},
+			"db/mydatabase/q.yaml":   {Data: []byte("name: Q")},
+			"web/_layout/keep.txt":   {Data: []byte("")},
+			"integrations/keep.yaml": {Data: []byte("")},
+		}},
+	}
+}
+
+func Test_layered(t *testing.T) {
+	layers := testLayers()
+
+	data, err := fs.ReadFile(layers, "web/index.html")
+	if err != nil || string(data) != "hotfix" {
+		t.Errorf("Expected overlay content, got '%s' (%v)", data, err)
+	}
+	if data, err = fs.ReadFile(layers, "web/assets/js/app.js"); err != nil || string(data) != "app" {
+		t.Errorf("Expected embedded content, got '%s' (%v)", data, err)
+	}
+	if _, err = fs.ReadFile(layers, "web/missing.html"); err == nil {
+		t.Error("Expected error for missing file")
+	}
+
+	sources, err := layers.Sources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FileSource{
+		{"db/mydatabase/q.yaml", "embedded"},
+		{"integrations/keep.yaml", "embedded"},
+		{"web/_layout/keep.txt", "embedded"},
+		{"web/assets/js/app.js", "embedded"},
+		{"web/index.html", "overlay"},
+	}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("sources mismatch:\nExpected %v\nGot      %v", expected, sources)
+	}
+
+	if err = fstest.TestFS(layers, "web/index.html", "web/assets/js/app.js", "db/mydatabase/q.yaml"); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_copy_dir(t *testing.T) {
+	dir := t.TempDir()
+	web, err := fs.Sub(testLayers(), "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CopyDir(web, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil || string(data) != "hotfix" {
+		t.Errorf("Expected overlay content, got '%s' (%v)", data, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "assets", "js", "app.js")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package health implements the liveness (/healthz) and readiness (/readyz) endpoints.
+//
+// Subsystems register a check for each dependency (Ex. "db.mydatabase", "redis.my-redis"). Readiness runs all checks
+// concurrently, each one with its own timeout, and caches the results so that frequent probes from the load balancer
+// do not overload the dependencies. During shutdown the Checker is drained, readiness fails while the requests in
+// progress finish.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOk       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+const (
+	DefaultTimeout = 2 * time.Second // Default timeout of each check
+	DefaultCache   = 2 * time.Second // Default time the result of a check is reused
+)
+
+// CheckFunc verifies a dependency, returns nil when it is healthy
+type CheckFunc func(ctx context.Context) error
+
+// Result of a check
+type Result struct {
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration"`
+	Checked  time.Time `json:"checked"`
+}
+
+// Report of the readiness, returned as JSON by /readyz
+type Report struct {
+	Status string             `json:"status"`
+	Checks map[string]*Result `json:"checks"`
+}
+
+type check struct {
+	name    string
+	timeout time.Duration
+	fn      CheckFunc
+	mutex   sync.Mutex
+	last    *Result
+}
+
+// Checker keeps the checks registered by the subsystems, safe for concurrent use
+type Checker struct {
+	Timeout time.Duration // Default timeout of the checks. Defaults to DefaultTimeout
+	Cache   time.Duration // Time the results are reused. Defaults to DefaultCache, negative disables the cache
+
+	mutex    sync.RWMutex
+	checks   map[string]*check
+	draining int32
+}
+
+// Register adds a check. A timeout of 0 uses the Checker timeout. Registering the same name again replaces the check.
+func (c *Checker) Register(name string, timeout time.Duration, fn CheckFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.checks == nil {
+		c.checks = map[string]*check{}
+	}
+	c.checks[name] = &check{name: name, timeout: timeout, fn: fn}
+}
+
+// Names returns the registered checks, sorted
+func (c *Checker) Names() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var names []string
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Drain flips the readiness to false, called at the beginning of the shutdown
+func (c *Checker) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+// Draining reports whether the process is shutting down
+func (c *Checker) Draining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+// Run executes all checks concurrently, reusing the cached results
+func (c *Checker) Run(ctx context.Context) *Report {
+	c.mutex.RLock()
+	checks := make([]*check, 0, len(c.checks))
+	for _, chk := range c.checks {
+		checks = append(checks, chk)
+	}
+	c.mutex.RUnlock()
+
+	results := make([]*Result, len(checks))
+	wg := sync.WaitGroup{}
+	for i, chk := range checks {
+		wg.Add(1)
+		go func(i int, chk *check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, chk)
+		}(i, chk)
+	}
+	wg.Wait()
+
+	report := &Report{Status: StatusOk, Checks: map[string]*Result{}}
+	for i, chk := range checks {
+		report.Checks[chk.name] = results[i]
+		if results[i].Status != StatusOk {
+			report.Status = StatusFail
+		}
+	}
+	if c.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, chk *check) *Result {
+	// concurrent probes wait for the check in progress and reuse its result
+	chk.mutex.Lock()
+	defer chk.mutex.Unlock()
+
+	cache := c.Cache
+	if cache == 0 {
+		cache = DefaultCache
+	}
+	if chk.last != nil && time.Since(chk.last.Checked) < cache {
+		return chk.last
+	}
+
+	timeout := chk.timeout
+	if timeout <= 0 {
+		timeout = c.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- chk.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// the check ignored the context
+		err = ctx.Err()
+	}
+
+	result := &Result{Status: StatusOk, Duration: time.Since(start).Round(time.Microsecond).String(), Checked: start}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "timeout after " + timeout.String()
+		}
+	}
+	chk.last = result
+	return result
+}
+
+// Liveness handler of /healthz, the process is alive while it answers
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": StatusOk})
+}
+
+// Readiness handler of /readyz, 200 when all checks pass, 503 otherwise or while draining
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusOk {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
@@ -0,0 +1,86 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_readiness(t *testing.T) {
+	var calls int32
+	checker := &Checker{Cache: time.Minute}
+	checker.Register("db.ok", 0, func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	report := readiness(t, checker, http.StatusOK)
+	if report.Status != StatusOk || report.Checks["db.ok"].Status != StatusOk {
+		t.Errorf("Expected ok, got %+v", report)
+	}
+
+	// cached
+	readiness(t, checker, http.StatusOK)
+	if calls != 1 {
+		t.Errorf("Expected the cached result to be used, check called %d times", calls)
+	}
+
+	checker.Register("redis.down", 0, func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	checker.Register("storage.slow", 20*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	start := time.Now()
+	report = readiness(t, checker, http.StatusServiceUnavailable)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the slow check to time out, took %s", elapsed)
+	}
+	if report.Status != StatusFail {
+		t.Errorf("Expected fail, got %s", report.Status)
+	}
+	if result := report.Checks["redis.down"]; result.Error != "connection refused" {
+		t.Errorf("redis.down: got %+v", result)
+	}
+	if result := report.Checks["storage.slow"]; result.Status != StatusFail || result.Error != "timeout after 20ms" {
+		t.Errorf("storage.slow: got %+v", result)
+	}
+}
+
+func Test_draining(t *testing.T) {
+	checker := &Checker{}
+	checker.Register("db.ok", 0, func(ctx context.Context) error { return nil })
+	readiness(t, checker, http.StatusOK)
+
+	checker.Drain()
+	if report := readiness(t, checker, http.StatusServiceUnavailable); report.Status != StatusDraining {
+		t.Errorf("Expected draining, got %s", report.Status)
+	}
+
+	w := httptest.NewRecorder()
+	checker.Liveness(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected liveness ok while draining, got %d", w.Code)
+	}
+}
+
+func readiness(t *testing.T, checker *Checker, status int) *Report {
+	t.Helper()
+	w := httptest.NewRecorder()
+	checker.Readiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != status {
+		t.Fatalf("Expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	report := &Report{}
+	if err := json.Unmarshal(w.Body.Bytes(), report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
@@ -0,0 +1,51 @@
+package https
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HSTS configures the Strict-Transport-Security header added to responses served over TLS, converted from the
+// `server.hsts` block of config.yaml (see config.HSTS).
+//
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Strict-Transport-Security
+type HSTS struct {
+	MaxAge            int  // Seconds the browser must remember to use HTTPS. 0 disables the header
+	IncludeSubDomains bool // The rule also applies to all subdomains
+	Preload           bool // Allows the domain to be included in the browsers preload list
+}
+
+// Value returns the header value, or an empty string when HSTS is disabled
+func (h HSTS) Value() string {
+	if h.MaxAge <= 0 {
+		return ""
+	}
+
+	value := &strings.Builder{}
+	value.WriteString("max-age=")
+	value.WriteString(strconv.Itoa(h.MaxAge))
+	if h.IncludeSubDomains {
+		value.WriteString("; includeSubDomains")
+	}
+	if h.Preload {
+		value.WriteString("; preload")
+	}
+	return value.String()
+}
+
+// Handler adds the Strict-Transport-Security header to every response of a TLS request.
+//
+// Browsers ignore the header when it is received over plain HTTP, so it is never sent there.
+func (h HSTS) Handler(next http.Handler) http.Handler {
+	value := h.Value()
+	if value == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package https
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_redirect(t *testing.T) {
+	tests := []struct {
+		tlsAddr  string
+		target   string
+		location string
+	}{
+		{"localhost:8080", "http://localhost:8081/user/gopher?tab=about&x=1", "https://localhost:8080/user/gopher?tab=about&x=1"},
+		{"localhost:8080", "http://example.com/", "https://example.com:8080/"},
+		{":443", "http://example.com:80/a%20b", "https://example.com/a%20b"},
+		{"", "http://example.com/", "https://example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rd := &Redirect{TLSAddr: tt.tlsAddr}
+			w := httptest.NewRecorder()
+			rd.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.target, nil))
+
+			if w.Code != http.StatusPermanentRedirect {
+				t.Errorf("status mismatch: Expected %d, got %d", http.StatusPermanentRedirect, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("location mismatch: Expected '%s', got '%s'", tt.location, location)
+			}
+		})
+	}
+}
+
+func Test_redirect_well_known(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "acme-challenge"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "acme-challenge", "token"), []byte("token.key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rd := &Redirect{TLSAddr: "localhost:8080", WellKnown: dir}
+
+	w := httptest.NewRecorder()
+	rd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/.well-known/acme-challenge/token", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "token.key" {
+		t.Errorf("well-known file: Expected 200 'token.key', got %d '%s'", w.Code, w.Body.String())
+	}
+
+	for _, target := range []string{
+		"http://localhost/.well-known/acme-challenge/",
+		"http://localhost/.well-known/acme-challenge/missing",
+		"http://localhost/.well-known/../../etc/passwd",
+	} {
+		w = httptest.NewRecorder()
+		rd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusNotFound && w.Code != http.StatusPermanentRedirect {
+			t.Errorf("'%s': Expected 404 or 308, got %d", target, w.Code)
+		}
+	}
+}
+
+func Test_hsts(t *testing.T) {
+	tests := []struct {
+		hsts  HSTS
+		value string
+	}{
+		{HSTS{}, ""},
+		{HSTS{MaxAge: 300}, "max-age=300"},
+		{HSTS{MaxAge: 63072000, IncludeSubDomains: true, Preload: true}, "max-age=63072000; includeSubDomains; preload"},
+	}
+	for _, tt := range tests {
+		handler := tt.hsts.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		r := httptest.NewRequest(http.MethodGet, "https://localhost/", nil)
+		r.TLS = &tls.ConnectionState{}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if value := w.Header().Get("Strict-Transport-Security"); value != tt.value {
+			t.Errorf("header mismatch: Expected '%s', got '%s'", tt.value, value)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+		if value := w.Header().Get("Strict-Transport-Security"); value != "" {
+			t.Errorf("header on plain HTTP: Expected none, got '%s'", value)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package https contains the parts needed to serve the application exclusively over TLS: a handler for a plain HTTP
+// listener that redirects every request to the TLS address and a middleware that adds the HSTS header.
+package https
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// WellKnownPrefix is the path prefix served by Redirect from the local directory instead of being redirected. Used by
+// ACME clients (Let's Encrypt) for the http-01 challenge.
+const WellKnownPrefix = "/.well-known/"
+
+// Redirect is the http.Handler of the plain HTTP listener.
+//
+// Every request receives a 308 (Permanent Redirect) to the same path and query on the TLS address, except
+// `/.well-known/` paths, which are served from the WellKnown directory when it is set.
+type Redirect struct {
+	TLSAddr   string // Address of the TLS listener (Ex. "localhost:8080", ":443")
+	WellKnown string // Directory served under /.well-known/. Empty disables it
+}
+
+func (rd *Redirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rd.WellKnown != "" && strings.HasPrefix(r.URL.Path, WellKnownPrefix) {
+		rd.serveWellKnown(w, r)
+		return
+	}
+
+	target := "https://" + rd.targetHost(r.Host) + r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	w.Header().Set("Connection", "close")
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
+
+// targetHost keeps the hostname used by the client and replaces the port with the one from the TLS listener
+func (rd *Redirect) targetHost(requestHost string) string {
+	tlsHost, tlsPort, err := net.SplitHostPort(rd.TLSAddr)
+	if err != nil {
+		tlsHost, tlsPort = rd.TLSAddr, ""
+	}
+
+	host := requestHost
+	if h, _, errSplit := net.SplitHostPort(requestHost); errSplit == nil {
+		host = h
+	}
+	if host == "" {
+		host = tlsHost
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	if tlsPort == "" || tlsPort == "443" {
+		if strings.Contains(host, ":") {
+			// IPv6 literal
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, tlsPort)
+}
+
+// serveWellKnown serves regular files from WellKnown, directory listing is never exposed
+func (rd *Redirect) serveWellKnown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, WellKnownPrefix))
+	file, err := http.Dir(rd.WellKnown).Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !strings.Contains(stat.Name(), ".") {
+		// ACME tokens have no extension
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
+}
@@ -0,0 +1,350 @@
+// Package limits bounds the time and the request body of the handlers, by the `limits` block of config.yaml:
+//
+//	limits:
+//	  timeout: 30s
+//	  max-body-bytes: 10485760
+//	  routes:
+//	    - routes: ["POST /upload/*"]
+//	      timeout: 5m
+//	      max-body-bytes: 104857600
+//	    - routes: ["GET /live"]
+//	      timeout: 0
+//
+// When the deadline expires the context of the request is cancelled and, if the handler did not start the response
+// yet, the client receives TimeoutStatus (503 or 504) with TimeoutBody. Handlers that already started the response
+// (Ex. streams) only see the cancelled context.
+//
+// Bodies larger than MaxBodyBytes are rejected with 413 before the handler runs when Content-Length is informed,
+// otherwise reading beyond the limit fails and the response of the handler is replaced by 413.
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/route"
+)
+
+// ErrBodyTooLarge returned when reading beyond the limit of the request body
+var ErrBodyTooLarge = errors.New("http: request body too large")
+
+var timeouts = metrics.NewCounter("http_request_timeouts_total", "Requests that exceeded the deadline")
+
+// Route overrides the defaults for the requests that match one of the Routes. Nil fields keep the default, zero
+// disables.
+type Route struct {
+	Routes       []*route.Rule
+	Timeout      *time.Duration
+	MaxBodyBytes *int64
+}
+
+// Limits the defaults and the overrides by route, the first route that informs the value is used
+type Limits struct {
+	Timeout       time.Duration // 0 disables
+	TimeoutStatus int           // http.StatusServiceUnavailable or http.StatusGatewayTimeout
+	TimeoutBody   string        // Empty uses the text of the status, rendered by the error pages
+	MaxBodyBytes  int64         // 0 disables
+	Routes        []*Route
+}
+
+// New creates the limits of the configuration, nil if there are no limits
+func New(cfg config.Limits) (*Limits, error) {
+	l := &Limits{
+		Timeout:       cfg.Timeout.Std(),
+		TimeoutStatus: cfg.TimeoutStatus,
+		TimeoutBody:   cfg.TimeoutBody,
+		MaxBodyBytes:  cfg.MaxBodyBytes,
+	}
+	enabled := l.Timeout > 0 || l.MaxBodyBytes > 0
+	for i, r := range cfg.Routes {
+		override := &Route{MaxBodyBytes: r.MaxBodyBytes}
+		if r.Timeout != nil {
+			timeout := r.Timeout.Std()
+			override.Timeout = &timeout
+			enabled = enabled || timeout > 0
+		}
+		if r.MaxBodyBytes != nil {
+			enabled = enabled || *r.MaxBodyBytes > 0
+		}
+		for _, value := range r.Routes {
+			parsed, err := route.ParseRule(value)
+			if err != nil {
+				return nil, fmt.Errorf("limits: routes[%d]: %w", i, err)
+			}
+			override.Routes = append(override.Routes, parsed)
+		}
+		l.Routes = append(l.Routes, override)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// Lookup returns the timeout and the max body size of the request
+func (l *Limits) Lookup(r *http.Request) (time.Duration, int64) {
+	timeout, maxBodyBytes := l.Timeout, l.MaxBodyBytes
+	var timeoutSet, maxBodySet bool
+	for _, override := range l.Routes {
+		if (timeoutSet || override.Timeout == nil) && (maxBodySet || override.MaxBodyBytes == nil) {
+			continue
+		}
+		if !matches(override.Routes, r) {
+			continue
+		}
+		if !timeoutSet && override.Timeout != nil {
+			timeout, timeoutSet = *override.Timeout, true
+		}
+		if !maxBodySet && override.MaxBodyBytes != nil {
+			maxBodyBytes, maxBodySet = *override.MaxBodyBytes, true
+		}
+	}
+	return timeout, maxBodyBytes
+}
+
+func matches(rules []*route.Rule, r *http.Request) bool {
+	for _, rule := range rules {
+		if _, matched := rule.Match(r); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler applies the limits of the route to the requests
+func (l *Limits) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, maxBodyBytes := l.Lookup(r)
+
+		handler := next
+		if maxBodyBytes > 0 && r.Body != nil && r.Body != http.NoBody {
+			if r.ContentLength > maxBodyBytes {
+				tooLarge(w)
+				return
+			}
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := &limitedBody{ReadCloser: r.Body, remaining: maxBodyBytes}
+				r.Body = body
+				next.ServeHTTP(&bodyWriter{ResponseWriter: w, body: body}, r)
+			})
+		}
+
+		if timeout <= 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		l.serveWithTimeout(w, r, handler, timeout)
+	})
+}
+
+// serveWithTimeout runs the handler in another goroutine, responding when the deadline expires even if the handler
+// does not check the context (Ex. a slow query without context)
+func (l *Limits) serveWithTimeout(w http.ResponseWriter, r *http.Request, next http.Handler, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	tw := &timeoutWriter{w: w, header: http.Header{}}
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					p = fmt.Sprintf("%v\n\n%s", p, debug.Stack())
+				}
+				panicked <- p
+				return
+			}
+			close(done)
+		}()
+		next.ServeHTTP(tw, r)
+	}()
+
+	wait := func() {
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+		}
+	}
+
+	select {
+	case p := <-panicked:
+		panic(p)
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	tw.mutex.Lock()
+	if tw.wroteHeader || ctx.Err() != context.DeadlineExceeded {
+		// the response already started or the client is gone, the handler sees the cancelled context
+		tw.mutex.Unlock()
+		wait()
+		return
+	}
+	tw.timedOut = true
+	tw.mutex.Unlock()
+
+	timeouts.Inc()
+	status := l.TimeoutStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if l.TimeoutBody == "" {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType([]byte(l.TimeoutBody)))
+	w.WriteHeader(status)
+	io.WriteString(w, l.TimeoutBody)
+}
+
+// timeoutWriter forwards the response of the handler until the deadline expires, the writes after that fail with
+// http.ErrHandlerTimeout. The handler has its own header map, merged into the one of the response when it starts:
+// the headers set meanwhile by the outer middlewares are kept (Ex. the rotated remember-me cookie) and, after the
+// timeout, the handler never touches the maps of the response.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	mutex       sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	tw.writeHeader(status)
+}
+
+func (tw *timeoutWriter) writeHeader(status int) {
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	dst := tw.w.Header()
+	for key, values := range tw.header {
+		// copied, the handler may keep changing its map
+		values = append([]string(nil), values...)
+		if key == "Set-Cookie" || key == "Vary" {
+			dst[key] = append(dst[key], values...)
+		} else {
+			dst[key] = values
+		}
+	}
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(data)
+}
+
+// Flush keeps streaming responses (Server-Sent Events) working
+func (tw *timeoutWriter) Flush() {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeader(http.StatusOK)
+	if flusher, ok := tw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// limitedBody fails with ErrBodyTooLarge when reading beyond the limit
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	// reads one byte more than the limit to detect larger bodies
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		b.exceeded = true
+		return int(b.remaining), ErrBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// bodyWriter replaces the response by 413 when the handler read beyond the limit of the body
+type bodyWriter struct {
+	http.ResponseWriter
+	body        *limitedBody
+	wroteHeader bool
+	discard     bool
+}
+
+func (w *bodyWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.body.exceeded {
+		w.discard = true
+		tooLarge(w.ResponseWriter)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush keeps streaming responses (Server-Sent Events) working
+func (w *bodyWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !w.discard {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the original writer, used by http.ResponseController
+func (w *bodyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func tooLarge(w http.ResponseWriter) {
+	// the rest of the body is not read, the connection can not be reused
+	w.Header().Set("Connection", "close")
+	w.Header().Del("Content-Length")
+	http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,192 @@
+package limits
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+)
+
+func duration(d time.Duration) *config.Duration {
+	value := config.Duration(d)
+	return &value
+}
+
+func size(n int64) *int64 {
+	return &n
+}
+
+func Test_lookup(t *testing.T) {
+	l, err := New(config.Limits{
+		Timeout:      config.Duration(30 * time.Second),
+		MaxBodyBytes: 100,
+		Routes: []*config.LimitsRoute{
+			{Routes: []string{"GET /live"}, Timeout: duration(0)},
+			{Routes: []string{"/upload/*"}, MaxBodyBytes: size(1000)},
+			{Routes: []string{"POST /upload/big"}, Timeout: duration(time.Minute), MaxBodyBytes: size(5000)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method  string
+		path    string
+		timeout time.Duration
+		maxBody int64
+	}{
+		{http.MethodGet, "/", 30 * time.Second, 100},
+		{http.MethodGet, "/live", 0, 100},
+		{http.MethodPost, "/live", 30 * time.Second, 100},
+		{http.MethodPost, "/upload/image", 30 * time.Second, 1000},
+		{http.MethodPost, "/upload/big", time.Minute, 1000}, // first route that informs the value
+	}
+	for _, tt := range tests {
+		timeout, maxBody := l.Lookup(httptest.NewRequest(tt.method, tt.path, nil))
+		if timeout != tt.timeout || maxBody != tt.maxBody {
+			t.Errorf("%s %s: got %v %d", tt.method, tt.path, timeout, maxBody)
+		}
+	}
+
+	if l, _ = New(config.Limits{}); l != nil {
+		t.Errorf("expected nil without limits")
+	}
+}
+
+func Test_timeout(t *testing.T) {
+	l := &Limits{Timeout: 20 * time.Millisecond, TimeoutStatus: http.StatusGatewayTimeout}
+	release := make(chan struct{})
+	cancelled := make(chan error, 1)
+	handler := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cancelled <- r.Context().Err()
+		<-release // ignores the context for a while
+		w.Header().Set("X-Late", "1")
+		if _, err := w.Write([]byte("late")); err != http.ErrHandlerTimeout {
+			t.Errorf("expected ErrHandlerTimeout, got %v", err)
+		}
+		close(cancelled)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), "Gateway Timeout") {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+	if err := <-cancelled; err == nil {
+		t.Errorf("expected the context to be cancelled")
+	}
+	close(release)
+	<-cancelled
+	if w.Header().Get("X-Late") != "" || strings.Contains(w.Body.String(), "late") {
+		t.Errorf("the handler must not write after the timeout")
+	}
+
+	// custom body
+	l.TimeoutBody = "<h1>Try again</h1>"
+	w = httptest.NewRecorder()
+	l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusGatewayTimeout || w.Body.String() != l.TimeoutBody || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+}
+
+func Test_timeout_started_response(t *testing.T) {
+	l := &Limits{Timeout: 20 * time.Millisecond}
+	w := httptest.NewRecorder()
+	l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		w.Write([]byte("data: 2\n\n"))
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/live", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "data: 1\n\ndata: 2\n\n" || !w.Flushed {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("got %v", w.Header())
+	}
+}
+
+// the headers of the outer middlewares are kept, the ones of the handler are added
+func Test_timeout_headers(t *testing.T) {
+	l := &Limits{Timeout: time.Second}
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Outer", "1")
+	l.Handler(http.HandlerFunc(func(inner http.ResponseWriter, r *http.Request) {
+		// set on the response while the handler runs (Ex. the rotated remember-me cookie)
+		w.Header().Add("Set-Cookie", "remember=2")
+		inner.Header().Add("Set-Cookie", "session=1")
+		inner.Header().Set("Content-Type", "text/plain")
+		inner.Write([]byte("ok"))
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cookies := w.Header().Values("Set-Cookie")
+	if len(cookies) != 2 || w.Header().Get("X-Outer") != "1" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("got %v", w.Header())
+	}
+}
+
+func Test_timeout_panic(t *testing.T) {
+	l := &Limits{Timeout: time.Second}
+	defer func() {
+		if p := recover(); p == nil || !strings.Contains(p.(string), "boom") {
+			t.Errorf("expected the panic of the handler, got %v", p)
+		}
+	}()
+	l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func Test_max_body(t *testing.T) {
+	l := &Limits{MaxBodyBytes: 10}
+	var called bool
+	var readErr error
+	handler := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		var data []byte
+		data, readErr = io.ReadAll(r.Body)
+		w.Header().Set("Content-Length", "2")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data[:2])
+	}))
+
+	// Content-Length informed, rejected before the handler
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("01234567890")))
+	if w.Code != http.StatusRequestEntityTooLarge || called {
+		t.Errorf("got %d, called %v", w.Code, called)
+	}
+
+	// unknown size (chunked), the response of the handler is replaced
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("01234"), strings.NewReader("567890")))
+	r.ContentLength = -1
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || !called || !errors.Is(readErr, ErrBodyTooLarge) {
+		t.Errorf("got %d, called %v, err %v", w.Code, called, readErr)
+	}
+	if w.Header().Get("Content-Length") != "" || !strings.Contains(w.Body.String(), "Request Entity Too Large") {
+		t.Errorf("got %v %q", w.Header(), w.Body.String())
+	}
+
+	// within the limit
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("01234"), strings.NewReader("56789")))
+	r.ContentLength = -1
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || readErr != nil || w.Body.String() != "01" {
+		t.Errorf("got %d %q, err %v", w.Code, w.Body.String(), readErr)
+	}
+}
@@ -0,0 +1,158 @@
+// Package listen creates the listeners of the server from the addresses of the configuration:
+//
+//	localhost:8080, tcp:localhost:8080  TCP
+//	unix:/run/demo/demo.sock            Unix domain socket, created with the permissions of Options
+//	systemd:https                       socket inherited from systemd (LISTEN_FDS), matched by the FileDescriptorName=
+//	                                    of the .socket unit or by its index (Ex. systemd:0)
+//
+// The listeners can be passed to a new copy of the binary, see Upgrader.
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const (
+	TCP     = "tcp"
+	Unix    = "unix"
+	Systemd = "systemd"
+)
+
+// Addr a parsed listener address
+type Addr struct {
+	Network string // TCP, Unix or Systemd
+	Address string // host:port, socket path or systemd name
+}
+
+func (a Addr) String() string {
+	return a.Network + ":" + a.Address
+}
+
+// Parse validates the address. Addresses without a prefix are TCP.
+func Parse(addr string) (Addr, error) {
+	network, address := TCP, addr
+	if i := strings.IndexByte(addr, ':'); i > 0 {
+		switch prefix := addr[:i]; prefix {
+		case TCP, Unix, Systemd:
+			network, address = prefix, addr[i+1:]
+		}
+	}
+
+	switch network {
+	case TCP:
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return Addr{}, fmt.Errorf("invalid address %q, expected host:port, unix:/path or systemd:name", addr)
+		}
+	case Unix:
+		if address == "" {
+			return Addr{}, fmt.Errorf("invalid address %q, the socket path is empty", addr)
+		}
+	case Systemd:
+		if address == "" {
+			return Addr{}, fmt.Errorf("invalid address %q, the systemd socket name is empty", addr)
+		}
+	}
+	return Addr{Network: network, Address: address}, nil
+}
+
+// TCPAddr returns host:port of TCP addresses, empty for the others
+func TCPAddr(addr string) string {
+	parsed, err := Parse(addr)
+	if err != nil || parsed.Network != TCP {
+		return ""
+	}
+	return parsed.Address
+}
+
+// Options of the Unix domain sockets
+type Options struct {
+	Mode  os.FileMode // Permissions of the socket file. Defaults to 0660
+	Group string      // Group owner of the socket file (Ex. www-data, to be used by nginx)
+}
+
+// Listen creates the listener of the address. After an upgrade, returns the listener of the same address passed by
+// the previous process.
+func Listen(addr string, opts Options) (net.Listener, error) {
+	parsed, err := Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if ln := handedOffListener(addr); ln != nil {
+		return ln, nil
+	}
+
+	switch parsed.Network {
+	case Unix:
+		return listenUnix(parsed.Address, opts)
+	case Systemd:
+		ln := inherited(parsed.Address)
+		if ln == nil {
+			return nil, fmt.Errorf("%s: socket not inherited from systemd (LISTEN_FDS), check the .socket unit", addr)
+		}
+		return ln, nil
+	}
+	return net.Listen(TCP, parsed.Address)
+}
+
+func listenUnix(path string, opts Options) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("unix:%s: file exists and is not a socket", path)
+		}
+		// stale socket of a previous process, only removed if nobody is listening on it
+		if conn, errDial := net.Dial(Unix, path); errDial == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix:%s: address already in use", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen(Unix, path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := opts.Mode
+	if mode == 0 {
+		mode = 0660
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	if opts.Group != "" {
+		group, errGroup := user.LookupGroup(opts.Group)
+		if errGroup != nil {
+			ln.Close()
+			return nil, errGroup
+		}
+		gid, _ := strconv.Atoi(group.Gid)
+		if err = os.Chown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// ParseMode parses the octal permissions of the socket (Ex. "0660")
+func ParseMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, errors.New("expected octal permissions (Ex. 0660)")
+	}
+	return os.FileMode(value), nil
+}
@@ -0,0 +1,91 @@
+package listen
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_parse(t *testing.T) {
+	tests := map[string]Addr{
+		"localhost:8080":      {TCP, "localhost:8080"},
+		":443":                {TCP, ":443"},
+		"tcp:127.0.0.1:80":    {TCP, "127.0.0.1:80"},
+		"unix:/run/demo.sock": {Unix, "/run/demo.sock"},
+		"systemd:https":       {Systemd, "https"},
+	}
+	for addr, expected := range tests {
+		parsed, err := Parse(addr)
+		if err != nil || parsed != expected {
+			t.Errorf("%s: Expected %+v, got %+v, %v", addr, expected, parsed, err)
+		}
+	}
+	for _, addr := range []string{"localhost", "unix:", "systemd:", "tcp:8080"} {
+		if _, err := Parse(addr); err == nil {
+			t.Errorf("%s: Expected error", addr)
+		}
+	}
+	if TCPAddr("unix:/run/demo.sock") != "" || TCPAddr("tcp::443") != ":443" {
+		t.Error("TCPAddr: unexpected result")
+	}
+}
+
+func Test_unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "demo.sock")
+	ln, err := Listen("unix:"+path, Options{Mode: 0600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %s", info.Mode().Perm())
+	}
+
+	if _, err = Listen("unix:"+path, Options{}); err == nil {
+		t.Error("Expected error, socket in use")
+	}
+	ln.Close()
+
+	// stale socket file left by a killed process
+	stale, err := net.Listen(Unix, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if ln, err = Listen("unix:"+path, Options{}); err != nil {
+		t.Fatalf("Expected the stale socket to be replaced, got %v", err)
+	}
+	ln.Close()
+}
+
+func Test_systemd_inherit(t *testing.T) {
+	tcp, err := net.Listen(TCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	file, err := tcp.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	if result := inherit("1", "1", "https", int(file.Fd())); result != nil {
+		t.Error("Expected no listeners for another pid")
+	}
+
+	result := inherit(pid, "1", "https", int(file.Fd()))
+	if len(result) != 1 || result[0].name != "https" || result[0].ln == nil {
+		t.Fatalf("got %+v", result)
+	}
+	defer result[0].ln.Close()
+	if result[0].ln.Addr().String() != tcp.Addr().String() {
+		t.Errorf("Expected %s, got %s", tcp.Addr(), result[0].ln.Addr())
+	}
+}
@@ -0,0 +1,115 @@
+package listen
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFdsStart first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+type namedListener struct {
+	name string
+	ln   net.Listener
+	used bool
+}
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	listeners   []*namedListener // inherited from systemd
+	handedOff   []*namedListener // inherited from the previous process, see Upgrader
+)
+
+func inheritAll() {
+	inheritOnce.Do(func() {
+		listeners = inherit(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), listenFdsStart)
+		handedOff = inheritSeparated(strconv.Itoa(os.Getpid()), os.Getenv(envUpgradeFds), os.Getenv(envUpgradeNames), listenFdsStart, "\n")
+		// not passed to child processes
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", envUpgradeFds, envUpgradeNames} {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+// handedOffListener returns the listener of the address passed by the previous process, nil if there is none
+func handedOffListener(addr string) net.Listener {
+	inheritAll()
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	for _, l := range handedOff {
+		if !l.used && l.name == addr {
+			l.used = true
+			// this process now owns the socket file, the sockets of systemd are kept
+			if unix, isUnix := l.ln.(*net.UnixListener); isUnix {
+				unix.SetUnlinkOnClose(true)
+			}
+			return l.ln
+		}
+	}
+	return nil
+}
+
+// inherited returns the listener passed by systemd with the name or index, nil if there is none. Each listener is
+// returned only once.
+func inherited(name string) net.Listener {
+	inheritAll()
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	for _, l := range listeners {
+		if !l.used && l.name == name {
+			l.used = true
+			return l.ln
+		}
+	}
+	if index, err := strconv.Atoi(name); err == nil && index >= 0 && index < len(listeners) && !listeners[index].used {
+		listeners[index].used = true
+		return listeners[index].ln
+	}
+	return nil
+}
+
+// inherit implements the sd_listen_fds protocol: LISTEN_PID must be the current process, LISTEN_FDS the number of
+// sockets starting at the first descriptor and LISTEN_FDNAMES their names, separated by ":"
+func inherit(pid string, fds string, names string, first int) []*namedListener {
+	return inheritSeparated(pid, fds, names, first, ":")
+}
+
+func inheritSeparated(pid string, fds string, names string, first int, separator string) []*namedListener {
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count <= 0 {
+		return nil
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, separator)
+	}
+
+	var result []*namedListener
+	for i := 0; i < count; i++ {
+		name := strconv.Itoa(i)
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+		file := os.NewFile(uintptr(first+i), name)
+		ln, errFile := net.FileListener(file)
+		// FileListener duplicates the descriptor
+		file.Close()
+		if errFile != nil {
+			// not a listening socket (Ex. a datagram socket), keeps the index
+			result = append(result, &namedListener{name: name, used: true})
+			continue
+		}
+		result = append(result, &namedListener{name: name, ln: ln})
+	}
+	return result
+}
@@ -0,0 +1,175 @@
+package listen
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables of the handoff to the new process. They do not use the DEMO_ prefix, which is reserved for
+// configuration overrides.
+const (
+	envUpgradeFds   = "UPGRADE_LISTEN_FDS"     // number of listeners, starting at descriptor 3
+	envUpgradeNames = "UPGRADE_LISTEN_FDNAMES" // addresses of the listeners, separated by "\n"
+	envReadyFd      = "UPGRADE_READY_FD"       // pipe used by the new process to report it is serving
+)
+
+// DefaultUpgradeTimeout time the new process has to report it is ready
+const DefaultUpgradeTimeout = 30 * time.Second
+
+// Upgrader starts a new copy of the binary passing the listening sockets, so the new version accepts the connections
+// on the same addresses without closing them (zero-downtime upgrade).
+//
+// The new process gets the listeners by calling Listen with the same addresses and reports it is serving by calling
+// Ready. Only then Upgrade returns, and the current process can drain and exit.
+type Upgrader struct {
+	Path    string        // Binary. Defaults to os.Executable()
+	Args    []string      // Arguments. Defaults to os.Args[1:]
+	Timeout time.Duration // Defaults to DefaultUpgradeTimeout
+	Stdout  io.Writer     // Output of the new process. Defaults to os.Stdout
+	Stderr  io.Writer     // Defaults to os.Stderr
+}
+
+// Upgrade starts the new process with the listeners, keyed by the address used to create them. On error the new
+// process is killed and the current one keeps serving.
+func (u *Upgrader) Upgrade(listeners map[string]net.Listener) (*os.Process, error) {
+	path := u.Path
+	if path == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		path = executable
+	}
+	args := u.Args
+	if args == nil {
+		args = os.Args[1:]
+	}
+	timeout := u.Timeout
+	if timeout <= 0 {
+		timeout = DefaultUpgradeTimeout
+	}
+
+	var addrs []string
+	for addr := range listeners {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, addr := range addrs {
+		ln, ok := listeners[addr].(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("%s: the listener can not be passed to another process", addr)
+		}
+		file, err := ln.File()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+		files = append(files, file)
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, u.Stdout, u.Stderr
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	cmd.ExtraFiles = append(files, readyWriter)
+	cmd.Env = append(upgradeEnviron(),
+		envUpgradeFds+"="+strconv.Itoa(len(files)),
+		envUpgradeNames+"="+strings.Join(addrs, "\n"),
+		envReadyFd+"="+strconv.Itoa(listenFdsStart+len(files)),
+	)
+	err = cmd.Start()
+	// only the new process writes on the pipe, reading gets EOF if it exits before reporting
+	readyWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		line, errRead := bufio.NewReader(readyReader).ReadString('\n')
+		if errRead != nil || line != "ready\n" {
+			ready <- errors.New("the new process exited before being ready")
+			return
+		}
+		ready <- nil
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("the new process was not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return nil, err
+	}
+
+	// the socket file now belongs to the new process
+	for _, ln := range listeners {
+		if unix, isUnix := ln.(*net.UnixListener); isUnix {
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+	// released when the new process exits, the current process does not wait for it
+	go cmd.Wait()
+	return cmd.Process, nil
+}
+
+// Ready reports to the previous process that this process is serving. Does nothing if the process was not started by
+// an Upgrader.
+func Ready() error {
+	value := os.Getenv(envReadyFd)
+	if value == "" {
+		return nil
+	}
+	os.Unsetenv(envReadyFd)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid descriptor %q", envReadyFd, value)
+	}
+	pipe := os.NewFile(uintptr(fd), "upgrade-ready")
+	defer pipe.Close()
+	_, err = pipe.WriteString("ready\n")
+	return err
+}
+
+// upgradeEnviron the environment of the current process without the variables of previous handoffs
+func upgradeEnviron() []string {
+	var env []string
+	for _, item := range os.Environ() {
+		name := strings.SplitN(item, "=", 2)[0]
+		switch name {
+		case envUpgradeFds, envUpgradeNames, envReadyFd, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES":
+			continue
+		}
+		env = append(env, item)
+	}
+	return env
+}
@@ -0,0 +1,90 @@
+package listen
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const envTestChild = "LISTEN_TEST_UPGRADE_CHILD"
+
+// Test_upgrade starts a second process (this test binary running Test_upgrade_child) with the listener
+func Test_upgrade(t *testing.T) {
+	t.Setenv(envTestChild, "1")
+
+	addr := "tcp:127.0.0.1:0"
+	ln, err := Listen(addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+
+	parent := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "parent")
+	})}
+	go parent.Serve(ln)
+	if body := get(t, url); body != "parent" {
+		t.Fatalf("Expected parent, got %s", body)
+	}
+
+	upgrader := &Upgrader{Path: os.Args[0], Args: []string{"-test.run=^Test_upgrade_child$"}, Timeout: 10 * time.Second, Stdout: io.Discard, Stderr: io.Discard}
+	child, err := upgrader.Upgrade(map[string]net.Listener{addr: ln})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Kill()
+
+	// the parent drains, the socket stays open in the child
+	parent.Close()
+
+	if body := get(t, url); body != "child "+strconv.Itoa(child.Pid) {
+		t.Errorf("Expected the new process to answer, got %s", body)
+	}
+}
+
+func Test_upgrade_child(t *testing.T) {
+	if os.Getenv(envTestChild) != "1" {
+		t.Skip("started by Test_upgrade")
+	}
+	ln, err := Listen("tcp:127.0.0.1:0", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Ready(); err != nil {
+		t.Fatal(err)
+	}
+	http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "child %d", os.Getpid())
+	}))
+}
+
+func Test_upgrade_not_ready(t *testing.T) {
+	ln, err := Listen("tcp:127.0.0.1:0", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// runs no test and exits without calling Ready
+	upgrader := &Upgrader{Path: os.Args[0], Args: []string{"-test.run=^$"}, Timeout: 10 * time.Second, Stdout: io.Discard, Stderr: io.Discard}
+	if _, err = upgrader.Upgrade(map[string]net.Listener{"tcp:127.0.0.1:0": ln}); err == nil {
+		t.Error("Expected error, the new process exited before being ready")
+	}
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return string(body)
+}
@@ -0,0 +1,39 @@
+(function () {
+  // live reload client, injected by the server in development mode
+  const script = document.currentScript;
+  const endpoint = script.dataset.endpoint;
+  const interval = Number.parseInt(script.dataset.interval) || 0;
+  const reloadPageOnCss = script.dataset.reloadPageOnCss === 'true';
+
+  function reloadPage() {
+    window.location.reload();
+  }
+
+  function reloadStylesheets() {
+    const selectors = 'link[rel=stylesheet]:not([data-no-reload]):not([data-pending-removal])';
+    document.querySelectorAll(selectors).forEach(function (link) {
+      const url = link.href.replace(/([&?])vsn=\d*/, '');
+      const fresh = link.cloneNode();
+      fresh.href = url + (url.indexOf('?') >= 0 ? '&' : '?') + 'vsn=' + Date.now();
+      fresh.onload = fresh.onerror = function () {
+        if (link.parentNode) {
+          link.parentNode.removeChild(link);
+        }
+      };
+      link.setAttribute('data-pending-removal', '');
+      link.parentNode.insertBefore(fresh, link.nextSibling);
+    });
+  }
+
+  const source = new EventSource(endpoint);
+  source.onmessage = function (message) {
+    const event = JSON.parse(message.data);
+    setTimeout(function () {
+      if (event.type === 'css' && !reloadPageOnCss) {
+        reloadStylesheets();
+      } else {
+        reloadPage();
+      }
+    }, interval);
+  };
+})();
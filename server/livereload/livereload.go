@@ -0,0 +1,149 @@
+// Package livereload implements the development live reload: a Watcher detects changes on the application files, the
+// application re-initialises the affected parts and the Hub pushes an Event through Server-Sent Events to the client
+// script injected in every page, which reloads the page or hot-swaps the stylesheets.
+package livereload
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/shtml/sht"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+//go:embed client.js
+var clientScript string
+
+const (
+	EventPage = "page" // The client reloads the page
+	EventCss  = "css"  // The client reloads the stylesheets, or the page when `reload-page-on-css` is true
+)
+
+// Event sent to the browsers
+type Event struct {
+	Type  string   `json:"type"`
+	Files []string `json:"files"`
+}
+
+// keepAlive interval of the comments sent to keep idle connections open
+const keepAlive = 30 * time.Second
+
+// Hub keeps the connected browsers and broadcasts events to them
+type Hub struct {
+	mutex   sync.Mutex
+	clients map[chan *Event]bool
+}
+
+// Broadcast sends the event to all connected browsers
+func (h *Hub) Broadcast(event *Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for client := range h.clients {
+		select {
+		case client <- event:
+		default:
+			// slow client, it will receive the next event
+		}
+	}
+}
+
+// ServeHTTP keeps the SSE connection open, writing the events
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Connection does not support streaming", http.StatusBadRequest)
+		return
+	}
+
+	client := make(chan *Event, 1)
+	h.mutex.Lock()
+	if h.clients == nil {
+		h.clients = map[chan *Event]bool{}
+	}
+	h.clients[client] = true
+	h.mutex.Unlock()
+
+	defer func() {
+		h.mutex.Lock()
+		delete(h.clients, client)
+		h.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-client:
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Handler delegates the requests to the current site, which is replaced every time the application is
+// re-initialised. The Hub is served by the application router on the `endpoint` of the configuration.
+type Handler struct {
+	site atomic.Value
+}
+
+// Swap replaces the site handler, requests in progress finish on the previous one
+func (h *Handler) Swap(site http.Handler) {
+	h.site.Store(&site)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	site, _ := h.site.Load().(*http.Handler)
+	if site == nil {
+		http.Error(w, "503 service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	(*site).ServeHTTP(w, r)
+}
+
+// Register adds the client script to all pages of the site. Must be called before `app.Init()`.
+func Register(app *syntax.Syntax, cfg config.LiveReload) {
+	asset := app.Template.(*sht.TemplateSystem).RegisterAssetJsContent(clientScript)
+	// the bundler writes the attributes without a separator
+	asset.Attributes = map[string]string{
+		" data-endpoint":           cfg.Endpoint,
+		" data-interval":           strconv.Itoa(cfg.Interval),
+		" data-reload-page-on-css": strconv.FormatBool(cfg.ReloadPageOnCss),
+	}
+	app.Bundler.AddRequiredAsset(asset)
+}
+
+// Classify returns the event to be sent to the browsers for the changed files
+func Classify(files []string) *Event {
+	event := &Event{Type: EventCss, Files: files}
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".css") {
+			event.Type = EventPage
+			break
+		}
+	}
+	return event
+}
@@ -0,0 +1,110 @@
+package livereload
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_watcher(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("index.html", "<p>1</p>")
+	write("ignored.txt", "1")
+
+	watcher := &Watcher{
+		Paths:    []string{dir},
+		Patterns: []*regexp.Regexp{regexp.MustCompile(`\.(html|css)$`)},
+		Debounce: 50 * time.Millisecond,
+		Poll:     10 * time.Millisecond,
+	}
+
+	changes := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx, func(files []string) {
+		changes <- files
+	})
+
+	time.Sleep(30 * time.Millisecond)
+	write("index.html", "<p>12</p>")
+	write("ignored.txt", "12")
+	time.Sleep(20 * time.Millisecond)
+	write("style.css", "p {}")
+
+	select {
+	case files := <-changes:
+		expected := []string{
+			filepath.ToSlash(filepath.Join(dir, "index.html")),
+			filepath.ToSlash(filepath.Join(dir, "style.css")),
+		}
+		if !reflect.DeepEqual(files, expected) {
+			t.Errorf("Expected a single debounced change %v, got %v", expected, files)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change reported")
+	}
+
+	select {
+	case files := <-changes:
+		t.Errorf("unexpected change %v", files)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_classify(t *testing.T) {
+	if event := Classify([]string{"web/assets/css/a.css", "web/assets/css/b.css"}); event.Type != EventCss {
+		t.Errorf("Expected css event, got %s", event.Type)
+	}
+	if event := Classify([]string{"web/assets/css/a.css", "web/index.html"}); event.Type != EventPage {
+		t.Errorf("Expected page event, got %s", event.Type)
+	}
+}
+
+func Test_handler(t *testing.T) {
+	hub := &Hub{}
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/dev.livereload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %s", contentType)
+	}
+
+	hub.Broadcast(&Event{Type: EventCss, Files: []string{"web/a.css"}})
+
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `data: {"type":"css","files":["web/a.css"]}`; strings.TrimSpace(line) != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, line)
+	}
+
+	handler := &Handler{}
+	handler.Swap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("site"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.String() != "site" {
+		t.Errorf("Expected request delegated to the site, got '%s'", w.Body.String())
+	}
+}
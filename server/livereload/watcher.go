@@ -0,0 +1,117 @@
+package livereload
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// DefaultPoll interval between two scans of the watched files
+const DefaultPoll = 250 * time.Millisecond
+
+// Watcher detects changes (create, update, delete) on files by polling their modification time and size.
+//
+// Polling avoids platform specific APIs and works the same way in containers and network file systems, the cost is
+// acceptable because it only runs in development mode.
+type Watcher struct {
+	Paths    []string         // Directories (recursive) and files to watch
+	Patterns []*regexp.Regexp // Only files matching one of the patterns are reported. Empty matches all files
+	Debounce time.Duration    // Time without new changes before reporting them
+	Poll     time.Duration    // Defaults to DefaultPoll
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Run watches the files until the context is done, calling onChange with the paths changed since the last call.
+func (w *Watcher) Run(ctx context.Context, onChange func(files []string)) {
+	poll := w.Poll
+	if poll <= 0 {
+		poll = DefaultPoll
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	previous := w.scan()
+	pending := map[string]bool{}
+	var lastChange time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current := w.scan()
+			for file, state := range current {
+				if old, exists := previous[file]; !exists || old != state {
+					pending[file] = true
+					lastChange = now
+				}
+			}
+			for file := range previous {
+				if _, exists := current[file]; !exists {
+					pending[file] = true
+					lastChange = now
+				}
+			}
+			previous = current
+
+			if len(pending) > 0 && now.Sub(lastChange) >= w.Debounce {
+				files := make([]string, 0, len(pending))
+				for file := range pending {
+					files = append(files, file)
+				}
+				sort.Strings(files)
+				pending = map[string]bool{}
+				onChange(files)
+			}
+		}
+	}
+}
+
+// scan the current state of the watched files
+func (w *Watcher) scan() map[string]fileState {
+	files := map[string]fileState{}
+	for _, root := range w.Paths {
+		stat, err := os.Stat(root)
+		if err != nil {
+			continue
+		}
+		if !stat.IsDir() {
+			if w.matches(root) {
+				files[filepath.ToSlash(root)] = fileState{stat.ModTime(), stat.Size()}
+			}
+			continue
+		}
+		_ = filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !w.matches(file) {
+				return nil
+			}
+			if info, errInfo := d.Info(); errInfo == nil {
+				files[filepath.ToSlash(file)] = fileState{info.ModTime(), info.Size()}
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func (w *Watcher) matches(file string) bool {
+	if len(w.Patterns) == 0 {
+		return true
+	}
+	file = filepath.ToSlash(file)
+	for _, pattern := range w.Patterns {
+		if pattern.MatchString(file) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/syntax-framework/demo/server/route"
+)
+
+var (
+	httpRequests = NewCounter("http_requests_total", "Requests served, by method, route pattern and status code", "method", "route", "code")
+	httpDuration = NewHistogram("http_request_duration_seconds", "Duration of the requests, by method and route pattern", nil, "method", "route")
+)
+
+// unmatched route label of the requests that did not match any route, keeps the cardinality bounded
+const unmatched = "unmatched"
+
+// HTTP counts the requests served by next and observes their duration. The labels use the route pattern (Ex.
+// /user/:name), never the path.
+func HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r, info := route.Track(w, r)
+		next.ServeHTTP(w, r)
+
+		pattern := info.Pattern
+		if pattern == "" {
+			pattern = unmatched
+		}
+		status := info.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		method := methodLabel(r.Method)
+		httpRequests.Inc(method, pattern, strconv.Itoa(status))
+		httpDuration.Observe(time.Since(info.Start).Seconds(), method, pattern)
+	})
+}
+
+// methodLabel the standard methods, OTHER for the rest
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
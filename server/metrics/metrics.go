@@ -0,0 +1,363 @@
+// Package metrics is a minimal registry of counters, gauges and histograms exposed in the Prometheus text format.
+//
+// The subsystems declare their metrics on the Default registry, the series are created on the first use of each
+// combination of label values:
+//
+//	var jobRuns = metrics.NewCounter("schedule_job_runs_total", "Runs of the scheduled jobs", "job")
+//
+//	jobRuns.Inc("SendEmails")
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets of the histograms, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default registry, exposed on /metrics
+var Default = &Registry{}
+
+// Registry a set of metrics, safe for concurrent use
+type Registry struct {
+	mutex   sync.RWMutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// desc the name, help and label names of a metric
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// series a combination of label values
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64 // histogram buckets
+	sum    float64
+}
+
+// vec the series of a metric, by label values
+type vec struct {
+	desc
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, exists := v.series[key]
+	if !exists {
+		if v.series == nil {
+			v.series = map[string]*series{}
+		}
+		s = &series{labels: append([]string{}, values...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// initial creates the series of the metrics without labels, exposed as 0 before the first use
+func (v *vec) initial() {
+	if len(v.labels) == 0 {
+		v.get(nil)
+	}
+}
+
+// sorted returns the series ordered by label values
+func (v *vec) sorted() []*series {
+	var list []*series
+	for _, s := range v.series {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labels, "\xff") < strings.Join(list[j].labels, "\xff")
+	})
+	return list
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.header(w)
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labels(v.labels, s.labels, "", ""), formatFloat(s.value))
+	}
+}
+
+// Counter a value that only increases
+type Counter struct {
+	vec
+}
+
+// Inc adds 1 to the series of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the value (>= 0) to the series of the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic("metrics: counter " + c.name + " cannot decrease")
+	}
+	c.mutex.Lock()
+	c.get(labelValues).value += value
+	c.mutex.Unlock()
+}
+
+// Value returns the current value of the series
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.get(labelValues).value
+}
+
+// Gauge a value that can go up and down
+type Gauge struct {
+	vec
+}
+
+// Set sets the value of the series of the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	g.get(labelValues).value = value
+	g.mutex.Unlock()
+}
+
+// Add adds the value, which can be negative
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	g.get(labelValues).value += value
+	g.mutex.Unlock()
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Value returns the current value of the series
+func (g *Gauge) Value(labelValues ...string) float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.get(labelValues).value
+}
+
+// GaugeFunc a gauge without labels computed when the metrics are collected
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, escapeHelp(g.help), g.name, g.name, formatFloat(g.fn()))
+}
+
+// Histogram counts the observed values (Ex. durations) in buckets
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+// Observe adds the value to the series of the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.value++ // count
+	s.sum += value
+}
+
+// Count returns the number of observations of the series
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return uint64(h.get(labelValues).value)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.header(w)
+	for _, s := range h.sorted() {
+		for i, bound := range h.buckets {
+			var count uint64
+			if s.counts != nil {
+				count = s.counts[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels(h.labels, s.labels, "le", formatFloat(bound)), count)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels(h.labels, s.labels, "le", "+Inf"), uint64(s.value))
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels(h.labels, s.labels, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels(h.labels, s.labels, "", ""), uint64(s.value))
+	}
+}
+
+// NewCounter creates a counter on the registry, panics if the name is already registered
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	c := &Counter{vec{desc: desc{name, help, "counter", labelNames}}}
+	r.register(name, c)
+	return c
+}
+
+// NewGauge creates a gauge on the registry, panics if the name is already registered
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	g := &Gauge{vec{desc: desc{name, help, "gauge", labelNames}}}
+	r.register(name, g)
+	return g
+}
+
+// NewGaugeFunc creates a gauge whose value is computed by fn on each collect
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name, help, "gauge", nil}, fn: fn}
+	r.register(name, g)
+	return g
+}
+
+// NewHistogram creates a histogram on the registry, nil buckets uses DefaultBuckets
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{vec: vec{desc: desc{name, help, "histogram", labelNames}}, buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+func (r *Registry) register(name string, m metric) {
+	if v, isVec := m.(interface{ initial() }); isVec {
+		v.initial()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.metrics == nil {
+		r.metrics = map[string]metric{}
+	}
+	if _, exists := r.metrics[name]; exists {
+		panic("metrics: " + name + " is already registered")
+	}
+	r.metrics[name] = m
+}
+
+// WriteText writes all metrics in the Prometheus text format, sorted by name
+func (r *Registry) WriteText(w io.Writer) {
+	r.mutex.RLock()
+	var names []string
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]metric, len(names))
+	for i, name := range names {
+		list[i] = r.metrics[name]
+	}
+	r.mutex.RUnlock()
+
+	for _, m := range list {
+		m.write(w)
+	}
+}
+
+// ServeHTTP exposes the metrics, the endpoint scraped by Prometheus
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	r.WriteText(w)
+}
+
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	return Default.NewCounter(name, help, labelNames...)
+}
+
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return Default.NewGauge(name, help, labelNames...)
+}
+
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	return Default.NewGaugeFunc(name, help, fn)
+}
+
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labelNames...)
+}
+
+// labels formats `{name="value",...}`, with an extra label (Ex. le of the histogram buckets)
+func labels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	buf := &strings.Builder{}
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(extraName + `="` + extraValue + `"`)
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(value string) string {
+	return helpEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
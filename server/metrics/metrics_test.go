@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/syntax-framework/demo/server/route"
+)
+
+func Test_exposition(t *testing.T) {
+	r := &Registry{}
+	counter := r.NewCounter("jobs_total", "Runs of the jobs", "job")
+	gauge := r.NewGauge("connections", "Open connections")
+	histogram := r.NewHistogram("duration_seconds", "Duration", []float64{0.1, 1}, "query")
+	r.NewGaugeFunc("ratio", "Hit ratio", func() float64 { return 0.5 })
+
+	counter.Inc(`Send "emails"`)
+	counter.Add(2, "Cleanup")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	histogram.Observe(0.05, "GetUser")
+	histogram.Observe(0.5, "GetUser")
+	histogram.Observe(3, "GetUser")
+
+	out := &bytes.Buffer{}
+	r.WriteText(out)
+
+	expected := `# HELP connections Open connections
+# TYPE connections gauge
+connections 1
+# HELP duration_seconds Duration
+# TYPE duration_seconds histogram
+duration_seconds_bucket{query="GetUser",le="0.1"} 1
+duration_seconds_bucket{query="GetUser",le="1"} 2
+duration_seconds_bucket{query="GetUser",le="+Inf"} 3
+duration_seconds_sum{query="GetUser"} 3.55
+duration_seconds_count{query="GetUser"} 3
+# HELP jobs_total Runs of the jobs
+# TYPE jobs_total counter
+jobs_total{job="Cleanup"} 2
+jobs_total{job="Send \"emails\""} 1
+# HELP ratio Hit ratio
+# TYPE ratio gauge
+ratio 0.5
+`
+	if out.String() != expected {
+		t.Errorf("got\n%s", out.String())
+	}
+}
+
+func Test_register_twice(t *testing.T) {
+	r := &Registry{}
+	r.NewCounter("a_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic")
+		}
+	}()
+	r.NewGauge("a_total", "")
+}
+
+func Test_http(t *testing.T) {
+	handler := HTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user/alex" {
+			route.SetPattern(r, "/user/:name")
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user/alex", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nope", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PURGE", "/nope", nil))
+
+	if v := httpRequests.Value("GET", "/user/:name", "200"); v != 1 {
+		t.Errorf("Expected 1 request to /user/:name, got %v", v)
+	}
+	if v := httpRequests.Value("GET", unmatched, "404"); v != 1 {
+		t.Errorf("Expected 1 unmatched request, got %v", v)
+	}
+	if v := httpRequests.Value("OTHER", unmatched, "404"); v != 1 {
+		t.Errorf("Expected 1 request with an unknown method, got %v", v)
+	}
+	if c := httpDuration.Count("GET", "/user/:name"); c != 1 {
+		t.Errorf("Expected 1 observation, got %d", c)
+	}
+
+	rec := httptest.NewRecorder()
+	Default.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `http_requests_total{method="GET",route="/user/:name",code="200"} 1`) {
+		t.Errorf("got\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,383 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metadata the discovery document of the provider (OpenID Connect Discovery 1.0)
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	ResponseTypes         []string `json:"response_types_supported"`
+	SubjectTypes          []string `json:"subject_types_supported"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+	Scopes                []string `json:"scopes_supported,omitempty"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// TokenResponse the response of the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+	IDToken     string `json:"id_token"`
+}
+
+// Claims of a verified ID token. Raw has all claims, for the ones of the provider (Ex. the roles).
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Party         string   `json:"azp,omitempty"`
+	Expires       int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	Name          string   `json:"name,omitempty"`
+
+	Raw map[string]interface{} `json:"-"`
+}
+
+// audience the `aud` claim, a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, value := range a {
+		if value == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Strings returns the values of the claim as a list, a string is split by spaces and commas (Ex. the roles)
+func (c *Claims) Strings(name string) ([]string, bool) {
+	value, exists := c.Raw[name]
+	if !exists {
+		return nil, false
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' }), true
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values, true
+	}
+	return nil, true
+}
+
+// Client the relying party of a provider: discovery, authorization URL with PKCE, code exchange and verification of
+// the ID tokens. The metadata and the keys are cached.
+type Client struct {
+	Name         string // Of the provider, in the login paths
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty for public clients
+	Scopes       []string
+	RolesClaim   string        // Claim with the roles of the user, empty does not change them
+	HTTP         *http.Client  // Defaults to a client with a 10s timeout
+	CacheTTL     time.Duration // Of the metadata and of the keys. Defaults to 1h
+	Skew         time.Duration // Tolerance of the clock on exp and iat. Defaults to 1m
+
+	now func() time.Time
+
+	mutex      sync.Mutex
+	metadata   *Metadata
+	discovered time.Time
+	keys       map[string]crypto.PublicKey // By kid
+	fetched    time.Time                   // Of the keys
+}
+
+func (c *Client) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func (c *Client) ttl() time.Duration {
+	if c.CacheTTL > 0 {
+		return c.CacheTTL
+	}
+	return time.Hour
+}
+
+func (c *Client) skew() time.Duration {
+	if c.Skew > 0 {
+		return c.Skew
+	}
+	return time.Minute
+}
+
+var defaultHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// get decodes the JSON document of the URL
+func (c *Client) get(ctx context.Context, endpoint string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return c.do(req, value)
+}
+
+func (c *Client) do(req *http.Request, value interface{}) error {
+	client := c.HTTP
+	if client == nil {
+		client = defaultHTTP
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc: %s: %w", c.Name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("oidc: %s: %w", c.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return fmt.Errorf("oidc: %s: %s %s: %s %s", c.Name, req.Method, req.URL.Path, failure.Error, failure.Description)
+		}
+		return fmt.Errorf("oidc: %s: %s %s: status %d", c.Name, req.Method, req.URL.Path, resp.StatusCode)
+	}
+	if err = json.Unmarshal(body, value); err != nil {
+		return fmt.Errorf("oidc: %s: decoding %s: %w", c.Name, req.URL.Path, err)
+	}
+	return nil
+}
+
+// Discover returns the metadata of the provider, cached for CacheTTL
+func (c *Client) Discover(ctx context.Context) (*Metadata, error) {
+	c.mutex.Lock()
+	if c.metadata != nil && c.clock().Sub(c.discovered) < c.ttl() {
+		defer c.mutex.Unlock()
+		return c.metadata, nil
+	}
+	c.mutex.Unlock()
+
+	m := &Metadata{}
+	if err := c.get(ctx, strings.TrimSuffix(c.Issuer, "/")+"/.well-known/openid-configuration", m); err != nil {
+		return nil, err
+	}
+	// the issuer of the document must be the configured one, it is compared with the `iss` of the tokens
+	if m.Issuer != c.Issuer {
+		return nil, fmt.Errorf("oidc: %s: the metadata is of the issuer %q", c.Name, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: %s: incomplete metadata", c.Name)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.metadata, c.discovered = m, c.clock()
+	return m, nil
+}
+
+// key returns the public key of the kid. The keys are fetched again after CacheTTL and when the kid is unknown (the
+// provider rotated them), at most once a minute.
+func (c *Client) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mutex.Lock()
+	age := c.clock().Sub(c.fetched)
+	key, found := c.lookup(kid)
+	loaded := c.keys != nil
+	c.mutex.Unlock()
+	if found && age < c.ttl() {
+		return key, nil
+	}
+	if !found && loaded && age < time.Minute {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	m, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set := &JWKS{}
+	if err = c.get(ctx, m.JWKSURI, set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if pub, errKey := jwk.PublicKey(); errKey != nil {
+			return nil, fmt.Errorf("oidc: %s: key %q: %w", c.Name, jwk.Kid, errKey)
+		} else if pub != nil {
+			keys[jwk.Kid] = pub
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.keys, c.fetched = keys, c.clock()
+	if key, found = c.lookup(kid); !found {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// lookup the key of the kid, a token without kid uses the only key of the set
+func (c *Client) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, found := c.keys[kid]
+	return key, found
+}
+
+// AuthCodeURL the authorization URL the browser is redirected to. The verifier is kept by the caller (session) and sent
+// on the Exchange, the provider receives its S256 challenge.
+func (c *Client) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, verifier string) (string, error) {
+	m, err := c.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: %s: authorization_endpoint: %w", c.Name, err)
+	}
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", strings.Join(c.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades the authorization code for the tokens, authenticated with the client secret (client_secret_basic)
+// and the PKCE verifier
+func (c *Client) Exchange(ctx context.Context, code, redirectURI, verifier string) (*TokenResponse, error) {
+	m, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	if c.ClientSecret == "" {
+		form.Set("client_id", c.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	tokens := &TokenResponse{}
+	if err = c.do(req, tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: the token response has no id_token", ErrInvalidToken)
+	}
+	return tokens, nil
+}
+
+// Verify checks the signature of the ID token with the keys of the provider, the issuer, the audience, the expiration
+// and the nonce of the login
+func (c *Client) Verify(ctx context.Context, token, nonce string) (*Claims, error) {
+	h, payload, signed, signature, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if h.Alg != RS256 && h.Alg != ES256 {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+	}
+	key, err := c.key(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(h.Alg, key, signed, signature); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err = json.Unmarshal(payload, &claims.Raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	now := c.clock()
+	switch {
+	case claims.Issuer != c.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, claims.Issuer)
+	case !claims.Audience.contains(c.ClientID):
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidToken, []string(claims.Audience))
+	case len(claims.Audience) > 1 && claims.Party != c.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q", ErrInvalidToken, claims.Party)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case !now.Before(time.Unix(claims.Expires, 0).Add(c.skew())):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(c.skew())):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// challenge the S256 PKCE challenge of the verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
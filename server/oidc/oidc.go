@@ -0,0 +1,306 @@
+// Package oidc logs the users in with OpenID Connect providers ("Sign in with ..."), by `auth.oidc` of config.yaml:
+//
+//	auth:
+//	  oidc:
+//	    providers:
+//	      google:
+//	        issuer: https://accounts.google.com
+//	        client-id: ${GOOGLE_CLIENT_ID:}
+//	        client-secret: ${GOOGLE_CLIENT_SECRET:}
+//	    local:
+//	      enabled: true  # built-in provider, ignored outside of dev mode
+//
+// GET /login/oidc/<name> redirects the browser to the provider with an authorization code request (PKCE S256, the
+// state, the nonce and the verifier are kept in the session). The provider redirects back to
+// /login/oidc/<name>/callback, which exchanges the code, verifies the ID token (signature with the keys of the
+// jwks_uri, issuer, audience, expiration and nonce) and logs the user in with auth.SignIn. The metadata of the
+// discovery and the keys are cached, the keys are fetched again when a token is signed by an unknown one.
+//
+// The subject of the provider is linked to a user of the auth store. On the first login the user with the same
+// verified email is linked, or a user without password is created when `auth.registration` is enabled. With
+// `roles-claim` the roles of the user are replaced by the ones of the claim on each login.
+//
+// The built-in provider (`auth.oidc.local`) is served under the path of its issuer and registered as the provider
+// `local`. The relying party reaches it in process, the whole flow runs offline.
+package oidc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/auth"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/session"
+	"github.com/syntax-framework/demo/server/tenant"
+)
+
+// sessionLogin key of the login in progress in the session
+const sessionLogin = "oidc.login"
+
+var (
+	ErrInvalidToken    = errors.New("oidc: invalid ID token")
+	ErrState           = errors.New("oidc: invalid or expired login, try again")
+	ErrNotLinked       = errors.New("oidc: there is no account for this login")
+	ErrEmailUnverified = errors.New("oidc: the provider did not verify the email")
+)
+
+var logins = metrics.NewCounter("oidc_logins_total", "Logins with OpenID Connect providers, by provider and result", "provider", "result")
+
+// RelyingParty logs the users in with the providers
+type RelyingParty struct {
+	Auth    *auth.Auth
+	Clients map[string]*Client // By name of the provider
+	Local   *Provider          // Built-in provider, nil when disabled
+}
+
+// New creates the relying party of the configuration, nil without providers. The built-in provider is only served in
+// dev mode.
+func New(cfg config.AuthOIDC, a *auth.Auth, dev bool) (*RelyingParty, error) {
+	if !dev {
+		cfg.Local.Enabled = false
+	}
+	if a == nil || (len(cfg.Providers) == 0 && !cfg.Local.Enabled) {
+		return nil, nil
+	}
+	rp := &RelyingParty{Auth: a, Clients: map[string]*Client{}}
+	for name, provider := range cfg.Providers {
+		rp.Clients[name] = &Client{
+			Name:         name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			Scopes:       provider.Scopes,
+			RolesClaim:   provider.RolesClaim,
+		}
+	}
+	if cfg.Local.Enabled {
+		local, err := NewProvider(cfg.Local.Issuer, LocalClientID)
+		if err != nil {
+			return nil, err
+		}
+		rp.Local = local
+		rp.Clients["local"] = &Client{
+			Name:       "local",
+			Issuer:     local.Issuer,
+			ClientID:   LocalClientID,
+			Scopes:     []string{"openid", "email", "profile"},
+			RolesClaim: "roles",
+			HTTP:       &http.Client{Transport: local},
+		}
+	}
+	return rp, nil
+}
+
+// Routes returns the login endpoints of each provider and the endpoints of the built-in provider
+func (rp *RelyingParty) Routes() []auth.Route {
+	names := make([]string, 0, len(rp.Clients))
+	for name := range rp.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var routes []auth.Route
+	for _, name := range names {
+		client := rp.Clients[name]
+		routes = append(routes,
+			auth.Route{Method: http.MethodGet, Path: "/login/oidc/" + name, Handler: func(w http.ResponseWriter, r *http.Request) {
+				rp.handleStart(w, r, client)
+			}},
+			auth.Route{Method: http.MethodGet, Path: "/login/oidc/" + name + "/callback", Handler: func(w http.ResponseWriter, r *http.Request) {
+				rp.handleCallback(w, r, client)
+			}},
+		)
+	}
+	if rp.Local != nil {
+		routes = append(routes, rp.Local.Routes()...)
+	}
+	return routes
+}
+
+// pending the login in progress, kept in the session between the redirect and the callback
+type pending struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next,omitempty"`
+	Remember bool   `json:"remember,omitempty"`
+}
+
+// redirectURI the callback of the provider on the public URL of the site, the Host of the request is chosen by the
+// client. Keeps the path prefix of the tenant.
+func (rp *RelyingParty) redirectURI(r *http.Request, c *Client) string {
+	return rp.Auth.BaseURL + tenant.Prefix(r) + "/login/oidc/" + c.Name + "/callback"
+}
+
+// handleStart redirects to the provider. Accepts `next` (page after the login) and `remember` on the query.
+func (rp *RelyingParty) handleStart(w http.ResponseWriter, r *http.Request, c *Client) {
+	s := session.FromRequest(r)
+	if s == nil {
+		rp.fail(w, r, c, auth.ErrNoSession)
+		return
+	}
+	query := r.URL.Query()
+	login := &pending{
+		Provider: c.Name,
+		State:    newSecret(32),
+		Nonce:    newSecret(32),
+		Verifier: newSecret(32),
+		Next:     local(query.Get("next")),
+	}
+	switch query.Get("remember") {
+	case "on", "true", "1":
+		login.Remember = true
+	}
+	target, err := c.AuthCodeURL(r.Context(), rp.redirectURI(r, c), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		rp.fail(w, r, c, err)
+		return
+	}
+	value, err := json.Marshal(login)
+	if err != nil {
+		rp.fail(w, r, c, err)
+		return
+	}
+	s.Set(sessionLogin, string(value))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleCallback completes the login with the code sent by the provider
+func (rp *RelyingParty) handleCallback(w http.ResponseWriter, r *http.Request, c *Client) {
+	s := session.FromRequest(r)
+	if s == nil {
+		rp.fail(w, r, c, auth.ErrNoSession)
+		return
+	}
+	// single use, a second callback with the same state is rejected
+	login := &pending{}
+	value := s.GetString(sessionLogin)
+	s.Delete(sessionLogin)
+	query := r.URL.Query()
+	if value == "" || json.Unmarshal([]byte(value), login) != nil || login.Provider != c.Name ||
+		subtle.ConstantTimeCompare([]byte(login.State), []byte(query.Get("state"))) != 1 {
+		rp.fail(w, r, c, ErrState)
+		return
+	}
+	if code := query.Get("error"); code != "" {
+		rp.fail(w, r, c, fmt.Errorf("%w: %s %s", auth.ErrDenied, code, query.Get("error_description")))
+		return
+	}
+
+	ctx := r.Context()
+	tokens, err := c.Exchange(ctx, query.Get("code"), rp.redirectURI(r, c), login.Verifier)
+	if err != nil {
+		rp.fail(w, r, c, err)
+		return
+	}
+	claims, err := c.Verify(ctx, tokens.IDToken, login.Nonce)
+	if err != nil {
+		rp.fail(w, r, c, err)
+		return
+	}
+	user, err := rp.user(r, c, claims)
+	if err != nil {
+		rp.fail(w, r, c, err)
+		return
+	}
+	if err = rp.Auth.SignIn(w, r, user, login.Remember); err != nil {
+		rp.fail(w, r, c, err)
+		return
+	}
+	logins.Inc(c.Name, "success")
+	target := login.Next
+	if target == "" {
+		target = rp.Auth.Redirect
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// user returns the user linked to the subject, linking it on the first login, with the roles of the claim
+func (rp *RelyingParty) user(r *http.Request, c *Client, claims *Claims) (*auth.User, error) {
+	ctx := r.Context()
+	user, err := rp.Auth.Store.UserByIdentity(ctx, c.Name, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if user, err = rp.link(r, c, claims); err != nil {
+			return nil, err
+		}
+	}
+	if c.RolesClaim != "" {
+		if roles, present := claims.Strings(c.RolesClaim); present {
+			if err = rp.Auth.Store.SetRoles(ctx, user.ID, roles); err != nil {
+				return nil, err
+			}
+			user.Roles = roles
+		}
+	}
+	return user, nil
+}
+
+// link links the subject to the user of the verified email, or to a new user when the registration is enabled
+func (rp *RelyingParty) link(r *http.Request, c *Client, claims *Claims) (*auth.User, error) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrEmailUnverified
+	}
+	ctx := r.Context()
+	user, err := rp.Auth.Store.UserByEmail(ctx, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if !rp.Auth.Registration {
+			return nil, ErrNotLinked
+		}
+		user = &auth.User{Email: claims.Email, Name: claims.Name}
+		if controller := rp.Auth.Controller; controller != nil && controller.Register != nil {
+			if err = controller.Register(r, user); err != nil {
+				return nil, fmt.Errorf("%w: %v", auth.ErrDenied, err)
+			}
+		}
+		if err = rp.Auth.Store.CreateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	if err = rp.Auth.Store.LinkIdentity(ctx, c.Name, claims.Subject, user.ID); err != nil {
+		return nil, err
+	}
+	log.Printf("oidc: %s subject %s linked to the user %s (request %s)", c.Name, claims.Subject, user.ID, requestid.FromRequest(r))
+	return user, nil
+}
+
+// fail logs the error and redirects to auth.redirect with a flash message, the unexpected errors are not shown
+func (rp *RelyingParty) fail(w http.ResponseWriter, r *http.Request, c *Client, err error) {
+	result, message := "error", "login failed, try again later"
+	switch {
+	case errors.Is(err, ErrState), errors.Is(err, ErrNotLinked), errors.Is(err, ErrEmailUnverified),
+		errors.Is(err, auth.ErrDenied), errors.Is(err, auth.ErrEmailTaken), errors.Is(err, auth.ErrLocked):
+		result, message = "denied", err.Error()
+	case errors.Is(err, ErrInvalidToken):
+		result = "invalid"
+	}
+	logins.Inc(c.Name, result)
+	log.Printf("oidc: %s %s: %v (request %s)", r.Method, r.URL.Path, err, requestid.FromRequest(r))
+	if s := session.FromRequest(r); s != nil {
+		message = strings.TrimPrefix(strings.TrimPrefix(message, "oidc: "), "auth: ")
+		s.AddFlash("error", message)
+	}
+	http.Redirect(w, r, rp.Auth.Redirect, http.StatusSeeOther)
+}
+
+// local returns the path when it is a page of the site, empty otherwise (open redirect)
+func local(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, `/\`) {
+		return ""
+	}
+	return next
+}
@@ -0,0 +1,361 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syntax-framework/demo/server/auth"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/session"
+	"github.com/syntax-framework/demo/server/tenant"
+)
+
+func newTestServer(t *testing.T) (*RelyingParty, *httptest.Server) {
+	conn, err := db.Open("test", &config.DB{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	a := &auth.Auth{
+		Store:        &auth.SQL{DB: conn},
+		Hasher:       &auth.Hasher{Algorithm: auth.Bcrypt, BcryptCost: 4},
+		MinPassword:  8,
+		Registration: true,
+		Redirect:     "/",
+	}
+	sessions, err := session.New(config.Session{
+		Cookie: "__Host-session",
+		Keys:   []string{base64.StdEncoding.EncodeToString(make([]byte, 32))},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]http.HandlerFunc{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if handler := routes[r.Method+" "+r.URL.Path]; handler != nil {
+			handler(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, auth.UserID(r)+"\n")
+		for _, flash := range session.FromRequest(r).Flashes() {
+			io.WriteString(w, flash.Kind+": "+flash.Message+"\n")
+		}
+	})
+	srv := httptest.NewUnstartedServer(sessions.Handler(a.Handler(mux)))
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	a.BaseURL = srv.URL
+
+	issuer := srv.URL + "/oidc"
+	rp, err := New(config.AuthOIDC{
+		// a provider of the network, the built-in one reached by https
+		Providers: map[string]*config.OIDCProvider{"remote": {Issuer: issuer, ClientID: LocalClientID, Scopes: []string{"openid", "email"}}},
+		Local:     config.OIDCLocal{Enabled: true, Issuer: issuer},
+	}, a, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.Clients["remote"].HTTP = srv.Client()
+	for _, route := range append(a.Routes(), rp.Routes()...) {
+		routes[route.Method+" "+route.Path] = route.Handler
+	}
+	return rp, srv
+}
+
+// browser keeps the cookies and does not follow redirects
+type browser struct {
+	t   *testing.T
+	srv *httptest.Server
+	*http.Client
+}
+
+func newBrowser(t *testing.T, srv *httptest.Server) *browser {
+	jar, _ := cookiejar.New(nil)
+	c := *srv.Client()
+	c.Jar = jar
+	c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &browser{t: t, srv: srv, Client: &c}
+}
+
+func (b *browser) get(target string) (*http.Response, string) {
+	if strings.HasPrefix(target, "/") {
+		target = b.srv.URL + target
+	}
+	res, err := b.Get(target)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return res, string(body)
+}
+
+var hiddenInput = regexp.MustCompile(`<input type="hidden" name="([^"]+)" value="([^"]*)">`)
+
+// signIn starts the login on the provider and submits its form, returns the callback URL
+func (b *browser) signIn(provider, email, roles string) string {
+	res, _ := b.get("/login/oidc/" + provider + "?next=/account")
+	if res.StatusCode != http.StatusFound {
+		b.t.Fatalf("start: got %d", res.StatusCode)
+	}
+	res, page := b.get(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusOK {
+		b.t.Fatalf("authorize: got %d %s", res.StatusCode, page)
+	}
+	form := url.Values{"email": {email}, "name": {"Ana"}, "roles": {roles}}
+	for _, match := range hiddenInput.FindAllStringSubmatch(page, -1) {
+		value := strings.NewReplacer("&amp;", "&", "&#43;", "+", "&#39;", "'", "&#34;", `"`).Replace(match[2])
+		form.Set(match[1], value)
+	}
+	res, err := b.PostForm(b.srv.URL+"/oidc/authorize", form)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		b.t.Fatalf("authorize form: got %d", res.StatusCode)
+	}
+	return res.Header.Get("Location")
+}
+
+// me returns the ID of the logged user and the flash messages
+func (b *browser) me() (string, string) {
+	_, body := b.get("/me")
+	id, flashes, _ := strings.Cut(body, "\n")
+	return id, flashes
+}
+
+func Test_login(t *testing.T) {
+	rp, srv := newTestServer(t)
+	ctx := context.Background()
+	store := rp.Auth.Store
+
+	b := newBrowser(t, srv)
+	callback := b.signIn("local", "Ana@Example.org", "editor")
+	if !strings.HasPrefix(callback, srv.URL+"/login/oidc/local/callback?") {
+		t.Fatalf("got %s", callback)
+	}
+	res, _ := b.get(callback)
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/account" {
+		t.Fatalf("callback: got %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+	id, _ := b.me()
+	user, _ := store.UserByIdentity(ctx, "local", "ana@example.org")
+	if id == "" || user == nil || user.ID != id || user.Email != "ana@example.org" || user.Name != "Ana" || user.Password != "" {
+		t.Fatalf("expected the new user to be logged in, got %q %+v", id, user)
+	}
+	if !reflect.DeepEqual(user.Roles, []string{"editor"}) {
+		t.Errorf("roles of the claim: got %v", user.Roles)
+	}
+
+	// the callback is single use
+	b.get(callback)
+	if _, flashes := b.me(); !strings.Contains(flashes, "invalid or expired login") {
+		t.Errorf("replay: got %q", flashes)
+	}
+	// the user has no password, the password login fails as any invalid credential
+	res, err := b.Post(srv.URL+"/login", "application/json", strings.NewReader(`{"email":"ana@example.org","password":""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("password login: got %d", res.StatusCode)
+	}
+
+	// same subject, same user, the roles of the claim replace the stored ones
+	b = newBrowser(t, srv)
+	b.get(b.signIn("local", "ana@example.org", "admin"))
+	if again, _ := b.me(); again != id {
+		t.Errorf("expected the same user, got %q", again)
+	}
+	if user, _ = store.UserByID(ctx, id); !reflect.DeepEqual(user.Roles, []string{"admin"}) {
+		t.Errorf("roles: got %v", user.Roles)
+	}
+
+	// the user of a password is linked by the verified email, over the network
+	bia := &auth.User{Email: "bia@example.org", Password: "x"}
+	if err := store.CreateUser(ctx, bia); err != nil {
+		t.Fatal(err)
+	}
+	b = newBrowser(t, srv)
+	b.get(b.signIn("remote", "bia@example.org", ""))
+	if got, _ := b.me(); got != bia.ID {
+		t.Errorf("expected the existing user %s, got %q", bia.ID, got)
+	}
+	if linked, _ := store.UserByIdentity(ctx, "remote", "bia@example.org"); linked == nil || linked.ID != bia.ID {
+		t.Errorf("expected the identity to be linked, got %+v", linked)
+	}
+
+	// without registration only the existing users log in
+	rp.Auth.Registration = false
+	b = newBrowser(t, srv)
+	b.get(b.signIn("local", "caio@example.org", ""))
+	if got, flashes := b.me(); got != "" || !strings.Contains(flashes, "there is no account") {
+		t.Errorf("got %q %q", got, flashes)
+	}
+
+	// a callback of another browser does not match its state
+	callback = newBrowser(t, srv).signIn("local", "ana@example.org", "")
+	b = newBrowser(t, srv)
+	b.get(callback)
+	if got, flashes := b.me(); got != "" || !strings.Contains(flashes, "invalid or expired login") {
+		t.Errorf("foreign callback: got %q %q", got, flashes)
+	}
+}
+
+// the callback is on the public URL of the site, not on the Host sent by the client, under the prefix of the tenant
+func Test_redirect_uri(t *testing.T) {
+	rp := &RelyingParty{Auth: &auth.Auth{BaseURL: "https://demo.example.org"}}
+	client := &Client{Name: "google"}
+	tenants := tenant.New(config.Tenants{
+		Resolve: []string{"path"},
+		Default: "globex",
+		List:    map[string]*config.Tenant{"acme": {Path: "/acme"}, "globex": {}},
+	})
+	var got string
+	handler := tenants.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = rp.redirectURI(r, client)
+	}))
+
+	tests := map[string]string{
+		"/acme/login/oidc/google": "https://demo.example.org/acme/login/oidc/google/callback",
+		"/login/oidc/google":      "https://demo.example.org/login/oidc/google/callback",
+	}
+	for path, expected := range tests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://evil.example.org"+path, nil))
+		if got != expected {
+			t.Errorf("%s: Expected %s, got %s", path, expected, got)
+		}
+	}
+}
+
+func Test_pkce(t *testing.T) {
+	rp, srv := newTestServer(t)
+	local := rp.Clients["local"]
+	ctx := context.Background()
+	redirect := srv.URL + "/login/oidc/local/callback"
+
+	code := func(verifier string) string {
+		rp.Local.mutex.Lock()
+		defer rp.Local.mutex.Unlock()
+		code := newSecret(16)
+		rp.Local.codes[code] = &grant{redirectURI: redirect, challenge: challenge(verifier), email: "ana@example.org", nonce: "n", expires: time.Now().Add(time.Minute)}
+		return code
+	}
+
+	if _, err := local.Exchange(ctx, code("right"), redirect, "wrong"); err == nil || !strings.Contains(err.Error(), "code_verifier") {
+		t.Errorf("wrong verifier: got %v", err)
+	}
+	if _, err := local.Exchange(ctx, code("right"), srv.URL+"/other", "right"); err == nil || !strings.Contains(err.Error(), "redirect_uri") {
+		t.Errorf("other redirect: got %v", err)
+	}
+	c := code("right")
+	tokens, err := local.Exchange(ctx, c, redirect, "right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = local.Verify(ctx, tokens.IDToken, "n"); err != nil {
+		t.Errorf("got %v", err)
+	}
+	if _, err = local.Exchange(ctx, c, redirect, "right"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("reused code: got %v", err)
+	}
+
+	// the authorization request requires PKCE
+	b := newBrowser(t, srv)
+	res, _ := b.get("/oidc/authorize?response_type=code&client_id=local&scope=openid&redirect_uri=" + url.QueryEscape(redirect))
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("without code_challenge: got %d", res.StatusCode)
+	}
+	res, _ = b.get("/oidc/authorize?response_type=code&client_id=local&scope=openid&code_challenge=x&code_challenge_method=S256" +
+		"&redirect_uri=" + url.QueryEscape("https://evil.example.org/callback"))
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("redirect to another host: got %d", res.StatusCode)
+	}
+}
+
+func Test_verify(t *testing.T) {
+	rp, _ := newTestServer(t)
+	provider, client := rp.Local, rp.Clients["local"]
+	ctx := context.Background()
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	token := func(change func(claims map[string]interface{})) string {
+		claims := map[string]interface{}{
+			"iss": provider.Issuer, "sub": "1", "aud": LocalClientID, "nonce": "n",
+			"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(),
+		}
+		if change != nil {
+			change(claims)
+		}
+		signed, err := signRS256(provider.key, provider.kid, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	if claims, err := client.Verify(ctx, token(nil), "n"); err != nil || claims.Subject != "1" {
+		t.Fatalf("got %+v %v", claims, err)
+	}
+	invalid := map[string]string{
+		"issuer":        token(func(c map[string]interface{}) { c["iss"] = "https://other.example.org" }),
+		"audience":      token(func(c map[string]interface{}) { c["aud"] = "other" }),
+		"party":         token(func(c map[string]interface{}) { c["aud"] = []string{LocalClientID, "other"} }),
+		"expired":       token(func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() }),
+		"future":        token(func(c map[string]interface{}) { c["iat"] = now.Add(time.Hour).Unix() }),
+		"nonce":         token(func(c map[string]interface{}) { c["nonce"] = "other" }),
+		"subject":       token(func(c map[string]interface{}) { delete(c, "sub") }),
+		"signature":     token(nil)[:len(token(nil))-4] + "AAAA",
+		"algorithm":     "eyJhbGciOiJub25lIn0." + strings.Split(token(nil), ".")[1] + ".",
+		"serialization": "not a token",
+	}
+	for name, value := range invalid {
+		if _, err := client.Verify(ctx, value, "n"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	if _, err := client.Verify(ctx, token(func(c map[string]interface{}) {
+		c["aud"] = []string{LocalClientID, "other"}
+		c["azp"] = LocalClientID
+	}), "n"); err != nil {
+		t.Errorf("authorized party: got %v", err)
+	}
+
+	// the provider rotates the key, the keys are fetched again at most once a minute
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.key, provider.kid = key, "rotated"
+	if _, err = client.Verify(ctx, token(nil), "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the unknown key to wait, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err = client.Verify(ctx, token(nil), "n"); err != nil {
+		t.Errorf("rotated key: got %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/auth"
+)
+
+//go:embed login.html
+var loginPage string
+
+var loginTemplate = template.Must(template.New("login").Parse(loginPage))
+
+// LocalClientID the client of the site on the built-in provider
+const LocalClientID = "local"
+
+// codeTTL validity of the authorization codes of the built-in provider
+const codeTTL = time.Minute
+
+// Provider a minimal OpenID Connect provider, the stand-in of the real ones in development and tests: anyone signs in
+// with the email typed on its form, optionally with roles. Only the authorization code flow of a public client with
+// PKCE (S256), the ID tokens are signed (RS256) by a key generated on startup.
+type Provider struct {
+	Issuer   string
+	ClientID string
+
+	path string // Of the issuer, prefix of the endpoints
+	host string // Of the issuer, the only host of the redirect URIs
+	key  *rsa.PrivateKey
+	kid  string
+	now  func() time.Time
+
+	mutex sync.Mutex
+	codes map[string]*grant
+}
+
+// grant an authorization code issued and not yet exchanged
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	name        string
+	roles       []string
+	expires     time.Time
+}
+
+// NewProvider creates the provider of the issuer, the endpoints are served under its path
+func NewProvider(issuer, clientID string) (*Provider, error) {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("oidc: invalid issuer %q, expected a https URL", issuer)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key.PublicKey.N.Bytes())
+	return &Provider{
+		Issuer:   issuer,
+		ClientID: clientID,
+		path:     strings.TrimSuffix(u.Path, "/"),
+		host:     u.Host,
+		key:      key,
+		kid:      hex.EncodeToString(sum[:8]),
+		codes:    map[string]*grant{},
+	}, nil
+}
+
+func (p *Provider) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// Routes returns the endpoints of the provider
+func (p *Provider) Routes() []auth.Route {
+	return []auth.Route{
+		{Method: http.MethodGet, Path: p.path + "/.well-known/openid-configuration", Handler: p.handleDiscovery},
+		{Method: http.MethodGet, Path: p.path + "/jwks", Handler: p.handleJWKS},
+		{Method: http.MethodGet, Path: p.path + "/authorize", Handler: p.handleAuthorize},
+		{Method: http.MethodPost, Path: p.path + "/authorize", Handler: p.handleAuthorize},
+		{Method: http.MethodPost, Path: p.path + "/token", Handler: p.handleToken},
+	}
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, endpoint := range p.Routes() {
+		if endpoint.Method == r.Method && endpoint.Path == r.URL.Path {
+			endpoint.Handler(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// RoundTrip serves the requests of the relying party in process (Client.HTTP), without the network and the TLS
+// certificate of the site
+func (p *Provider) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != p.host {
+		return nil, fmt.Errorf("oidc: the built-in provider does not serve %s", req.URL.Host)
+	}
+	req = req.Clone(req.Context())
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &Metadata{
+		Issuer:                p.Issuer,
+		AuthorizationEndpoint: p.Issuer + "/authorize",
+		TokenEndpoint:         p.Issuer + "/token",
+		JWKSURI:               p.Issuer + "/jwks",
+		ResponseTypes:         []string{"code"},
+		SubjectTypes:          []string{"public"},
+		SigningAlgs:           []string{RS256},
+		Scopes:                []string{"openid", "email", "profile"},
+		CodeChallengeMethods:  []string{"S256"},
+	})
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &JWKS{Keys: []*JWK{rsaJWK(&p.key.PublicKey, p.kid)}})
+}
+
+// handleAuthorize shows the form (GET) and issues the code to the redirect URI (POST)
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := r.Form
+	redirectURI, err := p.checkAuthorization(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var problem string
+	if r.Method == http.MethodPost {
+		email := strings.TrimSpace(r.PostForm.Get("email"))
+		if address, errEmail := mail.ParseAddress(email); errEmail != nil || address.Address != email {
+			problem = "Invalid email"
+		} else {
+			code := newSecret(32)
+			p.mutex.Lock()
+			now := p.clock()
+			for key, g := range p.codes {
+				if now.After(g.expires) {
+					delete(p.codes, key)
+				}
+			}
+			p.codes[code] = &grant{
+				redirectURI: redirectURI.String(),
+				challenge:   params.Get("code_challenge"),
+				nonce:       params.Get("nonce"),
+				email:       strings.ToLower(email),
+				name:        strings.TrimSpace(r.PostForm.Get("name")),
+				roles:       strings.Fields(strings.ReplaceAll(r.PostForm.Get("roles"), ",", " ")),
+				expires:     now.Add(codeTTL),
+			}
+			p.mutex.Unlock()
+
+			query := redirectURI.Query()
+			query.Set("code", code)
+			if state := params.Get("state"); state != "" {
+				query.Set("state", state)
+			}
+			redirectURI.RawQuery = query.Encode()
+			http.Redirect(w, r, redirectURI.String(), http.StatusSeeOther)
+			return
+		}
+	}
+
+	hidden := map[string]string{}
+	for _, name := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		hidden[name] = params.Get(name)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if problem != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	loginTemplate.Execute(w, map[string]interface{}{
+		"Action":  p.path + "/authorize",
+		"Hidden":  hidden,
+		"Problem": problem,
+		"Email":   r.PostForm.Get("email"),
+		"Name":    r.PostForm.Get("name"),
+	})
+}
+
+// checkAuthorization validates the parameters of the authorization request, returns the redirect URI
+func (p *Provider) checkAuthorization(params url.Values) (*url.URL, error) {
+	if params.Get("response_type") != "code" {
+		return nil, fmt.Errorf("unsupported response_type %q, only code", params.Get("response_type"))
+	}
+	if params.Get("client_id") != p.ClientID {
+		return nil, fmt.Errorf("unknown client_id %q", params.Get("client_id"))
+	}
+	redirectURI, err := url.Parse(params.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme != "https" || redirectURI.Host != p.host || redirectURI.Fragment != "" {
+		return nil, fmt.Errorf("redirect_uri must be on https://%s", p.host)
+	}
+	if !contains(strings.Fields(params.Get("scope")), "openid") {
+		return nil, fmt.Errorf("the scope must include openid")
+	}
+	if params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256" {
+		return nil, fmt.Errorf("PKCE is required, with code_challenge_method S256")
+	}
+	return redirectURI, nil
+}
+
+// handleToken exchanges the code for the ID token, checking the redirect URI and the PKCE verifier
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+	form := r.PostForm
+	if form.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "only authorization_code")
+		return
+	}
+	clientID := form.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(id)
+	}
+	if clientID != p.ClientID {
+		tokenError(w, "invalid_client", "unknown client")
+		return
+	}
+
+	p.mutex.Lock()
+	g := p.codes[form.Get("code")]
+	delete(p.codes, form.Get("code"))
+	p.mutex.Unlock()
+	now := p.clock()
+	switch {
+	case g == nil || now.After(g.expires):
+		tokenError(w, "invalid_grant", "invalid or expired code")
+		return
+	case g.redirectURI != form.Get("redirect_uri"):
+		tokenError(w, "invalid_grant", "redirect_uri does not match the authorization request")
+		return
+	case subtle.ConstantTimeCompare([]byte(challenge(form.Get("code_verifier"))), []byte(g.challenge)) != 1:
+		tokenError(w, "invalid_grant", "invalid code_verifier")
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":            p.Issuer,
+		"sub":            g.email,
+		"aud":            p.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"email":          g.email,
+		"email_verified": true,
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if g.name != "" {
+		claims["name"] = g.name
+	}
+	if len(g.roles) > 0 {
+		claims["roles"] = g.roles
+	}
+	idToken, err := signRS256(p.key, p.kid, claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &TokenResponse{
+		AccessToken: newSecret(32),
+		TokenType:   "Bearer",
+		ExpiresIn:   300,
+		IDToken:     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	status := http.StatusBadRequest
+	if code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newSecret returns a random base64url string of size bytes
+func newSecret(size int) string {
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret)
+}
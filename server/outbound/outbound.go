@@ -0,0 +1,17 @@
+// Package outbound is the HTTP client of the calls made by the application to other services (integrations, storage).
+// The requests carry the trace context and the ID of the request being served.
+package outbound
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/syntax-framework/demo/server/requestid"
+	"github.com/syntax-framework/demo/server/trace"
+)
+
+// Client used by all outbound calls, the timeout of each call is defined by its context
+var Client = &http.Client{
+	Transport: requestid.Transport(trace.Transport(http.DefaultTransport)),
+	Timeout:   time.Minute,
+}
@@ -0,0 +1,492 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/tenant"
+	"github.com/syntax-framework/demo/server/trace"
+)
+
+var (
+	queryDuration = metrics.NewHistogram("db_query_duration_seconds", "Duration of the named queries and commands", nil, "database", "query")
+	queryErrors   = metrics.NewCounter("db_query_errors_total", "Failed executions of the named queries and commands", "database", "query")
+)
+
+// Conn the database connection used to execute the definitions, implemented by db.DB
+type Conn interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Placeholder(n int) string // positional parameter of the engine, starting at 1
+}
+
+// TenantParam the param bound to the tenant of the context (see package tenant), a value informed by the caller is
+// replaced
+const TenantParam = "tenant"
+
+// ErrNoTenant a definition of a database of the tenants was executed without a tenant
+var ErrNoTenant = errors.New("there is no tenant")
+
+// Scoped implemented by the connections of the databases whose tables are shared by the tenants (see db.DB), returns
+// the column of the tenant, empty when the connection is not shared
+type Scoped interface {
+	TenantColumn() string
+}
+
+// Bind replaces the named parameters (`:name`) of the SQL by the positional placeholders of the connection,
+// converting the values to the types declared in `params`.
+//
+// Values informed as text (Ex. from the command line) are parsed according to the declared type: string, int, float
+// and bool. Text inside quotes and postgres casts (`::int`) are ignored.
+func (d *Definition) Bind(params map[string]interface{}, placeholder func(n int) string) (string, []interface{}, error) {
+	var args []interface{}
+	var missing []string
+	var invalid error
+	converted := map[string]interface{}{}
+
+	bound := scan(d.SQL, func(name string) string {
+		value, exists := converted[name]
+		if !exists {
+			var err error
+			if value, exists, err = d.param(name, params); err != nil && invalid == nil {
+				invalid = err
+			} else if !exists && err == nil {
+				missing = append(missing, name)
+			}
+			converted[name] = value
+		}
+		args = append(args, value)
+		return placeholder(len(args))
+	})
+
+	if invalid != nil {
+		return "", nil, invalid
+	}
+	if len(missing) > 0 {
+		return "", nil, fmt.Errorf("%s: missing params: %s", d.Name, strings.Join(missing, ", "))
+	}
+	return bound, args, nil
+}
+
+// scan calls replace for each named parameter of the SQL, returning the SQL with the parameters replaced
+func scan(sql string, replace func(name string) string) string {
+	out := &strings.Builder{}
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ':' && i+1 < len(sql) && sql[i+1] == ':':
+			out.WriteString("::")
+			i++
+			continue
+		case c == ':' && i+1 < len(sql) && isNameStart(sql[i+1]):
+			end := i + 1
+			for end < len(sql) && isNamePart(sql[end]) {
+				end++
+			}
+			out.WriteString(replace(sql[i+1 : end]))
+			i = end - 1
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// scope binds the tenant of the context to the `:tenant` param. On the connections shared by the tenants a tenant is
+// required and the statements must be scoped by the column of the tenant (see scopedBy). The SQL is never rewritten: a
+// filter applied around it would run after the LIMIT, the ORDER BY and the aggregates of the other tenants.
+func (d *Definition) scope(ctx context.Context, conn Conn, params map[string]interface{}) (map[string]interface{}, error) {
+	column := ""
+	if scoped, ok := conn.(Scoped); ok {
+		column = scoped.TenantColumn()
+	}
+	t := tenant.FromContext(ctx)
+	if t == nil {
+		if column != "" {
+			return nil, fmt.Errorf("%s: %w, the database %s is shared by the tenants", d.Name, ErrNoTenant, d.Database)
+		}
+		return params, nil
+	}
+	if column != "" && !scopedBy(d.SQL, column) {
+		return nil, fmt.Errorf("%s: the definitions of the database %s, shared by the tenants, must filter by %s = :%s", d.Name, d.Database, column, TenantParam)
+	}
+
+	bound := make(map[string]interface{}, len(params)+1)
+	for name, value := range params {
+		bound[name] = value
+	}
+	bound[TenantParam] = t.Name
+	return bound, nil
+}
+
+// scopedBy reports whether each statement of the SQL (and each side of its UNION, INTERSECT and EXCEPT) only reaches
+// the rows of the tenant:
+//
+//   - SELECT, UPDATE and DELETE have `<column> = :tenant` (or `alias.<column>`) as a condition of the WHERE, joined to
+//     the others by AND. A WHERE with an OR at its top level is refused, wrap the alternatives in parentheses.
+//   - INSERT ... VALUES lists the column and binds :tenant, INSERT ... SELECT follows the rule of the SELECT.
+//
+// The comments and the quoted text are ignored.
+func scopedBy(sql, column string) bool {
+	column = strings.ToLower(column)
+	statements := [][]string{nil}
+	depth := 0
+	for _, token := range tokenize(sql) {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth == 0 && (token == ";" || token == "union" || token == "intersect" || token == "except") {
+			statements = append(statements, nil)
+			continue
+		}
+		statements[len(statements)-1] = append(statements[len(statements)-1], token)
+	}
+
+	scoped := false
+	for _, statement := range statements {
+		if len(statement) == 0 {
+			continue
+		}
+		if !statementScoped(statement, column) {
+			return false
+		}
+		scoped = true
+	}
+	return scoped
+}
+
+// statementScoped see scopedBy
+func statementScoped(tokens []string, column string) bool {
+	if tokens[0] == "insert" {
+		if i := topLevel(tokens, "select"); i >= 0 {
+			return statementScoped(tokens[i:], column)
+		}
+		open, values := index(tokens, "("), index(tokens, "values")
+		if open < 0 || values < open {
+			return false
+		}
+		return contains(tokens[open:values], column) && contains(tokens[values:], ":"+TenantParam)
+	}
+
+	where := topLevel(tokens, "where")
+	if where < 0 {
+		return false
+	}
+	// the conditions of the WHERE joined by AND
+	var conditions [][]string
+	condition := []string{}
+	depth := 0
+	for _, token := range tokens[where+1:] {
+		if depth == 0 {
+			if clauseEnd[token] {
+				break
+			}
+			if token == "or" {
+				return false
+			}
+			if token == "and" {
+				conditions = append(conditions, condition)
+				condition = []string{}
+				continue
+			}
+		}
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		condition = append(condition, token)
+	}
+	for _, condition = range append(conditions, condition) {
+		if tenantCondition(condition, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// clauseEnd the keywords that end the WHERE
+var clauseEnd = map[string]bool{
+	"group": true, "order": true, "limit": true, "offset": true, "having": true, "window": true, "returning": true,
+	"fetch": true, "for": true,
+}
+
+// tenantCondition reports whether the tokens are `[alias.]column = :tenant` or `:tenant = [alias.]column`
+func tenantCondition(tokens []string, column string) bool {
+	for len(tokens) > 2 && tokens[0] == "(" && tokens[len(tokens)-1] == ")" {
+		tokens = tokens[1 : len(tokens)-1]
+	}
+	i := index(tokens, "=")
+	if i < 0 {
+		return false
+	}
+	left, right := tokens[:i], tokens[i+1:]
+	if len(left) == 1 && left[0] == ":"+TenantParam {
+		left, right = right, left
+	}
+	if len(right) != 1 || right[0] != ":"+TenantParam {
+		return false
+	}
+	return (len(left) == 1 && left[0] == column) || (len(left) == 3 && left[1] == "." && left[2] == column)
+}
+
+// tokenize splits the SQL into lower case words, named params (`:name`) and symbols, without the comments. The quoted
+// text becomes a single `'` token.
+func tokenize(sql string) []string {
+	var tokens []string
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 3
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(sql[i+1:], c)
+			if end < 0 {
+				return append(tokens, "'")
+			}
+			i += end + 1
+			tokens = append(tokens, "'")
+		case c == ':' && i+1 < len(sql) && sql[i+1] == ':':
+			tokens = append(tokens, "::")
+			i++
+		case c == ':' && i+1 < len(sql) && isNameStart(sql[i+1]):
+			end := i + 1
+			for end < len(sql) && isNamePart(sql[end]) {
+				end++
+			}
+			tokens = append(tokens, sql[i:end])
+			i = end - 1
+		case isNamePart(c):
+			end := i
+			for end < len(sql) && isNamePart(sql[end]) {
+				end++
+			}
+			tokens = append(tokens, strings.ToLower(sql[i:end]))
+			i = end - 1
+		default:
+			tokens = append(tokens, string(c))
+		}
+	}
+	return tokens
+}
+
+// topLevel returns the index of the token outside of parentheses, -1 when absent
+func topLevel(tokens []string, token string) int {
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t == "(":
+			depth++
+		case t == ")":
+			depth--
+		case depth == 0 && t == token:
+			return i
+		}
+	}
+	return -1
+}
+
+func index(tokens []string, token string) int {
+	for i, t := range tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+func contains(tokens []string, token string) bool {
+	return index(tokens, token) >= 0
+}
+
+// param returns the value of the param converted to the declared type
+func (d *Definition) param(name string, params map[string]interface{}) (interface{}, bool, error) {
+	value, exists := params[name]
+	if !exists {
+		return nil, false, nil
+	}
+	text, isText := value.(string)
+	declared := d.Params[name]
+	if !isText || declared == nil {
+		return value, true, nil
+	}
+
+	var err error
+	switch strings.ToLower(declared.Type) {
+	case "int", "integer":
+		value, err = strconv.ParseInt(text, 10, 64)
+	case "float", "number":
+		value, err = strconv.ParseFloat(text, 64)
+	case "bool", "boolean":
+		value, err = strconv.ParseBool(text)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: param %s: expected %s, found %q", d.Name, name, declared.Type, text)
+	}
+	return value, true, nil
+}
+
+// Query executes the definition, returning the rows. When `mapping` is declared, only the mapped columns are
+// returned.
+func (d *Definition) Query(ctx context.Context, conn Conn, params map[string]interface{}) (result []map[string]interface{}, err error) {
+	ctx, span := d.startSpan(ctx)
+	defer d.observe(span, time.Now(), &err)
+
+	params, err = d.scope(ctx, conn, params)
+	if err != nil {
+		return nil, err
+	}
+	sql, args, err := d.Bind(params, conn.Placeholder)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttribute("db.statement", sql)
+
+	rows, err := conn.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.Name, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result = []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("%s: %w", d.Name, err)
+		}
+
+		row := map[string]interface{}{}
+		for i, column := range columns {
+			if len(d.Mapping) > 0 {
+				if _, mapped := d.Mapping[column]; !mapped {
+					continue
+				}
+			}
+			if data, isBytes := values[i].([]byte); isBytes {
+				row[column] = string(data)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", d.Name, err)
+	}
+	return result, nil
+}
+
+// Exec executes the definition, returning the number of affected rows
+func (d *Definition) Exec(ctx context.Context, conn Conn, params map[string]interface{}) (affected int64, err error) {
+	ctx, span := d.startSpan(ctx)
+	defer d.observe(span, time.Now(), &err)
+
+	params, err = d.scope(ctx, conn, params)
+	if err != nil {
+		return 0, err
+	}
+	sql, args, err := d.Bind(params, conn.Placeholder)
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttribute("db.statement", sql)
+	result, err := conn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", d.Name, err)
+	}
+	return result.RowsAffected()
+}
+
+// startSpan creates the span of the execution, the values of the params are not recorded
+func (d *Definition) startSpan(ctx context.Context) (context.Context, *trace.Span) {
+	ctx, span := trace.Start(ctx, d.Name, trace.KindClient)
+	span.SetAttribute("db.name", d.Database)
+	span.SetAttribute("db.operation", string(d.Kind))
+	return ctx, span
+}
+
+// observe records the duration of the execution started at start and ends the span
+func (d *Definition) observe(span *trace.Span, start time.Time, err *error) {
+	queryDuration.Observe(time.Since(start).Seconds(), d.Database, d.Name)
+	if *err != nil {
+		queryErrors.Inc(d.Database, d.Name)
+		span.SetError(*err)
+	}
+	span.End()
+}
+
+// CacheKey the key of the cached result: the `cache.key` prefix followed by the params, sorted by name
+func (d *Definition) CacheKey(params map[string]interface{}) string {
+	key := &strings.Builder{}
+	key.WriteString(d.Database + ":" + d.Name + ":")
+	if d.Cache != nil {
+		key.WriteString(d.Cache.Key)
+	}
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i > 0 {
+			key.WriteByte('&')
+		}
+		key.WriteString(url.QueryEscape(name) + "=" + url.QueryEscape(fmt.Sprint(params[name])))
+	}
+	return key.String()
+}
+
+// ParamNames returns the declared params, sorted
+func (d *Definition) ParamNames() []string {
+	var names []string
+	for name := range d.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,204 @@
+// Package query loads the named SQL queries and commands declared in YAML files.
+//
+// Each database configured in the `db` block of config.yaml has its own directory:
+//
+//	db/<database>/queries/*.yaml   read only queries
+//	db/<database>/commands/*.yaml  commands (insert, update, delete)
+//	db/<database>/migrations/*.sql
+//
+// A definition looks like:
+//
+//	name: GetPlayerById
+//	params:
+//	  name: string
+//	mapping:
+//	  id: string
+//	  name: string
+//	query: >
+//	  SELECT id, name FROM users WHERE name = :name
+//
+// The params are bound by name (`:name`) and converted to the declared types when executed, see Definition.Bind.
+//
+// The definitions executed on behalf of a request can require roles or permissions of the logged user (see the
+// Requirement of server/authz), Ex. `auth: { permissions: [ users.write ] }` or `auth: admin`.
+//
+// The param `:tenant` is bound to the tenant of the context (see package tenant). On the databases shared by the
+// tenants (`tenancy: column`) the queries and the commands must filter by the column of `tenants.column` in the WHERE,
+// joined to the other conditions by AND (Ex. `WHERE tenant_id = :tenant AND id = :id`), and the inserts must set it.
+package query
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syntax-framework/demo/server/authz"
+	"gopkg.in/yaml.v3"
+)
+
+type Kind string
+
+const (
+	KindQuery   Kind = "queries"
+	KindCommand Kind = "commands"
+)
+
+// Definition of a named query or command
+type Definition struct {
+	Name     string             `yaml:"name"`
+	Params   map[string]*Param  `yaml:"params"`
+	Mapping  map[string]string  `yaml:"mapping"`
+	Cache    *Cache             `yaml:"cache"`
+	Triggers []string           `yaml:"triggers"`
+	SQL      string             `yaml:"query"`
+	Auth     *authz.Requirement `yaml:"auth"`
+
+	Database string `yaml:"-"` // Name of the database (directory) that declares this definition
+	Kind     Kind   `yaml:"-"`
+	File     string `yaml:"-"`
+}
+
+// Param declaration, accepts the short form `name: string` or the full form with validations
+type Param struct {
+	Type     string   `yaml:"type"`
+	Validate []string `yaml:"validate"`
+}
+
+func (p *Param) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		p.Type = node.Value
+		return nil
+	}
+	type plain Param
+	return node.Decode((*plain)(p))
+}
+
+// Cache the result of the query is cached (see the `cache` block of config.yaml), by the key followed by the params
+type Cache struct {
+	Key string `yaml:"key"`
+}
+
+// Registry of the definitions loaded from a FileSystem, safe for concurrent use
+type Registry struct {
+	fsys        fs.FS
+	mutex       sync.RWMutex
+	definitions map[string]*Definition // {kind}/{name}
+}
+
+// New creates a registry for the `db` directory of the FileSystem and loads the definitions
+func New(fsys fs.FS) (*Registry, error) {
+	r := &Registry{fsys: fsys}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewDir creates a registry for a `db` directory on disk
+func NewDir(dir string) (*Registry, error) {
+	return New(os.DirFS(dir))
+}
+
+// Reload reads all definitions again. On error, the definitions previously loaded are kept.
+func (r *Registry) Reload() error {
+	definitions := map[string]*Definition{}
+
+	databases, err := fs.ReadDir(r.fsys, ".")
+	if err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		if !database.IsDir() {
+			continue
+		}
+		for _, kind := range []Kind{KindQuery, KindCommand} {
+			dir := path.Join(database.Name(), string(kind))
+			files, errDir := fs.ReadDir(r.fsys, dir)
+			if errDir != nil {
+				continue
+			}
+			for _, file := range files {
+				if file.IsDir() || !(strings.HasSuffix(file.Name(), ".yaml") || strings.HasSuffix(file.Name(), ".yml")) {
+					continue
+				}
+
+				definition, errLoad := r.load(path.Join(dir, file.Name()))
+				if errLoad != nil {
+					return errLoad
+				}
+				definition.Database = database.Name()
+				definition.Kind = kind
+
+				key := string(kind) + "/" + definition.Name
+				if existing, exists := definitions[key]; exists {
+					return fmt.Errorf("%s: %s '%s' is already declared in %s", definition.File, kind, definition.Name, existing.File)
+				}
+				definitions[key] = definition
+			}
+		}
+	}
+
+	r.mutex.Lock()
+	r.definitions = definitions
+	r.mutex.Unlock()
+	return nil
+}
+
+func (r *Registry) load(file string) (*Definition, error) {
+	data, err := fs.ReadFile(r.fsys, file)
+	if err != nil {
+		return nil, err
+	}
+
+	definition := &Definition{File: file}
+	if err = yaml.Unmarshal(data, definition); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	definition.Name = strings.TrimSpace(definition.Name)
+	if definition.Name == "" {
+		return nil, fmt.Errorf("%s: name is required", file)
+	}
+	if strings.TrimSpace(definition.SQL) == "" {
+		return nil, fmt.Errorf("%s: query is required", file)
+	}
+	return definition, nil
+}
+
+// Query returns the query with the given name, nil if it does not exist
+func (r *Registry) Query(name string) *Definition {
+	return r.get(KindQuery, name)
+}
+
+// Command returns the command with the given name, nil if it does not exist
+func (r *Registry) Command(name string) *Definition {
+	return r.get(KindCommand, name)
+}
+
+func (r *Registry) get(kind Kind, name string) *Definition {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.definitions[string(kind)+"/"+name]
+}
+
+// List returns all definitions sorted by kind and name
+func (r *Registry) List() []*Definition {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list := make([]*Definition, 0, len(r.definitions))
+	for _, definition := range r.definitions {
+		list = append(list, definition)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind > list[j].Kind
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
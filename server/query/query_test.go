@@ -0,0 +1,207 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/fstest"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/tenant"
+)
+
+func Test_registry(t *testing.T) {
+	fsys := fstest.MapFS{
+		"mydatabase/queries/my-model.yaml": {Data: []byte(`
+name: GetPlayerById
+params:
+  name: string
+  age:
+    type: int
+    validate:
+      - min
+query: >
+  SELECT * FROM mydatabase WHERE user = :name
+`)},
+		"mydatabase/commands/update-users.yaml": {Data: []byte(`
+name: GetPlayerById
+triggers:
+  - onUpdateUsers
+query: UPDATE users SET name = :name
+`)},
+		"mydatabase/migrations/V1.Create_Database.sql": {Data: []byte(`CREATE TABLE users (id TEXT)`)},
+	}
+
+	registry, err := New(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := registry.Query("GetPlayerById")
+	if query == nil || query.Database != "mydatabase" || query.Kind != KindQuery {
+		t.Fatalf("query: got %+v", query)
+	}
+	if query.Params["name"].Type != "string" || query.Params["age"].Type != "int" || query.Params["age"].Validate[0] != "min" {
+		t.Errorf("params: got %+v %+v", query.Params["name"], query.Params["age"])
+	}
+
+	command := registry.Command("GetPlayerById")
+	if command == nil || command.Triggers[0] != "onUpdateUsers" {
+		t.Fatalf("command: got %+v", command)
+	}
+	if len(registry.List()) != 2 {
+		t.Errorf("list: Expected 2 definitions, got %d", len(registry.List()))
+	}
+
+	// duplicated name, keeps previous definitions
+	fsys["mydatabase/queries/other.yaml"] = &fstest.MapFile{Data: []byte("name: GetPlayerById\nquery: SELECT 1")}
+	if err = registry.Reload(); err == nil {
+		t.Error("Expected error for duplicated query name")
+	}
+	if registry.Query("GetPlayerById") != query {
+		t.Error("Expected previous definitions to be kept after a failed reload")
+	}
+}
+
+func Test_bind(t *testing.T) {
+	definition := &Definition{
+		Name:   "Test",
+		Params: map[string]*Param{"name": {Type: "string"}, "age": {Type: "int"}},
+		SQL:    `SELECT id::text, ':ignored' FROM users WHERE name = :name AND (age > :age OR :age IS NULL)`,
+	}
+
+	dollar := func(n int) string { return "$" + strconv.Itoa(n) }
+	sql, args, err := definition.Bind(map[string]interface{}{"name": "alex", "age": "30"}, dollar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `SELECT id::text, ':ignored' FROM users WHERE name = $1 AND (age > $2 OR $3 IS NULL)`
+	if sql != expected {
+		t.Errorf("sql: Expected '%s', got '%s'", expected, sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"alex", int64(30), int64(30)}) {
+		t.Errorf("args: got %#v", args)
+	}
+
+	if _, _, err = definition.Bind(map[string]interface{}{"name": "alex"}, dollar); err == nil {
+		t.Error("Expected error for missing param")
+	}
+	if _, _, err = definition.Bind(map[string]interface{}{"name": "alex", "age": "old"}, dollar); err == nil {
+		t.Error("Expected error for invalid int param")
+	}
+}
+
+func Test_cache_key(t *testing.T) {
+	definition := &Definition{Name: "GetPlayerById", Database: "main", Cache: &Cache{Key: "name-"}}
+	key := definition.CacheKey(map[string]interface{}{"name": "a&b", "age": 30})
+	if expected := "main:GetPlayerById:name-age=30&name=a%26b"; key != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, key)
+	}
+}
+
+func Test_tenant_scope(t *testing.T) {
+	conn, err := db.Open("main", &config.DB{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "main.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Column = "tenant_id"
+	_, err = conn.Exec(`CREATE TABLE posts (id INTEGER PRIMARY KEY, tenant_id TEXT NOT NULL, title TEXT);
+		INSERT INTO posts VALUES (1, 'acme', 'acme 1'), (2, 'acme', 'acme 2'), (3, 'globex', 'globex 1')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acme := tenant.WithTenant(context.Background(), &tenant.Tenant{Name: "acme"})
+	titles := func(ctx context.Context, definition *Definition, params map[string]interface{}) []interface{} {
+		t.Helper()
+		rows, errQuery := definition.Query(ctx, conn, params)
+		if errQuery != nil {
+			t.Fatalf("%s: %v", definition.Name, errQuery)
+		}
+		var list []interface{}
+		for _, row := range rows {
+			list = append(list, row["title"])
+		}
+		return list
+	}
+
+	// filtered by :tenant, the value of the caller is replaced
+	byTenant := &Definition{Name: "ByTenant", Kind: KindQuery, SQL: "SELECT title FROM posts WHERE tenant_id = :tenant ORDER BY id"}
+	if got := titles(acme, byTenant, map[string]interface{}{"tenant": "globex"}); !reflect.DeepEqual(got, []interface{}{"acme 1", "acme 2"}) {
+		t.Errorf("by tenant: got %v", got)
+	}
+	// the limit applies to the rows of the tenant
+	first := &Definition{Name: "FirstPost", Kind: KindQuery, SQL: "SELECT title FROM posts WHERE tenant_id = :tenant ORDER BY id DESC LIMIT 1"}
+	if got := titles(acme, first, nil); !reflect.DeepEqual(got, []interface{}{"acme 2"}) {
+		t.Errorf("first: got %v", got)
+	}
+
+	// not filtered by the tenant, the queries fail instead of returning the rows of all tenants
+	list := &Definition{Name: "ListPosts", Kind: KindQuery, SQL: "SELECT id, tenant_id, title FROM posts ORDER BY id"}
+	if _, err = list.Query(acme, conn, nil); err == nil {
+		t.Error("list: Expected error for a query without :tenant")
+	}
+	other := &Definition{Name: "OtherTenant", Kind: KindQuery, SQL: "SELECT * FROM posts WHERE tenant_id = :other"}
+	if _, err = other.Query(acme, conn, map[string]interface{}{"other": "globex"}); err == nil {
+		t.Error("other tenant: Expected error for a query without :tenant")
+	}
+
+	// :tenant somewhere in the SQL is not enough, the rows of the other tenants are never read
+	for _, sql := range []string{
+		"SELECT title FROM posts -- WHERE tenant_id = :tenant",
+		"SELECT title FROM posts WHERE id > 0 OR tenant_id = :tenant",
+		"SELECT title FROM posts WHERE tenant_id = :tenant OR 1=1",
+		"SELECT title FROM posts WHERE (tenant_id = :tenant OR 1=1)",
+		"SELECT title FROM posts WHERE tenant_id = :tenant UNION SELECT title FROM posts",
+		"SELECT title FROM posts WHERE tenant_id <> :tenant",
+		"SELECT title FROM posts WHERE title = :tenant",
+	} {
+		forged := &Definition{Name: "Forged", Kind: KindQuery, SQL: sql}
+		if rows, errQuery := forged.Query(acme, conn, nil); errQuery == nil {
+			t.Errorf("%s: Expected error, got %v", sql, rows)
+		}
+	}
+	aliased := &Definition{Name: "Aliased", Kind: KindQuery, SQL: "SELECT p.title FROM posts p WHERE p.id > :id AND (p.tenant_id = :tenant) ORDER BY p.id"}
+	if got := titles(acme, aliased, map[string]interface{}{"id": 0}); !reflect.DeepEqual(got, []interface{}{"acme 1", "acme 2"}) {
+		t.Errorf("aliased: got %v", got)
+	}
+	insert := &Definition{Name: "AddPost", Kind: KindCommand, SQL: "INSERT INTO posts (tenant_id, title) VALUES (:tenant, :title)"}
+	if _, errExec := insert.Exec(acme, conn, map[string]interface{}{"title": "acme 3"}); errExec != nil {
+		t.Errorf("insert: got %v", errExec)
+	}
+	if _, errExec := conn.Exec("DELETE FROM posts WHERE title = 'acme 3'"); errExec != nil {
+		t.Fatal(errExec)
+	}
+
+	// the column of the configuration
+	conn.Column = "org_id"
+	if _, err = byTenant.Query(acme, conn, nil); err == nil {
+		t.Error("other column: Expected error for a query without org_id = :tenant")
+	}
+	conn.Column = "tenant_id"
+
+	update := &Definition{Name: "UpdatePost", Kind: KindCommand, SQL: "UPDATE posts SET title = :title WHERE id = :id AND tenant_id = :tenant"}
+	if affected, errExec := update.Exec(acme, conn, map[string]interface{}{"id": 3, "title": "changed"}); errExec != nil || affected != 0 {
+		t.Errorf("update of the other tenant: Expected 0 rows, got %d %v", affected, errExec)
+	}
+	if affected, errExec := update.Exec(acme, conn, map[string]interface{}{"id": 1, "title": "changed"}); errExec != nil || affected != 1 {
+		t.Errorf("update: Expected 1 row, got %d %v", affected, errExec)
+	}
+	unscoped := &Definition{Name: "DeletePosts", Kind: KindCommand, SQL: "DELETE FROM posts"}
+	if _, err = unscoped.Exec(acme, conn, nil); err == nil {
+		t.Error("delete: Expected error for a command without :tenant")
+	}
+
+	if _, err = list.Query(context.Background(), conn, nil); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("without tenant: Expected ErrNoTenant, got %v", err)
+	}
+	var total int
+	if err = conn.QueryRow("SELECT count(*) FROM posts WHERE title <> 'changed'").Scan(&total); err != nil || total != 2 {
+		t.Errorf("Expected the other rows unchanged, got %d %v", total, err)
+	}
+}
@@ -0,0 +1,308 @@
+// Package ratelimit limits the requests per client of the routes and groups declared in the `rate-limit` block of
+// config.yaml:
+//
+//	rate-limit:
+//	  store: redis
+//	  redis: my-redis
+//	  rules:
+//	    - name: login
+//	      routes: ["POST /login"]
+//	      key: ip
+//	      limit: 5
+//	      window: 1m
+//
+// Every rule that matches the request consumes one request of the quota of its key. When one of them is exhausted the
+// request is rejected with 429 Too Many Requests and Retry-After. The responses of the limited routes carry the
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers of the most restrictive rule.
+//
+// Two algorithms are supported:
+//
+//	sliding-window  at most Limit requests in any Window, approximated by weighting the count of the previous window
+//	token-bucket    bursts of up to Burst requests, refilled at Limit requests per Window
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/syntax-framework/demo/server/apiauth"
+	"github.com/syntax-framework/demo/server/clientip"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/redis"
+	"github.com/syntax-framework/demo/server/route"
+)
+
+const (
+	SlidingWindow = "sliding-window"
+	TokenBucket   = "token-bucket"
+)
+
+var rejected = metrics.NewCounter("ratelimit_rejected_total", "Requests rejected by the rate limiter, by rule", "rule")
+
+// Policy the quota of a rule
+type Policy struct {
+	Algorithm string
+	Limit     int // Requests per Window
+	Window    time.Duration
+	Burst     int // Size of the token bucket
+}
+
+// Result of a request on the quota of a key
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Time until the quota is fully restored
+	RetryAfter time.Duration // Time until the next request is allowed, when rejected
+}
+
+// Rule a Policy applied to the requests that match one of the Routes, by the Key of the client (ip, user, api-key,
+// param:<name> or header:<name>). The header is only used with TrustProxy, it must be set by the proxy.
+type Rule struct {
+	Name   string
+	Routes []*route.Rule
+	Key    string
+	Policy Policy
+}
+
+// Limiter applies the rules to the requests, the quotas are kept in the Store
+type Limiter struct {
+	Store      Store
+	Rules      []*Rule
+	TrustProxy bool // Client IP from X-Real-IP/X-Forwarded-For, `header:<name>` keys
+	// User identifies the authenticated user of the request for the `user` key, empty for anonymous requests. The
+	// requests are limited by IP while it is nil.
+	User func(r *http.Request) string
+
+	failing int32 // the store is returning errors, logged once
+	noIP    int32 // requests without the client IP (unix sockets) were not limited, logged once
+}
+
+// New creates the limiter of the configuration, nil if there are no rules. The redis store uses the client of the named
+// connection, user identifies the authenticated user of the request (nil without authentication).
+func New(cfg config.RateLimit, clients map[string]*redis.Client, user func(r *http.Request) string) (*Limiter, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+
+	l := &Limiter{TrustProxy: cfg.TrustProxy, User: user}
+	switch cfg.Store {
+	case "", "memory":
+		l.Store = &Memory{}
+	case "redis":
+		client, exists := clients[cfg.Redis]
+		if !exists {
+			return nil, fmt.Errorf("ratelimit: there is no redis connection named %q", cfg.Redis)
+		}
+		l.Store = &Redis{Client: client, Prefix: "ratelimit:"}
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown store %q", cfg.Store)
+	}
+
+	for _, r := range cfg.Rules {
+		rule := &Rule{
+			Name: r.Name,
+			Key:  r.Key,
+			Policy: Policy{
+				Algorithm: r.Algorithm,
+				Limit:     r.Limit,
+				Window:    r.Window.Std(),
+				Burst:     r.Burst,
+			},
+		}
+		for _, value := range r.Routes {
+			parsed, err := route.ParseRule(value)
+			if err != nil {
+				return nil, fmt.Errorf("ratelimit: %s: %w", r.Name, err)
+			}
+			rule.Routes = append(rule.Routes, parsed)
+		}
+		l.Rules = append(l.Rules, rule)
+	}
+	return l, nil
+}
+
+// Handler rejects the requests that exceeded the quota of one of the rules
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limited *Rule
+		var result Result
+		for _, rule := range l.Rules {
+			params, pattern, matched := rule.match(r)
+			if !matched {
+				continue
+			}
+			key, identified := l.key(rule, r, params)
+			if !identified {
+				// a shared bucket would let one client exhaust the quota of all the others
+				if atomic.CompareAndSwapInt32(&l.noIP, 0, 1) {
+					log.Printf("ratelimit: %s: the requests on unix sockets have no client IP and are not limited by IP, enable rate-limit.trust-proxy behind a proxy", rule.Name)
+				}
+				continue
+			}
+			current, err := l.Store.Allow(r.Context(), rule.Name+":"+key, &rule.Policy)
+			if err != nil {
+				// the store being unavailable does not take the routes down
+				if atomic.CompareAndSwapInt32(&l.failing, 0, 1) {
+					log.Printf("ratelimit: %v, the requests are allowed until the store recovers", err)
+				}
+				continue
+			}
+			if atomic.CompareAndSwapInt32(&l.failing, 1, 0) {
+				log.Printf("ratelimit: store recovered")
+			}
+			if limited == nil || restrictive(current, result) {
+				limited, result = rule, current
+			}
+			if !current.Allowed {
+				route.SetPattern(r, pattern)
+			}
+		}
+
+		if limited == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", seconds(result.Reset))
+		header.Set("RateLimit-Policy", limited.Policy.String())
+		if result.Allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rejected.Inc(limited.Name)
+		header.Set("Retry-After", seconds(result.RetryAfter))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	})
+}
+
+// match returns the params and the pattern of the first route of the rule that matches the request
+func (r *Rule) match(req *http.Request) (map[string]string, string, bool) {
+	for _, rt := range r.Routes {
+		if params, matched := rt.Match(req); matched {
+			return params, rt.Pattern, true
+		}
+	}
+	return nil, "", false
+}
+
+// key identifies the client of the request for the rule, requests without the value of the key (Ex. anonymous user,
+// unauthenticated API client, header without trust-proxy) are limited by IP. Reports false when there is no IP either
+// (unix sockets without trust-proxy).
+func (l *Limiter) key(rule *Rule, r *http.Request, params map[string]string) (string, bool) {
+	switch {
+	case rule.Key == "user":
+		if l.User != nil {
+			if user := l.User(r); user != "" {
+				return "user:" + user, true
+			}
+		}
+	case rule.Key == "api-key":
+		// the client verified by server/apiauth, the raw header would give a new quota to each forged value
+		if c := apiauth.FromRequest(r); c != nil {
+			return "api-key:" + c.Principal(), true
+		}
+	case strings.HasPrefix(rule.Key, "param:"):
+		if value := params[strings.TrimPrefix(rule.Key, "param:")]; value != "" {
+			return rule.Key + "=" + value, true
+		}
+	case strings.HasPrefix(rule.Key, "header:"):
+		// any client can send the header, only the value set by a trusted proxy identifies it
+		if value := r.Header.Get(strings.TrimPrefix(rule.Key, "header:")); value != "" && l.TrustProxy {
+			return rule.Key + "=" + value, true
+		}
+	}
+	ip := clientip.FromRequest(r, l.TrustProxy)
+	return "ip:" + ip, ip != ""
+}
+
+// restrictive reports whether a is more restrictive than b
+func restrictive(a Result, b Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+// String the value of the RateLimit-Policy header (Ex. "100;w=60", "100;w=60;burst=20")
+func (p *Policy) String() string {
+	value := strconv.Itoa(p.Limit) + ";w=" + seconds(p.Window)
+	if p.Algorithm == TokenBucket && p.Burst != p.Limit {
+		value += ";burst=" + strconv.Itoa(p.Burst)
+	}
+	return value
+}
+
+// take consumes one token of a bucket that has the given tokens, already refilled. Returns the tokens left.
+func (p *Policy) take(tokens float64) (Result, float64) {
+	rate := float64(p.Limit) / float64(p.Window) // tokens per nanosecond
+	result := Result{Limit: p.Burst}
+	if tokens >= 1 {
+		result.Allowed = true
+		tokens--
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - tokens) / rate))
+	}
+	result.Remaining = int(tokens)
+	result.Reset = time.Duration(math.Ceil((float64(p.Burst) - tokens) / rate))
+	return result, tokens
+}
+
+// refill adds the tokens produced in the elapsed time, up to Burst
+func (p *Policy) refill(tokens float64, elapsed time.Duration) float64 {
+	if elapsed > 0 {
+		tokens += float64(elapsed) * float64(p.Limit) / float64(p.Window)
+	}
+	return math.Min(tokens, float64(p.Burst))
+}
+
+// count checks a request on the sliding window, given the requests of the previous and of the current fixed windows
+// and the time elapsed since the current one started. The previous window counts for the part of it that overlaps
+// the sliding window.
+func (p *Policy) count(previous int, current int, elapsed time.Duration) Result {
+	limit, window := float64(p.Limit), float64(p.Window)
+	weight := 1 - float64(elapsed)/window
+	estimate := float64(previous)*weight + float64(current)
+
+	result := Result{Limit: p.Limit, Reset: p.Window - elapsed}
+	if estimate+1 <= limit {
+		result.Allowed = true
+		result.Remaining = int(limit - estimate - 1)
+		return result
+	}
+
+	if previous > 0 && float64(current)+1 <= limit {
+		// the previous window slides out of the sliding window
+		needed := (limit - float64(current) - 1) / float64(previous)
+		result.RetryAfter = time.Duration((1-needed)*window) - elapsed
+	} else {
+		// wait for the current window to slide out
+		result.RetryAfter = p.Window - elapsed
+		if needed := (limit - 1) / float64(current); needed < 1 {
+			result.RetryAfter += time.Duration((1 - needed) * window)
+		}
+	}
+	if result.RetryAfter < time.Millisecond {
+		result.RetryAfter = time.Millisecond
+	}
+	return result
+}
+
+// seconds rounded up, as used by the headers
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
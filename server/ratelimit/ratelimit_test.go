@@ -0,0 +1,203 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/syntax-framework/demo/server/apiauth"
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/route"
+)
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func Test_sliding_window(t *testing.T) {
+	c := &clock{now: time.Unix(1000*60, 0)} // start of a window
+	store := &Memory{now: c.Now}
+	policy := &Policy{Algorithm: SlidingWindow, Limit: 3, Window: time.Minute}
+
+	for i := 2; i >= 0; i-- {
+		result, _ := store.Allow(context.Background(), "k", policy)
+		if !result.Allowed || result.Remaining != i {
+			t.Fatalf("request %d: got %+v", 3-i, result)
+		}
+	}
+	result, _ := store.Allow(context.Background(), "k", policy)
+	if result.Allowed || result.RetryAfter != time.Minute+20*time.Second {
+		t.Fatalf("expected rejection until 2/3 of the window slides out, got %+v", result)
+	}
+
+	// 1/3 of the previous window still counts (1 request)
+	c.now = c.now.Add(time.Minute + 40*time.Second)
+	result, _ = store.Allow(context.Background(), "k", policy)
+	if !result.Allowed || result.Remaining != 1 || result.Reset != 20*time.Second {
+		t.Fatalf("got %+v", result)
+	}
+	store.Allow(context.Background(), "k", policy)
+	result, _ = store.Allow(context.Background(), "k", policy)
+	if result.Allowed || result.RetryAfter != 20*time.Second {
+		t.Fatalf("got %+v", result)
+	}
+
+	// other keys have their own quota
+	if result, _ = store.Allow(context.Background(), "other", policy); !result.Allowed {
+		t.Fatalf("got %+v", result)
+	}
+}
+
+func Test_token_bucket(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	store := &Memory{now: c.Now}
+	policy := &Policy{Algorithm: TokenBucket, Limit: 60, Window: time.Minute, Burst: 2} // 1 per second
+
+	for i := 1; i >= 0; i-- {
+		result, _ := store.Allow(context.Background(), "k", policy)
+		if !result.Allowed || result.Remaining != i || result.Limit != 2 {
+			t.Fatalf("got %+v", result)
+		}
+	}
+	result, _ := store.Allow(context.Background(), "k", policy)
+	if result.Allowed || result.RetryAfter != time.Second || result.Reset != 2*time.Second {
+		t.Fatalf("got %+v", result)
+	}
+
+	c.now = c.now.Add(500 * time.Millisecond)
+	result, _ = store.Allow(context.Background(), "k", policy)
+	if result.Allowed || result.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("got %+v", result)
+	}
+
+	// refilled up to the burst
+	c.now = c.now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if result, _ = store.Allow(context.Background(), "k", policy); !result.Allowed {
+			t.Fatalf("got %+v", result)
+		}
+	}
+	if result, _ = store.Allow(context.Background(), "k", policy); result.Allowed {
+		t.Fatalf("got %+v", result)
+	}
+}
+
+func Test_handler(t *testing.T) {
+	limiter, err := New(config.RateLimit{Rules: []*config.RateLimitRule{
+		{Name: "login", Routes: []string{"POST /login"}, Key: "ip", Algorithm: SlidingWindow, Limit: 2, Window: config.Duration(time.Minute), Burst: 2},
+		{Name: "api", Routes: []string{"/api/:tenant/*"}, Key: "param:tenant", Algorithm: TokenBucket, Limit: 1, Window: config.Duration(time.Minute), Burst: 1},
+	}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	do := func(method string, path string, remote string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = remote + ":1234"
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/login", "10.0.0.1")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" ||
+		w.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+	do(http.MethodPost, "/login", "10.0.0.1")
+	w = do(http.MethodPost, "/login", "10.0.0.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+	if w = do(http.MethodPost, "/login", "10.0.0.2"); w.Code != http.StatusOK {
+		t.Fatalf("other ip: got %d", w.Code)
+	}
+
+	// routes without rules are not limited
+	if w = do(http.MethodGet, "/login", "10.0.0.1"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+
+	// keyed by the route param
+	if w = do(http.MethodGet, "/api/acme/users", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+	if w = do(http.MethodGet, "/api/acme/orders", "10.0.0.2"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+	if w = do(http.MethodGet, "/api/other/users", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+}
+
+func Test_key(t *testing.T) {
+	l := &Limiter{}
+	rule := func(key string) *Rule {
+		return &Rule{Key: key}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Tenant", "acme")
+
+	if key, _ := l.key(rule("user"), r, nil); key != "ip:10.0.0.1" {
+		t.Errorf("anonymous user: got %s", key)
+	}
+	l.User = func(r *http.Request) string { return "42" }
+	if key, _ := l.key(rule("user"), r, nil); key != "user:42" {
+		t.Errorf("user: got %s", key)
+	}
+	// the Bearer header is not verified here, only the client authenticated by server/apiauth has its own quota
+	if key, _ := l.key(rule("api-key"), r, nil); key != "ip:10.0.0.1" {
+		t.Errorf("api-key not verified: got %s", key)
+	}
+	verified := r.WithContext(apiauth.WithCredential(r.Context(), &apiauth.Credential{Kind: apiauth.KindToken, ID: "a1"}))
+	if key, _ := l.key(rule("api-key"), verified, nil); key != "api-key:token:a1" {
+		t.Errorf("api-key: got %s", key)
+	}
+	// the header can be forged by the client, it is only used behind a trusted proxy
+	if key, _ := l.key(rule("header:X-Tenant"), r, nil); key != "ip:10.0.0.1" {
+		t.Errorf("header without trust proxy: got %s", key)
+	}
+	if key, _ := l.key(rule("param:name"), r, map[string]string{"name": "alex"}); key != "param:name=alex" {
+		t.Errorf("param: got %s", key)
+	}
+
+	// unix sockets have no client IP, the requests are not limited instead of sharing one quota
+	socket := httptest.NewRequest(http.MethodGet, "/", nil)
+	socket.RemoteAddr = "@"
+	if key, identified := l.key(rule("ip"), socket, nil); identified {
+		t.Errorf("unix socket: got %s", key)
+	}
+
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	l.TrustProxy = true
+	if key, _ := l.key(rule("ip"), r, nil); key != "ip:2.2.2.2" {
+		t.Errorf("trust proxy: got %s", key)
+	}
+	if key, _ := l.key(rule("header:X-Tenant"), r, nil); key != "header:X-Tenant=acme" {
+		t.Errorf("header: got %s", key)
+	}
+}
+
+func Test_rejected_pattern(t *testing.T) {
+	limiter, _ := New(config.RateLimit{Rules: []*config.RateLimitRule{
+		{Name: "webhooks", Routes: []string{"/integrations/inbound/*"}, Key: "ip", Algorithm: SlidingWindow, Limit: 1, Window: config.Duration(time.Minute)},
+	}}, nil, nil)
+	handler := limiter.Handler(http.NotFoundHandler())
+
+	var info *route.Info
+	for i := 0; i < 2; i++ {
+		w, r, tracked := route.Track(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/integrations/inbound/github", nil))
+		handler.ServeHTTP(w, r)
+		info = tracked
+	}
+	if info.Status != http.StatusTooManyRequests || info.Pattern != "/integrations/inbound/*" {
+		t.Errorf("got %+v", info)
+	}
+}
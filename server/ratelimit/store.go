@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/redis"
+)
+
+// Store keeps the quotas of the keys
+type Store interface {
+	// Allow consumes one request of the quota of the key, if available
+	Allow(ctx context.Context, key string, policy *Policy) (Result, error)
+}
+
+// sweepInterval of the expired keys of the Memory store
+const sweepInterval = time.Minute
+
+// Memory keeps the quotas in the process, each instance of the application has its own
+type Memory struct {
+	mutex     sync.Mutex
+	entries   map[string]*state
+	nextSweep time.Time
+	now       func() time.Time // for tests
+}
+
+type state struct {
+	tokens   float64 // token bucket
+	updated  time.Time
+	window   int64 // sliding window, index of the current fixed window
+	current  int
+	previous int
+	expires  time.Time
+}
+
+func (m *Memory) Allow(_ context.Context, key string, policy *Policy) (Result, error) {
+	now := time.Now()
+	if m.now != nil {
+		now = m.now()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.entries == nil {
+		m.entries = map[string]*state{}
+	}
+	if now.After(m.nextSweep) {
+		for k, s := range m.entries {
+			if now.After(s.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.nextSweep = now.Add(sweepInterval)
+	}
+
+	s, exists := m.entries[key]
+	if !exists {
+		s = &state{tokens: float64(policy.Burst), updated: now}
+		m.entries[key] = s
+	}
+
+	var result Result
+	if policy.Algorithm == TokenBucket {
+		result, s.tokens = policy.take(policy.refill(s.tokens, now.Sub(s.updated)))
+		s.updated = now
+		s.expires = now.Add(result.Reset)
+		return result, nil
+	}
+
+	index := now.UnixNano() / int64(policy.Window)
+	switch index - s.window {
+	case 0:
+	case 1:
+		s.previous, s.current = s.current, 0
+	default:
+		s.previous, s.current = 0, 0
+	}
+	s.window = index
+
+	result = policy.count(s.previous, s.current, time.Duration(now.UnixNano()-index*int64(policy.Window)))
+	if result.Allowed {
+		s.current++
+	}
+	s.expires = now.Add(2 * policy.Window)
+	return result, nil
+}
+
+// tokenBucketScript refills and takes one token, returning the tokens available before taking. Uses the clock of the
+// redis server, shared by all the instances.
+const tokenBucketScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local burst, rate = tonumber(ARGV[1]), tonumber(ARGV[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+local left = tokens
+if tokens >= 1 then left = tokens - 1 end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(left), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - left) / rate) + 1000)
+return tostring(tokens)
+`
+
+// slidingWindowScript counts the request on the current fixed window when allowed, returning the requests of the
+// previous and of the current windows, before counting, and the milliseconds elapsed in the current window.
+const slidingWindowScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit, window = tonumber(ARGV[1]), tonumber(ARGV[2])
+local index = math.floor(now / window)
+local previous = tonumber(redis.call('HGET', KEYS[1], tostring(index - 1)) or 0)
+local current = tonumber(redis.call('HGET', KEYS[1], tostring(index)) or 0)
+local elapsed = now - index * window
+if previous * (1 - elapsed / window) + current + 1 <= limit then
+	redis.call('HINCRBY', KEYS[1], tostring(index), 1)
+	redis.call('HDEL', KEYS[1], tostring(index - 2))
+	redis.call('PEXPIRE', KEYS[1], window * 2)
+end
+return {previous, current, elapsed}
+`
+
+// Redis keeps the quotas on a redis server (5 or newer), shared by all the instances of the application
+type Redis struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (s *Redis) Allow(ctx context.Context, key string, policy *Policy) (Result, error) {
+	if policy.Algorithm == TokenBucket {
+		rate := float64(policy.Limit) / float64(policy.Window.Milliseconds()) // tokens per millisecond
+		reply, err := s.Client.Do(ctx, "EVAL", tokenBucketScript, 1, s.Prefix+key,
+			policy.Burst, strconv.FormatFloat(rate, 'g', -1, 64))
+		if err != nil {
+			return Result{}, err
+		}
+		value, _ := reply.(string)
+		tokens, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+		}
+		result, _ := policy.take(tokens)
+		return result, nil
+	}
+
+	reply, err := s.Client.Do(ctx, "EVAL", slidingWindowScript, 1, s.Prefix+key, policy.Limit, policy.Window.Milliseconds())
+	if err != nil {
+		return Result{}, err
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != 3 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	}
+	var counts [3]int64
+	for i, value := range values {
+		count, isInt := value.(int64)
+		if !isInt {
+			return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+		}
+		counts[i] = count
+	}
+	return policy.count(int(counts[0]), int(counts[1]), time.Duration(counts[2])*time.Millisecond), nil
+}
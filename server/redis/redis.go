@@ -0,0 +1,257 @@
+// Package redis is a minimal client of the redis protocol (RESP), used by the subsystems that accept a connection of
+// the `redis` block of config.yaml.
+//
+// The URI follows the redis scheme: `redis://[:password@]host[:port][/db]`, `rediss://` for TLS.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNil returned when the key does not exist (nil reply)
+var ErrNil = errors.New("redis: nil")
+
+// Error reply of the server (Ex. "WRONGTYPE ...")
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// maxIdle connections kept open by the client
+const maxIdle = 8
+
+// Client a connection pool to a redis server, safe for concurrent use
+type Client struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	TLS      *tls.Config // not nil for rediss://
+
+	idle chan *conn
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// ParseURI creates the client of the URI, no connection is opened
+func ParseURI(uri string) (*Client, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil || (parsed.Scheme != "redis" && parsed.Scheme != "rediss") || parsed.Host == "" {
+		return nil, fmt.Errorf("redis: invalid uri %q, expected redis://[:password@]host[:port][/db]", uri)
+	}
+
+	c := &Client{Addr: parsed.Host}
+	if parsed.Port() == "" {
+		c.Addr = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if parsed.User != nil {
+		c.Username = parsed.User.Username()
+		c.Password, _ = parsed.User.Password()
+	}
+	if db := strings.Trim(parsed.Path, "/"); db != "" {
+		if c.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis: invalid database %q in uri", db)
+		}
+	}
+	if parsed.Scheme == "rediss" {
+		c.TLS = &tls.Config{ServerName: parsed.Hostname()}
+	}
+	return c, nil
+}
+
+// Do sends the command and returns the reply: string, int64, []interface{} or nil
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.SetDeadline(deadline)
+	} else {
+		cn.SetDeadline(time.Time{})
+	}
+
+	reply, err := cn.do(args)
+	if err != nil {
+		var replyErr Error
+		if !errors.As(err, &replyErr) && err != ErrNil {
+			// broken connection
+			cn.Close()
+			return nil, err
+		}
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Ping checks the connection, used by the readiness check
+func (c *Client) Ping(ctx context.Context) error {
+	reply, err := c.Do(ctx, "PING")
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("redis: unexpected reply to PING: %v", reply)
+	}
+	return nil
+}
+
+// Close closes the idle connections
+func (c *Client) Close() error {
+	if c.idle == nil {
+		return nil
+	}
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	if c.idle == nil {
+		c.idle = make(chan *conn, maxIdle)
+	}
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{}
+	var nc net.Conn
+	var err error
+	if c.TLS != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: c.TLS}).DialContext(ctx, "tcp", c.Addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", c.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{Conn: nc, reader: bufio.NewReader(nc)}
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.SetDeadline(deadline)
+	}
+	if c.Password != "" {
+		auth := []interface{}{"AUTH", c.Password}
+		if c.Username != "" {
+			auth = []interface{}{"AUTH", c.Username, c.Password}
+		}
+		if _, err = cn.do(auth); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.DB != 0 {
+		if _, err = cn.do([]interface{}{"SELECT", c.DB}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (cn *conn) do(args []interface{}) (interface{}, error) {
+	buf := &strings.Builder{}
+	buf.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var value string
+		switch v := arg.(type) {
+		case string:
+			value = v
+		case []byte:
+			value = string(v)
+		case int:
+			value = strconv.Itoa(v)
+		case int64:
+			value = strconv.FormatInt(v, 10)
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			value = fmt.Sprint(v)
+		}
+		buf.WriteString("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+	}
+	if _, err := io.WriteString(cn, buf.String()); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+func (cn *conn) read() (interface{}, error) {
+	line, err := cn.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, Error(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, errSize := strconv.Atoi(value)
+		if errSize != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if size < 0 {
+			return nil, ErrNil
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(cn.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, errSize := strconv.Atoi(value)
+		if errSize != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if size < 0 {
+			return nil, ErrNil
+		}
+		items := make([]interface{}, size)
+		for i := range items {
+			items[i], err = cn.read()
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
@@ -0,0 +1,144 @@
+// Package requestid identifies each request, the ID is shown on the error pages and written on the logs so the
+// support can correlate a ticket with the logs.
+//
+// An inbound X-Request-ID (Ex. set by the load balancer) is kept when valid, otherwise a new ID is generated. The IDs
+// generated are sortable by time: 48 bits of milliseconds followed by 80 random bits, in Crockford's base32
+// (Ex. 01JAAZ5X6Q0S3B8V4C2N7M9K1D).
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Header of the request and of the response
+const Header = "X-Request-ID"
+
+// MaxLength of an inbound ID
+const MaxLength = 128
+
+type contextKey struct{}
+
+// Handler sets the ID of the request on the context and on the response header
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// NewContext returns a copy of the context with the ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the ID of the request, empty if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromRequest returns the ID of the request, empty if there is none
+func FromRequest(r *http.Request) string {
+	return FromContext(r.Context())
+}
+
+// Valid reports whether the inbound ID can be used: up to MaxLength letters, digits and `-_.:`. Any other value could
+// break the log formats.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var (
+	mutex    sync.Mutex
+	lastTime int64
+	lastID   [16]byte
+)
+
+// New generates an ID. The IDs of the same millisecond increment the random part, keeping the order.
+func New() string {
+	now := time.Now().UnixMilli()
+
+	mutex.Lock()
+	id := lastID
+	if now == lastTime {
+		// increment the 80 random bits
+		for i := 15; i >= 6; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+	} else {
+		rand.Read(id[6:])
+		for i := 0; i < 6; i++ {
+			id[i] = byte(now >> (40 - 8*i))
+		}
+	}
+	lastTime, lastID = now, id
+	mutex.Unlock()
+
+	return encode(id)
+}
+
+// encode the 128 bits as 26 base32 chars, the first char holds only 3 bits
+func encode(id [16]byte) string {
+	out := make([]byte, 26)
+	// 130 bits of output, the 2 most significant are zero
+	var carry uint
+	var bits uint
+	pos := 25
+	for i := 15; i >= 0; i-- {
+		carry |= uint(id[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			out[pos] = crockford[carry&31]
+			pos--
+			carry >>= 5
+			bits -= 5
+		}
+	}
+	out[0] = crockford[carry&31]
+	return string(out)
+}
+
+// Transport sets the ID of the request being served on the outbound requests
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := FromContext(req.Context())
+	if id == "" || req.Header.Get(Header) != "" {
+		return t.base.RoundTrip(req)
+	}
+	// the RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, id)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,87 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func Test_new(t *testing.T) {
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = New()
+		if len(ids[i]) != 26 || !Valid(ids[i]) {
+			t.Fatalf("invalid id %q", ids[i])
+		}
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Error("Expected the ids to be sorted")
+	}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicated id %s", id)
+		}
+		seen[id] = true
+	}
+}
+
+func Test_encode(t *testing.T) {
+	max := [16]byte{}
+	for i := range max {
+		max[i] = 0xff
+	}
+	if got := encode(max); got != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("got %s", got)
+	}
+	if got := encode([16]byte{15: 1}); got != "00000000000000000000000001" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func Test_handler(t *testing.T) {
+	var inContext string
+	var outbound string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outbound = r.Header.Get(Header)
+	}))
+	defer remote.Close()
+	client := &http.Client{Transport: Transport(nil)}
+
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inContext = FromRequest(r)
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, remote.URL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}))
+
+	for _, test := range []struct {
+		inbound string
+		keep    bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"with space", false},
+		{"line\nbreak", false},
+		{string(make([]byte, MaxLength+1)), false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.inbound != "" {
+			req.Header.Set(Header, test.inbound)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		echoed := rec.Header().Get(Header)
+		if test.keep && echoed != test.inbound || !test.keep && (echoed == test.inbound || len(echoed) != 26) {
+			t.Errorf("%q: got %q", test.inbound, echoed)
+		}
+		if inContext != echoed || outbound != echoed {
+			t.Errorf("%q: Expected %q on the context and on the outbound request, got %q and %q", test.inbound, echoed, inContext, outbound)
+		}
+	}
+}
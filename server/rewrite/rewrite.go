@@ -0,0 +1,221 @@
+// Package rewrite changes the HTML documents written by the handlers, used by the middlewares that add content to the
+// rendered pages (Ex. the CSP nonces and the CSRF tokens).
+//
+// The HTML responses are buffered and passed to the function of the middleware before the header is sent, the other
+// responses (Ex. assets, JSON, streams) are not changed.
+package rewrite
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Writer buffers the HTML responses to rewrite them, must be closed after the handler returns
+type Writer struct {
+	http.ResponseWriter
+	rewrite func(document []byte) []byte
+	status  int
+	decided bool
+	html    bool
+	buffer  []byte
+}
+
+// HTML creates the writer that calls rewrite with the HTML documents written to w
+func HTML(w http.ResponseWriter, rewrite func(document []byte) []byte) *Writer {
+	return &Writer{ResponseWriter: w, rewrite: rewrite}
+}
+
+func (w *Writer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.decide(data)
+	}
+	if w.html {
+		w.buffer = append(w.buffer, data...)
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// decide checks the type of the response, the header is sent right away when it is not HTML
+func (w *Writer) decide(data []byte) {
+	w.decided = true
+	header := w.Header()
+	contentType := header.Get("Content-Type")
+	if contentType == "" && len(data) > 0 {
+		// the same detection done by net/http, the header must be set before deciding
+		contentType = http.DetectContentType(data)
+		header.Set("Content-Type", contentType)
+	}
+	w.html = strings.HasPrefix(contentType, "text/html") && header.Get("Content-Encoding") == ""
+	if !w.html {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+// Flush sends the buffered document, each flushed part is rewritten separately. The end of the buffer that is not
+// complete (Ex. half of a tag, the text of a script still open) is kept until the next write or Close.
+func (w *Writer) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.decide(w.buffer)
+	}
+	if w.html {
+		w.send(false)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the original writer, used by http.ResponseController
+func (w *Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close sends the buffered document
+func (w *Writer) Close() {
+	if !w.decided {
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		return
+	}
+	if w.html {
+		w.send(true)
+	}
+}
+
+func (w *Writer) send(last bool) {
+	n := len(w.buffer)
+	if !last {
+		n = complete(w.buffer)
+	}
+	document := w.rewrite(w.buffer[:n])
+	w.buffer = append([]byte(nil), w.buffer[n:]...)
+	if w.status != 0 {
+		if last {
+			w.Header().Set("Content-Length", strconv.Itoa(len(document)))
+		} else {
+			w.Header().Del("Content-Length")
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+		w.status = 0
+	}
+	w.ResponseWriter.Write(document)
+}
+
+// Tag a start tag of the document
+type Tag struct {
+	Name  string            // Lower case
+	Attrs map[string]string // Lower-case names, the values are unescaped
+}
+
+// StartTags copies the document calling edit for every start tag. The attributes returned by edit (Ex. ` nonce="x"`)
+// are added to the tag and the content is inserted after it. Comments and the contents of the elements (Ex. the
+// text of the scripts) are not changed.
+func StartTags(document []byte, edit func(tag *Tag) (attrs string, content string)) []byte {
+	out := bytes.NewBuffer(make([]byte, 0, len(document)+256))
+	z := html.NewTokenizer(bytes.NewReader(document))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// a tag not closed at the end of the document
+			out.Write(z.Raw())
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(z.Raw())
+			continue
+		}
+
+		// TagName lower-cases the bytes of Raw
+		raw := append([]byte(nil), z.Raw()...)
+		name, hasAttr := z.TagName()
+		tag := &Tag{Name: string(name), Attrs: map[string]string{}}
+		for hasAttr {
+			var key, value []byte
+			key, value, hasAttr = z.TagAttr()
+			if _, exists := tag.Attrs[string(key)]; !exists {
+				tag.Attrs[string(key)] = string(value)
+			}
+		}
+
+		attrs, content := edit(tag)
+		if attrs == "" {
+			out.Write(raw)
+		} else {
+			out.Write(raw[:1+len(name)])
+			out.WriteString(attrs)
+			out.Write(raw[1+len(name):])
+		}
+		out.WriteString(content)
+	}
+	return out.Bytes()
+}
+
+// rawText the elements whose content is read as text until their end tag, as the tokenizer does
+var rawText = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true,
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
+}
+
+// complete returns the size of the start of the document that can be rewritten apart from the rest: it does not end in
+// the middle of a tag, a comment or the content of a raw text element (Ex. script), which would be tokenized otherwise
+// when joined with the next part.
+func complete(document []byte) int {
+	z := html.NewTokenizer(bytes.NewReader(document))
+	offset, end := 0, 0
+	open := false // inside a raw text element
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// the tag not closed at the end is not a token
+			return end
+		}
+		raw := z.Raw()
+		start := offset
+		offset += len(raw)
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			if rawText[string(name)] {
+				open = true
+			}
+		case html.EndTagToken:
+			// inside a raw text element only its end tag is a token
+			open = false
+		case html.CommentToken:
+			if !bytes.HasSuffix(raw, []byte(">")) || (bytes.HasPrefix(raw, []byte("<!--")) && !bytes.HasSuffix(raw, []byte("-->"))) {
+				continue
+			}
+		case html.DoctypeToken:
+			if !bytes.HasSuffix(raw, []byte(">")) {
+				continue
+			}
+		case html.TextToken:
+			// Ex. "a <", the start of a tag
+			if i := bytes.LastIndexByte(raw, '<'); i >= 0 && !open {
+				end = start + i
+				continue
+			}
+		}
+		if !open {
+			end = offset
+		}
+	}
+}
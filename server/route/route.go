@@ -0,0 +1,93 @@
+// Package route keeps the information of a request collected while it is handled: the matched route pattern (Ex.
+// "/user/:name", a low-cardinality label) and the status and size of the response. Shared by the access log, the
+// metrics and the tracing middlewares.
+//
+// The first middleware calls Track, the routers call SetPattern when a route matches.
+package route
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Info of the request
+type Info struct {
+	Pattern string // Matched route pattern, empty when no route matched
+	Status  int    // Response status, 200 when the handler did not call WriteHeader
+	Bytes   int64  // Response body size
+	Start   time.Time
+}
+
+type infoKey struct{}
+
+// Track returns the writer and the request that record the Info of the request. Calling it again for the same
+// request returns the same Info.
+func Track(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, *Info) {
+	if info := FromRequest(r); info != nil {
+		return w, r, info
+	}
+	info := &Info{Start: time.Now()}
+	r = r.WithContext(context.WithValue(r.Context(), infoKey{}, info))
+	return &Writer{ResponseWriter: w, info: info}, r, info
+}
+
+// FromRequest returns the Info of the request, nil if it is not tracked
+func FromRequest(r *http.Request) *Info {
+	info, _ := r.Context().Value(infoKey{}).(*Info)
+	return info
+}
+
+// SetPattern records the route pattern that matched the request
+func SetPattern(r *http.Request, pattern string) {
+	if info := FromRequest(r); info != nil {
+		info.Pattern = pattern
+	}
+}
+
+// Pattern returns the route pattern that matched the request, empty if none
+func Pattern(r *http.Request) string {
+	if info := FromRequest(r); info != nil {
+		return info.Pattern
+	}
+	return ""
+}
+
+// Writer records the status and size of the response
+type Writer struct {
+	http.ResponseWriter
+	info        *Info
+	wroteHeader bool
+}
+
+func (w *Writer) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.info.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.info.Bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses (Server-Sent Events) working
+func (w *Writer) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the original writer, used by http.ResponseController
+func (w *Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,79 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_track(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r, _ = Track(w, r) // nested middleware, same info
+		SetPattern(r, "/user/:name")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+	})
+
+	w, r, info := Track(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user/alex", nil))
+	handler.ServeHTTP(w, r)
+
+	if info.Pattern != "/user/:name" || info.Status != http.StatusCreated || info.Bytes != 5 {
+		t.Errorf("got %+v", info)
+	}
+	if Pattern(r) != "/user/:name" {
+		t.Errorf("Pattern: got %s", Pattern(r))
+	}
+
+	// without WriteHeader
+	w, r, info = Track(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	w.Write([]byte("ok"))
+	if info.Status != http.StatusOK || info.Pattern != "" {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func Test_rule(t *testing.T) {
+	tests := []struct {
+		rule   string
+		method string
+		path   string
+		match  bool
+		params map[string]string
+	}{
+		{"POST /login", http.MethodPost, "/login", true, nil},
+		{"POST /login", http.MethodGet, "/login", false, nil},
+		{"/login", http.MethodGet, "/login/", true, nil},
+		{"/user/:name", http.MethodGet, "/user/alex", true, map[string]string{"name": "alex"}},
+		{"/user/:name", http.MethodGet, "/user/alex/edit", false, nil},
+		{"/user/:name", http.MethodGet, "/user", false, nil},
+		{"/api/*", http.MethodGet, "/api", true, nil},
+		{"/api/*", http.MethodGet, "/api/v1/users", true, nil},
+		{"/api/*", http.MethodGet, "/apis", false, nil},
+		{"/files/*filepath", http.MethodGet, "/files/a/b.txt", true, map[string]string{"filepath": "/a/b.txt"}},
+		{"/", http.MethodGet, "/", true, nil},
+		{"/", http.MethodGet, "/index", false, nil},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, match := rule.Match(httptest.NewRequest(tt.method, tt.path, nil))
+		if match != tt.match || len(params) != len(tt.params) {
+			t.Errorf("%s %s %s: got %v %v", tt.rule, tt.method, tt.path, match, params)
+			continue
+		}
+		for name, value := range tt.params {
+			if params[name] != value {
+				t.Errorf("%s %s: param %s, got %q", tt.rule, tt.path, name, params[name])
+			}
+		}
+	}
+
+	for _, invalid := range []string{"login", "/files/*/x", "/user/:id:name"} {
+		if _, err := ParseRule(invalid); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package route
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Rule selects requests by method and path pattern, used by the middlewares configured per route or group in
+// config.yaml (Ex. "POST /login", "/user/:id", "/integrations/inbound/*").
+//
+// The method is optional. The pattern uses the syntax of the router, `:name` matches one segment and a trailing
+// `*name` matches the rest of the path, so "/api/*" is the group of all routes below /api (including /api itself).
+type Rule struct {
+	Method  string // Empty matches any method
+	Pattern string
+	parts   []string
+}
+
+// ParseRule parses "[METHOD ]pattern"
+func ParseRule(value string) (*Rule, error) {
+	rule := &Rule{}
+	value = strings.TrimSpace(value)
+	if i := strings.IndexByte(value, ' '); i > 0 {
+		rule.Method = strings.ToUpper(value[:i])
+		value = strings.TrimSpace(value[i+1:])
+	}
+	if !strings.HasPrefix(value, "/") {
+		return nil, errors.New("invalid route '" + value + "', expected a path starting with '/'")
+	}
+
+	rule.Pattern = path.Clean(value)
+	segments := strings.Split(strings.Trim(rule.Pattern, "/"), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "*") && i != len(segments)-1 {
+			return nil, errors.New("catch-all is only allowed at the end of the route '" + value + "'")
+		}
+		if strings.ContainsAny(strings.TrimLeft(segment, ":*"), ":*") {
+			return nil, errors.New("only one wildcard per path segment is allowed in '" + value + "'")
+		}
+	}
+	if rule.Pattern != "/" {
+		rule.parts = segments
+	}
+	return rule, nil
+}
+
+// Match reports whether the request matches the rule, returning the values of the pattern params
+func (r *Rule) Match(req *http.Request) (map[string]string, bool) {
+	if r.Method != "" && r.Method != req.Method {
+		return nil, false
+	}
+
+	var segments []string
+	if p := strings.Trim(req.URL.Path, "/"); p != "" {
+		segments = strings.Split(p, "/")
+	}
+
+	var params map[string]string
+	set := func(name string, value string) {
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[name] = value
+	}
+
+	for i, part := range r.parts {
+		if strings.HasPrefix(part, "*") {
+			if name := part[1:]; name != "" {
+				set(name, "/"+strings.Join(segments[minInt(i, len(segments)):], "/"))
+			}
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(part, ":") {
+			set(part[1:], segments[i])
+		} else if part != segments[i] {
+			return nil, false
+		}
+	}
+	if len(segments) != len(r.parts) {
+		return nil, false
+	}
+	return params, true
+}
+
+func (r *Rule) String() string {
+	if r.Method == "" {
+		return r.Pattern
+	}
+	return r.Method + " " + r.Pattern
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
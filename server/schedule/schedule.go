@@ -0,0 +1,234 @@
+// Package schedule runs the jobs declared in schedule/*.yaml.
+//
+// A job runs the commands declared in db/ when one of its triggers is fired (Ex. by the `triggers` of a command), or
+// periodically:
+//
+//	name: SendEmailsToUsers
+//	when:
+//	  name: my-trigger  # trigger that runs the job
+//	  every: 1h         # interval, optional
+//	commands:
+//	  - UpdateUsers
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syntax-framework/demo/server/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	jobRuns     = metrics.NewCounter("schedule_job_runs_total", "Runs of the scheduled jobs", "job")
+	jobFailures = metrics.NewCounter("schedule_job_failures_total", "Failed runs of the scheduled jobs", "job")
+)
+
+// Job a scheduled job
+type Job struct {
+	Name     string   `yaml:"name"`
+	When     When     `yaml:"when"`
+	Commands []string `yaml:"commands"`
+
+	File string `yaml:"-"`
+}
+
+type When struct {
+	Name  string `yaml:"name"`  // Trigger
+	Every string `yaml:"every"` // Go duration (Ex. 30s, 1h)
+
+	interval time.Duration
+}
+
+// Interval returns the parsed `every`, 0 when the job is not periodic
+func (w When) Interval() time.Duration {
+	return w.interval
+}
+
+// RunFunc executes a command of the job
+type RunFunc func(ctx context.Context, command string) error
+
+// Scheduler runs the jobs, safe for concurrent use
+type Scheduler struct {
+	Run RunFunc
+
+	mutex    sync.RWMutex
+	jobs     map[string]*Job
+	lastTick int64 // unix nano of the last tick of Start, 0 when not running
+}
+
+// Load reads the jobs of the FileSystem (the schedule/ directory)
+func Load(fsys fs.FS) ([]*Job, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*Job
+	names := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !(strings.HasSuffix(entry.Name(), ".yaml") || strings.HasSuffix(entry.Name(), ".yml")) {
+			continue
+		}
+		data, errRead := fs.ReadFile(fsys, entry.Name())
+		if errRead != nil {
+			return nil, errRead
+		}
+
+		job := &Job{File: entry.Name()}
+		if err = yaml.Unmarshal(data, job); err != nil {
+			return nil, fmt.Errorf("%s: %w", job.File, err)
+		}
+		job.Name = strings.TrimSpace(job.Name)
+		if job.Name == "" {
+			return nil, fmt.Errorf("%s: name is required", job.File)
+		}
+		if existing, exists := names[job.Name]; exists {
+			return nil, fmt.Errorf("%s: job '%s' is already declared in %s", job.File, job.Name, existing)
+		}
+		names[job.Name] = job.File
+
+		if job.When.Every != "" {
+			if job.When.interval, err = time.ParseDuration(job.When.Every); err != nil || job.When.interval <= 0 {
+				return nil, fmt.Errorf("%s: when.every: invalid duration %q", job.File, job.When.Every)
+			}
+		}
+
+		// ignores empty items (Ex. "commands: [ - ]")
+		var commands []string
+		for _, command := range job.Commands {
+			if command = strings.TrimSpace(command); command != "" {
+				commands = append(commands, command)
+			}
+		}
+		job.Commands = commands
+
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// New creates the scheduler for the jobs
+func New(jobs []*Job, run RunFunc) *Scheduler {
+	s := &Scheduler{Run: run}
+	s.Set(jobs)
+	return s
+}
+
+// Set replaces the jobs, used when the files are reloaded
+func (s *Scheduler) Set(jobs []*Job) {
+	byName := map[string]*Job{}
+	for _, job := range jobs {
+		byName[job.Name] = job
+	}
+	s.mutex.Lock()
+	s.jobs = byName
+	s.mutex.Unlock()
+}
+
+// Job returns the job with the given name, nil if it does not exist
+func (s *Scheduler) Job(name string) *Job {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.jobs[name]
+}
+
+// List returns all jobs sorted by name
+func (s *Scheduler) List() []*Job {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// RunJob executes the commands of the job in order, stopping at the first error
+func (s *Scheduler) RunJob(ctx context.Context, name string) error {
+	job := s.Job(name)
+	if job == nil {
+		return fmt.Errorf("job '%s' does not exist", name)
+	}
+	jobRuns.Inc(job.Name)
+	for _, command := range job.Commands {
+		if err := s.Run(ctx, command); err != nil {
+			jobFailures.Inc(job.Name)
+			return fmt.Errorf("job %s: %w", job.Name, err)
+		}
+	}
+	return nil
+}
+
+// Fire runs, in background, all jobs of the trigger
+func (s *Scheduler) Fire(ctx context.Context, trigger string) {
+	for _, job := range s.List() {
+		if job.When.Name != trigger {
+			continue
+		}
+		go s.runLogged(ctx, job.Name)
+	}
+}
+
+// Start runs the periodic jobs until the context is cancelled. Jobs added by Set are picked on the next tick.
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := map[string]time.Time{}
+	start := time.Now()
+	atomic.StoreInt64(&s.lastTick, start.UnixNano())
+	defer atomic.StoreInt64(&s.lastTick, 0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			atomic.StoreInt64(&s.lastTick, now.UnixNano())
+			for _, job := range s.List() {
+				interval := job.When.Interval()
+				if interval <= 0 {
+					continue
+				}
+				previous, exists := last[job.Name]
+				if !exists {
+					previous = start
+				}
+				if now.Sub(previous) >= interval {
+					last[job.Name] = now
+					go s.runLogged(ctx, job.Name)
+				}
+			}
+		}
+	}
+}
+
+// Check reports whether the periodic jobs are running, used by the readiness endpoint
+func (s *Scheduler) Check(ctx context.Context) error {
+	tick := atomic.LoadInt64(&s.lastTick)
+	if tick == 0 {
+		return errors.New("scheduler is not running")
+	}
+	if since := time.Since(time.Unix(0, tick)); since > 5*time.Second {
+		return fmt.Errorf("scheduler is stalled, last tick %s ago", since.Round(time.Second))
+	}
+	return nil
+}
+
+func (s *Scheduler) runLogged(ctx context.Context, name string) {
+	if err := s.RunJob(ctx, name); err != nil {
+		log.Printf("schedule: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package schedule
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func Test_scheduler(t *testing.T) {
+	fsys := fstest.MapFS{
+		"send-emails.yaml": {Data: []byte("name: SendEmailsToUsers\nwhen:\n  name: my-trigger\ncommands:\n  -\n  - UpdateUsers\n  - NotifyUsers\n")},
+		"cleanup.yaml":     {Data: []byte("name: Cleanup\nwhen:\n  every: 1h\ncommands:\n  - DeleteSessions\n")},
+	}
+
+	jobs, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	var executed []string
+	done := make(chan bool, 10)
+	scheduler := New(jobs, func(ctx context.Context, command string) error {
+		mutex.Lock()
+		executed = append(executed, command)
+		mutex.Unlock()
+		done <- true
+		return nil
+	})
+
+	list := scheduler.List()
+	if len(list) != 2 || list[0].Name != "Cleanup" || list[0].When.Interval() != time.Hour {
+		t.Fatalf("list: got %+v", list)
+	}
+
+	if err = scheduler.RunJob(context.Background(), "Cleanup"); err != nil {
+		t.Fatal(err)
+	}
+	if err = scheduler.RunJob(context.Background(), "Unknown"); err == nil {
+		t.Error("Expected error for unknown job")
+	}
+
+	scheduler.Fire(context.Background(), "my-trigger")
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for the triggered job")
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []string{"DeleteSessions", "UpdateUsers", "NotifyUsers"}
+	if !reflect.DeepEqual(executed, expected) {
+		t.Errorf("executed: Expected %v, got %v", expected, executed)
+	}
+}
+
+func Test_load_errors(t *testing.T) {
+	tests := map[string]string{
+		"no name":   "when:\n  name: x\n",
+		"bad every": "name: A\nwhen:\n  every: often\n",
+	}
+	for name, data := range tests {
+		if _, err := Load(fstest.MapFS{"job.yaml": {Data: []byte(data)}}); err == nil {
+			t.Errorf("%s: Expected error", name)
+		}
+	}
+}
+
+func Test_check(t *testing.T) {
+	scheduler := New(nil, nil)
+	if err := scheduler.Check(context.Background()); err == nil {
+		t.Error("Expected error before Start")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go scheduler.Start(ctx)
+	time.Sleep(10 * time.Millisecond)
+	if err := scheduler.Check(context.Background()); err != nil {
+		t.Error(err)
+	}
+	cancel()
+}
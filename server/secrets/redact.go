@@ -0,0 +1,75 @@
+package secrets
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the values of the secrets
+const Redacted = "[redacted]"
+
+// minRedacted shorter values are not redacted, they would hide common words of the logs
+const minRedacted = 4
+
+var (
+	mutex    sync.RWMutex
+	known    = map[string]bool{}
+	replacer *strings.Replacer
+)
+
+// Register adds the values to the ones redacted by Redact
+func Register(values ...string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	changed := false
+	for _, value := range values {
+		if len(value) >= minRedacted && !known[value] {
+			known[value] = true
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	sorted := make([]string, 0, len(known))
+	for value := range known {
+		sorted = append(sorted, value)
+	}
+	// the longest first, a secret that contains another is replaced whole
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, Redacted)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces the registered values of the text
+func Redact(text string) string {
+	mutex.RLock()
+	r := replacer
+	mutex.RUnlock()
+	if r == nil {
+		return text
+	}
+	return r.Replace(text)
+}
+
+// Writer redacts the registered values of each write (Ex. `log.SetOutput(secrets.Writer(os.Stderr))`). The values
+// split between two writes are not detected, the loggers write whole lines.
+func Writer(w io.Writer) io.Writer {
+	return &writer{w}
+}
+
+type writer struct {
+	w io.Writer
+}
+
+func (r *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,206 @@
+// Package secrets keeps the credentials of the configuration in a file encrypted with AES-256-GCM, referenced from
+// config.yaml as `secret:<name>`:
+//
+//	redis:
+//	  my-redis:
+//	    uri: secret:redis_uri
+//
+// The master key (32 random bytes, base64) comes from $DEMO_SECRETS_KEY or from the key file (`secrets.key-file`,
+// secrets.key by default), which must not be committed. The encrypted file (`secrets.file`, secrets.enc by default)
+// can be. The commands `demo secrets list|edit|set|rotate` manage them.
+//
+// The values read from the file are registered to be redacted (see Redact and Writer) from the logs and from
+// `demo config show`.
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// KeySize of the master key, AES-256
+const KeySize = 32
+
+// header first line of the file, followed by the ID of the key
+const header = "demo-secrets/v1"
+
+var (
+	ErrNoKey    = errors.New("secrets: there is no master key, set $DEMO_SECRETS_KEY or create the key file (demo secrets edit)")
+	ErrWrongKey = errors.New("secrets: the master key does not decrypt the file")
+)
+
+var nameReg = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidName reports whether the name can be used on a reference (letters, digits, `_`, `.` and `-`)
+func ValidName(name string) bool {
+	return nameReg.MatchString(name)
+}
+
+// NewKey generates a random master key
+func NewKey() []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// EncodeKey the key in base64, the format of the key file and of $DEMO_SECRETS_KEY
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// DecodeKey decodes a base64 key
+func DecodeKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("secrets: the master key must be %d bytes in base64", KeySize)
+	}
+	return key, nil
+}
+
+// KeyID a short fingerprint of the key, written on the file to tell which key encrypted it
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// LoadKey returns the key of the environment variable value, or of the key file when it is empty. Fails with ErrNoKey
+// when neither exists.
+func LoadKey(env, file string) ([]byte, error) {
+	if env != "" {
+		return DecodeKey(env)
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoKey
+	} else if err != nil {
+		return nil, err
+	}
+	key, err := DecodeKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return key, nil
+}
+
+// WriteKey saves the key on the file, readable only by the owner
+func WriteKey(file string, key []byte) error {
+	return writeFile(file, []byte(EncodeKey(key)+"\n"))
+}
+
+// Read decrypts the secrets of the file, empty when the file does not exist
+func Read(file string, key []byte) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	first, encoded, _ := bytes.Cut(data, []byte("\n"))
+	version, id, _ := strings.Cut(string(first), " ")
+	if version != header {
+		return nil, fmt.Errorf("secrets: %s is not a secrets file", file)
+	}
+	if id != KeyID(key) {
+		return nil, fmt.Errorf("%w %s, it was encrypted by the key %s (the current one is %s)", ErrWrongKey, file, id, KeyID(key))
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %s: %w", file, err)
+	}
+	plain, err := decrypt(key, sealed, first)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s", ErrWrongKey, file)
+	}
+	values := map[string]string{}
+	if err = json.Unmarshal(plain, &values); err != nil {
+		return nil, fmt.Errorf("secrets: %s: %w", file, err)
+	}
+	return values, nil
+}
+
+// Write encrypts the secrets on the file, replacing it atomically
+func Write(file string, key []byte, values map[string]string) error {
+	for name := range values {
+		if !ValidName(name) {
+			return fmt.Errorf("secrets: invalid name %q, use letters, digits, `_`, `.` and `-`", name)
+		}
+	}
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	first := []byte(header + " " + KeyID(key))
+	sealed, err := encrypt(key, plain, first)
+	if err != nil {
+		return err
+	}
+	content := append(first, '\n')
+	content = append(content, base64.StdEncoding.EncodeToString(sealed)...)
+	return writeFile(file, append(content, '\n'))
+}
+
+// encrypt returns the random nonce followed by the sealed plaintext, the header is authenticated
+func encrypt(key, plain, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, additional), nil
+}
+
+func decrypt(key, sealed, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrWrongKey
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFile writes a temporary file and renames it, a failure does not leave the file truncated
+func writeFile(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_write_read(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secrets.enc")
+	key := NewKey()
+	values := map[string]string{"redis_uri": "redis://:pass@localhost:6379", "s3.secret-key": "abc123"}
+	if err := Write(file, key, values); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(file)
+	if bytes.Contains(data, []byte("pass@localhost")) {
+		t.Error("Expected the values encrypted")
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %s", info.Mode())
+	}
+
+	read, err := Read(file, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, values) {
+		t.Errorf("Expected %v, got %v", values, read)
+	}
+
+	if _, err = Read(file, NewKey()); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("other key: Expected ErrWrongKey, got %v", err)
+	}
+
+	// a changed byte of the ciphertext fails the authentication
+	lines := strings.SplitN(string(data), "\n", 2)
+	body := []byte(lines[1])
+	if body[10] == 'A' {
+		body[10] = 'B'
+	} else {
+		body[10] = 'A'
+	}
+	if err = os.WriteFile(file, []byte(lines[0]+"\n"+string(body)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Read(file, key); err == nil {
+		t.Error("tampered: Expected error")
+	}
+
+	if err = Write(file, key, map[string]string{"bad name": "x"}); err == nil {
+		t.Error("Expected error for an invalid name")
+	}
+	if read, err = Read(filepath.Join(t.TempDir(), "missing.enc"), key); err != nil || len(read) != 0 {
+		t.Errorf("missing file: Expected empty, got %v %v", read, err)
+	}
+}
+
+func Test_load_key(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secrets.key")
+	if _, err := LoadKey("", file); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey, got %v", err)
+	}
+	key := NewKey()
+	if err := WriteKey(file, key); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := LoadKey("", file); err != nil || !bytes.Equal(loaded, key) {
+		t.Errorf("file: got %v %v", loaded, err)
+	}
+	other := NewKey()
+	if loaded, err := LoadKey(EncodeKey(other), file); err != nil || !bytes.Equal(loaded, other) {
+		t.Errorf("env: Expected the key of the environment first, got %v %v", loaded, err)
+	}
+	if _, err := LoadKey("c2hvcnQ=", file); err == nil {
+		t.Error("Expected error for a short key")
+	}
+}
+
+func Test_redact(t *testing.T) {
+	Register("token-1234", "token-1234-long", "abc")
+	got := Redact("a=token-1234-long b=token-1234 c=abc")
+	if got != "a=[redacted] b=[redacted] c=abc" {
+		t.Errorf("got '%s'", got)
+	}
+
+	var buf bytes.Buffer
+	w := Writer(&buf)
+	if n, err := w.Write([]byte("uri token-1234\n")); err != nil || n != 15 {
+		t.Errorf("write: got %d %v", n, err)
+	}
+	if buf.String() != "uri [redacted]\n" {
+		t.Errorf("writer: got '%s'", buf.String())
+	}
+}
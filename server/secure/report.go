@@ -0,0 +1,113 @@
+package secure
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/metrics"
+	"github.com/syntax-framework/demo/server/requestid"
+)
+
+// maxReportSize of the body of a violation report
+const maxReportSize = 64 << 10
+
+var violations = metrics.NewCounter("csp_violations_total", "Content-Security-Policy violations reported by the browsers")
+
+// Violation reported by the browser
+type Violation struct {
+	DocumentURI        string `json:"document-uri"`
+	BlockedURI         string `json:"blocked-uri"`
+	EffectiveDirective string `json:"effective-directive"`
+	ViolatedDirective  string `json:"violated-directive"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	Disposition        string `json:"disposition"` // enforce or report
+}
+
+// report of the Reporting API (Content-Type: application/reports+json), the body uses camelCase keys
+type report struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		Disposition        string `json:"disposition"`
+	} `json:"body"`
+}
+
+// Collector receives the violation reports sent by the browsers (report-uri and report-to) and writes them on the log
+type Collector struct {
+	// Logf defaults to log.Printf
+	Logf func(format string, args ...interface{})
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxReportSize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	list, err := ParseReports(r.Header.Get("Content-Type"), data)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	logf := c.Logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	for _, v := range list {
+		violations.Inc()
+		logf("csp: %s violation of %q by %q on %q (%s:%d, request %s)",
+			v.Disposition, v.EffectiveDirective, v.BlockedURI, v.DocumentURI, v.SourceFile, v.LineNumber,
+			requestid.FromRequest(r))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ParseReports decodes the report-uri format ({"csp-report": {...}}) and the Reporting API format ([{"type":
+// "csp-violation", "body": {...}}]), the other reports of the Reporting API are ignored
+func ParseReports(contentType string, data []byte) ([]*Violation, error) {
+	if strings.HasPrefix(contentType, "application/reports+json") {
+		var reports []*report
+		if err := json.Unmarshal(data, &reports); err != nil {
+			return nil, err
+		}
+		var list []*Violation
+		for _, rp := range reports {
+			if rp.Type != "csp-violation" {
+				continue
+			}
+			list = append(list, &Violation{
+				DocumentURI:        rp.Body.DocumentURL,
+				BlockedURI:         rp.Body.BlockedURL,
+				EffectiveDirective: rp.Body.EffectiveDirective,
+				ViolatedDirective:  rp.Body.EffectiveDirective,
+				SourceFile:         rp.Body.SourceFile,
+				LineNumber:         rp.Body.LineNumber,
+				Disposition:        rp.Body.Disposition,
+			})
+		}
+		return list, nil
+	}
+
+	var legacy struct {
+		Report *Violation `json:"csp-report"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, err
+	}
+	if legacy.Report == nil {
+		return nil, nil
+	}
+	if legacy.Report.EffectiveDirective == "" {
+		legacy.Report.EffectiveDirective = legacy.Report.ViolatedDirective
+	}
+	return []*Violation{legacy.Report}, nil
+}
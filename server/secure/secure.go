@@ -0,0 +1,203 @@
+// Package secure sets the security headers of the responses, by the `security-headers` block of config.yaml:
+// Content-Security-Policy, X-Content-Type-Options, Referrer-Policy, Permissions-Policy and X-Frame-Options.
+//
+// Every request has a random nonce. The `'nonce'` source of the CSP directives is replaced by it, and it is added to
+// the `<script>` and `<style>` elements of the templates and to the scripts of the bundler, so the ones of the site are
+// allowed while injected ones are not:
+//
+//	script-src: "'self' 'nonce'"  =>  Content-Security-Policy: script-src 'self' 'nonce-QmFzZTY0...'
+//	<script>...</script>          =>  <script nonce="QmFzZTY0...">...</script>
+//
+// The elements are marked at compile time (see Register) with a placeholder, random by process, that is replaced by
+// the nonce of the request. The markup rendered from values (Ex. the content of a post) never has the placeholder.
+// Handlers that write inline scripts by other means can read the nonce with Nonce.
+package secure
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/rewrite"
+	"github.com/syntax-framework/shtml/sht"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+// nonceSource the placeholder of the nonce on the CSP directives
+const nonceSource = "'nonce'"
+
+// Placeholder the nonce of the elements of the templates until the request, unknown outside the process
+var Placeholder = newPlaceholder()
+
+// Headers the security headers applied to all responses
+type Headers struct {
+	CSP                string // Policy, with the `'nonce'` placeholders. Empty disables
+	ReportOnly         bool
+	ReportURI          string
+	ContentTypeOptions string
+	ReferrerPolicy     string
+	PermissionsPolicy  string
+	FrameOptions       string
+}
+
+// New creates the headers of the configuration, nil if disabled
+func New(cfg config.Security) *Headers {
+	if cfg.Disabled {
+		return nil
+	}
+	h := &Headers{
+		ContentTypeOptions: cfg.ContentTypeOptions,
+		ReferrerPolicy:     cfg.ReferrerPolicy,
+		PermissionsPolicy:  cfg.PermissionsPolicy,
+		FrameOptions:       strings.ToUpper(cfg.FrameOptions),
+	}
+	if !cfg.CSP.Disabled {
+		h.CSP = Policy(cfg.CSP.Directives)
+		h.ReportOnly = cfg.CSP.ReportOnly
+		h.ReportURI = cfg.CSP.ReportURI
+	}
+	return h
+}
+
+// Policy joins the directives, default-src first and the others sorted by name
+func Policy(directives map[string]string) string {
+	names := make([]string, 0, len(directives))
+	for name := range directives {
+		if name != "default-src" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, exists := directives["default-src"]; exists {
+		names = append([]string{"default-src"}, names...)
+	}
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, strings.TrimSpace(name+" "+strings.TrimSpace(directives[name])))
+	}
+	return strings.Join(parts, "; ")
+}
+
+type nonceKey struct{}
+
+// Nonce returns the nonce of the request, empty when the CSP is disabled
+func Nonce(r *http.Request) string {
+	return NonceFromContext(r.Context())
+}
+
+// NonceFromContext returns the nonce of the request of the context
+func NonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
+
+// Nonces reports whether the policy uses the nonce of the requests
+func (h *Headers) Nonces() bool {
+	return h != nil && strings.Contains(h.CSP, nonceSource)
+}
+
+// Register marks the `<script>` and `<style>` elements of the templates with the Placeholder, must be called before
+// app.Init. The scripts of the bundler are marked by MarkAssets, after it.
+func Register(app *syntax.Syntax) {
+	for _, name := range []string{"script", "style"} {
+		app.Template.(*sht.TemplateSystem).Register(&sht.Directive{
+			Name:     name,
+			Restrict: sht.ELEMENT,
+			// before `script` (990), that moves the inline scripts to the assets
+			Priority: 995,
+			Compile: func(node *sht.Node, attrs *sht.Attributes, c *sht.Compiler) (*sht.DirectiveMethods, error) {
+				attrs.Set("nonce", Placeholder)
+				return nil, nil
+			},
+		})
+	}
+}
+
+// MarkAssets marks the scripts of the pages (`<script src="/assets/js/...">`) with the Placeholder, after app.Init
+func MarkAssets(app *syntax.Syntax) {
+	for asset := range app.Template.(*sht.TemplateSystem).Assets {
+		// the bundler writes the attributes without a separator, in any order
+		attributes := map[string]string{" nonce": Placeholder}
+		for name, value := range asset.Attributes {
+			attributes[" "+strings.TrimSpace(name)] = value
+		}
+		asset.Attributes = attributes
+	}
+}
+
+// Handler sets the headers and adds the nonce to the scripts and styles of the HTML responses
+func (h *Headers) Handler(next http.Handler) http.Handler {
+	nonces := h.Nonces()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		if h.ContentTypeOptions != "" {
+			header.Set("X-Content-Type-Options", h.ContentTypeOptions)
+		}
+		if h.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", h.ReferrerPolicy)
+		}
+		if h.PermissionsPolicy != "" {
+			header.Set("Permissions-Policy", h.PermissionsPolicy)
+		}
+		if h.FrameOptions != "" {
+			header.Set("X-Frame-Options", h.FrameOptions)
+		}
+		if h.CSP == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := h.CSP
+		if h.ReportURI != "" {
+			policy += "; report-uri " + h.ReportURI + "; report-to csp"
+			header.Set("Reporting-Endpoints", `csp="`+h.ReportURI+`"`)
+		}
+		name := "Content-Security-Policy"
+		if h.ReportOnly {
+			name = "Content-Security-Policy-Report-Only"
+		}
+		if !nonces {
+			header.Set(name, policy)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		nonce := newNonce()
+		header.Set(name, strings.ReplaceAll(policy, nonceSource, "'nonce-"+nonce+"'"))
+		r = r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce))
+		rw := rewrite.HTML(w, func(document []byte) []byte {
+			return Stamp(document, nonce)
+		})
+		defer rw.Close()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func newNonce() string {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func newPlaceholder() string {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return "nonce-" + hex.EncodeToString(data)
+}
+
+// Stamp replaces the Placeholder of the elements of the templates by the nonce. The other `<script>` and `<style>`
+// elements of the document (Ex. injected by the content of the pages) are not changed.
+func Stamp(document []byte, nonce string) []byte {
+	return bytes.ReplaceAll(document, []byte(`nonce="`+Placeholder+`"`), []byte(`nonce="`+nonce+`"`))
+}
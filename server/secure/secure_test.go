@@ -0,0 +1,165 @@
+package secure
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/shtml/sht"
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+func Test_policy(t *testing.T) {
+	policy := Policy(map[string]string{
+		"script-src":                "'self' 'nonce'",
+		"default-src":               "'self'",
+		"base-uri":                  " 'none' ",
+		"upgrade-insecure-requests": "",
+	})
+	expected := "default-src 'self'; base-uri 'none'; script-src 'self' 'nonce'; upgrade-insecure-requests"
+	if policy != expected {
+		t.Errorf("got %q", policy)
+	}
+}
+
+func Test_stamp(t *testing.T) {
+	marked := `nonce="` + Placeholder + `"`
+	document := `<html><head><style ` + marked + `>body{}</style><script src="/a.js" ` + marked + `></script></head>` +
+		`<body><script nonce="other">let s = "<style>";</script><div><script>alert(1)</script></div></body></html>`
+	expected := `<html><head><style nonce="n1">body{}</style><script src="/a.js" nonce="n1"></script></head>` +
+		`<body><script nonce="other">let s = "<style>";</script><div><script>alert(1)</script></div></body></html>`
+	if got := string(Stamp([]byte(document), "n1")); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+}
+
+// only the elements of the template are marked, not the markup of the values
+func Test_register(t *testing.T) {
+	app := syntax.New(&syntax.Config{})
+	Register(app)
+	compiler := sht.NewCompiler(app.Template.(*sht.TemplateSystem))
+	compiled, err := compiler.Compile(`<style>p{}</style><div>!{content}</div>`, "page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope := sht.NewRootScope()
+	scope.Set("content", `<script>alert(1)</script>`) // raw, Ex. the HTML of a post
+	got := string(Stamp([]byte(compiled.Exec(scope).String()), "n1"))
+	if !strings.Contains(got, `<style nonce="n1">p{}</style>`) || !strings.Contains(got, `<div><script>alert(1)</script></div>`) {
+		t.Errorf("got %s", got)
+	}
+}
+
+func Test_handler(t *testing.T) {
+	h := New(config.Security{
+		ContentTypeOptions: "nosniff",
+		ReferrerPolicy:     "no-referrer",
+		PermissionsPolicy:  "camera=()",
+		FrameOptions:       "sameorigin",
+		CSP: config.CSP{
+			Directives: map[string]string{"default-src": "'self'", "script-src": "'self' 'nonce'"},
+			ReportURI:  "/csp-report",
+		},
+	})
+
+	var nonce string
+	handler := h.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = Nonce(r)
+		page := `<html><body><script nonce="` + Placeholder + `">run()</script><p><script>alert(1)</script></p></body></html>`
+		w.Header().Set("Content-Length", fmt.Sprint(len(page)))
+		w.Write([]byte(page))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	header := w.Header()
+	if nonce == "" {
+		t.Fatal("expected a nonce")
+	}
+	if csp := header.Get("Content-Security-Policy"); csp != "default-src 'self'; script-src 'self' 'nonce-"+nonce+"'; report-uri /csp-report; report-to csp" {
+		t.Errorf("got %q", csp)
+	}
+	if header.Get("Reporting-Endpoints") != `csp="/csp-report"` || header.Get("X-Content-Type-Options") != "nosniff" ||
+		header.Get("Referrer-Policy") != "no-referrer" || header.Get("Permissions-Policy") != "camera=()" ||
+		header.Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Errorf("got %v", header)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `<script nonce="`+nonce+`">run()</script><p><script>alert(1)</script></p>`) || header.Get("Content-Length") != fmt.Sprint(len(body)) {
+		t.Errorf("got %q %v", body, header)
+	}
+
+	// every request has its own nonce
+	previous := nonce
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if nonce == previous {
+		t.Errorf("expected a new nonce")
+	}
+
+	// report only
+	h.ReportOnly = true
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("Content-Security-Policy") != "" || w.Header().Get("Content-Security-Policy-Report-Only") == "" {
+		t.Errorf("got %v", w.Header())
+	}
+}
+
+func Test_handler_not_html(t *testing.T) {
+	h := &Headers{CSP: "script-src 'nonce'"}
+	w := httptest.NewRecorder()
+	h.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: <script>\n\n"))
+		w.(http.Flusher).Flush()
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/live", nil))
+
+	if w.Body.String() != "data: <script>\n\n" || !w.Flushed {
+		t.Errorf("got %q", w.Body.String())
+	}
+
+	// status without body
+	w = httptest.NewRecorder()
+	h.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func Test_collector(t *testing.T) {
+	var logged []string
+	collector := &Collector{Logf: func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}}
+
+	legacy := `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src-elem",` +
+		`"blocked-uri":"inline","disposition":"enforce","source-file":"https://example.com/","line-number":10}}`
+	r := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(legacy))
+	r.Header.Set("Content-Type", "application/csp-report")
+	w := httptest.NewRecorder()
+	collector.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || len(logged) != 1 || !strings.Contains(logged[0], `"script-src-elem" by "inline"`) {
+		t.Errorf("got %d %v", w.Code, logged)
+	}
+
+	reports := `[{"type":"csp-violation","body":{"documentURL":"https://example.com/","effectiveDirective":"style-src-elem",` +
+		`"blockedURL":"https://evil.com/a.css","disposition":"report"}},{"type":"deprecation","body":{}}]`
+	r = httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(reports))
+	r.Header.Set("Content-Type", "application/reports+json")
+	collector.ServeHTTP(httptest.NewRecorder(), r)
+	if len(logged) != 2 || !strings.Contains(logged[1], `report violation of "style-src-elem" by "https://evil.com/a.css"`) {
+		t.Errorf("got %v", logged)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader("not json"))
+	w = httptest.NewRecorder()
+	collector.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCookie returned when the cookie was not encrypted by one of the keys, was changed or is malformed
+var ErrInvalidCookie = errors.New("session: invalid cookie")
+
+// Codec encrypts the values of the cookies with AES-GCM. The first key encrypts, all keys decrypt: to rotate, add the
+// new key first and remove the old one after the cookies encrypted by it expire.
+type Codec struct {
+	aeads []cipher.AEAD
+}
+
+// ParseKeys decodes the base64 keys of the configuration, empty values are ignored
+func ParseKeys(values []string) ([][]byte, error) {
+	var keys [][]byte
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+			return nil, fmt.Errorf("session: keys[%d]: expected the base64 of 16, 24 or 32 bytes", i)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// NewCodec creates the codec of the AES keys (16, 24 or 32 bytes)
+func NewCodec(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: no keys")
+	}
+	c := &Codec{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Encode encrypts the value with the first key. The name of the cookie is authenticated, a value can not be moved to
+// another cookie.
+func (c *Codec) Encode(name string, value []byte) string {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, value, []byte(name)))
+}
+
+// Decode decrypts the value with the first key that authenticates it
+func (c *Codec) Decode(name string, encoded string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize()+aead.Overhead() {
+			continue
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if value, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return value, nil
+		}
+	}
+	return nil, ErrInvalidCookie
+}
@@ -0,0 +1,338 @@
+// Package session keeps the sessions of the browsers, by the `session` block of config.yaml:
+//
+//	session:
+//	  store: redis         # cookie, memory, db or redis
+//	  redis: my-redis
+//	  keys: [ "${SESSION_KEY}", "${SESSION_KEY_OLD:}" ]
+//	  idle-timeout: 30m
+//	  absolute-timeout: 24h
+//
+// The cookie is encrypted and authenticated with AES-GCM (see Codec). The cookie store keeps all the data in it,
+// the server-side stores (Memory, SQL and Redis) keep the data on the server and the cookie only has the ID.
+//
+// Sessions expire after IdleTimeout without requests and after AbsoluteTimeout since they were created (or renewed).
+// The session is loaded on the first access (FromRequest), requests that do not use it (Ex. assets) do not touch the
+// store, and saved before the response starts.
+//
+// Handlers read the session with FromRequest, the controllers with FromScope.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/redis"
+	"github.com/syntax-framework/demo/server/requestid"
+)
+
+// maxCookieSize browsers ignore larger cookies
+const maxCookieSize = 4096
+
+// touchInterval the last access is only saved after this interval, unchanged sessions are not written on every request
+const touchInterval = time.Minute
+
+// Manager loads and saves the sessions of the requests
+type Manager struct {
+	Cookie          string
+	Codec           *Codec
+	Store           Store // Nil keeps the data in the cookie
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	// Partition of the request (Ex. the tenant), the sessions created on another partition are not loaded
+	Partition func(r *http.Request) string
+
+	now func() time.Time
+}
+
+// New creates the manager of the configuration. Without keys the sessions use a random key and are lost on restart.
+func New(cfg config.Session, dbs map[string]*db.DB, clients map[string]*redis.Client) (*Manager, error) {
+	keys, err := ParseKeys(cfg.Keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		log.Printf("session: no keys configured, using a random key (sessions are lost on restart)")
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	codec, err := NewCodec(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		Cookie:          cfg.Cookie,
+		Codec:           codec,
+		IdleTimeout:     cfg.IdleTimeout.Std(),
+		AbsoluteTimeout: cfg.AbsoluteTimeout.Std(),
+	}
+	switch cfg.Store {
+	case "", "cookie":
+	case "memory":
+		m.Store = &Memory{}
+	case "db":
+		conn, exists := dbs[cfg.DB]
+		if !exists {
+			return nil, fmt.Errorf("session: there is no db connection named %q", cfg.DB)
+		}
+		m.Store = &SQL{DB: conn}
+	case "redis":
+		client, exists := clients[cfg.Redis]
+		if !exists {
+			return nil, fmt.Errorf("session: there is no redis connection named %q", cfg.Redis)
+		}
+		m.Store = &Redis{Client: client, Prefix: "session:"}
+	default:
+		return nil, fmt.Errorf("session: unknown store %q", cfg.Store)
+	}
+	return m, nil
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+type holderKey struct{}
+
+// holder loads the session of the request on the first access
+type holder struct {
+	m       *Manager
+	r       *http.Request
+	once    sync.Once
+	session *Session
+	cookie  bool // The request has the cookie
+	saved   bool
+}
+
+// FromRequest returns the session of the request, nil without the Handler
+func FromRequest(r *http.Request) *Session {
+	return FromContext(r.Context())
+}
+
+// FromContext returns the session of the request of the context
+func FromContext(ctx context.Context) *Session {
+	h, _ := ctx.Value(holderKey{}).(*holder)
+	if h == nil {
+		return nil
+	}
+	h.once.Do(func() {
+		h.session, h.cookie = h.m.Load(h.r)
+	})
+	return h.session
+}
+
+// Handler makes the session available to the handlers and saves it before the response starts
+func (m *Manager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := &holder{m: m}
+		h.r = r.WithContext(context.WithValue(r.Context(), holderKey{}, h))
+		sw := &writer{ResponseWriter: w, h: h}
+		next.ServeHTTP(sw, h.r)
+		sw.save()
+	})
+}
+
+// Load returns the session of the cookie, a new one when absent, invalid or expired. The second value reports whether
+// the request has the cookie.
+func (m *Manager) Load(r *http.Request) (*Session, bool) {
+	now := m.clock()
+	partition := ""
+	if m.Partition != nil {
+		partition = m.Partition(r)
+	}
+	cookie, err := r.Cookie(m.Cookie)
+	if err != nil {
+		return m.create(now, partition), false
+	}
+	value, err := m.Codec.Decode(m.Cookie, cookie.Value)
+	if err != nil {
+		// encrypted by a removed key or changed by the client
+		return m.create(now, partition), true
+	}
+
+	id, encoded := "", value
+	if m.Store != nil {
+		id = string(value)
+		var found bool
+		encoded, found, err = m.Store.Get(r.Context(), id)
+		if err != nil {
+			log.Printf("session: loading from the store: %v (request %s)", err, requestid.FromRequest(r))
+		}
+		if !found {
+			return m.create(now, partition), true
+		}
+	}
+	s, err := unmarshal(id, encoded)
+	if err != nil {
+		return m.create(now, partition), true
+	}
+	if s.partition != partition {
+		// Ex. the login of a tenant is not valid on the others, the session stays on the store
+		return m.create(now, partition), true
+	}
+	if m.expired(s, now) {
+		fresh := m.create(now, partition)
+		fresh.previous = id
+		return fresh, true
+	}
+	return s, true
+}
+
+func (m *Manager) create(now time.Time, partition string) *Session {
+	id := ""
+	if m.Store != nil {
+		id = newID()
+	}
+	s := newSession(id, now)
+	s.partition = partition
+	return s
+}
+
+func (m *Manager) expired(s *Session, now time.Time) bool {
+	return (m.IdleTimeout > 0 && now.Sub(s.accessed) > m.IdleTimeout) ||
+		(m.AbsoluteTimeout > 0 && now.Sub(s.created) > m.AbsoluteTimeout)
+}
+
+// ttl remaining time of the session, the shortest of the idle and absolute timeouts
+func (m *Manager) ttl(s *Session, now time.Time) time.Duration {
+	ttl := time.Duration(0)
+	if m.IdleTimeout > 0 {
+		ttl = m.IdleTimeout
+	}
+	if m.AbsoluteTimeout > 0 {
+		if remaining := s.created.Add(m.AbsoluteTimeout).Sub(now); ttl == 0 || remaining < ttl {
+			ttl = remaining
+		}
+	}
+	return ttl
+}
+
+// Save writes the session on the store and the cookie on the response, when it changed or the last access must be
+// updated. Must be called before the response starts.
+func (m *Manager) Save(w http.ResponseWriter, r *http.Request, s *Session, hasCookie bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ctx := r.Context()
+	now := m.clock()
+
+	if s.previous != "" {
+		if err := m.Store.Delete(ctx, s.previous); err != nil {
+			return err
+		}
+		s.previous = ""
+	}
+	if s.isNew && len(s.values) == 0 && len(s.flashes) == 0 {
+		// nothing to keep, the sessions are only created when used
+		if hasCookie {
+			http.SetCookie(w, m.cookie("", -1))
+		}
+		return nil
+	}
+	if !s.changed && now.Sub(s.accessed) < touchInterval {
+		return nil
+	}
+
+	s.accessed = now
+	encoded, err := s.marshal()
+	if err != nil {
+		return err
+	}
+	ttl := m.ttl(s, now)
+	value := encoded
+	if m.Store != nil {
+		if err = m.Store.Set(ctx, s.id, encoded, ttl); err != nil {
+			return err
+		}
+		value = []byte(s.id)
+	}
+	cookie := m.cookie(m.Codec.Encode(m.Cookie, value), ttl)
+	if size := len(cookie.String()); size > maxCookieSize {
+		return fmt.Errorf("session: the cookie has %d bytes, the limit is %d (use a server-side store)", size, maxCookieSize)
+	}
+	http.SetCookie(w, cookie)
+	s.changed, s.isNew = false, false
+	return nil
+}
+
+func (m *Manager) cookie(value string, ttl time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     m.Cookie,
+		Value:    value,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	} else if ttl > 0 {
+		cookie.MaxAge = int((ttl + time.Second - 1) / time.Second)
+	}
+	return cookie
+}
+
+func newID() string {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// writer saves the session before the response starts
+type writer struct {
+	http.ResponseWriter
+	h *holder
+}
+
+func (w *writer) save() {
+	h := w.h
+	if h.saved {
+		return
+	}
+	h.saved = true
+	if h.session == nil {
+		// not used by the handler
+		return
+	}
+	if err := h.m.Save(w.ResponseWriter, h.r, h.session, h.cookie); err != nil {
+		log.Printf("session: %v (request %s)", err, requestid.FromRequest(h.r))
+	}
+}
+
+func (w *writer) WriteHeader(status int) {
+	w.save()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writer) Write(data []byte) (int, error) {
+	w.save()
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush keeps streaming responses (Server-Sent Events) working
+func (w *writer) Flush() {
+	w.save()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the original writer, used by http.ResponseController
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,176 @@
+package session
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/shtml/sht"
+)
+
+// ScopeKey name of the session in the scope of the controllers
+const ScopeKey = "session"
+
+// Flash a message shown once, on the next page rendered (Ex. "saved" after a redirect)
+type Flash struct {
+	Kind    string `json:"kind"` // Ex. info, success, error
+	Message string `json:"message"`
+}
+
+// Session of a browser. The values are serialized as JSON, numbers are read back as float64. Safe for concurrent use.
+type Session struct {
+	mutex     sync.Mutex
+	id        string // Empty on the cookie store
+	values    map[string]interface{}
+	flashes   []Flash
+	created   time.Time
+	accessed  time.Time
+	isNew     bool
+	changed   bool
+	previous  string // ID removed from the store on save, after Renew, Destroy or expiry
+	partition string // See Manager.Partition
+}
+
+// data serialized on the cookie or on the store
+type data struct {
+	Values    map[string]interface{} `json:"values,omitempty"`
+	Flashes   []Flash                `json:"flashes,omitempty"`
+	Created   int64                  `json:"created"`
+	Accessed  int64                  `json:"accessed"`
+	Partition string                 `json:"partition,omitempty"`
+}
+
+func newSession(id string, now time.Time) *Session {
+	return &Session{id: id, values: map[string]interface{}{}, created: now, accessed: now, isNew: true}
+}
+
+// FromScope returns the session of the request being rendered, nil outside of a page render or when the sessions
+// are disabled
+func FromScope(scope *sht.Scope) *Session {
+	value, _ := scope.Get(ScopeKey)
+	s, _ := value.(*Session)
+	return s
+}
+
+// ID of the session on the server-side store, empty on the cookie store
+func (s *Session) ID() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the session was created by this request
+func (s *Session) IsNew() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.isNew
+}
+
+// Get returns the value of the key, nil if it does not exist
+func (s *Session) Get(key string) interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.values[key]
+}
+
+// GetString returns the value of the key, empty if it does not exist or is not a string
+func (s *Session) GetString(key string) string {
+	value, _ := s.Get(key).(string)
+	return value
+}
+
+func (s *Session) Set(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[key] = value
+	s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.values[key]; exists {
+		delete(s.values, key)
+		s.changed = true
+	}
+}
+
+// AddFlash adds a message to be shown on the next page
+func (s *Session) AddFlash(kind, message string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flashes = append(s.flashes, Flash{Kind: kind, Message: message})
+	s.changed = true
+}
+
+// Flashes returns and removes the flash messages
+func (s *Session) Flashes() []Flash {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	flashes := s.flashes
+	if len(flashes) > 0 {
+		s.flashes = nil
+		s.changed = true
+	}
+	return flashes
+}
+
+// Renew changes the ID of the session keeping the values, the absolute expiry restarts. Must be called when the
+// privileges change (Ex. login) to prevent session fixation.
+func (s *Session) Renew() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.id != "" {
+		if s.previous == "" {
+			s.previous = s.id
+		}
+		s.id = newID()
+	}
+	s.created = s.accessed
+	s.changed = true
+}
+
+// Destroy removes the session (Ex. logout), the values set after it are kept in a new session
+func (s *Session) Destroy() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.id != "" {
+		if s.previous == "" && !s.isNew {
+			s.previous = s.id
+		}
+		s.id = newID()
+	}
+	s.values = map[string]interface{}{}
+	s.flashes = nil
+	s.created = s.accessed
+	s.isNew = true
+	s.changed = true
+}
+
+func (s *Session) marshal() ([]byte, error) {
+	return json.Marshal(&data{
+		Values:    s.values,
+		Flashes:   s.flashes,
+		Created:   s.created.Unix(),
+		Accessed:  s.accessed.Unix(),
+		Partition: s.partition,
+	})
+}
+
+func unmarshal(id string, encoded []byte) (*Session, error) {
+	var d data
+	if err := json.Unmarshal(encoded, &d); err != nil {
+		return nil, err
+	}
+	if d.Values == nil {
+		d.Values = map[string]interface{}{}
+	}
+	return &Session{
+		id:        id,
+		values:    d.Values,
+		flashes:   d.Flashes,
+		created:   time.Unix(d.Created, 0),
+		accessed:  time.Unix(d.Accessed, 0),
+		partition: d.Partition,
+	}, nil
+}
@@ -0,0 +1,221 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/db"
+)
+
+func Test_codec(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	old, err := NewCodec(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := old.Encode("session", []byte("value"))
+
+	// rotation: the new key encrypts, the old one still decrypts
+	rotated, _ := NewCodec(newKey, oldKey)
+	if value, err := rotated.Decode("session", encoded); err != nil || string(value) != "value" {
+		t.Errorf("got %q %v", value, err)
+	}
+	if _, err = old.Decode("session", rotated.Encode("session", []byte("value"))); err != ErrInvalidCookie {
+		t.Errorf("expected the old codec to reject the new key, got %v", err)
+	}
+	// the value is bound to the cookie name
+	if _, err = rotated.Decode("other", encoded); err != ErrInvalidCookie {
+		t.Errorf("got %v", err)
+	}
+	tampered := []byte(encoded)
+	tampered[len(tampered)-2] ^= 1
+	if _, err = rotated.Decode("session", string(tampered)); err != ErrInvalidCookie {
+		t.Errorf("got %v", err)
+	}
+
+	if _, err = ParseKeys([]string{"", "c2hvcnQ="}); err == nil {
+		t.Errorf("expected an error for a short key")
+	}
+}
+
+// browser keeps the cookies between the requests of the tests
+type browser struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (b *browser) get(handle func(s *Session)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range b.cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	b.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), handleKey{}, handle)))
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+		} else {
+			b.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+type handleKey struct{}
+
+func newBrowser(t *testing.T, m *Manager) *browser {
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, _ := r.Context().Value(handleKey{}).(func(s *Session)); handle != nil {
+			handle(FromRequest(r))
+		}
+		w.Write([]byte("ok"))
+	}))
+	return &browser{t: t, handler: handler, cookies: map[string]*http.Cookie{}}
+}
+
+func testManager(t *testing.T, store Store, now *time.Time) *Manager {
+	codec, err := NewCodec(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Manager{
+		Cookie:          "session",
+		Codec:           codec,
+		Store:           store,
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 2 * time.Hour,
+		now:             func() time.Time { return *now },
+	}
+}
+
+func Test_manager(t *testing.T) {
+	conn, err := db.Open("test", &config.DB{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stores := map[string]Store{"cookie": nil, "memory": &Memory{}, "db": &SQL{DB: conn}}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			b := newBrowser(t, testManager(t, store, &now))
+
+			// unused sessions do not set the cookie
+			w := b.get(nil)
+			b.get(func(s *Session) { s.Get("user") })
+			if len(b.cookies) != 0 || w.Header().Get("Set-Cookie") != "" {
+				t.Fatalf("got %v", b.cookies)
+			}
+
+			b.get(func(s *Session) {
+				s.Set("user", "alex")
+				s.AddFlash("success", "Saved")
+			})
+			cookie := b.cookies["session"]
+			if cookie == nil || !cookie.Secure || !cookie.HttpOnly || cookie.MaxAge != 30*60 {
+				t.Fatalf("got %v", cookie)
+			}
+
+			var id string
+			b.get(func(s *Session) {
+				id = s.ID()
+				if s.GetString("user") != "alex" || s.IsNew() {
+					t.Errorf("got %q new %v", s.GetString("user"), s.IsNew())
+				}
+				if flashes := s.Flashes(); len(flashes) != 1 || flashes[0].Message != "Saved" {
+					t.Errorf("got %v", flashes)
+				}
+			})
+			b.get(func(s *Session) {
+				if flashes := s.Flashes(); len(flashes) != 0 {
+					t.Errorf("flashes are shown once, got %v", flashes)
+				}
+			})
+
+			// renew keeps the values with another ID, the old one is removed from the store
+			b.get(func(s *Session) { s.Renew() })
+			b.get(func(s *Session) {
+				if s.GetString("user") != "alex" || (store != nil && s.ID() == id) {
+					t.Errorf("got %q %q", s.GetString("user"), s.ID())
+				}
+			})
+			if store != nil {
+				if _, found, _ := store.Get(context.Background(), id); found {
+					t.Errorf("the previous ID must be removed")
+				}
+			}
+
+			// idle timeout, the last access is refreshed by the requests
+			for i := 0; i < 4; i++ {
+				now = now.Add(20 * time.Minute)
+				b.get(func(s *Session) {
+					if s.GetString("user") != "alex" {
+						t.Errorf("%s: expected the session to be kept", now)
+					}
+				})
+			}
+			// absolute timeout, 2h after the renew
+			now = now.Add(45 * time.Minute)
+			b.get(func(s *Session) {
+				if !s.IsNew() || s.GetString("user") != "" {
+					t.Errorf("expected an expired session")
+				}
+			})
+			if len(b.cookies) != 0 {
+				t.Errorf("expected the cookie of the expired session to be removed, got %v", b.cookies)
+			}
+
+			// destroy, the values set after it are kept in a new session
+			b.get(func(s *Session) { s.Set("user", "alex") })
+			b.get(func(s *Session) {
+				id = s.ID()
+				s.Destroy()
+				s.AddFlash("info", "Bye")
+			})
+			b.get(func(s *Session) {
+				if s.GetString("user") != "" || len(s.Flashes()) != 1 || (store != nil && s.ID() == id) {
+					t.Errorf("got %q %q", s.GetString("user"), s.ID())
+				}
+			})
+		})
+	}
+}
+
+// the sessions of a partition (Ex. a tenant) are not loaded on the others
+func Test_partition(t *testing.T) {
+	now := time.Now()
+	partition := "acme"
+	m := testManager(t, &Memory{}, &now)
+	m.Partition = func(r *http.Request) string { return partition }
+	b := newBrowser(t, m)
+
+	b.get(func(s *Session) { s.Set("user", "alex") })
+	b.get(func(s *Session) {
+		if s.GetString("user") != "alex" {
+			t.Errorf("acme: expected the session, got %q", s.GetString("user"))
+		}
+	})
+	partition = "globex"
+	b.get(func(s *Session) {
+		if s.GetString("user") != "" || !s.IsNew() {
+			t.Errorf("globex: expected a new session, got %q", s.GetString("user"))
+		}
+	})
+}
+
+func Test_cookie_size(t *testing.T) {
+	now := time.Now()
+	b := newBrowser(t, testManager(t, nil, &now))
+	b.get(func(s *Session) { s.Set("data", string(bytes.Repeat([]byte("x"), 5000))) })
+	if len(b.cookies) != 0 {
+		t.Errorf("expected the cookie to be discarded")
+	}
+}
@@ -0,0 +1,193 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/syntax-framework/demo/server/db"
+	"github.com/syntax-framework/demo/server/redis"
+)
+
+// Store keeps the data of the sessions on the server, the cookie only has the ID
+type Store interface {
+	// Get returns the data of the session, false if it does not exist or is expired
+	Get(ctx context.Context, id string) ([]byte, bool, error)
+	Set(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+// sweepInterval of the expired sessions on the memory and db stores
+const sweepInterval = time.Minute
+
+// Memory keeps the sessions in the memory of the process, they are lost on restart
+type Memory struct {
+	mutex    sync.Mutex
+	sessions map[string]*entry
+	swept    time.Time
+}
+
+type entry struct {
+	data    []byte
+	expires time.Time
+}
+
+func (m *Memory) Get(_ context.Context, id string) ([]byte, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, exists := m.sessions[id]
+	if !exists || time.Now().After(e.expires) {
+		return nil, false, nil
+	}
+	return e.data, true, nil
+}
+
+func (m *Memory) Set(_ context.Context, id string, data []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if m.sessions == nil {
+		m.sessions = map[string]*entry{}
+	}
+	m.sessions[id] = &entry{data: data, expires: now.Add(ttl)}
+	if now.Sub(m.swept) > sweepInterval {
+		m.swept = now
+		for key, e := range m.sessions {
+			if now.After(e.expires) {
+				delete(m.sessions, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// Redis keeps the sessions on a redis server with the key prefix, the expiry is done by redis
+type Redis struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (r *Redis) Get(ctx context.Context, id string) ([]byte, bool, error) {
+	reply, err := r.Client.Do(ctx, "GET", r.Prefix+id)
+	if errors.Is(err, redis.ErrNil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	value, isString := reply.(string)
+	if !isString {
+		return nil, false, fmt.Errorf("session: unexpected reply %T", reply)
+	}
+	return []byte(value), true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	_, err := r.Client.Do(ctx, "SET", r.Prefix+id, data, "PX", ttl.Milliseconds())
+	return err
+}
+
+func (r *Redis) Delete(ctx context.Context, id string) error {
+	_, err := r.Client.Do(ctx, "DEL", r.Prefix+id)
+	return err
+}
+
+// SQL keeps the sessions on a table of the database, created on the first use:
+//
+//	CREATE TABLE sessions (id VARCHAR(64) PRIMARY KEY, data TEXT NOT NULL, expires BIGINT NOT NULL)
+type SQL struct {
+	DB    *db.DB
+	Table string // Defaults to sessions
+
+	mutex sync.Mutex
+	ready bool
+	swept time.Time
+}
+
+func (s *SQL) table() string {
+	if s.Table == "" {
+		return "sessions"
+	}
+	return s.Table
+}
+
+// init creates the table, retried on the next use when it fails
+func (s *SQL) init(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ready {
+		return nil
+	}
+	_, err := s.DB.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+s.table()+
+		" (id VARCHAR(64) PRIMARY KEY, data TEXT NOT NULL, expires BIGINT NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("session: creating the table %s on db.%s: %w", s.table(), s.DB.Name, err)
+	}
+	s.ready = true
+	return nil
+}
+
+func (s *SQL) Get(ctx context.Context, id string) ([]byte, bool, error) {
+	if err := s.init(ctx); err != nil {
+		return nil, false, err
+	}
+	var data string
+	err := s.DB.QueryRowContext(ctx, "SELECT data FROM "+s.table()+" WHERE id = "+s.DB.Placeholder(1)+
+		" AND expires > "+s.DB.Placeholder(2), id, time.Now().Unix()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return []byte(data), true, nil
+}
+
+func (s *SQL) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	now := time.Now()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, "DELETE FROM "+s.table()+" WHERE id = "+s.DB.Placeholder(1), id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "INSERT INTO "+s.table()+" (id, data, expires) VALUES ("+s.DB.Placeholder(1)+", "+
+		s.DB.Placeholder(2)+", "+s.DB.Placeholder(3)+")", id, string(data), now.Add(ttl).Unix()); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	sweep := now.Sub(s.swept) > sweepInterval
+	if sweep {
+		s.swept = now
+	}
+	s.mutex.Unlock()
+	if sweep {
+		_, err = s.DB.ExecContext(ctx, "DELETE FROM "+s.table()+" WHERE expires <= "+s.DB.Placeholder(1), now.Unix())
+	}
+	return err
+}
+
+func (s *SQL) Delete(ctx context.Context, id string) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM "+s.table()+" WHERE id = "+s.DB.Placeholder(1), id)
+	return err
+}
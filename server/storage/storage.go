@@ -0,0 +1,73 @@
+// Package storage the file storages declared in the `storage` block of config.yaml.
+//
+// Only the availability check of the engines is implemented, used by the readiness endpoint:
+//
+//	local  the directory exists
+//	s3     the bucket endpoint answers (any response that is not a server error)
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/outbound"
+)
+
+// Check returns the availability check of the storage
+func Check(cfg *config.Storage) func(ctx context.Context) error {
+	switch strings.ToLower(cfg.Engine) {
+	case "local":
+		return func(ctx context.Context) error {
+			if cfg.Dir == "" {
+				return errors.New("dir is not configured")
+			}
+			info, err := os.Stat(cfg.Dir)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", cfg.Dir)
+			}
+			return nil
+		}
+	case "s3":
+		return func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, BucketURL(cfg), nil)
+			if err != nil {
+				return err
+			}
+			res, err := outbound.Client.Do(req)
+			if err != nil {
+				return err
+			}
+			res.Body.Close()
+			if res.StatusCode >= 500 {
+				return fmt.Errorf("%s: %s", req.URL, res.Status)
+			}
+			return nil
+		}
+	}
+	return func(ctx context.Context) error {
+		return fmt.Errorf("unsupported engine %q", cfg.Engine)
+	}
+}
+
+// BucketURL url of the s3 bucket, path style. The endpoint defaults to the AWS endpoint of the region
+func BucketURL(cfg *config.Storage) string {
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+		if cfg.Region != "" {
+			endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+		}
+	}
+	if cfg.Bucket == "" {
+		return endpoint + "/"
+	}
+	return endpoint + "/" + cfg.Bucket
+}
@@ -0,0 +1,211 @@
+// Package tenant resolves the customer (tenant) of each request when the deployment hosts several of them, see the
+// `tenants` block of config.yaml:
+//
+//	tenants:
+//	  resolve: [ host, path, header ]
+//	  list:
+//	    acme:
+//	      hosts: [ acme.example.com ]
+//	      path: /acme
+//	      db:
+//	        mydatabase:
+//	          dsn: acme.db
+//
+// The methods of `resolve` are tried in order: the exact host of the request, the path prefix (removed from the path
+// before the routes) and the header (Ex. `X-Tenant: acme`, only with `tenants.trust-proxy`, the proxy must replace the
+// header sent by the client). Requests that do not resolve receive 404, unless `tenants.default` is set.
+//
+// Handlers read the tenant with FromRequest, controllers with FromScope and the named queries of db/ with FromContext:
+// they are scoped to the tenant automatically, see package query.
+package tenant
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/shtml/sht"
+)
+
+// ScopeKey name of the tenant in the scope of the controllers
+const ScopeKey = "tenant"
+
+// Tenant a customer of the deployment
+type Tenant struct {
+	Name   string
+	Config *config.Tenant // Complete db, storage and cms blocks of the tenant
+}
+
+// Resolver finds the tenant of the requests
+type Resolver struct {
+	Resolve    []string // host, path and header, in order
+	Header     string
+	TrustProxy bool // The header is only read when set by a trusted proxy
+	Default    *Tenant
+	Public     []string // Paths served without a tenant, when none is resolved (Ex. /healthz)
+
+	tenants map[string]*Tenant
+	hosts   map[string]*Tenant
+	paths   []string // Longest first
+	byPath  map[string]*Tenant
+}
+
+type contextKey struct{}
+
+type prefixKey struct{}
+
+// New creates the resolver of the configuration, nil when there are no tenants
+func New(cfg config.Tenants) *Resolver {
+	if len(cfg.List) == 0 {
+		return nil
+	}
+	r := &Resolver{
+		Resolve:    cfg.Resolve,
+		Header:     cfg.Header,
+		TrustProxy: cfg.TrustProxy,
+		tenants:    map[string]*Tenant{},
+		hosts:      map[string]*Tenant{},
+		byPath:     map[string]*Tenant{},
+	}
+	for name, tc := range cfg.List {
+		if tc == nil {
+			tc = &config.Tenant{}
+		}
+		t := &Tenant{Name: name, Config: tc}
+		r.tenants[name] = t
+		for _, host := range tc.Hosts {
+			r.hosts[strings.ToLower(host)] = t
+		}
+		if tc.Path != "" {
+			r.paths = append(r.paths, tc.Path)
+			r.byPath[tc.Path] = t
+		}
+	}
+	// the most specific prefix first (Ex. /acme-labs before /acme)
+	sort.Slice(r.paths, func(i, j int) bool { return len(r.paths[i]) > len(r.paths[j]) })
+	r.Default = r.tenants[cfg.Default]
+	return r
+}
+
+// Tenant returns the tenant with the given name, nil if it does not exist
+func (r *Resolver) Tenant(name string) *Tenant {
+	return r.tenants[name]
+}
+
+// Names returns the names of the tenants, sorted
+func (r *Resolver) Names() []string {
+	names := make([]string, 0, len(r.tenants))
+	for name := range r.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Find returns the tenant of the request and, when resolved by the path, its prefix. Does not apply the default.
+func (r *Resolver) Find(req *http.Request) (*Tenant, string) {
+	for _, method := range r.Resolve {
+		switch method {
+		case "host":
+			host := req.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if t := r.hosts[strings.ToLower(host)]; t != nil {
+				return t, ""
+			}
+		case "path":
+			for _, prefix := range r.paths {
+				if req.URL.Path == prefix || strings.HasPrefix(req.URL.Path, prefix+"/") {
+					return r.byPath[prefix], prefix
+				}
+			}
+		case "header":
+			if !r.TrustProxy {
+				continue
+			}
+			if t := r.tenants[strings.TrimSpace(req.Header.Get(r.Header))]; t != nil {
+				return t, ""
+			}
+		}
+	}
+	return nil, ""
+}
+
+// Handler resolves the tenant of the requests, removing the prefix of the path. The requests of unknown tenants
+// receive 404, except the Public paths.
+func (r *Resolver) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t, prefix := r.Find(req)
+		if t == nil {
+			t = r.Default
+		}
+		if t == nil {
+			for _, path := range r.Public {
+				if req.URL.Path == path {
+					next.ServeHTTP(w, req)
+					return
+				}
+			}
+			http.NotFound(w, req)
+			return
+		}
+
+		ctx := WithTenant(req.Context(), t)
+		if prefix != "" {
+			ctx = context.WithValue(ctx, prefixKey{}, prefix)
+		}
+		req = req.WithContext(ctx)
+		if prefix != "" {
+			u := *req.URL
+			u.Path = strings.TrimPrefix(u.Path, prefix)
+			if u.Path == "" {
+				u.Path = "/"
+			}
+			u.RawPath = ""
+			req.URL = &u
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// WithTenant returns a copy of the context with the tenant (Ex. the commands executed for a tenant)
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tenant of the context, nil when there is none
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(contextKey{}).(*Tenant)
+	return t
+}
+
+// FromRequest returns the tenant of the request, nil when the tenants are not configured
+func FromRequest(r *http.Request) *Tenant {
+	return FromContext(r.Context())
+}
+
+// Prefix returns the path prefix of the tenant removed from the request, empty when it was not resolved by the path.
+// The URLs sent to the browser (Ex. the callbacks of the providers) must keep it.
+func Prefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(prefixKey{}).(string)
+	return prefix
+}
+
+// FromScope returns the tenant of the page being rendered, nil when the tenants are not configured
+func FromScope(scope *sht.Scope) *Tenant {
+	value, _ := scope.Get(ScopeKey)
+	t, _ := value.(*Tenant)
+	return t
+}
+
+// Name returns the name of the tenant of the context, empty when there is none
+func Name(ctx context.Context) string {
+	if t := FromContext(ctx); t != nil {
+		return t.Name
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package tenant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/syntax-framework/demo/server/config"
+)
+
+func newResolver(resolve []string, fallback string) *Resolver {
+	return New(config.Tenants{
+		Resolve:    resolve,
+		Header:     "X-Tenant",
+		TrustProxy: true,
+		Default:    fallback,
+		List: map[string]*config.Tenant{
+			"acme":      {Hosts: []string{"acme.example.com"}, Path: "/acme"},
+			"acme-labs": {Path: "/acme-labs"},
+			"globex":    {Hosts: []string{"Globex.example.com"}},
+		},
+	})
+}
+
+func Test_resolve(t *testing.T) {
+	r := newResolver([]string{"host", "path", "header"}, "")
+	r.Public = []string{"/healthz"}
+	var got, path, prefix string
+	handler := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got, path, prefix = Name(req.Context()), req.URL.Path, Prefix(req)
+	}))
+
+	tests := []struct {
+		host   string
+		path   string
+		header string
+		tenant string
+		served string
+	}{
+		{"acme.example.com:8443", "/page", "", "acme", "/page"},
+		{"globex.example.com", "/acme/page", "", "globex", "/acme/page"}, // the host comes first
+		{"localhost", "/acme/page", "", "acme", "/page"},
+		{"localhost", "/acme", "", "acme", "/"},
+		{"localhost", "/acme-labs/x", "", "acme-labs", "/x"},
+		{"localhost", "/acmex", "globex", "globex", "/acmex"},
+		{"localhost", "/page", "acme", "acme", "/page"},
+		{"localhost", "/page", "unknown", "", ""},
+		{"localhost", "/page", "", "", ""},
+		{"localhost", "/healthz", "", "", "/healthz"},
+	}
+	for _, test := range tests {
+		got, path, prefix = "", "", ""
+		req := httptest.NewRequest(http.MethodGet, "https://"+test.host+test.path, nil)
+		if test.header != "" {
+			req.Header.Set("X-Tenant", test.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got != test.tenant || path != test.served {
+			t.Errorf("%s%s [%s]: Expected %q on %q, got %q on %q", test.host, test.path, test.header, test.tenant, test.served, got, path)
+		}
+		// the prefix restores the path of the request
+		if test.served != "" && test.served != "/" && prefix+path != test.path {
+			t.Errorf("%s%s: Expected the prefix of %q, got %q", test.host, test.path, test.path, prefix)
+		}
+		if test.served == "" && rec.Code != http.StatusNotFound {
+			t.Errorf("%s%s: Expected 404, got %d", test.host, test.path, rec.Code)
+		}
+	}
+}
+
+// without a trusted proxy the header is chosen by the client
+func Test_resolve_header_untrusted(t *testing.T) {
+	r := newResolver([]string{"header"}, "")
+	r.TrustProxy = false
+	req := httptest.NewRequest(http.MethodGet, "https://localhost/page", nil)
+	req.Header.Set("X-Tenant", "acme")
+	if tenant, _ := r.Find(req); tenant != nil {
+		t.Errorf("Expected no tenant, got %s", tenant.Name)
+	}
+}
+
+func Test_resolve_order(t *testing.T) {
+	// only the header, the host and the path are ignored
+	r := newResolver([]string{"header"}, "globex")
+	req := httptest.NewRequest(http.MethodGet, "https://acme.example.com/acme/page", nil)
+	if tenant, _ := r.Find(req); tenant != nil {
+		t.Errorf("Expected no tenant, got %s", tenant.Name)
+	}
+
+	var got string
+	handler := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = FromRequest(req).Name
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "globex" {
+		t.Errorf("Expected the default tenant, got %q", got)
+	}
+}
@@ -0,0 +1,82 @@
+package trace
+
+import (
+	"net/http"
+
+	"github.com/syntax-framework/demo/server/route"
+)
+
+// HTTP creates the server span of each request, child of the traceparent received. The span is named by the route
+// pattern (Ex. "GET /user/:name").
+func HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := tracer()
+		if t == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if remote, exists := Extract(r.Header); exists {
+			ctx = ContextWithRemote(ctx, remote)
+		}
+		ctx, span := t.Start(ctx, r.Method, KindServer)
+		w, r, info := route.Track(w, r.WithContext(ctx))
+
+		next.ServeHTTP(w, r)
+
+		status := info.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if info.Pattern != "" {
+			span.SetName(r.Method + " " + info.Pattern)
+			span.SetAttribute("http.route", info.Pattern)
+		}
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("http.response.status_code", status)
+		if status >= 500 {
+			span.Error = http.StatusText(status)
+		}
+		span.End()
+	})
+}
+
+// Transport creates the client span of the outbound requests and injects the traceparent header
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), req.Method+" "+req.URL.Host, KindClient)
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+	defer span.End()
+
+	// the RoundTripper must not modify the request
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.full", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", res.StatusCode)
+	if res.StatusCode >= 500 {
+		span.Error = res.Status
+	}
+	return res, nil
+}
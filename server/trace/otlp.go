@@ -0,0 +1,155 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLP exports the spans with the OpenTelemetry protocol, HTTP with JSON encoding (Ex. to a local collector on
+// http://localhost:4318)
+type OTLP struct {
+	Endpoint string            // Base URL of the collector, `/v1/traces` is appended
+	Headers  map[string]string // Extra headers (Ex. authorization)
+	Service  string            // service.name resource attribute
+	Client   *http.Client      // Defaults to a client with 10s timeout
+}
+
+func (o *OTLP) Export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(o.payload(spans))
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(o.Endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range o.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := o.Client
+	if client == nil {
+		// not the outbound client, the export must not be traced
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%w %s from %s", ErrStatus, res.Status, url)
+	}
+	return nil
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	TraceState   string          `json:"traceState,omitempty"`
+	Name         string          `json:"name"`
+	Kind         Kind            `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0 unset, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+func (o *OTLP) payload(spans []*Span) interface{} {
+	service := o.Service
+	if service == "" {
+		service = "demo"
+	}
+
+	var encoded []otlpSpan
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:    span.Context.TraceID.String(),
+			SpanID:     span.Context.SpanID.String(),
+			TraceState: span.Context.State,
+			Name:       span.Name,
+			Kind:       span.Kind,
+			Start:      strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			End:        strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes: attributes(span.Attributes),
+		}
+		if span.Parent != (SpanID{}) {
+			s.ParentSpanID = span.Parent.String()
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+		encoded = append(encoded, s)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": attributes(map[string]interface{}{"service.name": service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/syntax-framework/demo/server/trace"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+// attributes encodes the values as OTLP AnyValue, sorted by key
+func attributes(values map[string]interface{}) []otlpAttribute {
+	var list []otlpAttribute
+	for key, value := range values {
+		var v otlpValue
+		switch typed := value.(type) {
+		case string:
+			v = otlpValue{"stringValue": typed}
+		case bool:
+			v = otlpValue{"boolValue": typed}
+		case int:
+			v = otlpValue{"intValue": strconv.Itoa(typed)}
+		case int64:
+			v = otlpValue{"intValue": strconv.FormatInt(typed, 10)}
+		case float64:
+			v = otlpValue{"doubleValue": typed}
+		default:
+			v = otlpValue{"stringValue": fmt.Sprint(typed)}
+		}
+		list = append(list, otlpAttribute{Key: key, Value: v})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
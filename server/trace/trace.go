@@ -0,0 +1,371 @@
+// Package trace records the spans of the requests and propagates them with the W3C Trace Context headers
+// (`traceparent`, `tracestate`), see https://www.w3.org/TR/trace-context/.
+//
+// Tracing is disabled (no-op) until a Tracer is set, Start returns a nil span and all the Span methods accept a nil
+// receiver:
+//
+//	ctx, span := trace.Start(ctx, "GetPlayerById", trace.KindClient)
+//	defer span.End()
+//	span.SetAttribute("db.name", "main")
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+
+	DefaultBatchSize = 512
+	DefaultInterval  = 5 * time.Second
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext the identification of a span, propagated between the services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	State   string // tracestate, vendor specific values
+}
+
+// Valid reports whether the trace and span IDs are not zero
+func (c SpanContext) Valid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+// Traceparent formats the header value (Ex. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + c.TraceID.String() + "-" + c.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses the header value. Future versions are accepted as long as the known fields are valid.
+func ParseTraceparent(value string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("trace: invalid traceparent %q", value)
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, fmt.Errorf("trace: invalid traceparent version %q", parts[0])
+	}
+	if !isLowerHex(parts[1]) || !isLowerHex(parts[2]) || !isLowerHex(parts[3]) {
+		return sc, fmt.Errorf("trace: invalid traceparent %q", value)
+	}
+	hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	flags, _ := hex.DecodeString(parts[3])
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.Valid() {
+		return SpanContext{}, fmt.Errorf("trace: invalid traceparent %q, zero id", value)
+	}
+	return sc, nil
+}
+
+func isLowerHex(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Span a timed operation of a trace
+type Span struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID // zero for the root span
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]interface{}
+	Error      string // the operation failed
+
+	tracer *Tracer
+	mutex  sync.Mutex
+	ended  bool
+}
+
+// SetName renames the span (Ex. with the route pattern, known only after the routing)
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.Name = name
+	s.mutex.Unlock()
+}
+
+// SetAttribute sets an attribute, the values are strings, bools, ints or floats
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]interface{}{}
+	}
+	s.Attributes[key] = value
+	s.mutex.Unlock()
+}
+
+// SetError marks the span as failed, nil is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.Error = err.Error()
+	s.mutex.Unlock()
+}
+
+// End finishes the span, exported when sampled. Calling End more than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mutex.Unlock()
+	if s.Context.Sampled {
+		s.tracer.record(s)
+	}
+}
+
+// Exporter sends the finished spans to a collector
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+// Tracer creates the spans and exports them in batches
+type Tracer struct {
+	exporter  Exporter
+	sample    float64
+	batchSize int
+	interval  time.Duration
+	queue     chan *Span
+	flush     chan chan struct{}
+	failing   bool
+}
+
+// New creates the tracer and starts the export loop. Sample is the fraction of the new traces recorded (0 records
+// all), the traces started by another service follow its sampling decision.
+func New(exporter Exporter, sample float64) *Tracer {
+	t := &Tracer{
+		exporter:  exporter,
+		sample:    sample,
+		batchSize: DefaultBatchSize,
+		interval:  DefaultInterval,
+		queue:     make(chan *Span, 4*DefaultBatchSize),
+		flush:     make(chan chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+var current atomic.Value // *Tracer
+
+// SetTracer enables the tracing, nil disables
+func SetTracer(t *Tracer) {
+	current.Store(&t)
+}
+
+func tracer() *Tracer {
+	if t, exists := current.Load().(**Tracer); exists {
+		return *t
+	}
+	return nil
+}
+
+// Enabled reports whether a tracer is set
+func Enabled() bool {
+	return tracer() != nil
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of the context with the span, the parent of the next spans
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// ContextWithRemote returns a copy of the context with the span received from another service (Ex. traceparent)
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanFromContext returns the current span, nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start creates a span, child of the span of the context. Returns a nil span when tracing is disabled.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	t := tracer()
+	if t == nil {
+		return ctx, nil
+	}
+	return t.Start(ctx, name, kind)
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	span := &Span{Name: name, Kind: kind, StartTime: time.Now(), tracer: t}
+
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.Context
+	} else if remote, exists := ctx.Value(remoteKey{}).(SpanContext); exists {
+		parent = remote
+	}
+
+	if parent.Valid() {
+		span.Context = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled, State: parent.State}
+		span.Parent = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = t.sample <= 0 || t.sample >= 1 || mrand.Float64() < t.sample
+	}
+	rand.Read(span.Context.SpanID[:])
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Inject sets the traceparent and tracestate headers of the span of the context, used on outbound requests
+func Inject(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	header.Set(HeaderTraceparent, span.Context.Traceparent())
+	if span.Context.State != "" {
+		header.Set(HeaderTracestate, span.Context.State)
+	}
+}
+
+// Extract returns the span context of the traceparent and tracestate headers
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(header.Get(HeaderTraceparent))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.State = header.Get(HeaderTracestate)
+	return sc, true
+}
+
+// record queues the span for the export, the span is dropped when the queue is full
+func (t *Tracer) record(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+	}
+}
+
+// Flush exports the queued spans, used on shutdown
+func (t *Tracer) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case span := <-t.queue:
+			if batch = append(batch, span); len(batch) >= t.batchSize {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		case done := <-t.flush:
+			for drained := false; !drained; {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			batch = t.export(batch)
+			close(done)
+		}
+	}
+}
+
+// export sends the batch, the failures are logged once until the exporter recovers
+func (t *Tracer) export(batch []*Span) []*Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err := t.exporter.Export(ctx, batch)
+	cancel()
+	if err != nil && !t.failing {
+		log.Printf("trace: export failed, spans are dropped until it recovers: %v", err)
+	} else if err == nil && t.failing {
+		log.Printf("trace: export recovered")
+	}
+	t.failing = err != nil
+	return batch[:0]
+}
+
+// ErrStatus the collector answered with an error status
+var ErrStatus = errors.New("trace: unexpected status")
@@ -0,0 +1,151 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/syntax-framework/demo/server/route"
+)
+
+type memoryExporter struct {
+	mutex sync.Mutex
+	spans []*Span
+}
+
+func (m *memoryExporter) Export(_ context.Context, spans []*Span) error {
+	m.mutex.Lock()
+	m.spans = append(m.spans, spans...)
+	m.mutex.Unlock()
+	return nil
+}
+
+func Test_traceparent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("got %+v", sc)
+	}
+	if sc.Traceparent() != valid {
+		t.Errorf("Expected '%s', got '%s'", valid, sc.Traceparent())
+	}
+
+	// future version with extra fields
+	if _, err = ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); err != nil {
+		t.Errorf("Expected future version to be accepted, got %v", err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err = ParseTraceparent(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func Test_disabled(t *testing.T) {
+	SetTracer(nil)
+	ctx, span := Start(context.Background(), "noop", KindInternal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Error("Expected no span when tracing is disabled")
+	}
+	span.SetAttribute("a", 1)
+	span.End()
+}
+
+func Test_http(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := New(exporter, 1)
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	var outbound http.Header
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outbound = r.Header
+	}))
+	defer remote.Close()
+	client := &http.Client{Transport: Transport(nil)}
+
+	handler := HTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route.SetPattern(r, "/user/:name")
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, remote.URL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/user/alex", nil)
+	req.Header.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(HeaderTracestate, "vendor=a")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush(context.Background())
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(exporter.spans))
+	}
+	clientSpan, server := exporter.spans[0], exporter.spans[1]
+	if server.Name != "GET /user/:name" || server.Kind != KindServer || server.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("server span: got %+v", server)
+	}
+	if clientSpan.Kind != KindClient || clientSpan.Parent != server.Context.SpanID || clientSpan.Context.TraceID != server.Context.TraceID {
+		t.Errorf("client span: got %+v", clientSpan)
+	}
+	if outbound.Get(HeaderTraceparent) != clientSpan.Context.Traceparent() || outbound.Get(HeaderTracestate) != "vendor=a" {
+		t.Errorf("Expected the trace context on the outbound request, got %v", outbound)
+	}
+}
+
+func Test_otlp(t *testing.T) {
+	var body map[string]interface{}
+	var path string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+	}))
+	defer collector.Close()
+
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	span := &Span{Name: "GetPlayerById", Kind: KindClient, Context: sc, Attributes: map[string]interface{}{"db.name": "main", "rows": 2}, Error: "boom"}
+	exporter := &OTLP{Endpoint: collector.URL, Service: "test"}
+	if err := exporter.Export(context.Background(), []*Span{span}); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v1/traces" {
+		t.Errorf("Expected /v1/traces, got %s", path)
+	}
+	resource := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := resource["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["value"].(map[string]interface{})["stringValue"] != "test" {
+		t.Errorf("service.name: got %v", service)
+	}
+	encoded := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	if encoded["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || encoded["kind"] != float64(KindClient) {
+		t.Errorf("span: got %v", encoded)
+	}
+	if encoded["status"].(map[string]interface{})["code"] != float64(2) {
+		t.Errorf("status: got %v", encoded["status"])
+	}
+	rows := encoded["attributes"].([]interface{})[1].(map[string]interface{})
+	if rows["key"] != "rows" || rows["value"].(map[string]interface{})["intValue"] != "2" {
+		t.Errorf("attributes: got %v", encoded["attributes"])
+	}
+}
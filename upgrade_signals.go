@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals start the zero-downtime upgrade, see listen.Upgrader
+var upgradeSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
@@ -0,0 +1,8 @@
+package main
+
+import (
+	"os"
+)
+
+// upgradeSignals the listeners can not be passed to another process on windows
+var upgradeSignals []os.Signal
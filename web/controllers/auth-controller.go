@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/syntax-framework/demo/server/auth"
+	"github.com/syntax-framework/demo/server/config"
+)
+
+// minhaController personaliza a autenticação (auth.controller no config.yaml), todos os hooks são opcionais
+var minhaController = &auth.Controller{
+	// Cadastro, valida ou completa o usuário antes de salvar
+	Register: func(r *http.Request, user *auth.User) error {
+		if user.Name == "" {
+			user.Name = strings.Split(user.Email, "@")[0]
+		}
+		if strings.HasSuffix(user.Email, "@example.com") {
+			return errors.New("use um email real")
+		}
+		return nil
+	},
+	// Envio do link de redefinição de senha, Ex. por email. O link dá acesso à conta, só é logado em dev
+	SendReset: func(ctx context.Context, user *auth.User, link string) error {
+		if config.Current().Dev {
+			log.Printf("auth: link de redefinição de senha de %s: %s", user.Email, link)
+		}
+		return nil
+	},
+}
+
+func RegisterMinhaController() {
+	auth.RegisterController("minhaController", minhaController)
+}
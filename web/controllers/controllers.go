@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"github.com/syntax-framework/syntax/syntax"
+)
+
+// Register registra todas as controllers da aplicação.
+// `demo new controller <Nome>` adiciona as novas controllers aqui
+func Register(app *syntax.Syntax) {
+	RegisterMinhaController()
+	RegisterMyController(app)
+	RegisterMyLiveController(app)
+	// demo new controller: não remova este comentário
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"github.com/syntax-framework/demo/server/config"
+	"github.com/syntax-framework/demo/server/session"
 	"github.com/syntax-framework/shtml/sht"
 	"github.com/syntax-framework/syntax/syntax"
 )
@@ -13,6 +15,16 @@ func myControllerSetup(scope *sht.Scope, params map[string]interface{}) {
 	scope.Set("method", func() string {
 		return "Método da Controller"
 	})
+
+	// Configuração da aplicação (config.yaml) disponível para as controllers
+	scope.Set("dev", config.Current().Dev)
+
+	// Sessão do usuário, os valores são serializados em JSON (números voltam como float64)
+	if s := session.FromScope(scope); s != nil {
+		visits, _ := s.Get("visits").(float64)
+		s.Set("visits", visits+1)
+		scope.Set("visits", visits+1)
+	}
 }
 
 func RegisterMyController(app *syntax.Syntax) {